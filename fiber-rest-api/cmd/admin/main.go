@@ -0,0 +1,568 @@
+// Command admin performs operator tasks on the database of the server, through the
+// same repository code the server uses:
+//
+//	go run ./cmd/admin [-db data.db] <command> [arguments]
+//
+// Passwords are read from the first line of standard input, so they do not show up
+// in the process list or shell history. Changes are recorded in the profile history
+// with actor 0 and user agent cmd/admin.
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/oauth"
+	"fiber-rest-api/internal/userio"
+	"fiber-rest-api/internal/users"
+)
+
+const usage = `usage: admin [-db path] <command> [arguments]
+
+commands:
+  migrate                          bring the database schema up to date
+  verify                           check database integrity and user data
+  list [-role role] [-disabled]    list users
+  create-user [-role role] [-generate] <email>
+                                   create a user; the password is read from stdin
+  set-role <user> <role>           change the role of a user (user or admin)
+  reset-password [-generate] <user>
+                                   replace a password, signing the user out everywhere
+  disable <user>                   stop a user from logging in or using tokens
+  enable <user>                    undo disable
+  export [-format csv|jsonl] [-columns list] [-o file]
+                                   write users (default stdout)
+  import [-format csv|jsonl] [-dry-run] [-on-conflict skip|update] <file|->
+                                   create or update users from a file with the columns
+                                   email, password or password_hash, role, first_name,
+                                   last_name
+  add-client [-public] [-trusted] -name name <redirect-uri>...
+                                   register an app with the OpenID Connect provider;
+                                   the secret is printed once
+  list-clients                     list registered apps
+  remove-client <client-id>        remove an app, revoking its tokens
+  rotate-key                       sign tokens with a new key from now on
+
+<user> is an id or an email address.
+`
+
+// commands maps a command name to its implementation, which receives the arguments
+// after the name.
+var commands = map[string]func(args []string) error{
+	"migrate":        migrate,
+	"verify":         verify,
+	"list":           list,
+	"create-user":    createUser,
+	"set-role":       setRole,
+	"reset-password": resetPassword,
+	"disable":        func(args []string) error { return setDisabled(args, true) },
+	"enable":         func(args []string) error { return setDisabled(args, false) },
+	"export":         exportUsers,
+	"import":         importUsers,
+	"add-client":     addClient,
+	"list-clients":   listClients,
+	"remove-client":  removeClient,
+	"rotate-key":     rotateKey,
+}
+
+// errUsage reports wrong arguments; main prints the usage and exits with 2.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	path := flag.String("db", "data.db", "sqlite database file")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "admin: unknown command %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// passwords set here are hashed like the server's
+	hasher, err := users.HasherFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		os.Exit(1)
+	}
+	users.DefaultHasher = hasher
+
+	// every command except migrate needs the current schema, but only migrate
+	// should change it
+	if err := db.Open(*path); err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	if flag.Arg(0) != "migrate" {
+		if err := requireMigrated(); err != nil {
+			fmt.Fprintln(os.Stderr, "admin:", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := run(flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		if err == errUsage {
+			flag.Usage()
+			os.Exit(2)
+		}
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+// requireMigrated fails unless the users table has every column this version uses.
+func requireMigrated() error {
+	_, err := db.DB.Exec("SELECT disabled_at FROM users LIMIT 1")
+	if err != nil {
+		return fmt.Errorf("database schema is out of date; run admin migrate first (%v)", err)
+	}
+	return nil
+}
+
+func migrate(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	changes, err := db.Migrate()
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("database schema is up to date")
+	return nil
+}
+
+func verify(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	problems, err := db.Verify()
+	if err != nil {
+		return err
+	}
+	list, err := users.List(db.DB)
+	if err != nil {
+		return err
+	}
+	emails := map[string]int{}
+	for _, u := range list {
+		if !users.ValidRole(u.Role) {
+			problems = append(problems, fmt.Sprintf("user %d has unknown role %q", u.ID, u.Role))
+		}
+		// the unique index is case-sensitive, but addresses are not
+		key := strings.ToLower(u.Email)
+		if other, ok := emails[key]; ok {
+			problems = append(problems, fmt.Sprintf("users %d and %d have the same email address %q", other, u.ID, u.Email))
+		}
+		emails[key] = u.ID
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(problems))
+	}
+	fmt.Printf("ok: %d user(s) checked\n", len(list))
+	return nil
+}
+
+func list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	role := fs.String("role", "", "only users with this role")
+	disabled := fs.Bool("disabled", false, "only disabled users")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	all, err := users.List(db.DB)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tUSERNAME\tROLE\tSTATUS")
+	for _, u := range all {
+		if (*role != "" && u.Role != *role) || (*disabled && !u.Disabled()) {
+			continue
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", u.ID, u.Email, u.Username, u.Role, status(u))
+	}
+	return tw.Flush()
+}
+
+// status describes the state of an account for list.
+func status(u users.User) string {
+	switch {
+	case u.Disabled():
+		return "disabled since " + u.DisabledAt.Format("2006-01-02 15:04")
+	case !u.DeleteAfter.IsZero():
+		return "deleted after " + u.DeleteAfter.Format("2006-01-02 15:04")
+	}
+	return "active"
+}
+
+func createUser(args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	role := fs.String("role", users.RoleUser, "role of the new user")
+	generate := fs.Bool("generate", false, "generate a password and print it instead of reading one")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	email := fs.Arg(0)
+	password, err := newPassword(*generate, email)
+	if err != nil {
+		return err
+	}
+	id, err := users.Create(db.DB, users.NewUser{Email: email, Password: password, Role: *role})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created user %d <%s> with role %s\n", id, email, *role)
+	return nil
+}
+
+func setRole(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	u, err := findUser(args[0])
+	if err != nil {
+		return err
+	}
+	role := args[1]
+	if u.Role == role {
+		fmt.Printf("user %d already has role %s\n", u.ID, role)
+		return nil
+	}
+	err = inTx(func(tx *sql.Tx) error {
+		if err := users.SetRole(tx, u.ID, role); err != nil {
+			return err
+		}
+		return record(tx, u.ID, "role.change", "role", &u.Role, &role)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("user %d now has role %s\n", u.ID, role)
+	return nil
+}
+
+func resetPassword(args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	generate := fs.Bool("generate", false, "generate a password and print it instead of reading one")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	u, err := findUser(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	password, err := newPassword(*generate, u.Email)
+	if err != nil {
+		return err
+	}
+	err = inTx(func(tx *sql.Tx) error {
+		if _, err := users.SetPassword(tx, u.ID, password); err != nil {
+			return err
+		}
+		// only the fact of the change is recorded, never the hashes
+		return record(tx, u.ID, "password.reset", "password", nil, nil)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("password of user %d replaced; existing tokens are revoked\n", u.ID)
+	return nil
+}
+
+func setDisabled(args []string, disabled bool) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	u, err := findUser(args[0])
+	if err != nil {
+		return err
+	}
+	var changed bool
+	err = inTx(func(tx *sql.Tx) error {
+		if changed, err = users.SetDisabled(tx, u.ID, disabled); err != nil || !changed {
+			return err
+		}
+		if disabled {
+			return record(tx, u.ID, "account.disable", "disabled_at", nil, strPtr(time.Now().UTC().Format(time.RFC3339)))
+		}
+		old := u.DisabledAt.UTC().Format(time.RFC3339)
+		return record(tx, u.ID, "account.enable", "disabled_at", &old, nil)
+	})
+	if err != nil {
+		return err
+	}
+	state := "enabled"
+	if disabled {
+		state = "disabled"
+	}
+	if !changed {
+		fmt.Printf("user %d is already %s\n", u.ID, state)
+	} else {
+		fmt.Printf("user %d %s\n", u.ID, state)
+	}
+	return nil
+}
+
+func exportUsers(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("o", "", "file to write instead of stdout")
+	formatName := fs.String("format", "", "csv or jsonl (default from the -o extension, else csv)")
+	columns := fs.String("columns", "", "comma-separated columns (default "+strings.Join(userio.Columns, ",")+")")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	format := userio.FormatOf(*out)
+	if *formatName != "" {
+		var err error
+		if format, err = userio.ParseFormat(*formatName); err != nil {
+			return err
+		}
+	}
+	var cols []string
+	if *columns != "" {
+		cols = strings.Split(*columns, ",")
+		if err := userio.CheckColumns(cols); err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := userio.Export(db.DB, w, format, cols)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "exported %d user(s) to %s\n", n, *out)
+	}
+	return nil
+}
+
+// importUsers creates or updates the users of a file. Rows that fail are reported
+// and skipped; the others are imported.
+func importUsers(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	formatName := fs.String("format", "", "csv or jsonl (default from the file extension, else csv)")
+	dryRun := fs.Bool("dry-run", false, "check every row without changing anything")
+	onConflict := fs.String("on-conflict", userio.OnConflictSkip, "skip or update existing accounts")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	name := fs.Arg(0)
+	format := userio.FormatOf(name)
+	if *formatName != "" {
+		var err error
+		if format, err = userio.ParseFormat(*formatName); err != nil {
+			return err
+		}
+	}
+	var in io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	r, err := userio.NewReader(in, format)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	report, err := userio.Import(db.DB, r, userio.ImportOptions{
+		DryRun:     *dryRun,
+		OnConflict: *onConflict,
+		Audit:      users.Event{UserAgent: "cmd/admin"},
+	})
+	if report != nil {
+		for _, row := range report.Rows {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s %s: %s\n", name, row.Line, row.Status, row.Email, row.Error)
+		}
+		prefix := ""
+		if report.DryRun {
+			prefix = "dry run: "
+		}
+		fmt.Printf("%screated %d, updated %d, unchanged %d, skipped %d, failed %d\n",
+			prefix, report.Created, report.Updated, report.Unchanged, report.Skipped, report.Failed)
+	}
+	if err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d row(s) failed", report.Failed)
+	}
+	return nil
+}
+
+func addClient(args []string) error {
+	fs := flag.NewFlagSet("add-client", flag.ContinueOnError)
+	name := fs.String("name", "", "name shown on the consent page")
+	public := fs.Bool("public", false, "for apps that cannot keep a secret; they must use PKCE")
+	trusted := fs.Bool("trusted", false, "skip the consent page")
+	if err := fs.Parse(args); err != nil || *name == "" || fs.NArg() == 0 {
+		return errUsage
+	}
+	c, secret, err := oauth.CreateClient(db.DB, oauth.NewClient{Name: *name, RedirectURIs: fs.Args(), Public: *public, Trusted: *trusted})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("registered client %q\nclient_id:     %s\n", c.Name, c.ID)
+	if secret != "" {
+		fmt.Printf("client_secret: %s\n(store it now, it cannot be shown again)\n", secret)
+	}
+	return nil
+}
+
+func listClients(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	clients, err := oauth.ListClients(db.DB)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tTYPE\tREDIRECT URIS")
+	for _, c := range clients {
+		kind := "confidential"
+		if c.Public {
+			kind = "public"
+		}
+		if c.Trusted {
+			kind += ", trusted"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.ID, c.Name, kind, strings.Join(c.RedirectURIs, " "))
+	}
+	return tw.Flush()
+}
+
+func removeClient(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := oauth.DeleteClient(db.DB, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("client %s removed\n", args[0])
+	return nil
+}
+
+// rotateKey adds a signing key. The old ones stay published, so tokens they signed
+// remain valid until they expire.
+func rotateKey(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	k, err := oauth.RotateKey(db.DB)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("tokens are now signed with key %s\n", k.ID)
+	return nil
+}
+
+// findUser resolves a user given by id or email address.
+func findUser(ref string) (*users.User, error) {
+	var u *users.User
+	var err error
+	if id, convErr := strconv.Atoi(ref); convErr == nil {
+		u, err = users.Get(db.DB, id)
+	} else {
+		u, err = users.GetByEmail(db.DB, ref)
+	}
+	if err == users.ErrNotFound {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	return u, err
+}
+
+// newPassword reads a password from the first line of stdin, or generates one and
+// prints it, and checks it against the policy.
+func newPassword(generate bool, email string) (string, error) {
+	if generate {
+		for {
+			b := make([]byte, 12)
+			if _, err := rand.Read(b); err != nil {
+				return "", err
+			}
+			password := base64.RawURLEncoding.EncodeToString(b)
+			if users.CheckPasswordPolicy(password, email) == nil {
+				fmt.Println("password:", password)
+				return password, nil
+			}
+		}
+	}
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "password: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.New("no password on stdin")
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if err := users.CheckPasswordPolicy(password, email); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// inTx runs fn in a transaction, committing if it returns nil.
+func inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// record appends a change made with this command to the user's profile history.
+func record(tx *sql.Tx, uid int, action, field string, oldValue, newValue *string) error {
+	return users.RecordEvent(tx, users.Event{
+		UserID:    uid,
+		Action:    action,
+		Field:     field,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		UserAgent: "cmd/admin",
+	})
+}
+
+func strPtr(s string) *string {
+	return &s
+}
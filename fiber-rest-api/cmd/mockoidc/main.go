@@ -0,0 +1,42 @@
+// Command mockoidc serves a stand-in OpenID Connect provider for development, so
+// that signing in with a provider can be tried without registering an application
+// with Google or LINE:
+//
+//	go run ./cmd/mockoidc
+//
+// It prints the environment to start the server with. Its sign-in page asks only
+// for an email address; "deny" declines the sign-in.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"fiber-rest-api/internal/oidc/mock"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9999", "address to listen on")
+	name := flag.String("name", "mock", "provider name the server is configured with")
+	clientID := flag.String("client-id", "fiber-rest-api", "client id of the server")
+	clientSecret := flag.String("client-secret", "mock-secret", "client secret of the server")
+	flag.Parse()
+
+	issuer := "http://" + *addr
+	p, err := mock.New(issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatalf("failed to create provider: %v", err)
+	}
+
+	prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(*name, "-", "_")) + "_"
+	fmt.Printf("start the server with:\n\n")
+	fmt.Printf("  OIDC_PROVIDERS=%s %sISSUER=%s %sCLIENT_ID=%s %sCLIENT_SECRET=%s\n\n",
+		*name, prefix, issuer, prefix, *clientID, prefix, *clientSecret)
+	fmt.Printf("and sign in at http://localhost:3000/profile/ui\n")
+
+	log.Printf("mock provider listening on %s", issuer)
+	log.Fatal(http.ListenAndServe(*addr, p.Handler()))
+}
@@ -7,9 +7,17 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	// embed the IANA time zone database so user time zones work on minimal images
+	_ "time/tzdata"
 
+	"fiber-rest-api/internal/apperr"
 	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/mail"
+	"fiber-rest-api/internal/oidc"
 	"fiber-rest-api/internal/router"
+	"fiber-rest-api/internal/sms"
+	"fiber-rest-api/internal/users"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -21,8 +29,51 @@ func main() {
 	}
 	defer db.Close()
 
-	app := fiber.New()
+	hasher, err := users.HasherFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure password hashing: %v", err)
+	}
+	users.DefaultHasher = hasher
+
+	sender, err := sms.FromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure sms provider: %v", err)
+	}
+	handlers.SMS = sender
+
+	mailer, err := mail.FromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure mail provider: %v", err)
+	}
+	handlers.Mail = mailer
+
+	providers, err := oidc.FromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure identity providers: %v", err)
+	}
+	handlers.OIDCProviders = providers
+
+	// erase accounts whose deletion grace period has ended
+	purgeInterval := time.Hour
+	if v := os.Getenv("ACCOUNT_PURGE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid ACCOUNT_PURGE_INTERVAL %q", v)
+		}
+		purgeInterval = d
+	}
+	stopJobs := make(chan struct{})
+	defer close(stopJobs)
+	go handlers.RunAccountPurge(stopJobs, purgeInterval)
+
+	// errors returned by handlers are rendered as application/problem+json. Request
+	// bodies are streamed so that user imports need not fit in memory; the routes read
+	// the others up to BodyLimit (see openapi.Operation.BodyStream).
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler, StreamRequestBody: true, DisablePreParseMultipartForm: true})
 	router.SetupRoutes(app)
+	if err := router.CheckSpec(app); err != nil {
+		log.Printf("warning: %v", err)
+	}
 
 	// start server in background
 	srvErr := make(chan error, 1)
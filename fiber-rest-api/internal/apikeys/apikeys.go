@@ -0,0 +1,256 @@
+// Package apikeys is the repository of the API keys users create for scripts and
+// integrations, shared by the HTTP handlers and AuthRequired. A key stands in for
+// the user's password-based token, restricted to the scopes it was created with.
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrKeyNotFound  = errors.New("API key not found")
+	ErrInvalidKey   = errors.New("invalid or expired API key")
+	ErrInvalidScope = errors.New("invalid scope")
+)
+
+// Prefix starts every key, which tells keys apart from JWTs in the Authorization
+// header and makes leaked keys easy to find with secret scanners.
+const Prefix = "pat_"
+
+// touchInterval is how often last_used_at is updated for a key in use, so that a
+// busy script does not write to the database on every request.
+const touchInterval = time.Minute
+
+// Scope is a permission granted to a key. Operations name the scope they need;
+// those that name none cannot be called with a key.
+type Scope string
+
+const (
+	ScopeProfileRead  Scope = "profile:read"
+	ScopeProfileWrite Scope = "profile:write"
+	// ScopeAdmin lets keys of admins call the admin operations.
+	ScopeAdmin Scope = "admin"
+)
+
+// Scopes lists every valid scope.
+var Scopes = []Scope{ScopeProfileRead, ScopeProfileWrite, ScopeAdmin}
+
+// OpenAPIName names the schema of Scope.
+func (Scope) OpenAPIName() string {
+	return "APIKeyScope"
+}
+
+// OpenAPISchema lists every scope.
+func (Scope) OpenAPISchema() map[string]interface{} {
+	scopes := make([]string, len(Scopes))
+	for i, s := range Scopes {
+		scopes[i] = string(s)
+	}
+	return map[string]interface{}{
+		"type":        "string",
+		"description": "profile:read and profile:write cover the profile, preferences and related data of the key's user; admin the admin operations, for keys of admins",
+		"enum":        scopes,
+	}
+}
+
+// ValidScope reports whether s is a known scope.
+func ValidScope(s Scope) bool {
+	for _, v := range Scopes {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Queryer is satisfied by *sql.DB and *sql.Tx.
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Key is an API key. The key itself is only stored hashed.
+type Key struct {
+	ID     int64
+	UserID int
+	Name   string
+	// Hint is the start of the key, to tell keys apart in lists.
+	Hint       string
+	Scopes     []Scope
+	CreatedAt  time.Time
+	LastUsedAt time.Time // zero until the key is first used
+	ExpiresAt  time.Time // zero for keys that do not expire
+}
+
+// NewKey holds the fields of a key to create.
+type NewKey struct {
+	UserID    int
+	Name      string
+	Scopes    []Scope
+	ExpiresAt time.Time
+}
+
+// IsKey reports whether the bearer token is an API key rather than a JWT.
+func IsKey(token string) bool {
+	return strings.HasPrefix(token, Prefix)
+}
+
+// Create stores a key for nk.UserID and returns it with the key, which cannot be
+// shown again.
+func Create(q Queryer, nk NewKey) (*Key, string, error) {
+	if strings.TrimSpace(nk.Name) == "" {
+		return nil, "", errors.New("key name required")
+	}
+	if len(nk.Scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	seen := map[Scope]bool{}
+	var scopes []Scope
+	for _, s := range nk.Scopes {
+		if !ValidScope(s) {
+			return nil, "", ErrInvalidScope
+		}
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	sort.Slice(scopes, func(i, j int) bool { return scopes[i] < scopes[j] })
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := Prefix + base64.RawURLEncoding.EncodeToString(b)
+	k := &Key{
+		UserID:    nk.UserID,
+		Name:      strings.TrimSpace(nk.Name),
+		Hint:      token[:len(Prefix)+6],
+		Scopes:    scopes,
+		CreatedAt: time.Unix(time.Now().Unix(), 0),
+	}
+	var expiresAt interface{}
+	if !nk.ExpiresAt.IsZero() {
+		k.ExpiresAt = time.Unix(nk.ExpiresAt.Unix(), 0)
+		expiresAt = k.ExpiresAt.Unix()
+	}
+	res, err := q.Exec("INSERT INTO api_keys (user_id, name, hint, key_hash, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		k.UserID, k.Name, k.Hint, hashKey(token), joinScopes(k.Scopes), k.CreatedAt.Unix(), expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	if k.ID, err = res.LastInsertId(); err != nil {
+		return nil, "", err
+	}
+	return k, token, nil
+}
+
+const columns = "id, user_id, name, hint, scopes, created_at, last_used_at, expires_at"
+
+func scan(row interface{ Scan(...interface{}) error }) (*Key, error) {
+	var k Key
+	var scopes string
+	var createdAt int64
+	var lastUsedAt, expiresAt sql.NullInt64
+	if err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Hint, &scopes, &createdAt, &lastUsedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	for _, s := range strings.Fields(scopes) {
+		k.Scopes = append(k.Scopes, Scope(s))
+	}
+	k.CreatedAt = time.Unix(createdAt, 0)
+	if lastUsedAt.Valid {
+		k.LastUsedAt = time.Unix(lastUsedAt.Int64, 0)
+	}
+	if expiresAt.Valid {
+		k.ExpiresAt = time.Unix(expiresAt.Int64, 0)
+	}
+	return &k, nil
+}
+
+// List returns the keys of user uid, newest first, including expired ones.
+func List(q Queryer, uid int) ([]Key, error) {
+	rows, err := q.Query("SELECT "+columns+" FROM api_keys WHERE user_id = ? ORDER BY created_at DESC, id DESC", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Key{}
+	for rows.Next() {
+		k, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *k)
+	}
+	return list, rows.Err()
+}
+
+// Authenticate returns the key token belongs to, or ErrInvalidKey when there is
+// none or it has expired, and records that it was used.
+func Authenticate(q Queryer, token string) (*Key, error) {
+	k, err := scan(q.QueryRow("SELECT "+columns+" FROM api_keys WHERE key_hash = ?", hashKey(token)))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return nil, ErrInvalidKey
+	}
+	if now.Sub(k.LastUsedAt) >= touchInterval {
+		if _, err := q.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", now.Unix(), k.ID); err != nil {
+			return nil, err
+		}
+		k.LastUsedAt = time.Unix(now.Unix(), 0)
+	}
+	return k, nil
+}
+
+// Revoke deletes key id of user uid.
+func Revoke(q Queryer, uid int, id int64) error {
+	res, err := q.Exec("DELETE FROM api_keys WHERE id = ? AND user_id = ?", id, uid)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// HasScope reports whether the key was granted s.
+func (k *Key) HasScope(s Scope) bool {
+	for _, v := range k.Scopes {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func joinScopes(scopes []Scope) string {
+	s := make([]string, len(scopes))
+	for i, v := range scopes {
+		s[i] = string(v)
+	}
+	return strings.Join(s, " ")
+}
+
+// hashKey hashes keys for storage and lookup. They are long and random, so a fast
+// hash is enough.
+func hashKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,275 @@
+// Package apperr is the error model of the API. Handlers return an *Error carrying a
+// stable machine-readable Code, and Handler renders it as an RFC 7807 problem
+// document in the language negotiated from Accept-Language.
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Code identifies a kind of error. Codes are part of the API: clients match on them
+// rather than on messages, so existing codes must keep their meaning.
+type Code string
+
+const (
+	// MalformedBody: the request body could not be parsed.
+	MalformedBody Code = "malformed_body"
+	// InvalidRequest: parameters or body values are invalid; see the field errors.
+	InvalidRequest Code = "invalid_request"
+	// WeakPassword: the new password does not meet the password policy.
+	WeakPassword Code = "weak_password"
+	// PhoneMissing: the profile has no phone number to verify.
+	PhoneMissing Code = "phone_missing"
+	// VerificationNotPending: no code was sent for the current phone number.
+	VerificationNotPending Code = "verification_not_pending"
+	// VerificationExpired: the phone verification code has expired.
+	VerificationExpired Code = "verification_expired"
+	// InvalidVerificationCode: the phone verification code does not match.
+	InvalidVerificationCode Code = "invalid_verification_code"
+	// InvalidConfirmationToken: an email confirmation token is unknown or expired.
+	InvalidConfirmationToken Code = "invalid_confirmation_token"
+	// InvalidState: a sign-in with an identity provider came back without the state
+	// it was started with, or too late.
+	InvalidState Code = "invalid_state"
+
+	// Unauthenticated: no usable Authorization header was sent.
+	Unauthenticated Code = "unauthenticated"
+	// InvalidToken: the bearer token is malformed, expired or names no user.
+	InvalidToken Code = "invalid_token"
+	// TokenRevoked: the token predates an email or password change.
+	TokenRevoked Code = "token_revoked"
+	// AccountPendingDeletion: the account is closed until its owner logs in again.
+	AccountPendingDeletion Code = "account_pending_deletion"
+	// InvalidCredentials: the email or password is wrong.
+	InvalidCredentials Code = "invalid_credentials"
+
+	// Forbidden: the caller lacks the required role.
+	Forbidden Code = "forbidden"
+	// AccountDisabled: an operator has disabled the account.
+	AccountDisabled Code = "account_disabled"
+	// InsufficientScope: the API key was not granted the scope of the operation, or
+	// the operation cannot be called with an API key.
+	InsufficientScope Code = "insufficient_scope"
+	// AuthorizationDenied: the user declined the sign-in at the identity provider.
+	AuthorizationDenied Code = "authorization_denied"
+	// EmailUnverified: the identity provider has no verified email address for the user.
+	EmailUnverified Code = "email_unverified"
+	// ReauthenticationRequired: the account has no password, and its owner must sign
+	// in again to confirm the change.
+	ReauthenticationRequired Code = "reauthentication_required"
+	// InvalidSignature: a signed link has been tampered with.
+	InvalidSignature Code = "invalid_signature"
+	// NotFound: the resource or route does not exist.
+	NotFound Code = "not_found"
+	// MethodNotAllowed: the route does not support the method.
+	MethodNotAllowed Code = "method_not_allowed"
+	// EmailTaken: another account uses the email address.
+	EmailTaken Code = "email_taken"
+	// UsernameTaken: another account uses the username.
+	UsernameTaken Code = "username_taken"
+	// AttributeExists: a custom attribute with the key is already defined.
+	AttributeExists Code = "attribute_exists"
+	// IdentityLinked: the provider identity belongs to another account, or the
+	// account already has one of that provider.
+	IdentityLinked Code = "identity_linked"
+	// LastLoginMethod: the identity is the only way to sign in to the account.
+	LastLoginMethod Code = "last_login_method"
+	// PhoneAlreadyVerified: the current phone number is already verified.
+	PhoneAlreadyVerified Code = "phone_already_verified"
+	// ExportExpired: the data export has been deleted.
+	ExportExpired Code = "export_expired"
+	// LinkExpired: a signed link is past its expiry.
+	LinkExpired Code = "link_expired"
+	// PayloadTooLarge: the request body exceeds the server limit.
+	PayloadTooLarge Code = "payload_too_large"
+	// UnsupportedMediaType: the body is in a media type the operation does not accept.
+	UnsupportedMediaType Code = "unsupported_media_type"
+	// PreconditionRequired: the server requires If-Match on this write.
+	PreconditionRequired Code = "precondition_required"
+	// RateLimited: the action was performed too recently; retry later.
+	RateLimited Code = "rate_limited"
+
+	// Internal: the server failed; the cause is logged with the request ID.
+	Internal Code = "internal_error"
+	// DeliveryFailed: an email or SMS could not be handed to the provider.
+	DeliveryFailed Code = "delivery_failed"
+	// ProviderError: an identity provider could not be reached or gave an invalid answer.
+	ProviderError Code = "provider_error"
+)
+
+// statuses maps every code to its HTTP status. The ErrorCode schema lists its keys.
+var statuses = map[Code]int{
+	MalformedBody:            fiber.StatusBadRequest,
+	InvalidRequest:           fiber.StatusBadRequest,
+	WeakPassword:             fiber.StatusBadRequest,
+	PhoneMissing:             fiber.StatusBadRequest,
+	VerificationNotPending:   fiber.StatusBadRequest,
+	VerificationExpired:      fiber.StatusBadRequest,
+	InvalidVerificationCode:  fiber.StatusBadRequest,
+	InvalidConfirmationToken: fiber.StatusBadRequest,
+	InvalidState:             fiber.StatusBadRequest,
+	Unauthenticated:          fiber.StatusUnauthorized,
+	InvalidToken:             fiber.StatusUnauthorized,
+	TokenRevoked:             fiber.StatusUnauthorized,
+	AccountPendingDeletion:   fiber.StatusUnauthorized,
+	InvalidCredentials:       fiber.StatusUnauthorized,
+	Forbidden:                fiber.StatusForbidden,
+	AccountDisabled:          fiber.StatusForbidden,
+	InsufficientScope:        fiber.StatusForbidden,
+	AuthorizationDenied:      fiber.StatusForbidden,
+	EmailUnverified:          fiber.StatusForbidden,
+	ReauthenticationRequired: fiber.StatusForbidden,
+	InvalidSignature:         fiber.StatusForbidden,
+	NotFound:                 fiber.StatusNotFound,
+	MethodNotAllowed:         fiber.StatusMethodNotAllowed,
+	EmailTaken:               fiber.StatusConflict,
+	UsernameTaken:            fiber.StatusConflict,
+	AttributeExists:          fiber.StatusConflict,
+	IdentityLinked:           fiber.StatusConflict,
+	LastLoginMethod:          fiber.StatusConflict,
+	PhoneAlreadyVerified:     fiber.StatusConflict,
+	ExportExpired:            fiber.StatusGone,
+	LinkExpired:              fiber.StatusGone,
+	PayloadTooLarge:          fiber.StatusRequestEntityTooLarge,
+	UnsupportedMediaType:     fiber.StatusUnsupportedMediaType,
+	PreconditionRequired:     fiber.StatusPreconditionRequired,
+	RateLimited:              fiber.StatusTooManyRequests,
+	Internal:                 fiber.StatusInternalServerError,
+	DeliveryFailed:           fiber.StatusBadGateway,
+	ProviderError:            fiber.StatusBadGateway,
+}
+
+// Status returns the HTTP status of errors with code c.
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return fiber.StatusInternalServerError
+}
+
+// codeForStatus picks the code of errors that only carry a status, such as the
+// *fiber.Error returned for unknown routes.
+func codeForStatus(status int) Code {
+	switch status {
+	case fiber.StatusBadRequest:
+		return MalformedBody
+	case fiber.StatusUnauthorized:
+		return Unauthenticated
+	case fiber.StatusForbidden:
+		return Forbidden
+	case fiber.StatusNotFound:
+		return NotFound
+	case fiber.StatusMethodNotAllowed:
+		return MethodNotAllowed
+	case fiber.StatusRequestEntityTooLarge:
+		return PayloadTooLarge
+	case fiber.StatusUnsupportedMediaType:
+		return UnsupportedMediaType
+	case fiber.StatusTooManyRequests:
+		return RateLimited
+	}
+	if status < 500 {
+		return InvalidRequest
+	}
+	return Internal
+}
+
+// Error is an API error. Detail is the English message; it is translated when the
+// error is rendered. Err, the cause, is logged but never sent to the client.
+type Error struct {
+	Status int
+	Code   Code
+	Detail string
+	Fields []FieldError
+	Err    error
+
+	format string
+	args   []interface{}
+}
+
+// New returns an error with code and detail, answered with the status of code.
+func New(code Code, detail string) *Error {
+	return &Error{Status: code.Status(), Code: code, Detail: detail}
+}
+
+// Newf is New with a formatted detail. The format, not the result, is looked up in
+// the translations.
+func Newf(code Code, format string, args ...interface{}) *Error {
+	e := New(code, fmt.Sprintf(format, args...))
+	e.format, e.args = format, args
+	return e
+}
+
+// Wrap returns an error with code and detail caused by err.
+func Wrap(err error, code Code, detail string) *Error {
+	e := New(code, detail)
+	e.Err = err
+	return e
+}
+
+// WithFields adds field errors to e and returns it.
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	e.Fields = append(e.Fields, fields...)
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Message returns the detail of e translated to lang, for errors shown other than
+// as a problem document, such as on an HTML page.
+func (e *Error) Message(lang string) string {
+	if e.format != "" {
+		return translate(lang, e.format, e.args)
+	}
+	return translate(lang, e.Detail, nil)
+}
+
+// FieldError describes one invalid value of a request.
+type FieldError struct {
+	In      string `json:"in" openapi:"enum=body|query|path|header|response"`
+	Field   string `json:"field,omitempty" doc:"path of the value, e.g. attributes.team or options[0]; empty for the whole body"`
+	Message string `json:"message"`
+
+	format string
+	args   []interface{}
+}
+
+// Field returns a field error whose message is built from format, so that it can be
+// translated.
+func Field(in, field, format string, args ...interface{}) FieldError {
+	if len(args) == 0 {
+		return FieldError{In: in, Field: field, Message: format}
+	}
+	return FieldError{In: in, Field: field, Message: fmt.Sprintf(format, args...), format: format, args: args}
+}
+
+// From converts any error returned by a handler into an *Error. *fiber.Error keeps its
+// status; other errors become internal errors.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		detail := fe.Message
+		if detail == "" {
+			detail = http.StatusText(fe.Code)
+		}
+		return &Error{Status: fe.Code, Code: codeForStatus(fe.Code), Detail: detail}
+	}
+	return Wrap(err, Internal, "internal error")
+}
@@ -0,0 +1,289 @@
+package apperr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// languages lists the languages messages are available in, the default first.
+var languages = []string{"en", "th"}
+
+// Language picks the language of error messages from the Accept-Language header of
+// the request, honouring quality values. Only the primary subtag is compared, so
+// th-TH selects th.
+func Language(c *fiber.Ctx) string {
+	best, bestQ := languages[0], 0.0
+	for _, part := range strings.Split(c.Get(fiber.HeaderAcceptLanguage), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			f, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		for _, l := range languages {
+			if l == primary && q > bestQ {
+				best, bestQ = l, q
+			}
+		}
+	}
+	return best
+}
+
+// translate renders the message built from format and args in lang. Messages
+// without a translation, such as those naming custom attributes, stay in English.
+func translate(lang, format string, args []interface{}) string {
+	if t, ok := translations[format][lang]; ok {
+		format = t
+	}
+	if args == nil {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// translations holds every translated message by its English text (or format) and
+// language. Verbs must appear in the same order as in the English format.
+var translations = map[string]map[string]string{
+	// status titles
+	"Bad Request":              {"th": "คำขอไม่ถูกต้อง"},
+	"Unauthorized":             {"th": "ไม่ได้รับอนุญาต"},
+	"Forbidden":                {"th": "ไม่มีสิทธิ์เข้าถึง"},
+	"Not Found":                {"th": "ไม่พบข้อมูล"},
+	"Method Not Allowed":       {"th": "ไม่รองรับเมธอดนี้"},
+	"Conflict":                 {"th": "ข้อมูลขัดแย้ง"},
+	"Gone":                     {"th": "ไม่มีข้อมูลนี้แล้ว"},
+	"Request Entity Too Large": {"th": "ข้อมูลที่ส่งมีขนาดใหญ่เกินไป"},
+	"Unsupported Media Type":   {"th": "ไม่รองรับประเภทข้อมูลนี้"},
+	"Precondition Required":    {"th": "ต้องระบุเงื่อนไขก่อนดำเนินการ"},
+	"Too Many Requests":        {"th": "ส่งคำขอบ่อยเกินไป"},
+	"Internal Server Error":    {"th": "เซิร์ฟเวอร์ขัดข้อง"},
+	"Bad Gateway":              {"th": "บริการภายนอกขัดข้อง"},
+
+	// requests
+	"invalid request":                                   {"th": "คำขอไม่ถูกต้อง"},
+	"invalid request body":                              {"th": "เนื้อหาคำขอไม่ถูกต้อง"},
+	"unsupported content type":                          {"th": "ไม่รองรับประเภทเนื้อหานี้"},
+	"failed to validate request":                        {"th": "ไม่สามารถตรวจสอบคำขอได้"},
+	"internal error":                                    {"th": "เกิดข้อผิดพลาดภายในระบบ"},
+	"unknown field: %s":                                 {"th": "ไม่รู้จักฟิลด์: %s"},
+	"%s must be a string or null":                       {"th": "%s ต้องเป็นข้อความหรือ null"},
+	"attributes must be an object":                      {"th": "attributes ต้องเป็นออบเจ็กต์"},
+	"limit must be between 1 and 200":                   {"th": "limit ต้องอยู่ระหว่าง 1 ถึง 200"},
+	"before must be an entry id":                        {"th": "before ต้องเป็นรหัสรายการ"},
+	"invalid user id":                                   {"th": "รหัสผู้ใช้ไม่ถูกต้อง"},
+	"user not found":                                    {"th": "ไม่พบผู้ใช้"},
+	"no route for %s %s":                                {"th": "ไม่พบเส้นทาง %s %s"},
+	"content type must be application/merge-patch+json": {"th": "Content-Type ต้องเป็น application/merge-patch+json"},
+	"If-Match header required":                          {"th": "ต้องระบุส่วนหัว If-Match"},
+	"format must be csv or jsonl":                       {"th": "format ต้องเป็น csv หรือ jsonl"},
+	"unknown column: %s":                                {"th": "ไม่รู้จักคอลัมน์: %s"},
+	"invalid header row":                                {"th": "แถวหัวตารางไม่ถูกต้อง"},
+	"on_conflict must be skip or update":                {"th": "on_conflict ต้องเป็น skip หรือ update"},
+	"import larger than %d bytes":                       {"th": "ข้อมูลนำเข้าเกิน %d ไบต์"},
+
+	"content type must be text/csv or application/x-ndjson": {"th": "Content-Type ต้องเป็น text/csv หรือ application/x-ndjson"},
+
+	// field errors of request validation
+	"is required":                    {"th": "จำเป็นต้องระบุ"},
+	"request body is required":       {"th": "ต้องส่งเนื้อหาคำขอ"},
+	"malformed JSON":                 {"th": "JSON ไม่ถูกต้อง"},
+	"unknown field":                  {"th": "ไม่รู้จักฟิลด์นี้"},
+	"must not be null":               {"th": "ต้องไม่เป็น null"},
+	"must not be empty":              {"th": "ต้องไม่เว้นว่าง"},
+	"must be one of: %s":             {"th": "ต้องเป็นค่าใดค่าหนึ่งต่อไปนี้: %s"},
+	"must be a string":               {"th": "ต้องเป็นข้อความ"},
+	"must be an integer":             {"th": "ต้องเป็นจำนวนเต็ม"},
+	"must be a number":               {"th": "ต้องเป็นตัวเลข"},
+	"must be a boolean":              {"th": "ต้องเป็น true หรือ false"},
+	"must be an array":               {"th": "ต้องเป็นอาร์เรย์"},
+	"must be an object":              {"th": "ต้องเป็นออบเจ็กต์"},
+	"must have at least %v items":    {"th": "ต้องมีอย่างน้อย %v รายการ"},
+	"must have at most %v items":     {"th": "ต้องมีไม่เกิน %v รายการ"},
+	"must be at least %v characters": {"th": "ต้องมีอย่างน้อย %v ตัวอักษร"},
+	"must be at most %v characters":  {"th": "ต้องมีไม่เกิน %v ตัวอักษร"},
+	"must be at least %v":            {"th": "ต้องไม่น้อยกว่า %v"},
+	"must be at most %v":             {"th": "ต้องไม่เกิน %v"},
+	"must match %s":                  {"th": "ต้องตรงกับรูปแบบ %s"},
+	"must be an email address":       {"th": "ต้องเป็นอีเมล"},
+	"must be a date (YYYY-MM-DD)":    {"th": "ต้องเป็นวันที่ (YYYY-MM-DD)"},
+	"must be an RFC 3339 date-time":  {"th": "ต้องเป็นวันเวลาตาม RFC 3339"},
+
+	// authentication
+	"unauthorized":                 {"th": "กรุณาเข้าสู่ระบบ"},
+	"missing authorization header": {"th": "ไม่พบส่วนหัว Authorization"},
+	"invalid authorization header": {"th": "ส่วนหัว Authorization ไม่ถูกต้อง"},
+	"invalid token":                {"th": "โทเค็นไม่ถูกต้องหรือหมดอายุ"},
+	"invalid token claims":         {"th": "ข้อมูลในโทเค็นไม่ถูกต้อง"},
+	"invalid subject claim":        {"th": "ข้อมูลผู้ใช้ในโทเค็นไม่ถูกต้อง"},
+	"invalid user id in token":     {"th": "รหัสผู้ใช้ในโทเค็นไม่ถูกต้อง"},
+	"token revoked":                {"th": "โทเค็นถูกเพิกถอนแล้ว กรุณาเข้าสู่ระบบใหม่"},
+	"account pending deletion":     {"th": "บัญชีนี้อยู่ระหว่างรอการลบ เข้าสู่ระบบเพื่อกู้คืนบัญชี"},
+	"invalid credentials":          {"th": "อีเมลหรือรหัสผ่านไม่ถูกต้อง"},
+	"invalid password":             {"th": "รหัสผ่านไม่ถูกต้อง"},
+	"invalid current password":     {"th": "รหัสผ่านปัจจุบันไม่ถูกต้อง"},
+	"admin role required":          {"th": "ต้องเป็นผู้ดูแลระบบ"},
+	"account disabled":             {"th": "บัญชีนี้ถูกระงับการใช้งาน"},
+	"sign in again to confirm":     {"th": "กรุณาเข้าสู่ระบบอีกครั้งเพื่อยืนยัน"},
+	"email and password required":  {"th": "กรุณาระบุอีเมลและรหัสผ่าน"},
+	"email already registered":     {"th": "อีเมลนี้ถูกใช้ลงทะเบียนแล้ว"},
+
+	// passwordless sign-in
+	"email required":                              {"th": "กรุณาระบุอีเมล"},
+	"email is not a valid email address":          {"th": "อีเมลไม่ถูกต้อง"},
+	"sign-in link recently sent, try again later": {"th": "เพิ่งส่งลิงก์เข้าสู่ระบบไป กรุณาลองใหม่ภายหลัง"},
+	"token, or email and code required":           {"th": "กรุณาระบุโทเค็น หรืออีเมลและรหัส"},
+	"email and code required":                     {"th": "กรุณาระบุอีเมลและรหัส"},
+	"invalid or expired sign-in link":             {"th": "ลิงก์เข้าสู่ระบบไม่ถูกต้องหรือหมดอายุแล้ว"},
+	"invalid or expired code":                     {"th": "รหัสไม่ถูกต้องหรือหมดอายุแล้ว"},
+
+	// API keys
+	"invalid API key":               {"th": "API key ไม่ถูกต้องหรือหมดอายุ"},
+	"not available with an API key": {"th": "ไม่สามารถใช้ API key กับคำขอนี้ได้ กรุณาเข้าสู่ระบบด้วยรหัสผ่าน"},
+	"API key lacks the %s scope":    {"th": "API key นี้ไม่มีสิทธิ์ %s"},
+	"API key not found":             {"th": "ไม่พบ API key นี้"},
+	"must be in the future":         {"th": "ต้องเป็นเวลาในอนาคต"},
+
+	// identity providers
+	"unknown provider":                             {"th": "ไม่รู้จักผู้ให้บริการนี้"},
+	"redirect must be a path on this server":       {"th": "redirect ต้องเป็นพาธบนเซิร์ฟเวอร์นี้"},
+	"authorization code required":                  {"th": "ไม่พบรหัสอนุญาตจากผู้ให้บริการ"},
+	"sign-in was not started here or has expired":  {"th": "ไม่พบการเข้าสู่ระบบที่เริ่มไว้ หรือหมดเวลาแล้ว กรุณาเริ่มใหม่"},
+	"sign-in was declined at the provider":         {"th": "การเข้าสู่ระบบถูกปฏิเสธที่ผู้ให้บริการ"},
+	"sign-in with the provider failed":             {"th": "เข้าสู่ระบบกับผู้ให้บริการไม่สำเร็จ"},
+	"provider reported no verified email address":  {"th": "ผู้ให้บริการไม่มีอีเมลที่ยืนยันแล้วของบัญชีนี้"},
+	"provider account is linked to another user":   {"th": "บัญชีของผู้ให้บริการนี้เชื่อมกับผู้ใช้อื่นแล้ว"},
+	"a %s account is already linked":               {"th": "เชื่อมบัญชี %s ไว้แล้ว"},
+	"identity not linked":                          {"th": "ไม่ได้เชื่อมบัญชีนี้ไว้"},
+	"set a password before unlinking the last one": {"th": "กรุณาตั้งรหัสผ่านก่อนยกเลิกการเชื่อมบัญชีสุดท้าย"},
+
+	"email already registered, sign in and link the provider from your profile": {"th": "อีเมลนี้ถูกใช้ลงทะเบียนแล้ว กรุณาเข้าสู่ระบบแล้วเชื่อมบัญชีจากหน้าโปรไฟล์"},
+
+	// client applications of the OpenID Connect provider
+	"client not found":     {"th": "ไม่พบแอปพลิเคชันนี้"},
+	"consent not found":    {"th": "ไม่ได้อนุญาตแอปพลิเคชันนี้ไว้"},
+	"invalid redirect URI": {"th": "ที่อยู่สำหรับส่งกลับไม่ถูกต้อง"},
+
+	"must be an https URL, or http on localhost, without a fragment": {"th": "ต้องเป็น URL แบบ https หรือ http บน localhost และไม่มี fragment"},
+
+	// passwords
+	"password required":                                       {"th": "กรุณาระบุรหัสผ่าน"},
+	"current_password required":                               {"th": "กรุณาระบุรหัสผ่านปัจจุบัน"},
+	"new_password required":                                   {"th": "กรุณาระบุรหัสผ่านใหม่"},
+	"password too short (min %d characters)":                  {"th": "รหัสผ่านสั้นเกินไป (อย่างน้อย %d ตัวอักษร)"},
+	"password too long (max %d bytes)":                        {"th": "รหัสผ่านยาวเกินไป (ไม่เกิน %d ไบต์)"},
+	"password must contain at least one letter and one digit": {"th": "รหัสผ่านต้องมีตัวอักษรและตัวเลขอย่างน้อยอย่างละหนึ่งตัว"},
+	"password must not be the email address":                  {"th": "รหัสผ่านต้องไม่ซ้ำกับอีเมล"},
+	"new password must differ from the current one":           {"th": "รหัสผ่านใหม่ต้องไม่ซ้ำกับรหัสผ่านปัจจุบัน"},
+
+	// profile
+	"first_name/last_name too long (max 100)":              {"th": "first_name/last_name ยาวเกินไป (ไม่เกิน 100 ตัวอักษร)"},
+	"phone too long (max 20)":                              {"th": "เบอร์โทรศัพท์ยาวเกินไป (ไม่เกิน 20 ตัวอักษร)"},
+	"phone contains invalid characters":                    {"th": "เบอร์โทรศัพท์มีอักขระที่ไม่ถูกต้อง"},
+	"phone is not a valid phone number":                    {"th": "เบอร์โทรศัพท์ไม่ถูกต้อง"},
+	"missing avatar file":                                  {"th": "กรุณาแนบไฟล์รูปโปรไฟล์"},
+	"file too large (max 5MB)":                             {"th": "ไฟล์มีขนาดใหญ่เกินไป (ไม่เกิน 5MB)"},
+	"unsupported file extension":                           {"th": "ไม่รองรับนามสกุลไฟล์นี้"},
+	"username must be 3-30 letters, digits or underscores": {"th": "ชื่อผู้ใช้ต้องประกอบด้วยตัวอักษร ตัวเลข หรือขีดล่าง 3-30 ตัว"},
+	"username is reserved":                                 {"th": "ไม่สามารถใช้ชื่อผู้ใช้นี้ได้"},
+	"username already taken":                               {"th": "ชื่อผู้ใช้นี้ถูกใช้แล้ว"},
+	"field cannot be shared: %s":                           {"th": "ไม่สามารถเปิดเผยฟิลด์นี้ได้: %s"},
+	"attribute cannot be shared: %s":                       {"th": "ไม่สามารถเปิดเผยข้อมูลนี้ได้: %s"},
+	"visibility must be public or private":                 {"th": "การมองเห็นต้องเป็น public หรือ private"},
+	"attribute already exists":                             {"th": "มีข้อมูลนี้อยู่แล้ว"},
+	"attribute not found":                                  {"th": "ไม่พบข้อมูลนี้"},
+
+	// preferences
+	"locale must be a BCP 47 language tag, e.g. th or en-US":        {"th": "locale ต้องเป็นรหัสภาษาตาม BCP 47 เช่น th หรือ en-US"},
+	"timezone must be an IANA time zone, e.g. Asia/Bangkok":         {"th": "timezone ต้องเป็นเขตเวลาตาม IANA เช่น Asia/Bangkok"},
+	"date_format must be one of DD/MM/YYYY, MM/DD/YYYY, YYYY-MM-DD": {"th": "date_format ต้องเป็น DD/MM/YYYY, MM/DD/YYYY หรือ YYYY-MM-DD"},
+
+	// phone verification
+	"no phone number on profile":                       {"th": "ยังไม่ได้ระบุเบอร์โทรศัพท์ในโปรไฟล์"},
+	"phone already verified":                           {"th": "ยืนยันเบอร์โทรศัพท์แล้ว"},
+	"verification code recently sent, try again later": {"th": "เพิ่งส่งรหัสยืนยันไป กรุณาลองใหม่ภายหลัง"},
+	"code required":                                    {"th": "กรุณาระบุรหัสยืนยัน"},
+	"no pending verification for current phone":        {"th": "ไม่มีรหัสยืนยันที่รอดำเนินการสำหรับเบอร์โทรศัพท์ปัจจุบัน"},
+	"verification code expired, request a new one":     {"th": "รหัสยืนยันหมดอายุแล้ว กรุณาขอรหัสใหม่"},
+	"invalid verification code":                        {"th": "รหัสยืนยันไม่ถูกต้อง"},
+	"failed to send verification code":                 {"th": "ไม่สามารถส่งรหัสยืนยันได้"},
+
+	// email change
+	"new_email required":                     {"th": "กรุณาระบุ new_email"},
+	"new_email is not a valid email address": {"th": "new_email ไม่ใช่อีเมลที่ถูกต้อง"},
+	"new_email is the current email":         {"th": "new_email ซ้ำกับอีเมลปัจจุบัน"},
+	"token required":                         {"th": "กรุณาระบุโทเค็น"},
+	"invalid or expired token":               {"th": "โทเค็นไม่ถูกต้องหรือหมดอายุแล้ว"},
+	"failed to send confirmation email":      {"th": "ไม่สามารถส่งอีเมลยืนยันได้"},
+
+	// data export
+	"export not found":  {"th": "ไม่พบไฟล์ข้อมูล"},
+	"export expired":    {"th": "ไฟล์ข้อมูลหมดอายุแล้ว"},
+	"invalid signature": {"th": "ลิงก์ไม่ถูกต้อง"},
+	"link expired":      {"th": "ลิงก์หมดอายุแล้ว"},
+	"asset not found":   {"th": "ไม่พบไฟล์"},
+
+	// server errors
+	"failed to build spec":               {"th": "สร้างเอกสาร API ไม่สำเร็จ"},
+	"failed to confirm email change":     {"th": "ยืนยันการเปลี่ยนอีเมลไม่สำเร็จ"},
+	"failed to create API key":           {"th": "สร้าง API key ไม่สำเร็จ"},
+	"failed to create attribute":         {"th": "สร้างข้อมูลไม่สำเร็จ"},
+	"failed to create user":              {"th": "สร้างผู้ใช้ไม่สำเร็จ"},
+	"failed to delete account":           {"th": "ลบบัญชีไม่สำเร็จ"},
+	"failed to delete attribute":         {"th": "ลบข้อมูลไม่สำเร็จ"},
+	"failed to delete client":            {"th": "ลบแอปพลิเคชันไม่สำเร็จ"},
+	"failed to export users":             {"th": "ส่งออกข้อมูลผู้ใช้ไม่สำเร็จ"},
+	"failed to fetch API keys":           {"th": "ดึงรายการ API key ไม่สำเร็จ"},
+	"failed to fetch clients":            {"th": "ดึงรายการแอปพลิเคชันไม่สำเร็จ"},
+	"failed to fetch consents":           {"th": "ดึงรายการแอปพลิเคชันที่อนุญาตไว้ไม่สำเร็จ"},
+	"failed to fetch identities":         {"th": "ดึงรายการบัญชีที่เชื่อมไว้ไม่สำเร็จ"},
+	"failed to fetch attributes":         {"th": "ดึงข้อมูลเพิ่มเติมไม่สำเร็จ"},
+	"failed to fetch export":             {"th": "ดึงไฟล์ข้อมูลไม่สำเร็จ"},
+	"failed to fetch history":            {"th": "ดึงประวัติไม่สำเร็จ"},
+	"failed to fetch preferences":        {"th": "ดึงการตั้งค่าไม่สำเร็จ"},
+	"failed to fetch user":               {"th": "ดึงข้อมูลผู้ใช้ไม่สำเร็จ"},
+	"failed to fetch verification":       {"th": "ดึงข้อมูลการยืนยันไม่สำเร็จ"},
+	"failed to fetch visibility":         {"th": "ดึงการตั้งค่าการมองเห็นไม่สำเร็จ"},
+	"failed to generate code":            {"th": "สร้างรหัสยืนยันไม่สำเร็จ"},
+	"failed to generate token":           {"th": "สร้างโทเค็นไม่สำเร็จ"},
+	"failed to hash password":            {"th": "ประมวลผลรหัสผ่านไม่สำเร็จ"},
+	"failed to import users":             {"th": "นำเข้าผู้ใช้ไม่สำเร็จ"},
+	"failed to issue authorization code": {"th": "ออกรหัสอนุญาตไม่สำเร็จ"},
+	"failed to link identity":            {"th": "เชื่อมบัญชีไม่สำเร็จ"},
+	"failed to load signing keys":        {"th": "โหลดกุญแจสำหรับลงนามไม่สำเร็จ"},
+	"failed to query API key":            {"th": "ค้นหา API key ไม่สำเร็จ"},
+	"failed to query client":             {"th": "ค้นหาแอปพลิเคชันไม่สำเร็จ"},
+	"failed to query consent":            {"th": "ค้นหาการอนุญาตไม่สำเร็จ"},
+	"failed to query user":               {"th": "ค้นหาผู้ใช้ไม่สำเร็จ"},
+	"failed to read asset":               {"th": "อ่านไฟล์ไม่สำเร็จ"},
+	"failed to record history":           {"th": "บันทึกประวัติไม่สำเร็จ"},
+	"failed to register client":          {"th": "ลงทะเบียนแอปพลิเคชันไม่สำเร็จ"},
+	"failed to render page":              {"th": "แสดงหน้าเว็บไม่สำเร็จ"},
+	"failed to restore account":          {"th": "กู้คืนบัญชีไม่สำเร็จ"},
+	"failed to revoke API key":           {"th": "เพิกถอน API key ไม่สำเร็จ"},
+	"failed to revoke consent":           {"th": "เพิกถอนการอนุญาตไม่สำเร็จ"},
+	"failed to save consent":             {"th": "บันทึกการอนุญาตไม่สำเร็จ"},
+	"failed to sign in":                  {"th": "เข้าสู่ระบบไม่สำเร็จ"},
+	"failed to sign token":               {"th": "ออกโทเค็นไม่สำเร็จ"},
+	"failed to start export":             {"th": "เริ่มส่งออกข้อมูลไม่สำเร็จ"},
+	"failed to start sign-in":            {"th": "เริ่มเข้าสู่ระบบไม่สำเร็จ"},
+	"failed to store email change":       {"th": "บันทึกคำขอเปลี่ยนอีเมลไม่สำเร็จ"},
+	"failed to store sign-in link":       {"th": "บันทึกลิงก์เข้าสู่ระบบไม่สำเร็จ"},
+	"failed to store verification code":  {"th": "บันทึกรหัสยืนยันไม่สำเร็จ"},
+	"failed to unlink identity":          {"th": "ยกเลิกการเชื่อมบัญชีไม่สำเร็จ"},
+	"failed to update attribute":         {"th": "แก้ไขข้อมูลไม่สำเร็จ"},
+	"failed to update attributes":        {"th": "แก้ไขข้อมูลเพิ่มเติมไม่สำเร็จ"},
+	"failed to update avatar":            {"th": "อัปเดตรูปโปรไฟล์ไม่สำเร็จ"},
+	"failed to update password":          {"th": "เปลี่ยนรหัสผ่านไม่สำเร็จ"},
+	"failed to update preferences":       {"th": "บันทึกการตั้งค่าไม่สำเร็จ"},
+	"failed to update profile":           {"th": "อัปเดตโปรไฟล์ไม่สำเร็จ"},
+	"failed to update username":          {"th": "เปลี่ยนชื่อผู้ใช้ไม่สำเร็จ"},
+	"failed to update visibility":        {"th": "บันทึกการตั้งค่าการมองเห็นไม่สำเร็จ"},
+	"failed to verify phone":             {"th": "ยืนยันเบอร์โทรศัพท์ไม่สำเร็จ"},
+}
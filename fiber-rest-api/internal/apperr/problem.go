@@ -0,0 +1,94 @@
+package apperr
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MIMEProblemJSON is the media type of error responses.
+const MIMEProblemJSON = "application/problem+json"
+
+// RequestIDKey is the Locals key the request ID middleware stores the ID under.
+const RequestIDKey = "requestid"
+
+// typeBase prefixes the code to form the problem type URI.
+const typeBase = "urn:fiber-rest-api:problem:"
+
+// Problem is the RFC 7807 body of every error response.
+type Problem struct {
+	Type      string       `json:"type" openapi:"required" doc:"URI of the problem type, derived from code"`
+	Title     string       `json:"title" openapi:"required" doc:"HTTP status text, translated"`
+	Status    int          `json:"status" openapi:"required"`
+	Detail    string       `json:"detail,omitempty" doc:"human-readable explanation, translated; do not match on it"`
+	Instance  string       `json:"instance,omitempty" doc:"path of the request"`
+	Code      Code         `json:"code" openapi:"required"`
+	RequestID string       `json:"request_id,omitempty" doc:"also sent in the X-Request-ID header; quote it when reporting a problem"`
+	Errors    []FieldError `json:"errors,omitempty" doc:"invalid values of a request rejected with invalid_request"`
+}
+
+// OpenAPIName names the schema of Code.
+func (Code) OpenAPIName() string {
+	return "ErrorCode"
+}
+
+// OpenAPISchema lists every code.
+func (Code) OpenAPISchema() map[string]interface{} {
+	codes := make([]string, 0, len(statuses))
+	for c := range statuses {
+		codes = append(codes, string(c))
+	}
+	sort.Strings(codes)
+	return map[string]interface{}{
+		"type":        "string",
+		"description": "stable, machine-readable error code",
+		"enum":        codes,
+	}
+}
+
+// NoRoute answers requests that match no route; it must be registered after every
+// route. Fiber would otherwise answer them with plain text.
+func NoRoute(c *fiber.Ctx) error {
+	return Newf(NotFound, "no route for %s %s", c.Method(), c.Path())
+}
+
+// Handler is the Fiber ErrorHandler rendering errors returned by handlers as problem
+// documents. The causes of 5xx errors are logged with the request ID.
+func Handler(c *fiber.Ctx, err error) error {
+	e := From(err)
+	lang := Language(c)
+	requestID, _ := c.Locals(RequestIDKey).(string)
+	if e.Status >= 500 {
+		log.Printf("%s %s failed (request %s): %v", c.Method(), c.Path(), requestID, e)
+	}
+
+	p := Problem{
+		Type:      typeBase + string(e.Code),
+		Title:     translate(lang, http.StatusText(e.Status), nil),
+		Status:    e.Status,
+		Detail:    e.Message(lang),
+		Instance:  c.Path(),
+		Code:      e.Code,
+		RequestID: requestID,
+	}
+	for _, f := range e.Fields {
+		if f.format != "" {
+			f.Message = translate(lang, f.format, f.args)
+		} else {
+			f.Message = translate(lang, f.Message, nil)
+		}
+		p.Errors = append(p.Errors, f)
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	c.Vary(fiber.HeaderAcceptLanguage)
+	c.Set(fiber.HeaderContentLanguage, lang)
+	c.Set(fiber.HeaderContentType, MIMEProblemJSON)
+	return c.Status(e.Status).Send(body)
+}
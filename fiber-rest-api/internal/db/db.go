@@ -1,38 +1,524 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"fiber-rest-api/internal/phone"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
 
-// Init opens (and creates if needed) the sqlite database file and ensures the users table exists.
+// Init opens the database at path and brings its schema up to date.
 func Init(path string) error {
+	if err := Open(path); err != nil {
+		return err
+	}
+	_, err := Migrate()
+	return err
+}
+
+// Open opens (and creates if needed) the sqlite database file without changing its
+// schema.
+func Open(path string) error {
 	var err error
-	DB, err = sql.Open("sqlite3", path)
+	// foreign keys are off by default in sqlite; enable them so ON DELETE CASCADE applies
+	DB, err = sql.Open("sqlite3", path+"?_foreign_keys=on")
 	if err != nil {
 		return err
 	}
 	// simple ping to validate
-	if err := DB.Ping(); err != nil {
-		return err
+	return DB.Ping()
+}
+
+// Migrate ensures the users table exists, brings databases created by older versions
+// up to date and creates the other tables. It returns the changes it made, such as
+// "added column users.phone".
+func Migrate() ([]string, error) {
+	if _, err := DB.Exec(usersTable); err != nil {
+		return nil, err
+	}
+	changes, err := addMissingColumns()
+	if err != nil {
+		return changes, err
+	}
+	rebuilt, err := makePasswordOptional()
+	if err != nil {
+		return changes, err
+	}
+	if rebuilt {
+		changes = append(changes, "made users.password optional")
+	}
+	for _, stmt := range tables {
+		if _, err := DB.Exec(stmt); err != nil {
+			return changes, err
+		}
+	}
+	millis, err := passwordChangesToMillis()
+	if err != nil {
+		return changes, err
+	}
+	if millis > 0 {
+		changes = append(changes, fmt.Sprintf("converted %d password change times to milliseconds", millis))
+	}
+	normalized, cleared, err := normalizePhones()
+	if err != nil {
+		return changes, err
+	}
+	if normalized > 0 {
+		changes = append(changes, fmt.Sprintf("normalized %d phone numbers to E.164", normalized))
 	}
+	if cleared > 0 {
+		changes = append(changes, fmt.Sprintf("cleared %d phone numbers that could not be read", cleared))
+	}
+	return changes, nil
+}
 
-	create := `CREATE TABLE IF NOT EXISTS users (
+// usersTable creates the users table. password is NULL for accounts without one,
+// such as those created by signing in with a provider or an emailed link.
+// password_changed_at is in Unix milliseconds, the other times in Unix seconds.
+const usersTable = `CREATE TABLE IF NOT EXISTS users (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		email TEXT NOT NULL UNIQUE,
-		password TEXT NOT NULL,
+		password TEXT,
 		first_name TEXT,
 		last_name TEXT,
 		phone TEXT,
-		avatar TEXT
+		avatar TEXT,
+		version INTEGER NOT NULL DEFAULT 1,
+		phone_display TEXT,
+		phone_verified_at INTEGER,
+		password_changed_at INTEGER,
+		role TEXT NOT NULL DEFAULT 'user',
+		username TEXT,
+		delete_after INTEGER,
+		disabled_at INTEGER,
+		email_verified_at INTEGER
 	);`
-	if _, err := DB.Exec(create); err != nil {
-		return err
+
+// tables holds the schema of tables that hang off users, plus indexes on columns
+// that may have been added by columnMigrations.
+var tables = []string{
+	// usernames are unique regardless of case; users without one (NULL) are not constrained
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_username_nocase ON users (username COLLATE NOCASE);`,
+	// pending SMS verification codes, at most one per user
+	`CREATE TABLE IF NOT EXISTS phone_verifications (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		phone TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);`,
+	// pending email address changes, confirmed through a link sent to new_email
+	`CREATE TABLE IF NOT EXISTS email_changes (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		old_email TEXT NOT NULL,
+		new_email TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);`,
+	// pending passwordless sign-ins, by SHA-256 of the emailed link's token, at most
+	// one per address. email need not belong to an account: using the link creates
+	// one. redirect is the path the link sends the token to.
+	`CREATE TABLE IF NOT EXISTS login_links (
+		token_hash TEXT PRIMARY KEY,
+		email TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		redirect TEXT,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS login_links_email ON login_links (email);`,
+	// admin-defined custom profile attributes and their per-user values (JSON encoded)
+	`CREATE TABLE IF NOT EXISTS profile_attributes (
+		key TEXT PRIMARY KEY,
+		label TEXT NOT NULL,
+		type TEXT NOT NULL,
+		required INTEGER NOT NULL DEFAULT 0,
+		min REAL,
+		max REAL,
+		pattern TEXT,
+		options TEXT,
+		visibility TEXT NOT NULL DEFAULT 'private',
+		created_at INTEGER NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS profile_attribute_values (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		key TEXT NOT NULL REFERENCES profile_attributes(key) ON DELETE CASCADE,
+		value TEXT NOT NULL,
+		PRIMARY KEY (user_id, key)
+	);`,
+	// fields of a profile the owner chose to make public; anything absent is private
+	`CREATE TABLE IF NOT EXISTS profile_visibility (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		field TEXT NOT NULL,
+		PRIMARY KEY (user_id, field)
+	);`,
+	`CREATE TABLE IF NOT EXISTS user_preferences (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		locale TEXT NOT NULL,
+		timezone TEXT NOT NULL,
+		date_format TEXT NOT NULL,
+		notify_security_email INTEGER NOT NULL DEFAULT 1,
+		updated_at INTEGER NOT NULL
+	);`,
+	// append-only audit trail of profile changes. user_id has no foreign key so the
+	// trail of a deleted account is kept until it is erased explicitly.
+	`CREATE TABLE IF NOT EXISTS profile_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		actor_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		field TEXT NOT NULL,
+		old_value TEXT,
+		new_value TEXT,
+		ip TEXT NOT NULL,
+		user_agent TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		admin_only INTEGER NOT NULL DEFAULT 0
+	);`,
+	`CREATE INDEX IF NOT EXISTS profile_history_user ON profile_history (user_id, id);`,
+	// login attempts (successful or with a wrong password) against existing accounts
+	`CREATE TABLE IF NOT EXISTS login_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		success INTEGER NOT NULL,
+		ip TEXT NOT NULL,
+		user_agent TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS login_events_user ON login_events (user_id, id);`,
+	// accounts at OpenID Connect providers that users sign in with, at most one per
+	// provider and user
+	`CREATE TABLE IF NOT EXISTS user_identities (
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		email TEXT,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (provider, subject),
+		UNIQUE (user_id, provider)
+	);`,
+	// applications that sign users in with this server as their OpenID Connect
+	// provider; public clients have no secret. redirect_uris is a JSON array.
+	`CREATE TABLE IF NOT EXISTS oauth_clients (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		secret_hash TEXT,
+		redirect_uris TEXT NOT NULL,
+		trusted INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL
+	);`,
+	// scopes each user allowed each client on the consent screen
+	`CREATE TABLE IF NOT EXISTS oauth_consents (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+		scope TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, client_id)
+	);`,
+	// authorization codes waiting to be redeemed, by SHA-256 of the code
+	`CREATE TABLE IF NOT EXISTS oauth_codes (
+		code_hash TEXT PRIMARY KEY,
+		client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		redirect_uri TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		nonce TEXT,
+		code_challenge TEXT,
+		code_challenge_method TEXT,
+		auth_time INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);`,
+	// keys that sign ID and access tokens; the newest signs, all are published
+	`CREATE TABLE IF NOT EXISTS oauth_signing_keys (
+		id TEXT PRIMARY KEY,
+		private_key TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);`,
+	// API keys users created for scripts, by SHA-256 of the key; scopes is
+	// space-separated
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		hint TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		last_used_at INTEGER,
+		expires_at INTEGER
+	);`,
+	// personal data export archives; file is relative to the exports directory
+	`CREATE TABLE IF NOT EXISTS data_exports (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		status TEXT NOT NULL,
+		file TEXT,
+		created_at INTEGER NOT NULL,
+		completed_at INTEGER,
+		expires_at INTEGER
+	);`,
+	`CREATE TRIGGER IF NOT EXISTS profile_history_no_update BEFORE UPDATE ON profile_history
+	BEGIN
+		SELECT RAISE(ABORT, 'profile_history is append-only');
+	END;`,
+	// rows may only be removed once the account they describe is gone
+	`CREATE TRIGGER IF NOT EXISTS profile_history_no_delete BEFORE DELETE ON profile_history
+	WHEN EXISTS (SELECT 1 FROM users WHERE id = OLD.user_id)
+	BEGIN
+		SELECT RAISE(ABORT, 'profile_history is append-only');
+	END;`,
+}
+
+// passwordChangesToMillis converts password change times stored in Unix seconds to
+// milliseconds, so that tokens issued in the second of a change can be told apart.
+// Values below 1e11 are seconds: as milliseconds they would predate 1974.
+func passwordChangesToMillis() (int, error) {
+	res, err := DB.Exec("UPDATE users SET password_changed_at = password_changed_at * 1000 WHERE password_changed_at < 100000000000")
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// normalizePhones converts phone numbers stored before they were normalized on input
+// to E.164, keeping them as entered in phone_display. Numbers that cannot be read
+// are cleared and logged, so that their owners are asked for them again.
+func normalizePhones() (normalized, cleared int, err error) {
+	// numbers already in E.164 are left alone
+	rows, err := DB.Query(`SELECT id, phone FROM users WHERE phone IS NOT NULL
+		AND (phone NOT GLOB '+[1-9]*' OR substr(phone, 2) GLOB '*[^0-9]*')`)
+	if err != nil {
+		return 0, 0, err
+	}
+	type stored struct {
+		id    int
+		phone string
+	}
+	var list []stored
+	for rows.Next() {
+		var s stored
+		if err := rows.Scan(&s.id, &s.phone); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		list = append(list, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(list) == 0 {
+		return 0, 0, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	for _, s := range list {
+		number, nerr := phone.Normalize(s.phone, phone.Region())
+		if nerr != nil {
+			if _, err := tx.Exec("UPDATE users SET phone = NULL, phone_display = NULL, phone_verified_at = NULL, version = version + 1 WHERE id = ?", s.id); err != nil {
+				return 0, 0, err
+			}
+			// blank numbers were no number at all
+			if nerr != phone.ErrEmpty {
+				log.Printf("db: cleared phone %q of user %d: %v", s.phone, s.id, nerr)
+				cleared++
+			}
+			continue
+		}
+		if _, err := tx.Exec("UPDATE users SET phone = ?, phone_display = COALESCE(phone_display, ?), version = version + 1 WHERE id = ?",
+			number, strings.TrimSpace(s.phone), s.id); err != nil {
+			return 0, 0, err
+		}
+		normalized++
+	}
+	return normalized, cleared, tx.Commit()
+}
+
+// columnMigrations lists columns added after a table was first created. They are
+// applied with ALTER TABLE to databases that predate them.
+var columnMigrations = []struct {
+	table, column, definition string
+}{
+	{"users", "first_name", "TEXT"},
+	{"users", "last_name", "TEXT"},
+	{"users", "phone", "TEXT"},
+	{"users", "avatar", "TEXT"},
+	{"users", "version", "INTEGER NOT NULL DEFAULT 1"},
+	{"users", "phone_display", "TEXT"},
+	{"users", "phone_verified_at", "INTEGER"},
+	{"users", "password_changed_at", "INTEGER"},
+	{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+	{"users", "username", "TEXT"},
+	{"users", "delete_after", "INTEGER"},
+	{"users", "disabled_at", "INTEGER"},
+	{"users", "email_verified_at", "INTEGER"},
+}
+
+// addMissingColumns brings existing tables up to date with columnMigrations and
+// returns the columns it added.
+func addMissingColumns() ([]string, error) {
+	var added []string
+	for _, m := range columnMigrations {
+		exists, err := hasColumn(m.table, m.column)
+		if err != nil {
+			return added, err
+		}
+		if exists {
+			continue
+		}
+		if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)); err != nil {
+			return added, fmt.Errorf("add column %s.%s: %w", m.table, m.column, err)
+		}
+		added = append(added, "added column "+m.table+"."+m.column)
+	}
+	return added, nil
+}
+
+func hasColumn(table, column string) (bool, error) {
+	exists, _, err := columnInfo(table, column)
+	return exists, err
+}
+
+// columnInfo reports whether table has the column and whether it is NOT NULL.
+func columnInfo(table, column string) (exists, notNull bool, err error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid, nn, pk int
+			name, ctype string
+			dflt        sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &nn, &dflt, &pk); err != nil {
+			return false, false, err
+		}
+		if name == column {
+			return true, nn != 0, nil
+		}
+	}
+	return false, false, rows.Err()
+}
+
+// userColumns lists the columns of usersTable, which makePasswordOptional copies.
+const userColumns = "id, email, password, first_name, last_name, phone, avatar, version, phone_display, " +
+	"phone_verified_at, password_changed_at, role, username, delete_after, disabled_at, email_verified_at"
+
+// makePasswordOptional rebuilds a users table whose password column is NOT NULL, as
+// in databases created before accounts could have no password, and reports whether
+// it did. sqlite cannot drop the constraint in place, so the rows are copied to a
+// new table, with the empty passwords that stood in for none turned into NULL. It
+// runs after addMissingColumns, so that every column of usersTable exists.
+func makePasswordOptional() (bool, error) {
+	_, notNull, err := columnInfo("users", "password")
+	if err != nil || !notNull {
+		return false, err
+	}
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	// dropping users would otherwise delete every row that references it; the pragma
+	// has no effect inside a transaction
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return false, err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var seq sql.NullInt64
+	if err := tx.QueryRow("SELECT seq FROM sqlite_sequence WHERE name = 'users'").Scan(&seq); err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	stmts := []string{
+		// the trigger refers to users, which would make renaming the new table
+		// fail while users is missing; tables creates it again
+		"DROP TRIGGER IF EXISTS profile_history_no_delete",
+		strings.Replace(usersTable, "TABLE IF NOT EXISTS users", "TABLE users_new", 1),
+		"INSERT INTO users_new (" + userColumns + ") SELECT " +
+			strings.Replace(userColumns, "password,", "NULLIF(password, ''),", 1) + " FROM users",
+		"DROP TABLE users",
+		"ALTER TABLE users_new RENAME TO users",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return false, fmt.Errorf("make users.password optional: %w", err)
+		}
+	}
+	// keep ids of deleted accounts from being handed out again
+	if seq.Valid {
+		if _, err := tx.Exec("UPDATE sqlite_sequence SET seq = MAX(seq, ?) WHERE name = 'users'", seq.Int64); err != nil {
+			return false, err
+		}
+	}
+	var broken int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM pragma_foreign_key_check").Scan(&broken); err != nil {
+		return false, err
+	}
+	if broken > 0 {
+		return false, fmt.Errorf("make users.password optional: %d rows reference missing rows", broken)
+	}
+	return true, tx.Commit()
+}
+
+// Verify runs sqlite's integrity and foreign key checks and returns the problems they
+// report, if any.
+func Verify() ([]string, error) {
+	var problems []string
+	rows, err := DB.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = DB.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return nil, err
+		}
+		problems = append(problems, fmt.Sprintf("%s row %d references a missing %s row", table, rowid.Int64, parent))
+	}
+	return problems, rows.Err()
 }
 
 func Close() error {
@@ -0,0 +1,117 @@
+package db_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"fiber-rest-api/internal/db"
+)
+
+// Phone numbers stored before they were normalized on input are converted when the
+// schema is brought up to date, and those that cannot be read are cleared.
+func TestMigrateNormalizesPhones(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "data.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	t.Setenv("PHONE_DEFAULT_REGION", "TH")
+
+	stored := []struct {
+		phone, display interface{}
+	}{
+		{"081-234 5678", nil},
+		{"+66 2 123 4567", "02-123-4567"},
+		{"+66812345678", "081 234 5678"},
+		{"call me", nil},
+		{"  ", nil},
+		{nil, nil},
+	}
+	for i, s := range stored {
+		if _, err := db.DB.Exec("INSERT INTO users (email, phone, phone_display) VALUES (?, ?, ?)",
+			string(rune('a'+i))+"@example.com", s.phone, s.display); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, err := db.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"normalized 2 phone numbers to E.164", "cleared 1 phone numbers that could not be read"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes %q, want %q", changes, want)
+	}
+
+	rows, err := db.DB.Query("SELECT phone, phone_display FROM users ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got [][2]sql.NullString
+	for rows.Next() {
+		var p [2]sql.NullString
+		if err := rows.Scan(&p[0], &p[1]); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p)
+	}
+	str := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	wantRows := [][2]sql.NullString{
+		{str("+66812345678"), str("081-234 5678")},
+		{str("+6621234567"), str("02-123-4567")},
+		{str("+66812345678"), str("081 234 5678")},
+		{}, {}, {},
+	}
+	if !reflect.DeepEqual(got, wantRows) {
+		t.Errorf("phones %v, want %v", got, wantRows)
+	}
+
+	if changes, err := db.Migrate(); err != nil || len(changes) != 0 {
+		t.Errorf("second run: %q, %v", changes, err)
+	}
+}
+
+// Password change times stored in seconds are converted to milliseconds once.
+func TestMigratePasswordChangeMillis(t *testing.T) {
+	if err := db.Init(filepath.Join(t.TempDir(), "data.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i, changed := range []interface{}{int64(1700000000), int64(1700000000123), nil} {
+		if _, err := db.DB.Exec("INSERT INTO users (email, password_changed_at) VALUES (?, ?)",
+			string(rune('a'+i))+"@example.com", changed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, err := db.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"converted 1 password change times to milliseconds"}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes %q, want %q", changes, want)
+	}
+	rows, err := db.DB.Query("SELECT password_changed_at FROM users ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []sql.NullInt64
+	for rows.Next() {
+		var v sql.NullInt64
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	want := []sql.NullInt64{{Int64: 1700000000000, Valid: true}, {Int64: 1700000000123, Valid: true}, {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("password_changed_at %v, want %v", got, want)
+	}
+
+	if changes, err := db.Migrate(); err != nil || len(changes) != 0 {
+		t.Errorf("second run: %q, %v", changes, err)
+	}
+}
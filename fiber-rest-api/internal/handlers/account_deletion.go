@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultDeletionGrace is how long a closed account can still be restored by logging in.
+const defaultDeletionGrace = 30 * 24 * time.Hour
+
+// AccountDeletion is the body of DELETE /profile, which accounts without a password
+// can leave out.
+type AccountDeletion struct {
+	Password string `json:"password,omitempty" doc:"required unless the account has no password, in which case it must have signed in within the last 10 minutes"`
+}
+
+// AccountDeletionResponse is the body of a successful DELETE /profile.
+type AccountDeletionResponse struct {
+	Message     string `json:"message"`
+	DeleteAfter int64  `json:"delete_after" doc:"unix seconds"`
+}
+
+// deletionGrace reads ACCOUNT_DELETION_GRACE (a Go duration such as "720h").
+func deletionGrace() time.Duration {
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("invalid ACCOUNT_DELETION_GRACE %q, using %s", v, defaultDeletionGrace)
+	}
+	return defaultDeletionGrace
+}
+
+// DeleteAccount closes the current user's account after checking the password, or
+// for accounts without one that they signed in recently (checkReauthentication). The
+// account is erased once the grace period ends; logging in before then restores it.
+// All tokens stop working immediately.
+func DeleteAccount(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	// accounts without a password have nothing to send
+	var req AccountDeletion
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apperr.New(apperr.MalformedBody, "invalid request body")
+		}
+	}
+
+	user, err := users.Get(db.DB, uid)
+	switch err {
+	case users.ErrNotFound:
+		return apperr.New(apperr.NotFound, "user not found")
+	case nil:
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch user")
+	}
+	if err := checkReauthentication(c, user, req.Password); err != nil {
+		return err
+	}
+	email := user.Email
+
+	deleteAfter := time.Now().Add(deletionGrace())
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to delete account")
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE users SET delete_after = ? WHERE id = ?", deleteAfter.Unix(), uid); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to delete account")
+	}
+	when := deleteAfter.UTC().Format(time.RFC3339)
+	if err := recordProfileEvent(tx, c, uid, uid, "account.delete", "delete_after", nil, &when); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to record history")
+	}
+	if err := tx.Commit(); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to delete account")
+	}
+
+	// sent regardless of notification settings so a hijacked account can be recovered
+	if prefs, err := loadPreferences(uid); err == nil {
+		sendUserMail(email, prefs, "account_deletion_scheduled", map[string]interface{}{
+			"DeleteAfter": deleteAfter,
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(AccountDeletionResponse{
+		Message:     "account scheduled for deletion; log in before delete_after to restore it",
+		DeleteAfter: deleteAfter.Unix(),
+	})
+}
+
+// restoreAccount cancels a pending deletion of user uid and reports whether there was
+// one. It is called by Login.
+func restoreAccount(c *fiber.Ctx, uid int) (bool, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var deleteAfter sql.NullInt64
+	if err := tx.QueryRow("SELECT delete_after FROM users WHERE id = ?", uid).Scan(&deleteAfter); err != nil {
+		return false, err
+	}
+	if !deleteAfter.Valid {
+		return false, nil
+	}
+	if _, err := tx.Exec("UPDATE users SET delete_after = NULL WHERE id = ?", uid); err != nil {
+		return false, err
+	}
+	old := time.Unix(deleteAfter.Int64, 0).UTC().Format(time.RFC3339)
+	if err := recordProfileEvent(tx, c, uid, uid, "account.restore", "delete_after", &old, nil); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// PurgeDeletedAccounts erases every account whose grace period has ended: the users
+// row and, through foreign keys, everything hanging off it, plus its profile history,
+// avatar files and export archives. It returns the number of accounts erased.
+func PurgeDeletedAccounts() (int, error) {
+	rows, err := db.DB.Query("SELECT id FROM users WHERE delete_after IS NOT NULL AND delete_after <= ?", time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	erased := 0
+	for _, uid := range ids {
+		ok, err := eraseAccount(uid)
+		if err != nil {
+			return erased, fmt.Errorf("erase user %d: %w", uid, err)
+		}
+		if ok {
+			erased++
+		}
+	}
+	return erased, nil
+}
+
+// eraseAccount permanently removes user uid and reports whether it did; the account
+// is kept if it was restored in the meantime. Files are deleted after the database
+// changes are committed so a failed transaction leaves the account intact.
+func eraseAccount(uid int) (bool, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var exports []string
+	rows, err := tx.Query("SELECT file FROM data_exports WHERE user_id = ? AND file IS NOT NULL", uid)
+	if err != nil {
+		return false, err
+	}
+	for rows.Next() {
+		var f string
+		if err := rows.Scan(&f); err != nil {
+			rows.Close()
+			return false, err
+		}
+		exports = append(exports, f)
+	}
+	rows.Close()
+
+	// re-check inside the transaction: the user may have logged in meanwhile
+	res, err := tx.Exec("DELETE FROM users WHERE id = ? AND delete_after IS NOT NULL AND delete_after <= ?", uid, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	// allowed by the profile_history_no_delete trigger now that the user is gone
+	if _, err := tx.Exec("DELETE FROM profile_history WHERE user_id = ?", uid); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	// every avatar ever uploaded, including ones no longer referenced
+	avatars, _ := filepath.Glob(filepath.Join("uploads", fmt.Sprintf("u%d_*", uid)))
+	for _, f := range avatars {
+		os.Remove(f)
+	}
+	for _, f := range exports {
+		os.Remove(filepath.Join(exportsDir, filepath.Base(f)))
+	}
+	return true, nil
+}
+
+// RunAccountPurge calls PurgeDeletedAccounts every interval until stop is closed.
+// It also deletes expired data exports, which otherwise would only go when someone
+// requests a new one.
+func RunAccountPurge(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := PurgeDeletedAccounts(); err != nil {
+			log.Printf("account purge: %v", err)
+		} else if n > 0 {
+			log.Printf("account purge: erased %d account(s)", n)
+		}
+		purgeExpiredExports()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/userio"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// adminTargetID reads the :id route parameter of the admin user endpoints.
+func adminTargetID(c *fiber.Ctx) (int, bool) {
+	id, err := c.ParamsInt("id")
+	return id, err == nil && id > 0
+}
+
+// AdminGetUser returns any user's profile, including admin-only attributes.
+func AdminGetUser(c *fiber.Ctx) error {
+	uid, ok := adminTargetID(c)
+	if !ok {
+		return apperr.New(apperr.InvalidRequest, "invalid user id")
+	}
+	return writeProfile(c, uid, true)
+}
+
+// AdminPatchUser applies a JSON Merge Patch to any user's profile, like PatchProfile.
+// Changes are recorded in the user's history with the admin as actor.
+func AdminPatchUser(c *fiber.Ctx) error {
+	actorID, _ := currentUserID(c)
+	uid, ok := adminTargetID(c)
+	if !ok {
+		return apperr.New(apperr.InvalidRequest, "invalid user id")
+	}
+	return patchProfile(c, uid, actorID, true)
+}
+
+// AdminUserHistory returns the complete change history of any user's profile.
+func AdminUserHistory(c *fiber.Ctx) error {
+	uid, ok := adminTargetID(c)
+	if !ok {
+		return apperr.New(apperr.InvalidRequest, "invalid user id")
+	}
+	return writeHistory(c, uid, true)
+}
+
+// ExportUsers answers with every user as CSV or JSON Lines, chosen by the "format"
+// query parameter, with the columns listed in "columns". Users are streamed as they
+// are read, so an error after the first ones were sent can only be logged, and cuts
+// the file short.
+func ExportUsers(c *fiber.Ctx) error {
+	format, err := userio.ParseFormat(c.Query("format", string(userio.CSV)))
+	if err != nil {
+		return apperr.New(apperr.InvalidRequest, "format must be csv or jsonl")
+	}
+	var columns []string
+	if list := c.Query("columns"); list != "" {
+		columns = strings.Split(list, ",")
+		for _, col := range columns {
+			if userio.CheckColumns([]string{col}) != nil {
+				return apperr.Newf(apperr.InvalidRequest, "unknown column: %s", col)
+			}
+		}
+	}
+	c.Set(fiber.HeaderContentType, format.MediaType())
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="users.%s"`, format))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if _, err := userio.Export(db.DB, w, format, columns); err != nil {
+			log.Printf("export users: %v", err)
+		}
+	})
+	return nil
+}
+
+// defaultImportLimit is the largest import body accepted unless USER_IMPORT_MAX_BYTES
+// says otherwise.
+const defaultImportLimit = 64 << 20
+
+// importLimit reads USER_IMPORT_MAX_BYTES.
+func importLimit() int64 {
+	if v := os.Getenv("USER_IMPORT_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err == nil && n > 0 {
+			return n
+		}
+		log.Printf("invalid USER_IMPORT_MAX_BYTES %q, using %d", v, defaultImportLimit)
+	}
+	return defaultImportLimit
+}
+
+// errImportTooLarge is returned by limitedBody past the import limit.
+var errImportTooLarge = errors.New("import body too large")
+
+// limitedBody reads at most n bytes of r, then fails with errImportTooLarge.
+type limitedBody struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// only an error if there is more to read
+		if k, err := l.r.Read(make([]byte, 1)); k > 0 || err == nil {
+			return 0, errImportTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	k, err := l.r.Read(p)
+	l.n -= int64(k)
+	return k, err
+}
+
+// ImportUsers creates, or with on_conflict=update also updates, the users of a CSV or
+// JSON Lines body and reports the rows that were skipped or failed. With dry_run=true
+// nothing is changed. The body is read as it arrives, up to importLimit bytes; the
+// batches written before a body turns out to be too large are kept.
+func ImportUsers(c *fiber.Ctx) error {
+	actorID, _ := currentUserID(c)
+	ct := strings.ToLower(strings.TrimSpace(strings.SplitN(c.Get(fiber.HeaderContentType), ";", 2)[0]))
+	format, ok := userio.FormatOfMediaType(ct)
+	if !ok {
+		return apperr.New(apperr.UnsupportedMediaType, "content type must be text/csv or application/x-ndjson")
+	}
+	onConflict := c.Query("on_conflict", userio.OnConflictSkip)
+	if onConflict != userio.OnConflictSkip && onConflict != userio.OnConflictUpdate {
+		return apperr.New(apperr.InvalidRequest, "on_conflict must be skip or update")
+	}
+	limit := importLimit()
+	if int64(c.Request().Header.ContentLength()) > limit {
+		return apperr.Newf(apperr.PayloadTooLarge, "import larger than %d bytes", limit)
+	}
+	var body io.Reader
+	if c.Request().IsBodyStream() {
+		body = c.Context().RequestBodyStream()
+	} else {
+		body = bytes.NewReader(c.Body())
+	}
+	r, err := userio.NewReader(&limitedBody{r: body, n: limit}, format)
+	if errors.Is(err, errImportTooLarge) {
+		return apperr.Newf(apperr.PayloadTooLarge, "import larger than %d bytes", limit)
+	}
+	if err != nil {
+		return apperr.Wrap(err, apperr.InvalidRequest, "invalid header row")
+	}
+	report, err := userio.Import(db.DB, r, userio.ImportOptions{
+		DryRun:     c.Query("dry_run") == "true",
+		OnConflict: onConflict,
+		Audit:      users.Event{ActorID: actorID, IP: c.IP(), UserAgent: c.Get(fiber.HeaderUserAgent)},
+	})
+	if errors.Is(err, errImportTooLarge) {
+		return apperr.Newf(apperr.PayloadTooLarge, "import larger than %d bytes", limit)
+	}
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to import users")
+	}
+	return c.JSON(report)
+}
@@ -0,0 +1,71 @@
+package handlers_test
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/userio"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// signUpAdmin registers email as an admin and returns a token of the account.
+func signUpAdmin(t testing.TB, email string) string {
+	t.Helper()
+	token := signUp(t, email)
+	user, err := users.GetByEmail(db.DB, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetRole(db.DB, user.ID, users.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// chunked hides the length of r, so that it is sent with chunked transfer encoding.
+func chunked(r io.Reader) io.Reader {
+	return io.MultiReader(r)
+}
+
+func TestExportUsers(t *testing.T) {
+	token := signUpAdmin(t, "export-admin@example.com")
+	resp, body := do(t, request{method: "GET", path: "/api/v1/admin/users/export?format=csv&columns=email,role", token: token})
+	if resp.StatusCode != fiber.StatusOK || resp.Header.Get(fiber.HeaderContentType) != userio.CSV.MediaType() {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get(fiber.HeaderContentType))
+	}
+	if !strings.HasPrefix(string(body), "email,role\n") || !strings.Contains(string(body), "\nexport-admin@example.com,admin\n") {
+		t.Errorf("export:\n%s", body)
+	}
+	expect(t, request{method: "GET", path: "/api/v1/admin/users/export?columns=email,password", token: token}, fiber.StatusBadRequest, nil)
+}
+
+func TestImportUsersStreamed(t *testing.T) {
+	token := signUpAdmin(t, "import-admin@example.com")
+	import_ := func(body io.Reader) request {
+		return request{method: "POST", path: "/api/v1/admin/users/import", token: token, body: body, contentType: userio.MIMEJSONL}
+	}
+	row := `{"email": "import-streamed@example.com", "password": "` + password + `"}` + "\n"
+
+	var rep userio.ImportReport
+	expect(t, import_(chunked(strings.NewReader(row))), fiber.StatusOK, &rep)
+	if rep.Created != 1 {
+		t.Errorf("report %+v", rep)
+	}
+
+	os.Setenv("USER_IMPORT_MAX_BYTES", "100")
+	defer os.Unsetenv("USER_IMPORT_MAX_BYTES")
+	large := strings.Repeat("\n", 200)
+	expectCode(t, import_(strings.NewReader(large)), fiber.StatusRequestEntityTooLarge, "payload_too_large")
+	expectCode(t, import_(chunked(strings.NewReader(large))), fiber.StatusRequestEntityTooLarge, "payload_too_large")
+}
+
+// Other requests are still limited to the app's BodyLimit.
+func TestBodyLimit(t *testing.T) {
+	body := `{"email": "body-limit@example.com", "password": "` + strings.Repeat("x", fiber.DefaultBodyLimit) + `"}`
+	expectCode(t, request{method: "POST", path: "/api/v1/auth/login", body: chunked(strings.NewReader(body)), contentType: fiber.MIMEApplicationJSON}, fiber.StatusRequestEntityTooLarge, "payload_too_large")
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"fiber-rest-api/internal/apikeys"
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIKeyRequest is the body of POST /profile/api-keys.
+type APIKeyRequest struct {
+	Name      string          `json:"name" openapi:"required,minLength=1,maxLength=100,example=nightly backup"`
+	Scopes    []apikeys.Scope `json:"scopes" openapi:"required,minItems=1"`
+	ExpiresAt int64           `json:"expires_at,omitempty" doc:"Unix time the key stops working at; omit for a key that does not expire"`
+}
+
+// APIKey is an API key of the current user.
+type APIKey struct {
+	ID         int64           `json:"id"`
+	Name       string          `json:"name"`
+	Hint       string          `json:"hint" doc:"start of the key, to tell keys apart" openapi:"example=pat_Xq3v9T"`
+	Scopes     []apikeys.Scope `json:"scopes"`
+	CreatedAt  int64           `json:"created_at"`
+	LastUsedAt int64           `json:"last_used_at,omitempty" doc:"to the minute; absent until the key is used"`
+	ExpiresAt  int64           `json:"expires_at,omitempty"`
+	// Key is only returned when the key is created.
+	Key string `json:"key,omitempty" doc:"only returned on creation; store it, it cannot be shown again"`
+}
+
+// APIKeyList is the body of GET /profile/api-keys.
+type APIKeyList struct {
+	Keys []APIKey `json:"keys"`
+}
+
+func apiKeyResponse(k *apikeys.Key) APIKey {
+	resp := APIKey{
+		ID:        k.ID,
+		Name:      k.Name,
+		Hint:      k.Hint,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt.Unix(),
+	}
+	if !k.LastUsedAt.IsZero() {
+		resp.LastUsedAt = k.LastUsedAt.Unix()
+	}
+	if !k.ExpiresAt.IsZero() {
+		resp.ExpiresAt = k.ExpiresAt.Unix()
+	}
+	return resp
+}
+
+// CheckScope lets API keys call only the operations whose scope they were granted;
+// operations without a scope, such as changing the password or managing keys, need
+// a token from a login. It is the router's openapi.Spec.CheckScope.
+func CheckScope(c *fiber.Ctx, scope string) error {
+	key, ok := c.Locals("api_key").(*apikeys.Key)
+	if !ok {
+		return nil
+	}
+	if scope == "" {
+		return apperr.New(apperr.InsufficientScope, "not available with an API key")
+	}
+	if !key.HasScope(apikeys.Scope(scope)) {
+		return apperr.Newf(apperr.InsufficientScope, "API key lacks the %s scope", scope)
+	}
+	return nil
+}
+
+// loadAPIKeys returns the API keys of user uid.
+func loadAPIKeys(uid int) ([]APIKey, error) {
+	keys, err := apikeys.List(db.DB, uid)
+	if err != nil {
+		return nil, err
+	}
+	list := []APIKey{}
+	for i := range keys {
+		list = append(list, apiKeyResponse(&keys[i]))
+	}
+	return list, nil
+}
+
+// ListAPIKeys lists the current user's API keys, expired ones included.
+func ListAPIKeys(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	list, err := loadAPIKeys(uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch API keys")
+	}
+	return c.JSON(APIKeyList{Keys: list})
+}
+
+// CreateAPIKey creates an API key for the current user. The response is the only
+// place the key is shown.
+func CreateAPIKey(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	var req APIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	var fields []apperr.FieldError
+	if strings.TrimSpace(req.Name) == "" {
+		fields = append(fields, apperr.Field("body", "name", "must not be empty"))
+	}
+	if len(req.Scopes) == 0 {
+		fields = append(fields, apperr.Field("body", "scopes", "must have at least %v items", 1))
+	}
+	for i, s := range req.Scopes {
+		if !apikeys.ValidScope(s) {
+			names := make([]string, len(apikeys.Scopes))
+			for j, v := range apikeys.Scopes {
+				names[j] = string(v)
+			}
+			fields = append(fields, apperr.Field("body", "scopes["+strconv.Itoa(i)+"]", "must be one of: %s", strings.Join(names, ", ")))
+		}
+	}
+	var expiresAt time.Time
+	if req.ExpiresAt != 0 {
+		expiresAt = time.Unix(req.ExpiresAt, 0)
+		if !expiresAt.After(time.Now()) {
+			fields = append(fields, apperr.Field("body", "expires_at", "must be in the future"))
+		}
+	}
+	if len(fields) > 0 {
+		return apperr.New(apperr.InvalidRequest, "invalid request").WithFields(fields...)
+	}
+
+	key, token, err := apikeys.Create(db.DB, apikeys.NewKey{
+		UserID:    uid,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to create API key")
+	}
+	resp := apiKeyResponse(key)
+	resp.Key = token
+	return c.Status(fiber.StatusCreated).JSON(resp)
+}
+
+// RevokeAPIKey deletes one of the current user's API keys; it stops working at once.
+func RevokeAPIKey(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return apperr.New(apperr.NotFound, "API key not found")
+	}
+	switch err := apikeys.Revoke(db.DB, uid, id); err {
+	case nil:
+	case apikeys.ErrKeyNotFound:
+		return apperr.New(apperr.NotFound, "API key not found")
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to revoke API key")
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
@@ -0,0 +1,126 @@
+package handlers_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fiber-rest-api/internal/apikeys"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// createKey creates an API key with the scopes, using a token from a login.
+func createKey(t testing.TB, token string, scopes ...apikeys.Scope) handlers.APIKey {
+	t.Helper()
+	var key handlers.APIKey
+	expect(t, request{method: "POST", path: "/api/v1/profile/api-keys", token: token, body: handlers.APIKeyRequest{Name: "test", Scopes: scopes}}, fiber.StatusCreated, &key)
+	if !strings.HasPrefix(key.Key, apikeys.Prefix) {
+		t.Fatalf("key %q", key.Key)
+	}
+	return key
+}
+
+// expectCode sends r and fails the test unless the answer has the status and the
+// problem code.
+func expectCode(t testing.TB, r request, status int, code string) {
+	t.Helper()
+	resp, body := do(t, r)
+	if resp.StatusCode != status || problemCode(body) != code {
+		t.Errorf("%s %s: status %d, want %d %s: %s", r.method, r.path, resp.StatusCode, status, code, body)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	const email = "api-key-scopes@example.com"
+	token := signUp(t, email)
+	read := createKey(t, token, apikeys.ScopeProfileRead).Key
+	write := createKey(t, token, apikeys.ScopeProfileWrite).Key
+
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: read}, fiber.StatusOK, nil)
+	patch := request{method: "PATCH", path: "/api/v1/profile", token: read, body: map[string]string{"first_name": "Key"}, contentType: "application/merge-patch+json"}
+	expectCode(t, patch, fiber.StatusForbidden, "insufficient_scope")
+	patch.token = write
+	expect(t, patch, fiber.StatusOK, nil)
+	expectCode(t, request{method: "DELETE", path: "/api/v1/profile", token: read, body: handlers.AccountDeletion{Password: password}}, fiber.StatusForbidden, "insufficient_scope")
+
+	// operations without a scope need a token from a login
+	expectCode(t, request{method: "PUT", path: "/api/v1/profile/password", token: write, body: handlers.PasswordChange{CurrentPassword: password, NewPassword: "n3w-password"}}, fiber.StatusForbidden, "insufficient_scope")
+	expectCode(t, request{method: "POST", path: "/api/v1/profile/api-keys", token: write, body: handlers.APIKeyRequest{Name: "more", Scopes: []apikeys.Scope{apikeys.ScopeAdmin}}}, fiber.StatusForbidden, "insufficient_scope")
+}
+
+func TestAPIKeyAdminScope(t *testing.T) {
+	const email = "api-key-admin@example.com"
+	token := signUp(t, email)
+	read := createKey(t, token, apikeys.ScopeProfileRead).Key
+	admin := createKey(t, token, apikeys.ScopeAdmin).Key
+
+	// not an admin yet
+	expectCode(t, request{method: "GET", path: "/api/v1/admin/profile-attributes", token: admin}, fiber.StatusForbidden, "forbidden")
+
+	user, err := users.GetByEmail(db.DB, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetRole(db.DB, user.ID, users.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, request{method: "GET", path: "/api/v1/admin/profile-attributes", token: read}, fiber.StatusForbidden, "insufficient_scope")
+	expect(t, request{method: "GET", path: "/api/v1/admin/profile-attributes", token: admin}, fiber.StatusOK, nil)
+}
+
+func TestAPIKeyRevokedAndExpired(t *testing.T) {
+	token := signUp(t, "api-key-revoked@example.com")
+	revoked := createKey(t, token, apikeys.ScopeProfileRead)
+	expect(t, request{method: "DELETE", path: "/api/v1/profile/api-keys/" + strconv.FormatInt(revoked.ID, 10), token: token}, fiber.StatusNoContent, nil)
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: revoked.Key}, fiber.StatusUnauthorized, nil)
+
+	var expiring handlers.APIKey
+	expect(t, request{method: "POST", path: "/api/v1/profile/api-keys", token: token, body: handlers.APIKeyRequest{
+		Name: "expiring", Scopes: []apikeys.Scope{apikeys.ScopeProfileRead}, ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}}, fiber.StatusCreated, &expiring)
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: expiring.Key}, fiber.StatusOK, nil)
+	if _, err := db.DB.Exec("UPDATE api_keys SET expires_at = ? WHERE id = ?", time.Now().Add(-time.Second).Unix(), expiring.ID); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: expiring.Key}, fiber.StatusUnauthorized, nil)
+}
+
+// Keys are stored hashed, and lists show when they were last used.
+func TestAPIKeyStorage(t *testing.T) {
+	token := signUp(t, "api-key-storage@example.com")
+	key := createKey(t, token, apikeys.ScopeProfileRead)
+
+	var stored string
+	if err := db.DB.QueryRow("SELECT key_hash FROM api_keys WHERE id = ?", key.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored == key.Key || strings.Contains(stored, key.Key[len(apikeys.Prefix):]) {
+		t.Error("key stored in clear")
+	}
+
+	var list handlers.APIKeyList
+	expect(t, request{method: "GET", path: "/api/v1/profile/api-keys", token: token}, fiber.StatusOK, &list)
+	if len(list.Keys) != 1 || list.Keys[0].Key != "" || list.Keys[0].LastUsedAt != 0 {
+		t.Fatalf("before use: %+v", list.Keys)
+	}
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: key.Key}, fiber.StatusOK, nil)
+	expect(t, request{method: "GET", path: "/api/v1/profile/api-keys", token: token}, fiber.StatusOK, &list)
+	if len(list.Keys) != 1 || list.Keys[0].LastUsedAt == 0 {
+		t.Errorf("after use: %+v", list.Keys)
+	}
+}
+
+// Keys cannot stand in for the sign-in that confirms closing an account without a
+// password or changing its address, whatever their scopes.
+func TestAPIKeyPasswordlessAccount(t *testing.T) {
+	token := signInByCode(t, "api-key-passwordless@example.com")
+	key := createKey(t, token, apikeys.ScopeProfileRead, apikeys.ScopeProfileWrite, apikeys.ScopeAdmin).Key
+	expectCode(t, request{method: "DELETE", path: "/api/v1/profile", token: key, body: handlers.AccountDeletion{}}, fiber.StatusForbidden, "insufficient_scope")
+	expectCode(t, request{method: "POST", path: "/api/v1/profile/email", token: key, body: handlers.EmailChangeRequest{NewEmail: "api-key-passwordless-new@example.com"}}, fiber.StatusForbidden, "insufficient_scope")
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: key}, fiber.StatusOK, nil)
+}
@@ -1,212 +1,556 @@
 package handlers
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"mime/multipart"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"fiber-rest-api/internal/apikeys"
+	"fiber-rest-api/internal/apperr"
 	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/users"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" openapi:"required,minLength=1"`
+	Password string `json:"password" openapi:"required,minLength=1"`
+}
+
+// TokenResponse is the body of a successful login.
+type TokenResponse struct {
+	Token    string `json:"token"`
+	Restored bool   `json:"restored,omitempty" doc:"present when the login cancelled a pending account deletion"`
+	Created  bool   `json:"created,omitempty" doc:"present when signing in with a provider or an emailed link created the account"`
+	Linked   bool   `json:"linked,omitempty" doc:"present when the provider was linked to the account that started the sign-in"`
 }
 
 func Register(c *fiber.Ctx) error {
 	var req AuthRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+		return apperr.New(apperr.MalformedBody, "invalid request")
 	}
 	if strings.TrimSpace(req.Email) == "" || strings.TrimSpace(req.Password) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "email and password required"})
+		return apperr.New(apperr.InvalidRequest, "email and password required")
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to hash password"})
+	// Create hashes the password before it finds out whether the address is taken,
+	// so both outcomes take as long
+	uid, err := users.Create(db.DB, users.NewUser{Email: req.Email, Password: req.Password})
+	if err != nil && err != users.ErrEmailTaken {
+		return apperr.Wrap(err, apperr.Internal, "failed to create user")
 	}
-
-	_, err = db.DB.Exec("INSERT INTO users (email, password) VALUES (?, ?)", req.Email, string(hash))
-	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE") {
-			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "email already registered"})
+	if uniformRegistration() {
+		existing := err == users.ErrEmailTaken
+		if existing {
+			if u, err := users.GetByEmail(db.DB, req.Email); err == nil {
+				uid = u.ID
+			}
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create user"})
+		sendRegistrationMail(uid, req.Email, existing, c.IP())
+		return c.Status(fiber.StatusAccepted).JSON(MessageResponse{Message: "registration received, check your email"})
+	}
+	if err == users.ErrEmailTaken {
+		return apperr.New(apperr.EmailTaken, "email already registered")
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "registered"})
+	return c.Status(fiber.StatusCreated).JSON(MessageResponse{Message: "registered"})
+}
+
+// uniformRegistration reports whether REGISTRATION_UNIFORM_RESPONSE is enabled:
+// Register then answers 202 whether or not the address was already registered, and
+// tells the owner of the address by email instead.
+func uniformRegistration() bool {
+	return os.Getenv("REGISTRATION_UNIFORM_RESPONSE") == "true"
+}
+
+// sendRegistrationMail welcomes the owner of a new account, or tells the owner of
+// an existing one that someone tried to register their address. It sends in the
+// background so that the time mail delivery takes does not tell the two apart.
+func sendRegistrationMail(uid int, email string, existing bool, ip string) {
+	name := "registration_welcome"
+	if existing {
+		name = "registration_attempt"
+	}
+	at := time.Now()
+	go func() {
+		prefs, err := loadPreferences(uid)
+		if err != nil {
+			log.Printf("register: failed to load preferences of user %d: %v", uid, err)
+			return
+		}
+		if err := sendUserMail(email, prefs, name, map[string]interface{}{"Time": at, "IP": ip}); err != nil {
+			log.Printf("register: failed to send %s to user %d: %v", name, uid, err)
+		}
+	}()
 }
 
 func Login(c *fiber.Ctx) error {
 	var req AuthRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+		return apperr.New(apperr.MalformedBody, "invalid request")
 	}
 	if strings.TrimSpace(req.Email) == "" || strings.TrimSpace(req.Password) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "email and password required"})
+		return apperr.New(apperr.InvalidRequest, "email and password required")
 	}
 
-	var id int
-	var hashed string
-	row := db.DB.QueryRow("SELECT id, password FROM users WHERE email = ?", req.Email)
-	switch err := row.Scan(&id, &hashed); err {
-	case sql.ErrNoRows:
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid credentials"})
+	user, restored, err := passwordLogin(c, req.Email, req.Password)
+	if err != nil {
+		return err
+	}
+
+	// create JWT
+	signed, err := issueToken(user.ID, user.Email)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to sign token")
+	}
+
+	return c.JSON(TokenResponse{Token: signed, Restored: restored})
+}
+
+// passwordLogin signs user email in with password, as POST /auth/login and the
+// sign-in page of the OpenID Connect provider do, and records the attempt. It
+// reports whether the login cancelled a pending account deletion.
+func passwordLogin(c *fiber.Ctx, email, password string) (*users.User, bool, error) {
+	user, err := users.GetByEmail(db.DB, email)
+	switch err {
+	case users.ErrNotFound:
+		// hash the password anyway so the response does not come back faster than
+		// for a wrong password, revealing that the address is not registered
+		users.DummyPasswordCheck(password)
+		return nil, false, apperr.New(apperr.InvalidCredentials, "invalid credentials")
 	case nil:
 		// ok
 	default:
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to query user"})
+		return nil, false, apperr.Wrap(err, apperr.Internal, "failed to query user")
 	}
+	id := user.ID
 
-	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(req.Password)); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid credentials"})
-	}
-
-	// create JWT
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "secret"
+	if !user.PasswordMatches(password) {
+		recordLoginEvent(c, id, false)
+		return nil, false, apperr.New(apperr.InvalidCredentials, "invalid credentials")
 	}
-	claims := jwt.MapClaims{
-		"sub":   fmt.Sprintf("%d", id),
-		"email": req.Email,
-		"exp":   time.Now().Add(24 * time.Hour).Unix(),
+	// only reported once the password is known to be right
+	if user.Disabled() {
+		return nil, false, apperr.New(apperr.AccountDisabled, "account disabled")
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(secret))
+	recordLoginEvent(c, id, true)
+	// logging in during the grace period cancels a pending account deletion
+	restored, err := restoreAccount(c, id)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to sign token"})
+		return nil, false, apperr.Wrap(err, apperr.Internal, "failed to restore account")
 	}
-
-	return c.JSON(fiber.Map{"token": signed})
+	// the clear-text password is only at hand now, so outdated hashes (bcrypt, or
+	// weaker argon2id parameters) are replaced here; failing to is not the user's
+	// problem
+	if _, err := users.UpgradePasswordHash(db.DB, user, password); err != nil {
+		log.Printf("login: failed to upgrade password hash of user %d: %v", id, err)
+	}
+	return user, restored, nil
 }
 
-// AuthRequired is middleware that validates JWT and sets the user_id in locals.
+// AuthRequired is middleware that validates the bearer token, a JWT or an API key,
+// and sets the user_id in locals. For API keys it also sets api_key, which
+// CheckScope reads, and for JWTs issued_at, which checkReauthentication reads.
 func AuthRequired(c *fiber.Ctx) error {
 	auth := c.Get("Authorization")
 	if auth == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing authorization header"})
+		return apperr.New(apperr.Unauthenticated, "missing authorization header")
 	}
 	parts := strings.SplitN(auth, " ", 2)
 	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid authorization header"})
+		return apperr.New(apperr.Unauthenticated, "invalid authorization header")
 	}
-	tokenStr := parts[1]
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "secret"
+	var user *users.User
+	var err error
+	if apikeys.IsKey(parts[1]) {
+		var key *apikeys.Key
+		if key, user, err = apiKeyUser(parts[1]); err != nil {
+			return err
+		}
+		c.Locals("api_key", key)
+	} else {
+		var issuedAt time.Time
+		if user, issuedAt, err = tokenUser(parts[1]); err != nil {
+			return err
+		}
+		c.Locals("issued_at", issuedAt)
+	}
+	// closing an account signs it out everywhere until it is restored by logging in
+	if !user.DeleteAfter.IsZero() {
+		return apperr.New(apperr.AccountPendingDeletion, "account pending deletion")
 	}
+	if user.Disabled() {
+		return apperr.New(apperr.AccountDisabled, "account disabled")
+	}
+	c.Locals("user_id", user.ID)
+	return c.Next()
+}
+
+// tokenUser returns the user of a JWT issued by issueToken and when it was issued.
+func tokenUser(tokenStr string) (*users.User, time.Time, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method")
 		}
-		return []byte(secret), nil
+		return jwtSecret(), nil
 	})
 	if err != nil || !token.Valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token"})
+		return nil, time.Time{}, apperr.New(apperr.InvalidToken, "invalid token")
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token claims"})
+		return nil, time.Time{}, apperr.New(apperr.InvalidToken, "invalid token claims")
 	}
 	subVal, ok := claims["sub"].(string)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid subject claim"})
+		return nil, time.Time{}, apperr.New(apperr.InvalidToken, "invalid subject claim")
 	}
 	uid, err := strconv.Atoi(subVal)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid user id in token"})
+		return nil, time.Time{}, apperr.New(apperr.InvalidToken, "invalid user id in token")
 	}
-	c.Locals("user_id", uid)
-	return c.Next()
+	// tokens carry the email they were issued for; once the address changes
+	// they no longer identify the account and are rejected
+	// tokens issued before the last password change are rejected as well
+	emailClaim, _ := claims["email"].(string)
+	issuedAt, _ := claims["iat"].(float64)
+	user, err := users.Get(db.DB, uid)
+	switch err {
+	case users.ErrNotFound:
+		return nil, time.Time{}, apperr.New(apperr.InvalidToken, "user not found")
+	case nil:
+	default:
+		return nil, time.Time{}, apperr.Wrap(err, apperr.Internal, "failed to query user")
+	}
+	if emailClaim != user.Email {
+		return nil, time.Time{}, apperr.New(apperr.TokenRevoked, "token revoked")
+	}
+	// iat is in seconds with milliseconds as the fraction, the precision of
+	// password_changed_at; a token from the millisecond of the change is revoked too
+	issued := time.UnixMilli(int64(math.Round(issuedAt * 1000)))
+	if !user.PasswordChangedAt.IsZero() && !issued.After(user.PasswordChangedAt) {
+		return nil, time.Time{}, apperr.New(apperr.TokenRevoked, "token revoked")
+	}
+	return user, issued, nil
 }
 
-// GetProfile returns the current user's profile information.
+// reauthWindow is how recently an account without a password must have signed in
+// to make the changes others confirm with their password.
+const reauthWindow = 10 * time.Minute
+
+// checkReauthentication confirms that the caller is the owner of user before
+// closing the account or changing its email address: with the password, or for
+// accounts without one (signed in through emailed links or identity providers) with
+// a token from a sign-in within reauthWindow. API keys are never recent enough.
+func checkReauthentication(c *fiber.Ctx, user *users.User, password string) error {
+	if user.HasPassword() {
+		if password == "" {
+			return apperr.New(apperr.InvalidRequest, "password required")
+		}
+		if !user.PasswordMatches(password) {
+			return apperr.New(apperr.InvalidCredentials, "invalid password")
+		}
+		return nil
+	}
+	issuedAt, ok := c.Locals("issued_at").(time.Time)
+	if !ok || time.Since(issuedAt) > reauthWindow {
+		return apperr.New(apperr.ReauthenticationRequired, "sign in again to confirm")
+	}
+	return nil
+}
+
+// apiKeyUser returns an API key and its user. Keys are not bound to the email
+// address or password: they stay valid until they expire or are revoked.
+func apiKeyUser(token string) (*apikeys.Key, *users.User, error) {
+	key, err := apikeys.Authenticate(db.DB, token)
+	switch err {
+	case nil:
+	case apikeys.ErrInvalidKey:
+		return nil, nil, apperr.New(apperr.InvalidToken, "invalid API key")
+	default:
+		return nil, nil, apperr.Wrap(err, apperr.Internal, "failed to query API key")
+	}
+	user, err := users.Get(db.DB, key.UserID)
+	if err != nil {
+		return nil, nil, apperr.Wrap(err, apperr.Internal, "failed to query user")
+	}
+	return key, user, nil
+}
+
+// issueToken signs a 24h JWT for the given user.
+func issueToken(uid int, email string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":   fmt.Sprintf("%d", uid),
+		"email": email,
+		"iat":   float64(now.UnixMilli()) / 1000,
+		"exp":   now.Add(24 * time.Hour).Unix(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret())
+}
+
+// jwtSecret returns the HMAC key used to sign and verify tokens.
+func jwtSecret() []byte {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		secret = "secret"
+	}
+	return []byte(secret)
+}
+
+// signCookieValue encodes v as <payload>.<HMAC>, keyed with the JWT secret, for
+// state kept in cookies.
+func signCookieValue(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, jwtSecret())
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyCookieValue decodes a value made by signCookieValue into v, reporting false
+// when it was tampered with.
+func verifyCookieValue(value string, v interface{}) bool {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, jwtSecret())
+	mac.Write([]byte(value[:i]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(value[:i])
+	return err == nil && json.Unmarshal(payload, v) == nil
+}
+
+// GetProfile returns the current user's profile information along with an ETag
+// carrying the row version, to be echoed back in If-Match by editing clients.
 func GetProfile(c *fiber.Ctx) error {
 	uidRaw := c.Locals("user_id")
 	if uidRaw == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
 	}
 	uid, ok := uidRaw.(int)
 	if !ok {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "invalid user id"})
+		return apperr.New(apperr.Internal, "invalid user id")
 	}
+	return writeProfile(c, uid, false)
+}
 
-	var email, firstName, lastName, phone, avatar string
-	row := db.DB.QueryRow("SELECT email, first_name, last_name, phone, avatar FROM users WHERE id = ?", uid)
-	switch err := row.Scan(&email, &firstName, &lastName, &phone, &avatar); err {
+// writeProfile answers with the profile of user uid. Admin-only custom attributes are
+// included when admin is set.
+func writeProfile(c *fiber.Ctx, uid int, admin bool) error {
+	profile, version, err := loadProfile(uid, admin)
+	switch err {
 	case sql.ErrNoRows:
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "user not found"})
+		return apperr.New(apperr.NotFound, "user not found")
 	case nil:
-		// return avatar as full path if set
-		avatarURL := ""
-		if avatar != "" {
-			avatarURL = "/uploads/" + avatar
-		}
-		return c.JSON(fiber.Map{
-			"id":         uid,
-			"email":      email,
-			"first_name": firstName,
-			"last_name":  lastName,
-			"phone":      phone,
-			"avatar":     avatarURL,
-		})
+		c.Set(fiber.HeaderETag, profileETag(version))
+		return c.JSON(profile)
 	default:
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch user"})
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch user")
 	}
 }
 
-// ProfileUpdate represents allowed profile fields to update.
+// Profile is the representation of a user's own profile.
+type Profile struct {
+	ID            int               `json:"id"`
+	Email         string            `json:"email"`
+	EmailVerified bool              `json:"email_verified" doc:"the address was confirmed through an emailed link or code, or by the identity provider the account was created with"`
+	Username      string            `json:"username"`
+	FirstName     string            `json:"first_name"`
+	LastName      string            `json:"last_name"`
+	Phone         string            `json:"phone" doc:"E.164, e.g. +66812345678"`
+	PhoneDisplay  string            `json:"phone_display" doc:"phone as entered by the user"`
+	PhoneVerified bool              `json:"phone_verified"`
+	Avatar        string            `json:"avatar"`
+	Attributes    ProfileAttributes `json:"attributes"`
+}
+
+// ProfileAttributes holds custom profile attribute values by key.
+type ProfileAttributes map[string]json.RawMessage
+
+// OpenAPISchema leaves the properties empty; SwaggerJSON fills them with the
+// attributes defined when the document is served.
+func (ProfileAttributes) OpenAPISchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":                 "object",
+		"description":          "Custom profile attributes defined by admins via /admin/profile-attributes.",
+		"additionalProperties": false,
+		"properties":           map[string]interface{}{},
+	}
+}
+
+// loadProfile reads the profile representation of user uid and its version.
+func loadProfile(uid int, admin bool) (*Profile, int, error) {
+	// profile columns are NULL until set (or after being cleared via PATCH)
+	var email string
+	var username, firstName, lastName, phone, phoneDisplay, avatar sql.NullString
+	var phoneVerifiedAt, emailVerifiedAt sql.NullInt64
+	var version int
+	row := db.DB.QueryRow("SELECT email, email_verified_at, username, first_name, last_name, phone, phone_display, phone_verified_at, avatar, version FROM users WHERE id = ?", uid)
+	if err := row.Scan(&email, &emailVerifiedAt, &username, &firstName, &lastName, &phone, &phoneDisplay, &phoneVerifiedAt, &avatar, &version); err != nil {
+		return nil, 0, err
+	}
+	attributes, err := profileAttributeValues(uid, admin)
+	if err != nil {
+		return nil, 0, err
+	}
+	// return avatar as full path if set
+	avatarURL := ""
+	if avatar.String != "" {
+		avatarURL = "/uploads/" + avatar.String
+	}
+	return &Profile{
+		ID:            uid,
+		Email:         email,
+		EmailVerified: emailVerifiedAt.Valid,
+		Username:      username.String,
+		FirstName:     firstName.String,
+		LastName:      lastName.String,
+		Phone:         phone.String,
+		PhoneDisplay:  phoneDisplay.String,
+		PhoneVerified: phone.String != "" && phoneVerifiedAt.Valid,
+		Avatar:        avatarURL,
+		Attributes:    attributes,
+	}, version, nil
+}
+
+// ProfileUpdate represents allowed profile fields to update. Attributes holds custom
+// profile attribute values; when present it replaces all of them, when omitted they
+// are left unchanged.
 type ProfileUpdate struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Phone     string `json:"phone"`
+	FirstName  string            `json:"first_name" openapi:"maxLength=100"`
+	LastName   string            `json:"last_name" openapi:"maxLength=100"`
+	Phone      string            `json:"phone" openapi:"maxLength=20"`
+	Attributes ProfileAttributes `json:"attributes,omitempty" doc:"replaces all custom attributes when present; left unchanged when omitted"`
+}
+
+// ProfilePatch documents the JSON Merge Patch body of PATCH /profile. The handler
+// decodes the body generically to tell omitted fields from null ones.
+type ProfilePatch struct {
+	FirstName  *string           `json:"first_name" openapi:"nullable,maxLength=100"`
+	LastName   *string           `json:"last_name" openapi:"nullable,maxLength=100"`
+	Phone      *string           `json:"phone" openapi:"nullable,maxLength=20"`
+	Attributes ProfileAttributes `json:"attributes" doc:"merge patch of custom attributes; null as a value removes the attribute, null for the whole member removes every attribute" openapi:"nullable"`
 }
 
-var phoneRe = regexp.MustCompile(`^[0-9()+\-\s]+$`)
+// AvatarUpload documents the multipart body of POST /profile/avatar.
+type AvatarUpload struct {
+	Avatar []byte `json:"avatar" openapi:"required,format=binary"`
+}
 
-// UpdateProfile updates the current user's profile (first name, last name, phone) with validation.
+// AvatarResponse is the body of a successful avatar upload.
+type AvatarResponse struct {
+	Avatar string `json:"avatar"`
+}
+
+// UpdateProfile replaces the current user's profile (first name, last name, phone) with validation.
+// Fields missing from the body are stored as empty; use PatchProfile for partial updates.
 func UpdateProfile(c *fiber.Ctx) error {
 	uidRaw := c.Locals("user_id")
 	if uidRaw == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
 	}
 	uid, ok := uidRaw.(int)
 	if !ok {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "invalid user id"})
+		return apperr.New(apperr.Internal, "invalid user id")
 	}
 
 	var req ProfileUpdate
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		return apperr.New(apperr.MalformedBody, "invalid request body")
 	}
 
 	// Validation: max lengths and phone format
 	if len(req.FirstName) > 100 || len(req.LastName) > 100 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "first_name/last_name too long (max 100)"})
+		return apperr.New(apperr.InvalidRequest, "first_name/last_name too long (max 100)")
 	}
 	if len(req.Phone) > 20 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "phone too long (max 20)"})
+		return apperr.New(apperr.InvalidRequest, "phone too long (max 20)")
+	}
+	display := strings.TrimSpace(req.Phone)
+	normalized, msg := normalizeProfilePhone(display)
+	if msg != "" {
+		return apperr.New(apperr.InvalidRequest, msg)
+	}
+
+	versions, conditional, ok := ifMatchVersions(c)
+	if !ok {
+		return preconditionRequired(c)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update profile")
+	}
+	defer tx.Rollback()
+
+	before, err := snapshotProfile(tx, uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update profile")
+	}
+
+	// verification is kept only while the normalized number stays the same
+	query := "UPDATE users SET first_name = ?, last_name = ?, phone = ?, phone_display = ?, " +
+		"phone_verified_at = CASE WHEN phone IS ? THEN phone_verified_at ELSE NULL END, version = version + 1 WHERE id = ?"
+	args := []interface{}{strings.TrimSpace(req.FirstName), strings.TrimSpace(req.LastName), normalized, display, normalized, uid}
+	if conditional {
+		cond, versionArgs := versionCondition(versions)
+		query += cond
+		args = append(args, versionArgs...)
 	}
-	if strings.TrimSpace(req.Phone) != "" && !phoneRe.MatchString(req.Phone) {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "phone contains invalid characters"})
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update profile")
+	}
+	if n, _ := res.RowsAffected(); n == 0 && conditional {
+		tx.Rollback()
+		return preconditionFailed(c, uid, false)
 	}
 
-	_, err := db.DB.Exec("UPDATE users SET first_name = ?, last_name = ?, phone = ? WHERE id = ?", strings.TrimSpace(req.FirstName), strings.TrimSpace(req.LastName), strings.TrimSpace(req.Phone), uid)
+	if req.Attributes != nil {
+		msg, err := applyAttributeChanges(tx, uid, false, req.Attributes, true)
+		if err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to update attributes")
+		}
+		if msg != "" {
+			return apperr.New(apperr.InvalidRequest, msg)
+		}
+	}
+	after, err := snapshotProfile(tx, uid)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update profile"})
+		return apperr.Wrap(err, apperr.Internal, "failed to update profile")
+	}
+	if err := recordProfileChanges(tx, c, uid, uid, "profile.update", before, after); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to record history")
+	}
+	if err := tx.Commit(); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update profile")
 	}
 
 	// Return updated profile
@@ -249,36 +593,84 @@ func saveUploadedFile(fileHeader *multipart.FileHeader, uid int) (string, error)
 func UploadAvatar(c *fiber.Ctx) error {
 	uidRaw := c.Locals("user_id")
 	if uidRaw == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
 	}
 	uid, ok := uidRaw.(int)
 	if !ok {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "invalid user id"})
+		return apperr.New(apperr.Internal, "invalid user id")
 	}
 
 	// Fiber provides c.FormFile to access uploaded files; no ParseMultipartForm needed.
 
 	fileHeader, err := c.FormFile("avatar")
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "missing avatar file"})
+		return apperr.New(apperr.InvalidRequest, "missing avatar file")
 	}
 
 	// limit file size (basic check)
 	if fileHeader.Size > 5<<20 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "file too large (max 5MB)"})
+		return apperr.New(apperr.InvalidRequest, "file too large (max 5MB)")
+	}
+
+	versions, conditional, ok := ifMatchVersions(c)
+	if !ok {
+		return preconditionRequired(c)
 	}
 
 	fname, err := saveUploadedFile(fileHeader, uid)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return apperr.New(apperr.InvalidRequest, err.Error())
 	}
 
-	_, err = db.DB.Exec("UPDATE users SET avatar = ? WHERE id = ?", fname, uid)
+	tx, err := db.DB.Begin()
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update avatar"})
+		os.Remove(filepath.Join("uploads", fname))
+		return apperr.Wrap(err, apperr.Internal, "failed to update avatar")
 	}
+	defer tx.Rollback()
 
-	return c.JSON(fiber.Map{"avatar": "/uploads/" + fname})
+	var oldAvatar sql.NullString
+	if err := tx.QueryRow("SELECT avatar FROM users WHERE id = ?", uid).Scan(&oldAvatar); err != nil {
+		os.Remove(filepath.Join("uploads", fname))
+		return apperr.Wrap(err, apperr.Internal, "failed to update avatar")
+	}
+	query := "UPDATE users SET avatar = ?, version = version + 1 WHERE id = ?"
+	args := []interface{}{fname, uid}
+	if conditional {
+		cond, versionArgs := versionCondition(versions)
+		query += cond
+		args = append(args, versionArgs...)
+	}
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		os.Remove(filepath.Join("uploads", fname))
+		return apperr.Wrap(err, apperr.Internal, "failed to update avatar")
+	}
+	if n, _ := res.RowsAffected(); n == 0 && conditional {
+		tx.Rollback()
+		os.Remove(filepath.Join("uploads", fname))
+		return preconditionFailed(c, uid, false)
+	}
+	var oldValue *string
+	if oldAvatar.Valid {
+		oldValue = &oldAvatar.String
+	}
+	if err := recordProfileEvent(tx, c, uid, uid, "avatar.upload", "avatar", oldValue, strPtr(fname)); err != nil {
+		os.Remove(filepath.Join("uploads", fname))
+		return apperr.Wrap(err, apperr.Internal, "failed to record history")
+	}
+	if err := tx.Commit(); err != nil {
+		os.Remove(filepath.Join("uploads", fname))
+		return apperr.Wrap(err, apperr.Internal, "failed to update avatar")
+	}
+
+	// expose the new version so the client can keep editing without a reload
+	var newVersion int
+	if err := db.DB.QueryRow("SELECT version FROM users WHERE id = ?", uid).Scan(&newVersion); err == nil {
+		c.Set(fiber.HeaderETag, profileETag(newVersion))
+	}
+
+	return c.JSON(AvatarResponse{Avatar: "/uploads/" + fname})
 }
 
 // ProfileUI serves a minimal HTML page that lets a user view and edit their profile using the API.
@@ -291,35 +683,89 @@ func ProfileUI(c *fiber.Ctx) error {
     <style>
       body { font-family: Arial, sans-serif; max-width: 600px; margin: 2rem auto; }
       label { display:block; margin-top: 0.5rem; }
-      input { width:100%; padding:0.5rem; }
+      input, select { width:100%; padding:0.5rem; }
+      input.check { width:auto; }
       button { margin-top:1rem; padding:0.6rem 1rem; }
       .token { margin-bottom:1rem; }
       img.avatar { max-width:120px; display:block; margin-top:0.5rem; }
     </style>
   </head>
   <body>
-    <h1>แก้ไขโปรไฟล์</h1>
-    <p>กรอก JWT token (หลังจากเข้าสู่ระบบ) เพื่อเรียกดูและแก้ไขข้อมูล</p>
+    <h1 data-i18n="title">แก้ไขโปรไฟล์</h1>
+    <p data-i18n="intro">กรอก JWT token (หลังจากเข้าสู่ระบบ) เพื่อเรียกดูและแก้ไขข้อมูล</p>
     <div class="token">
       <label>Token (Bearer)</label>
       <input id="token" placeholder="paste token here" />
-      <button id="load">Load profile</button>
+      <button id="load" data-i18n="load">Load profile</button>
     </div>
+    <div id="providers"></div>
     <form id="profile" onsubmit="return false;">
       <label>Avatar</label>
       <img id="avatarPreview" class="avatar" src="" alt="avatar" />
       <input id="avatarFile" type="file" accept="image/*" />
-      <button id="uploadAvatar">Upload Avatar</button>
+      <button id="uploadAvatar" data-i18n="upload">Upload Avatar</button>
 
-      <label>ชื่อ (First name)</label>
+      <label data-i18n="first_name">ชื่อ (First name)</label>
       <input id="first_name" />
-      <label>นามสกุล (Last name)</label>
+      <label data-i18n="last_name">นามสกุล (Last name)</label>
       <input id="last_name" />
-      <label>เบอร์โทร (Phone)</label>
+      <label><span data-i18n="phone">เบอร์โทร (Phone)</span> <span id="phoneStatus"></span></label>
       <input id="phone" />
-      <label>อีเมล (Email - read only)</label>
+      <button id="sendCode" data-i18n="send_code">Send verification code</button>
+      <input id="phoneCode" placeholder="6-digit code" />
+      <button id="confirmCode" data-i18n="verify_phone">Verify phone</button>
+      <label data-i18n="username">ชื่อผู้ใช้ (Username)</label>
+      <input id="username" />
+      <button id="saveUsername" data-i18n="save_username">Save username</button>
+      <label data-i18n="email">อีเมล (Email - read only)</label>
       <input id="email" readonly />
-      <button id="save">Save</button>
+      <div id="attributes"></div>
+      <button id="save" data-i18n="save">Save</button>
+    </form>
+    <form id="changeEmail" onsubmit="return false;">
+      <h2 data-i18n="change_email">เปลี่ยนอีเมล (Change email)</h2>
+      <label data-i18n="new_email">อีเมลใหม่ (New email)</label>
+      <input id="new_email" type="email" />
+      <label data-i18n="current_password">รหัสผ่านปัจจุบัน (Current password)</label>
+      <input id="email_password" type="password" />
+      <button id="requestEmailChange" data-i18n="send_confirmation">Send confirmation</button>
+    </form>
+    <form id="changePassword" onsubmit="return false;">
+      <h2 data-i18n="change_password">เปลี่ยนรหัสผ่าน (Change password)</h2>
+      <label data-i18n="current_password">รหัสผ่านปัจจุบัน (Current password)</label>
+      <input id="current_password" type="password" />
+      <label data-i18n="new_password">รหัสผ่านใหม่ (New password)</label>
+      <input id="new_password" type="password" />
+      <button id="savePassword" data-i18n="change_password">Change password</button>
+    </form>
+    <div id="identities">
+      <h2 data-i18n="linked_accounts">บัญชีที่เชื่อมไว้ (Linked accounts)</h2>
+      <ul id="identityList"></ul>
+    </div>
+    <form id="preferences" onsubmit="return false;">
+      <h2 data-i18n="preferences">การตั้งค่า (Preferences)</h2>
+      <label data-i18n="locale">ภาษา (Language)</label>
+      <select id="locale">
+        <option value="th">ไทย</option>
+        <option value="en">English</option>
+      </select>
+      <label data-i18n="timezone">เขตเวลา (Time zone)</label>
+      <input id="timezone" placeholder="Asia/Bangkok" />
+      <label data-i18n="date_format">รูปแบบวันที่ (Date format)</label>
+      <select id="date_format">
+        <option>DD/MM/YYYY</option>
+        <option>MM/DD/YYYY</option>
+        <option>YYYY-MM-DD</option>
+      </select>
+      <label><input id="notify_security" type="checkbox" class="check" /> <span data-i18n="notify_security">แจ้งเตือนความปลอดภัยทางอีเมล (Security emails)</span></label>
+      <button id="savePreferences" data-i18n="save">Save</button>
+    </form>
+    <form id="closeAccount" onsubmit="return false;">
+      <h2 data-i18n="close_account">ปิดบัญชี (Close account)</h2>
+      <p data-i18n="close_account_note">บัญชีจะถูกลบถาวรเมื่อพ้นระยะเวลาผ่อนผัน เข้าสู่ระบบก่อนหน้านั้นเพื่อกู้คืน</p>
+      <label data-i18n="current_password">รหัสผ่านปัจจุบัน (Current password)</label>
+      <input id="delete_password" type="password" />
+      <button id="deleteAccount" data-i18n="close_account">Close account</button>
     </form>
     <script>
       const loadBtn = document.getElementById('load')
@@ -327,52 +773,276 @@ func ProfileUI(c *fiber.Ctx) error {
       const tokenInput = document.getElementById('token')
       const uploadBtn = document.getElementById('uploadAvatar')
 
+      const messages = {
+        th: {
+          title: 'แก้ไขโปรไฟล์', intro: 'กรอก JWT token (หลังจากเข้าสู่ระบบ) เพื่อเรียกดูและแก้ไขข้อมูล',
+          load: 'โหลดโปรไฟล์', upload: 'อัปโหลดรูปโปรไฟล์', first_name: 'ชื่อ', last_name: 'นามสกุล',
+          phone: 'เบอร์โทร', username: 'ชื่อผู้ใช้', save_username: 'บันทึกชื่อผู้ใช้', send_code: 'ส่งรหัสยืนยัน', verify_phone: 'ยืนยันเบอร์โทร', email: 'อีเมล (แก้ไขไม่ได้)',
+          save: 'บันทึก', change_email: 'เปลี่ยนอีเมล', new_email: 'อีเมลใหม่', current_password: 'รหัสผ่านปัจจุบัน',
+          send_confirmation: 'ส่งลิงก์ยืนยัน', change_password: 'เปลี่ยนรหัสผ่าน', new_password: 'รหัสผ่านใหม่',
+          preferences: 'การตั้งค่า', locale: 'ภาษา', timezone: 'เขตเวลา', date_format: 'รูปแบบวันที่', notify_security: 'แจ้งเตือนความปลอดภัยทางอีเมล',
+          close_account: 'ปิดบัญชี', close_account_note: 'บัญชีจะถูกลบถาวรเมื่อพ้นระยะเวลาผ่อนผัน เข้าสู่ระบบก่อนหน้านั้นเพื่อกู้คืน',
+          linked_accounts: 'บัญชีที่เชื่อมไว้'
+        },
+        en: {
+          title: 'Edit profile', intro: 'Paste a JWT token (after logging in) to view and edit your profile',
+          load: 'Load profile', upload: 'Upload avatar', first_name: 'First name', last_name: 'Last name',
+          phone: 'Phone', username: 'Username', save_username: 'Save username', send_code: 'Send verification code', verify_phone: 'Verify phone', email: 'Email (read only)',
+          save: 'Save', change_email: 'Change email', new_email: 'New email', current_password: 'Current password',
+          send_confirmation: 'Send confirmation', change_password: 'Change password', new_password: 'New password',
+          preferences: 'Preferences', locale: 'Language', timezone: 'Time zone', date_format: 'Date format', notify_security: 'Security emails',
+          close_account: 'Close account', close_account_note: 'The account is erased when the grace period ends. Log in before then to restore it.',
+          linked_accounts: 'Linked accounts'
+        }
+      }
+
+      // translate the page into the user's preferred language (th or en)
+      function applyLocale(locale) {
+        const dict = messages[(locale || '').toLowerCase().startsWith('th') ? 'th' : 'en']
+        document.documentElement.lang = locale
+        document.querySelectorAll('[data-i18n]').forEach(el => {
+          const text = dict[el.dataset.i18n]
+          if (text) el.textContent = text
+        })
+      }
+
+      function fillPreferences(p) {
+        document.getElementById('locale').value = p.locale.toLowerCase().startsWith('th') ? 'th' : 'en'
+        document.getElementById('timezone').value = p.timezone
+        document.getElementById('date_format').value = p.date_format
+        document.getElementById('notify_security').checked = p.notifications.security_email
+        applyLocale(p.locale)
+      }
+
+      // ETag of the profile as last loaded; sent as If-Match so that edits made
+      // elsewhere (e.g. another tab) are not silently overwritten
+      let etag = null
+
+      // custom attribute inputs are generated from the ProfileAttributes schema
+      let attributeSchema = {}
+
+      async function loadAttributeSchema() {
+        const spec = await fetch('/docs/swagger.json').then(r => r.json()).catch(() => null)
+        const props = (spec && spec.components.schemas.ProfileAttributes.properties) || {}
+        attributeSchema = {}
+        const box = document.getElementById('attributes')
+        box.innerHTML = ''
+        for (const [key, s] of Object.entries(props)) {
+          if (s['x-visibility'] === 'admin') continue
+          attributeSchema[key] = s
+          const label = document.createElement('label')
+          label.textContent = s.title || key
+          let input
+          if (s.enum) {
+            input = document.createElement('select')
+            input.appendChild(document.createElement('option'))
+            for (const v of s.enum) {
+              const o = document.createElement('option')
+              o.value = o.textContent = v
+              input.appendChild(o)
+            }
+          } else {
+            input = document.createElement('input')
+            input.type = s.type === 'boolean' ? 'checkbox' : s.format === 'date' ? 'date' : (s.type === 'integer' || s.type === 'number') ? 'number' : 'text'
+          }
+          input.id = 'attr_' + key
+          box.appendChild(label)
+          box.appendChild(input)
+        }
+      }
+
+      function readAttributes() {
+        const out = {}
+        for (const [key, s] of Object.entries(attributeSchema)) {
+          const input = document.getElementById('attr_' + key)
+          if (s.type === 'boolean') out[key] = input.checked
+          else if (input.value === '') continue
+          else if (s.type === 'integer' || s.type === 'number') out[key] = Number(input.value)
+          else out[key] = input.value
+        }
+        return out
+      }
+
+      function fill(data) {
+        const attrs = data.attributes || {}
+        for (const [key, s] of Object.entries(attributeSchema)) {
+          const input = document.getElementById('attr_' + key)
+          if (s.type === 'boolean') input.checked = !!attrs[key]
+          else input.value = attrs[key] === undefined ? '' : attrs[key]
+        }
+        document.getElementById('first_name').value = data.first_name || ''
+        document.getElementById('last_name').value = data.last_name || ''
+        document.getElementById('phone').value = data.phone_display || data.phone || ''
+        document.getElementById('email').value = data.email || ''
+        document.getElementById('username').value = data.username || ''
+        document.getElementById('phoneStatus').textContent = data.phone ? (data.phone_verified ? '✔ verified' : 'not verified') : ''
+        const avatar = data.avatar || ''
+        document.getElementById('avatarPreview').src = avatar || ''
+      }
+
       async function api(path, method='GET', body, isJSON=true) {
         const token = tokenInput.value.trim()
         if (!token) { alert('Please provide token'); return null }
         const headers = { 'Authorization': 'Bearer ' + token }
         if (isJSON) headers['Content-Type'] = 'application/json'
+        if (method !== 'GET' && etag) headers['If-Match'] = etag
         const res = await fetch(path, {
           method,
           headers,
           body: body ? (isJSON ? JSON.stringify(body) : body) : undefined
         })
+        if (res.headers.get('ETag')) etag = res.headers.get('ETag')
+        if (res.status === 204) return {}
+        if (res.status === 412) {
+          fill(await res.json())
+          alert('This profile was changed elsewhere. The latest version has been loaded; please re-apply your changes.')
+          return null
+        }
         if (!res.ok) {
-          const e = await res.json().catch(()=>({error:'failed'}))
-          alert('Error: ' + (e.error || res.status))
+          // errors are problem documents (RFC 7807) in the browser's language
+          const e = await res.json().catch(()=>({}))
+          const fields = (e.errors || []).map(f => (f.field ? f.field + ': ' : '') + f.message)
+          alert('Error: ' + [e.detail || e.title || res.status].concat(fields).join('\n'))
           return null
         }
         return await res.json()
       }
 
+      // identity providers users can sign in with and link; signing in through one
+      // comes back to this page with the token in the fragment (#token=...)
+      const providers = fetch('/api/v1/auth/oidc').then(r => r.json()).then(d => d.providers).catch(() => [])
+
+      async function showProviders() {
+        const box = document.getElementById('providers')
+        for (const p of await providers) {
+          const a = document.createElement('a')
+          a.href = p.login_url + '?redirect=' + encodeURIComponent(location.pathname)
+          a.textContent = 'Sign in with ' + p.name
+          a.style.marginRight = '1rem'
+          box.appendChild(a)
+        }
+      }
+
+      async function loadIdentities() {
+        const data = await api('/api/v1/profile/identities')
+        if (!data) return
+        const list = document.getElementById('identityList')
+        list.innerHTML = ''
+        for (const p of await providers) {
+          const linked = data.identities.find(i => i.provider === p.name)
+          const li = document.createElement('li')
+          li.textContent = p.name + (linked ? ' (' + linked.email + ') ' : ' ')
+          const btn = document.createElement('button')
+          btn.textContent = linked ? 'Unlink' : 'Link'
+          btn.onclick = async () => {
+            const path = '/api/v1/profile/identities/' + encodeURIComponent(p.name)
+            if (linked) {
+              if (await api(path, 'DELETE')) loadIdentities()
+              return
+            }
+            const res = await api(path + '?redirect=' + encodeURIComponent(location.pathname), 'POST')
+            if (res) location.href = res.authorization_url
+          }
+          li.appendChild(btn)
+          list.appendChild(li)
+        }
+      }
+
       loadBtn.onclick = async () => {
-        const data = await api('/profile')
+        await loadAttributeSchema()
+        const data = await api('/api/v1/profile')
         if (!data) return
-        document.getElementById('first_name').value = data.first_name || ''
-        document.getElementById('last_name').value = data.last_name || ''
-        document.getElementById('phone').value = data.phone || ''
-        document.getElementById('email').value = data.email || ''
-        const avatar = data.avatar || ''
-        document.getElementById('avatarPreview').src = avatar || ''
+        fill(data)
+        const prefs = await api('/api/v1/profile/preferences')
+        if (prefs) fillPreferences(prefs)
+        loadIdentities()
+      }
+
+      showProviders()
+      const fragment = new URLSearchParams(location.hash.slice(1))
+      if (fragment.get('token')) {
+        tokenInput.value = fragment.get('token')
+        history.replaceState(null, '', location.pathname)
+        loadBtn.click()
+      }
+
+      document.getElementById('savePreferences').onclick = async () => {
+        const body = {
+          locale: document.getElementById('locale').value,
+          timezone: document.getElementById('timezone').value,
+          date_format: document.getElementById('date_format').value,
+          notifications: {
+            security_email: document.getElementById('notify_security').checked
+          }
+        }
+        const data = await api('/api/v1/profile/preferences', 'PUT', body)
+        if (data) fillPreferences(data)
       }
 
       saveBtn.onclick = async () => {
         const body = {
           first_name: document.getElementById('first_name').value,
           last_name: document.getElementById('last_name').value,
-          phone: document.getElementById('phone').value
+          phone: document.getElementById('phone').value,
+          attributes: readAttributes()
         }
-        const data = await api('/profile', 'PUT', body)
+        const data = await api('/api/v1/profile', 'PUT', body)
         if (data) alert('Saved')
       }
 
+      document.getElementById('sendCode').onclick = async () => {
+        const data = await api('/api/v1/profile/phone/verification', 'POST')
+        if (data) alert('Code sent')
+      }
+
+      document.getElementById('confirmCode').onclick = async () => {
+        const data = await api('/api/v1/profile/phone/verification/confirm', 'POST', { code: document.getElementById('phoneCode').value })
+        if (data) { fill(data); alert('Phone verified') }
+      }
+
+      document.getElementById('saveUsername').onclick = async () => {
+        const data = await api('/api/v1/profile/username', 'PUT', { username: document.getElementById('username').value })
+        if (data) { fill(data); alert('Saved') }
+      }
+
+      document.getElementById('requestEmailChange').onclick = async () => {
+        const body = {
+          new_email: document.getElementById('new_email').value,
+          password: document.getElementById('email_password').value
+        }
+        const data = await api('/api/v1/profile/email', 'POST', body)
+        if (data) alert('Check the new mailbox for a confirmation link')
+      }
+
+      document.getElementById('savePassword').onclick = async () => {
+        const body = {
+          current_password: document.getElementById('current_password').value,
+          new_password: document.getElementById('new_password').value
+        }
+        const data = await api('/api/v1/profile/password', 'PUT', body)
+        if (data) {
+          // older tokens are revoked by the change; continue with the new one
+          tokenInput.value = data.token
+          alert('Password changed')
+        }
+      }
+
+      document.getElementById('deleteAccount').onclick = async () => {
+        if (!confirm('Close your account?')) return
+        const data = await api('/api/v1/profile', 'DELETE', { password: document.getElementById('delete_password').value })
+        if (data) {
+          alert('Account closed. It will be deleted on ' + new Date(data.delete_after * 1000).toLocaleString())
+          tokenInput.value = ''
+        }
+      }
+
       uploadBtn.onclick = async (e) => {
         e.preventDefault()
         const fileInput = document.getElementById('avatarFile')
         if (!fileInput.files.length) { alert('Choose a file'); return }
         const fd = new FormData()
         fd.append('avatar', fileInput.files[0])
-        const data = await api('/profile/avatar', 'POST', fd, false)
+        const data = await api('/api/v1/profile/avatar', 'POST', fd, false)
         if (data && data.avatar) {
           document.getElementById('avatarPreview').src = data.avatar
           alert('Uploaded')
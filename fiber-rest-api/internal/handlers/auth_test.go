@@ -0,0 +1,196 @@
+package handlers_test
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Registration accepts any non-empty password, as it always has; the policy applies
+// to passwords set later.
+func TestRegisterWithoutPolicy(t *testing.T) {
+	for _, r := range []handlers.AuthRequest{
+		{Email: "register-short@example.com", Password: "abc"},
+		{Email: "register-letters@example.com", Password: "password"},
+		{Email: "register-email@example.com", Password: "register-email@example.com"},
+	} {
+		expect(t, request{method: "POST", path: "/api/v1/auth/register", body: r}, fiber.StatusCreated, nil)
+		login(t, r.Email, r.Password)
+	}
+	expect(t, request{method: "POST", path: "/api/v1/auth/register", body: map[string]string{"email": "register-blank@example.com", "password": " "}}, fiber.StatusBadRequest, nil)
+}
+
+func TestPasswordChangePolicy(t *testing.T) {
+	token := signUp(t, "password-policy@example.com")
+	change := func(pw string) request {
+		return request{method: "PUT", path: "/api/v1/profile/password", token: token, body: handlers.PasswordChange{CurrentPassword: password, NewPassword: pw}}
+	}
+	_, body := do(t, change("short1"))
+	if c := problemCode(body); c != "weak_password" && c != "invalid_request" {
+		t.Errorf("short password: code %q", c)
+	}
+	_, body = do(t, change("lettersonly"))
+	if c := problemCode(body); c != "weak_password" {
+		t.Errorf("password without digits: code %q, want weak_password", c)
+	}
+}
+
+// The notice of a password change cannot be turned off: it is how the owner finds
+// out about a takeover.
+func TestPasswordChangeNoticeAlwaysSent(t *testing.T) {
+	const email = "password-notice@example.com"
+	token := signUp(t, email)
+	expect(t, request{method: "PUT", path: "/api/v1/profile/preferences", token: token, body: handlers.Preferences{
+		Locale: "en", Timezone: "UTC", DateFormat: "YYYY-MM-DD",
+		Notifications: handlers.NotificationSettings{SecurityEmail: false},
+	}}, fiber.StatusOK, nil)
+	before := len(mailbox.Messages())
+	expect(t, request{method: "PUT", path: "/api/v1/profile/password", token: token, body: handlers.PasswordChange{CurrentPassword: password, NewPassword: "n3w-password"}}, fiber.StatusOK, nil)
+	if len(mailbox.Messages()) == before {
+		t.Fatal("no mail after the password change")
+	}
+	if m := lastMail(t, email); m.Subject != "Your password was changed" {
+		t.Errorf("subject %q", m.Subject)
+	}
+}
+
+// Tokens are revoked by a password change to the millisecond: those issued in the
+// same second before it are rejected, the one returned by the change is not.
+func TestPasswordChangeRevokesSameSecond(t *testing.T) {
+	const email = "password-same-second@example.com"
+	token := signUp(t, email)
+	var resp handlers.PasswordChangeResponse
+	expect(t, request{method: "PUT", path: "/api/v1/profile/password", token: token, body: handlers.PasswordChange{CurrentPassword: password, NewPassword: "n3w-password"}}, fiber.StatusOK, &resp)
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: resp.Token}, fiber.StatusOK, nil)
+
+	user, err := users.GetByEmail(db.DB, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := user.PasswordChangedAt.UnixMilli()
+	for _, iat := range []float64{float64(changed / 1000), float64(changed-1) / 1000, float64(changed) / 1000} {
+		expectCode(t, request{method: "GET", path: "/api/v1/profile", token: tokenIssuedAt(t, email, iat)}, fiber.StatusUnauthorized, "token_revoked")
+	}
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: tokenIssuedAt(t, email, float64(changed+1)/1000)}, fiber.StatusOK, nil)
+}
+
+// The notice of an attempt to register a taken address is the one security email
+// that can be turned off.
+func TestRegistrationAttemptNoticeOptOut(t *testing.T) {
+	const email = "registration-opt-out@example.com"
+	token := signUp(t, email)
+	os.Setenv("REGISTRATION_UNIFORM_RESPONSE", "true")
+	defer os.Unsetenv("REGISTRATION_UNIFORM_RESPONSE")
+	register := request{method: "POST", path: "/api/v1/auth/register", body: handlers.AuthRequest{Email: email, Password: password}}
+	sent := func() int {
+		n := 0
+		for _, m := range mailbox.Messages() {
+			if m.To == email {
+				n++
+			}
+		}
+		return n
+	}
+
+	before := sent()
+	expect(t, register, fiber.StatusAccepted, nil)
+	for deadline := time.Now().Add(2 * time.Second); sent() == before && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+	}
+	if sent() == before {
+		t.Fatal("no notice with security_email on")
+	}
+
+	expect(t, request{method: "PUT", path: "/api/v1/profile/preferences", token: token, body: handlers.Preferences{
+		Locale: "en", Timezone: "UTC", DateFormat: "YYYY-MM-DD",
+		Notifications: handlers.NotificationSettings{SecurityEmail: false},
+	}}, fiber.StatusOK, nil)
+	before = sent()
+	expect(t, register, fiber.StatusAccepted, nil)
+	time.Sleep(200 * time.Millisecond)
+	if sent() != before {
+		t.Errorf("notice sent with security_email off: %q", lastMail(t, email).Subject)
+	}
+}
+
+// Email changes match addresses exactly, like registration and login.
+func TestEmailChangeExactAddress(t *testing.T) {
+	signUp(t, "email-change-taken@example.com")
+	token := signUp(t, "email-change-exact@example.com")
+	change := func(to string) request {
+		return request{method: "POST", path: "/api/v1/profile/email", token: token, body: handlers.EmailChangeRequest{NewEmail: to, Password: password}}
+	}
+	expectCode(t, change("email-change-taken@example.com"), fiber.StatusConflict, "email_taken")
+	expect(t, change("Email-Change-Taken@example.com"), fiber.StatusAccepted, nil)
+}
+
+// If-Match may list several tags; the write goes ahead if any of them is current.
+func TestIfMatchList(t *testing.T) {
+	token := signUp(t, "if-match-list@example.com")
+	etag := expect(t, request{method: "GET", path: "/api/v1/profile", token: token}, fiber.StatusOK, nil).Header.Get(fiber.HeaderETag)
+	patch := func(ifMatch string, body map[string]string) request {
+		return request{method: "PATCH", path: "/api/v1/profile", token: token, body: body, contentType: "application/merge-patch+json",
+			header: map[string]string{fiber.HeaderIfMatch: ifMatch}}
+	}
+	name := map[string]string{"first_name": "Listed"}
+
+	expect(t, patch(`"v999", `+etag, name), fiber.StatusOK, nil)
+	expect(t, patch(`"v999", `+etag, name), fiber.StatusPreconditionFailed, nil)
+	current := expect(t, request{method: "GET", path: "/api/v1/profile", token: token}, fiber.StatusOK, nil).Header.Get(fiber.HeaderETag)
+	// weak tags never match, even an empty patch
+	expect(t, patch(etag+", W/"+current, map[string]string{}), fiber.StatusPreconditionFailed, nil)
+	expect(t, patch(etag+", "+current, map[string]string{}), fiber.StatusOK, nil)
+}
+
+// Form posts were accepted before requests were validated against the spec, on the
+// versioned routes and their deprecated aliases alike.
+func TestAuthFormBody(t *testing.T) {
+	for _, prefix := range []string{"/api/v1", ""} {
+		email := "form" + strings.ReplaceAll(prefix, "/", "-") + "@example.com"
+		form := url.Values{"email": {email}, "password": {password}}.Encode()
+		expect(t, request{method: "POST", path: prefix + "/auth/register", body: form, contentType: fiber.MIMEApplicationForm}, fiber.StatusCreated, nil)
+		var resp handlers.TokenResponse
+		expect(t, request{method: "POST", path: prefix + "/auth/login", body: form, contentType: fiber.MIMEApplicationForm}, fiber.StatusOK, &resp)
+		if resp.Token == "" {
+			t.Errorf("%s/auth/login: no token", prefix)
+		}
+	}
+	expect(t, request{method: "POST", path: "/api/v1/auth/login", body: "email=form-blank@example.com", contentType: fiber.MIMEApplicationForm}, fiber.StatusBadRequest, nil)
+	// JSON is still validated, and only in the documented media type
+	expect(t, request{method: "POST", path: "/api/v1/auth/login", body: `{"email": "form-api-v1@example.com"}`, contentType: "application/merge-patch+json"}, fiber.StatusUnsupportedMediaType, nil)
+}
+
+// bcrypt hashed only the first 72 bytes of longer passwords. Accounts with such a
+// hash still log in with the whole password, which then replaces the hash.
+func TestLongPasswordBcryptHash(t *testing.T) {
+	const email = "bcrypt-long@example.com"
+	long := strings.Repeat("correct-h0rse-", 6) // 84 bytes
+	expect(t, request{method: "POST", path: "/api/v1/auth/register", body: handlers.AuthRequest{Email: email, Password: long}}, fiber.StatusCreated, nil)
+	hash, err := bcrypt.GenerateFromPassword([]byte(long[:72]), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB.Exec("UPDATE users SET password = ? WHERE email = ?", string(hash), email); err != nil {
+		t.Fatal(err)
+	}
+
+	login(t, email, long)
+	user, err := users.GetByEmail(db.DB, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users.NeedsRehash(user.PasswordHash()) {
+		t.Error("bcrypt hash not replaced at login")
+	}
+	// the new hash covers the whole password
+	login(t, email, long)
+	expect(t, request{method: "POST", path: "/api/v1/auth/login", body: handlers.AuthRequest{Email: email, Password: long[:72] + "different"}}, fiber.StatusUnauthorized, nil)
+}
@@ -1,148 +1,165 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/openapi"
+	"fiber-rest-api/internal/profileattr"
+
 	"github.com/gofiber/fiber/v2"
+	swaggerFiles "github.com/swaggo/files/v2"
 )
 
+// MessageResponse is the body of responses that only confirm an action.
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// V1 is the path prefix of version 1 of the API. Links built by handlers point there.
+const V1 = "/api/v1"
+
+// APISpec documents the routes registered by router.SetupRoutes.
+var APISpec *openapi.Spec
+
+// SwaggerJSON serves APISpec as OpenAPI 3.0 JSON.
 func SwaggerJSON(c *fiber.Ctx) error {
-	// OpenAPI 3.0 JSON describing auth and profile endpoints
-	spec := `{
-  "openapi": "3.0.0",
-  "info": { "title": "Fiber REST API", "version": "1.0.0" },
-  "paths": {
-    "/auth/register": {
-      "post": {
-        "summary": "Register a new user",
-        "requestBody": {
-          "required": true,
-          "content": {
-            "application/json": {
-              "schema": { "$ref": "#/components/schemas/AuthRequest" }
-            }
-          }
-        },
-        "responses": { "201": { "description": "registered" }, "409": { "description": "email already registered" } }
-      }
-    },
-    "/auth/login": {
-      "post": {
-        "summary": "Login and receive JWT",
-        "requestBody": {
-          "required": true,
-          "content": {
-            "application/json": {
-              "schema": { "$ref": "#/components/schemas/AuthRequest" }
-            }
-          }
-        },
-        "responses": { "200": { "description": "token returned", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/TokenResponse" } } } }, "401": { "description": "invalid credentials" } }
-      }
-    },
-    "/profile": {
-      "get": {
-        "summary": "Get current user's profile",
-        "security": [ { "bearerAuth": [] } ],
-        "responses": { "200": { "description": "profile returned", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Profile" } } } }, "401": { "description": "unauthorized" } }
-      },
-      "put": {
-        "summary": "Update current user's profile",
-        "security": [ { "bearerAuth": [] } ],
-        "requestBody": {
-          "required": true,
-          "content": {
-            "application/json": { "schema": { "$ref": "#/components/schemas/ProfileUpdate" } }
-          }
-        },
-        "responses": { "200": { "description": "updated profile", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Profile" } } } }, "400": { "description": "validation error" }, "401": { "description": "unauthorized" } }
-      }
-    },
-    "/profile/avatar": {
-      "post": {
-        "summary": "Upload avatar for current user",
-        "security": [ { "bearerAuth": [] } ],
-        "requestBody": {
-          "required": true,
-          "content": {
-            "multipart/form-data": {
-              "schema": {
-                "type": "object",
-                "properties": {
-                  "avatar": { "type": "string", "format": "binary" }
-                },
-                "required": ["avatar"]
-              }
-            }
-          }
-        },
-        "responses": { "200": { "description": "avatar uploaded", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/AvatarResponse" } } } }, "400": { "description": "invalid file" }, "401": { "description": "unauthorized" } }
+	doc, err := json.Marshal(APISpec)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to build spec")
+	}
+	// the document follows the attribute definitions, so always revalidate
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set("Content-Type", "application/json")
+	return c.Send(doc)
+}
+
+// ProfileAttributesSchema fills the ProfileAttributes schema with the custom
+// attributes currently defined, so the document and request validation always match
+// the database. Optional attributes are nullable because null clears them.
+func ProfileAttributesSchema(schema openapi.Schema) (openapi.Schema, error) {
+	// code that only renders the document (cmd/clientgen, the router test) has no
+	// database, and then any attribute is allowed
+	if db.DB == nil {
+		schema["additionalProperties"] = true
+		return schema, nil
+	}
+	defs, err := profileattr.LoadAll(db.DB)
+	if err != nil {
+		return nil, err
+	}
+	props := openapi.Schema{}
+	for _, d := range defs {
+		p := d.Schema()
+		if !d.Required {
+			p["nullable"] = true
+		}
+		props[d.Key] = p
+	}
+	schema["properties"] = props
+	return schema, nil
+}
+
+// docsCSP is the Content-Security-Policy of the documentation pages. Everything is
+// served from this origin; Swagger UI needs inline styles and data: images.
+const docsCSP = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; connect-src 'self'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'"
+
+// swaggerAssets are the files of the embedded Swagger UI distribution served under
+// /docs/assets, by name.
+var swaggerAssets = map[string]bool{
+	"swagger-ui-bundle.js": true,
+	"swagger-ui.css":       true,
+	"favicon-16x16.png":    true,
+	"favicon-32x32.png":    true,
+}
+
+// swaggerInitJS starts Swagger UI. It is served as a file because the CSP forbids
+// inline scripts. A successful "Try it out" of POST /auth/login (or a password
+// change) authorizes the other operations with the returned token.
+const swaggerInitJS = `window.onload = function () {
+  var ui = SwaggerUIBundle({
+    url: '/docs/swagger.json',
+    dom_id: '#swagger-ui',
+    deepLinking: true,
+    persistAuthorization: true,
+    responseInterceptor: function (res) {
+      var path = new URL(res.url, window.location.href).pathname;
+      if (res.ok && /\/(auth\/login|profile\/password)$/.test(path) && res.body && res.body.token) {
+        ui.preauthorizeApiKey('bearerAuth', res.body.token);
       }
-    }
-  },
-  "components": {
-    "securitySchemes": {
-      "bearerAuth": { "type": "http", "scheme": "bearer", "bearerFormat": "JWT" }
+      return res;
     },
-    "schemas": {
-      "AuthRequest": {
-        "type": "object",
-        "properties": { "email": { "type": "string" }, "password": { "type": "string" } },
-        "required": ["email", "password"]
-      },
-      "TokenResponse": {
-        "type": "object",
-        "properties": { "token": { "type": "string" } }
-      },
-      "Profile": {
-        "type": "object",
-        "properties": {
-          "id": { "type": "integer" },
-          "email": { "type": "string" },
-          "first_name": { "type": "string" },
-          "last_name": { "type": "string" },
-          "phone": { "type": "string" },
-          "avatar": { "type": "string" }
-        }
-      },
-      "ProfileUpdate": {
-        "type": "object",
-        "properties": {
-          "first_name": { "type": "string" },
-          "last_name": { "type": "string" },
-          "phone": { "type": "string" }
-        }
-      },
-      "AvatarResponse": {
-        "type": "object",
-        "properties": { "avatar": { "type": "string" } }
-      }
-    }
-  }
-}`
+  });
+  window.ui = ui;
+};
+`
 
-	c.Set("Content-Type", "application/json")
-	return c.SendString(spec)
-}
+// docsAssetVersion changes whenever an asset does. It is appended to asset URLs so
+// they can be cached forever.
+var docsAssetVersion = func() string {
+	h := sha256.New()
+	for _, name := range []string{"swagger-ui-bundle.js", "swagger-ui.css"} {
+		data, err := fs.ReadFile(swaggerFiles.FS, name)
+		if err != nil {
+			panic(fmt.Sprintf("embedded swagger ui: %v", err))
+		}
+		h.Write(data)
+	}
+	h.Write([]byte(swaggerInitJS))
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}()
 
+// SwaggerUI serves the Swagger UI page. Its assets are embedded in the binary, so the
+// page works without internet access.
 func SwaggerUI(c *fiber.Ctx) error {
 	html := `<!doctype html>
 <html>
   <head>
     <meta charset="utf-8" />
     <title>Swagger UI</title>
-    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@4/swagger-ui.css" />
+    <link rel="stylesheet" href="/docs/assets/swagger-ui.css?v={{v}}" />
+    <link rel="icon" type="image/png" href="/docs/assets/favicon-32x32.png?v={{v}}" sizes="32x32" />
+    <link rel="icon" type="image/png" href="/docs/assets/favicon-16x16.png?v={{v}}" sizes="16x16" />
   </head>
   <body>
     <div id="swagger-ui"></div>
-    <script src="https://unpkg.com/swagger-ui-dist@4/swagger-ui-bundle.js"></script>
-    <script>
-      const ui = SwaggerUIBundle({
-        url: '/docs/swagger.json',
-        dom_id: '#swagger-ui',
-      });
-    </script>
+    <script src="/docs/assets/swagger-ui-bundle.js?v={{v}}"></script>
+    <script src="/docs/assets/swagger-init.js?v={{v}}"></script>
   </body>
 </html>`
 
+	c.Set(fiber.HeaderContentSecurityPolicy, docsCSP)
+	c.Set(fiber.HeaderCacheControl, "no-cache")
 	c.Type("html")
-	return c.SendString(html)
+	return c.SendString(strings.ReplaceAll(html, "{{v}}", docsAssetVersion))
+}
+
+// SwaggerAsset serves a file of the embedded Swagger UI. URLs carry the asset version,
+// so responses are cacheable for a year.
+func SwaggerAsset(c *fiber.Ctx) error {
+	name := c.Params("name")
+	var data []byte
+	switch {
+	case name == "swagger-init.js":
+		data = []byte(swaggerInitJS)
+	case swaggerAssets[name]:
+		var err error
+		if data, err = fs.ReadFile(swaggerFiles.FS, name); err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to read asset")
+		}
+	default:
+		return apperr.New(apperr.NotFound, "asset not found")
+	}
+	c.Set(fiber.HeaderCacheControl, "public, max-age=31536000, immutable")
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Type(path.Ext(name))
+	return c.Send(data)
 }
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	netmail "net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/mail"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Mail delivers account emails. main replaces it with the configured provider.
+var Mail mail.Sender = mail.LogSender{}
+
+const emailChangeTTL = 24 * time.Hour
+
+// EmailChangeRequest is the body of POST /profile/email.
+type EmailChangeRequest struct {
+	NewEmail string `json:"new_email" openapi:"required,format=email"`
+	Password string `json:"password,omitempty" doc:"required unless the account has no password, in which case it must have signed in within the last 10 minutes"`
+}
+
+// EmailChangeConfirm is the body of POST /profile/email/confirm.
+type EmailChangeConfirm struct {
+	Token string `json:"token" openapi:"required"`
+}
+
+// EmailChangeResult is the body of a successful email change confirmation.
+type EmailChangeResult struct {
+	Message string `json:"message"`
+	Email   string `json:"email"`
+}
+
+// baseURL is the public address of the server, used to build links in emails.
+func baseURL() string {
+	if u := os.Getenv("APP_BASE_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:3000"
+}
+
+// randomToken returns a URL-safe random token and the hash stored in the database.
+func randomToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequestEmailChange starts an email change. The current password is required, or a
+// recent sign-in for accounts without one (checkReauthentication); a
+// confirmation link is sent to the new address and a notice to the old one. The
+// address only changes once the link is confirmed.
+func RequestEmailChange(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+
+	var req EmailChangeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	newEmail := strings.TrimSpace(req.NewEmail)
+	if newEmail == "" {
+		return apperr.New(apperr.InvalidRequest, "new_email required")
+	}
+	if addr, err := netmail.ParseAddress(newEmail); err != nil || addr.Address != newEmail {
+		return apperr.New(apperr.InvalidRequest, "new_email is not a valid email address")
+	}
+
+	user, err := users.Get(db.DB, uid)
+	switch err {
+	case users.ErrNotFound:
+		return apperr.New(apperr.NotFound, "user not found")
+	case nil:
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch user")
+	}
+	if err := checkReauthentication(c, user, req.Password); err != nil {
+		return err
+	}
+	email := user.Email
+	if newEmail == email {
+		return apperr.New(apperr.InvalidRequest, "new_email is the current email")
+	}
+
+	// early check for a friendlier error; uniqueness is enforced again on confirmation
+	var taken int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", newEmail).Scan(&taken); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to query user")
+	}
+	if taken > 0 {
+		return apperr.New(apperr.EmailTaken, "email already registered")
+	}
+
+	token, tokenHash, err := randomToken()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to generate token")
+	}
+	now := time.Now()
+	// a new request supersedes any pending one
+	if _, err := db.DB.Exec("DELETE FROM email_changes WHERE user_id = ?", uid); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to store email change")
+	}
+	_, err = db.DB.Exec("INSERT INTO email_changes (token_hash, user_id, old_email, new_email, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		tokenHash, uid, email, newEmail, now.Unix(), now.Add(emailChangeTTL).Unix())
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to store email change")
+	}
+
+	prefs, err := loadPreferences(uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch preferences")
+	}
+	err = sendUserMail(newEmail, prefs, "email_change_confirm", map[string]interface{}{
+		"OldEmail": email,
+		"Hours":    int(emailChangeTTL.Hours()),
+		"Link":     baseURL() + V1 + "/profile/email/confirm?token=" + url.QueryEscape(token),
+	})
+	if err != nil {
+		return apperr.New(apperr.DeliveryFailed, "failed to send confirmation email")
+	}
+	// always sent regardless of notification settings: it is the owner's chance to
+	// notice an account takeover
+	sendUserMail(email, prefs, "email_change_notice", map[string]interface{}{
+		"NewEmail": newEmail,
+		"Time":     now,
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(MessageResponse{Message: "confirmation sent to new email"})
+}
+
+// ConfirmEmailChange applies a pending email change. The token is read from the
+// "token" query parameter (the emailed link) or the JSON body. Tokens issued for the
+// old address stop working once the change is applied.
+func ConfirmEmailChange(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" && len(c.Body()) > 0 {
+		var req EmailChangeConfirm
+		if err := c.BodyParser(&req); err != nil {
+			return apperr.New(apperr.MalformedBody, "invalid request body")
+		}
+		token = req.Token
+	}
+	if token == "" {
+		return apperr.New(apperr.InvalidRequest, "token required")
+	}
+	tokenHash := hashToken(token)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to confirm email change")
+	}
+	defer tx.Rollback()
+
+	var uid int
+	var oldEmail, newEmail string
+	var expiresAt int64
+	row := tx.QueryRow("SELECT user_id, old_email, new_email, expires_at FROM email_changes WHERE token_hash = ?", tokenHash)
+	switch err := row.Scan(&uid, &oldEmail, &newEmail, &expiresAt); err {
+	case sql.ErrNoRows:
+		return apperr.New(apperr.InvalidConfirmationToken, "invalid or expired token")
+	case nil:
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to confirm email change")
+	}
+	if time.Now().Unix() > expiresAt {
+		tx.Exec("DELETE FROM email_changes WHERE token_hash = ?", tokenHash)
+		tx.Commit()
+		return apperr.New(apperr.InvalidConfirmationToken, "invalid or expired token")
+	}
+
+	var taken int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE email = ? AND id != ?", newEmail, uid).Scan(&taken); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to confirm email change")
+	}
+	if taken > 0 {
+		return apperr.New(apperr.EmailTaken, "email already registered")
+	}
+
+	// the change only applies to the address it was requested for
+	res, err := tx.Exec("UPDATE users SET email = ?, email_verified_at = ?, version = version + 1 WHERE id = ? AND email = ?", newEmail, time.Now().Unix(), uid, oldEmail)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return apperr.New(apperr.EmailTaken, "email already registered")
+		}
+		return apperr.New(apperr.Internal, "failed to confirm email change")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Exec("DELETE FROM email_changes WHERE token_hash = ?", tokenHash)
+		tx.Commit()
+		return apperr.New(apperr.InvalidConfirmationToken, "invalid or expired token")
+	}
+	if _, err := tx.Exec("DELETE FROM email_changes WHERE user_id = ?", uid); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to confirm email change")
+	}
+	// the link is opened without a session; holding the token makes the owner the actor
+	if err := recordProfileEvent(tx, c, uid, uid, "email.change", "email", &oldEmail, &newEmail); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to record history")
+	}
+	if err := tx.Commit(); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to confirm email change")
+	}
+
+	return c.JSON(EmailChangeResult{Message: "email changed, please log in again", Email: newEmail})
+}
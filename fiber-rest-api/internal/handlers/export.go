@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Export statuses stored in data_exports.status.
+const (
+	exportPending = "pending"
+	exportReady   = "ready"
+	exportFailed  = "failed"
+)
+
+const (
+	exportsDir = "exports"
+	// archives are deleted this long after they were built
+	exportRetention = 24 * time.Hour
+	// download links stay valid this long; GET /profile/export/{id} issues fresh ones
+	exportLinkTTL = time.Hour
+	// a pending export older than this was interrupted (e.g. by a restart)
+	exportStaleAfter = 10 * time.Minute
+)
+
+// DataExport is the status of a personal data export as returned by the API.
+type DataExport struct {
+	ID          string `json:"id"`
+	Status      string `json:"status" openapi:"enum=pending|ready|failed"`
+	CreatedAt   int64  `json:"created_at"`
+	CompletedAt int64  `json:"completed_at,omitempty"`
+	ExpiresAt   int64  `json:"expires_at,omitempty" doc:"when the archive is deleted"`
+	DownloadURL string `json:"download_url,omitempty" doc:"signed link, present when ready"`
+}
+
+// exportSigningKey is the HMAC key of download links. It falls back to the JWT secret.
+func exportSigningKey() []byte {
+	if k := os.Getenv("EXPORT_SIGNING_KEY"); k != "" {
+		return []byte(k)
+	}
+	return jwtSecret()
+}
+
+func signExport(id string, expires int64) string {
+	mac := hmac.New(sha256.New, exportSigningKey())
+	mac.Write([]byte(id + "." + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// exportDownloadURL returns a signed link to the archive, valid for exportLinkTTL
+// but never past the archive's own expiry.
+func exportDownloadURL(id string, archiveExpires int64) string {
+	expires := time.Now().Add(exportLinkTTL).Unix()
+	if archiveExpires < expires {
+		expires = archiveExpires
+	}
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", signExport(id, expires))
+	return baseURL() + V1 + "/exports/" + id + "?" + q.Encode()
+}
+
+// purgeExpiredExports deletes archives past their retention and exports that never
+// finished. Errors are only logged.
+func purgeExpiredExports() {
+	now := time.Now()
+	rows, err := db.DB.Query(`SELECT id, file FROM data_exports
+		WHERE expires_at < ? OR (status != ? AND created_at < ?)`,
+		now.Unix(), exportReady, now.Add(-exportRetention).Unix())
+	if err != nil {
+		log.Printf("purge exports: %v", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		var file sql.NullString
+		if err := rows.Scan(&id, &file); err != nil {
+			log.Printf("purge exports: %v", err)
+			break
+		}
+		if file.String != "" {
+			os.Remove(filepath.Join(exportsDir, file.String))
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		db.DB.Exec("DELETE FROM data_exports WHERE id = ?", id)
+	}
+}
+
+// RequestExport starts building an archive of the current user's personal data:
+// profile, preferences, avatars, login history and profile history. The archive is
+// built in the background and the user is emailed a download link when it is ready.
+func RequestExport(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	purgeExpiredExports()
+
+	// one export at a time; a second request returns the one in progress
+	var existing DataExport
+	err := db.DB.QueryRow("SELECT id, status, created_at FROM data_exports WHERE user_id = ? AND status = ? AND created_at >= ?",
+		uid, exportPending, time.Now().Add(-exportStaleAfter).Unix()).Scan(&existing.ID, &existing.Status, &existing.CreatedAt)
+	switch err {
+	case nil:
+		c.Location(V1 + "/profile/export/" + existing.ID)
+		return c.Status(fiber.StatusAccepted).JSON(existing)
+	case sql.ErrNoRows:
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to start export")
+	}
+
+	id, _, err := randomToken()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to start export")
+	}
+	export := DataExport{ID: id, Status: exportPending, CreatedAt: time.Now().Unix()}
+	if _, err := db.DB.Exec("INSERT INTO data_exports (id, user_id, status, created_at) VALUES (?, ?, ?, ?)",
+		export.ID, uid, export.Status, export.CreatedAt); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to start export")
+	}
+
+	go func() {
+		if err := buildExport(id, uid); err != nil {
+			log.Printf("export %s for user %d failed: %v", id, uid, err)
+			db.DB.Exec("UPDATE data_exports SET status = ?, completed_at = ? WHERE id = ?", exportFailed, time.Now().Unix(), id)
+		}
+	}()
+
+	c.Location(V1 + "/profile/export/" + id)
+	return c.Status(fiber.StatusAccepted).JSON(export)
+}
+
+// buildExport writes the archive of export id, marks it ready and emails the link.
+func buildExport(id string, uid int) error {
+	profile, _, err := loadProfile(uid, false)
+	if err != nil {
+		return err
+	}
+	prefs, err := loadPreferences(uid)
+	if err != nil {
+		return err
+	}
+	visibility, err := loadVisibility(uid)
+	if err != nil {
+		return err
+	}
+	logins, err := loadLoginEvents(uid)
+	if err != nil {
+		return err
+	}
+	history, err := loadHistory(uid, false, 0, 0)
+	if err != nil {
+		return err
+	}
+	identities, err := loadIdentities(uid)
+	if err != nil {
+		return err
+	}
+	consents, err := loadConsents(uid)
+	if err != nil {
+		return err
+	}
+	keys, err := loadAPIKeys(uid)
+	if err != nil {
+		return err
+	}
+
+	// the current avatar plus earlier uploads that are still on disk
+	var current sql.NullString
+	if err := db.DB.QueryRow("SELECT avatar FROM users WHERE id = ?", uid).Scan(&current); err != nil {
+		return err
+	}
+	avatars := []string{}
+	seen := map[string]bool{}
+	addAvatar := func(name string) {
+		name = filepath.Base(name)
+		if name == "" || name == "." || seen[name] {
+			return
+		}
+		seen[name] = true
+		avatars = append(avatars, name)
+	}
+	addAvatar(current.String)
+	for _, e := range history {
+		if e.Field == "avatar" && e.OldValue != nil {
+			addAvatar(*e.OldValue)
+		}
+	}
+
+	if err := os.MkdirAll(exportsDir, 0700); err != nil {
+		return err
+	}
+	fname := id + ".zip"
+	tmp := filepath.Join(exportsDir, fname+".tmp")
+	if err := writeExportArchive(tmp, map[string]interface{}{
+		"profile.json": fiber.Map{
+			"profile":     profile,
+			"preferences": prefs,
+			"visibility":  visibility,
+			"identities":  identities,
+			"consents":    consents,
+			"api_keys":    keys,
+			"exported_at": time.Now().UTC().Format(time.RFC3339),
+		},
+		"login_history.json":   logins,
+		"profile_history.json": history,
+	}, avatars); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(exportsDir, fname)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	now := time.Now()
+	expires := now.Add(exportRetention).Unix()
+	if _, err := db.DB.Exec("UPDATE data_exports SET status = ?, file = ?, completed_at = ?, expires_at = ? WHERE id = ?",
+		exportReady, fname, now.Unix(), expires, id); err != nil {
+		os.Remove(filepath.Join(exportsDir, fname))
+		return err
+	}
+
+	// the archive stays available through GET /profile/export/{id} if mail fails
+	err = sendUserMail(profile.Email, prefs, "data_export_ready", map[string]interface{}{
+		"Link":    exportDownloadURL(id, expires),
+		"Minutes": int(exportLinkTTL.Minutes()),
+		"Expires": time.Unix(expires, 0),
+	})
+	if err != nil {
+		log.Printf("export %s: notify user %d: %v", id, uid, err)
+	}
+	return nil
+}
+
+// writeExportArchive creates a ZIP at path holding each document as indented JSON
+// and the named avatar files under avatars/. Avatars missing from disk are skipped.
+func writeExportArchive(path string, documents map[string]interface{}, avatars []string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	now := time.Now()
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+	}
+
+	for name, doc := range documents {
+		w, err := create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+	for _, name := range avatars {
+		src, err := os.Open(filepath.Join("uploads", name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		w, err := create("avatars/" + name)
+		if err == nil {
+			_, err = io.Copy(w, src)
+		}
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// GetExport returns the status of one of the current user's exports and, once it is
+// ready, a fresh signed download link.
+func GetExport(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	var export DataExport
+	var completedAt, expiresAt sql.NullInt64
+	row := db.DB.QueryRow("SELECT id, status, created_at, completed_at, expires_at FROM data_exports WHERE id = ? AND user_id = ?", c.Params("id"), uid)
+	switch err := row.Scan(&export.ID, &export.Status, &export.CreatedAt, &completedAt, &expiresAt); err {
+	case sql.ErrNoRows:
+		return apperr.New(apperr.NotFound, "export not found")
+	case nil:
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch export")
+	}
+	export.CompletedAt = completedAt.Int64
+	export.ExpiresAt = expiresAt.Int64
+	if export.Status == exportReady {
+		if time.Now().Unix() >= export.ExpiresAt {
+			return apperr.New(apperr.ExportExpired, "export expired")
+		}
+		export.DownloadURL = exportDownloadURL(export.ID, export.ExpiresAt)
+	}
+	return c.JSON(export)
+}
+
+// DownloadExport serves an export archive. It needs no session: the "expires" and
+// "signature" query parameters issued by exportDownloadURL authorize the download.
+func DownloadExport(c *fiber.Ctx) error {
+	id := c.Params("id")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(c.Query("signature")), []byte(signExport(id, expires))) {
+		return apperr.New(apperr.InvalidSignature, "invalid signature")
+	}
+	if time.Now().Unix() >= expires {
+		return apperr.New(apperr.LinkExpired, "link expired")
+	}
+
+	var file sql.NullString
+	var archiveExpires sql.NullInt64
+	row := db.DB.QueryRow("SELECT file, expires_at FROM data_exports WHERE id = ? AND status = ?", id, exportReady)
+	switch err := row.Scan(&file, &archiveExpires); err {
+	case sql.ErrNoRows:
+		return apperr.New(apperr.NotFound, "export not found")
+	case nil:
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch export")
+	}
+	path := filepath.Join(exportsDir, filepath.Base(file.String))
+	if time.Now().Unix() >= archiveExpires.Int64 {
+		return apperr.New(apperr.ExportExpired, "export expired")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return apperr.New(apperr.ExportExpired, "export expired")
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Download(path, "personal-data-"+time.Now().Format("20060102")+".zip")
+}
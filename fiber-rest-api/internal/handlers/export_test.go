@@ -0,0 +1,49 @@
+package handlers_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/users"
+)
+
+// Expired archives are deleted by the periodic job, not only when the next export is
+// requested.
+func TestExpiredExportPurged(t *testing.T) {
+	const email = "export-purge@example.com"
+	signUp(t, email)
+	user, err := users.GetByEmail(db.DB, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("exports", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join("exports", "expired.zip")
+	if err := os.WriteFile(file, []byte("zip"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-48 * time.Hour).Unix()
+	if _, err := db.DB.Exec("INSERT INTO data_exports (id, user_id, status, file, created_at, completed_at, expires_at) VALUES ('expired', ?, 'ready', 'expired.zip', ?, ?, ?)",
+		user.ID, past, past, past+1); err != nil {
+		t.Fatal(err)
+	}
+
+	// one round: the job returns once stop is closed
+	stop := make(chan struct{})
+	close(stop)
+	handlers.RunAccountPurge(stop, time.Hour)
+
+	var n int
+	db.DB.QueryRow("SELECT COUNT(*) FROM data_exports WHERE id = 'expired'").Scan(&n)
+	if n != 0 {
+		t.Error("expired export still listed")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("archive still on disk: %v", err)
+	}
+}
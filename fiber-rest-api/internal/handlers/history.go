@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/profileattr"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HistoryEntry is one recorded change of a profile field.
+type HistoryEntry struct {
+	ID        int64   `json:"id"`
+	ActorID   int64   `json:"actor_id" doc:"user who made the change; differs from the owner for admin edits, 0 for changes made with cmd/admin"`
+	Action    string  `json:"action" openapi:"example=profile.patch"`
+	Field     string  `json:"field" doc:"profile field, or attributes.<key> for custom attributes"`
+	OldValue  *string `json:"old_value" openapi:"nullable"`
+	NewValue  *string `json:"new_value" openapi:"nullable"`
+	IP        string  `json:"ip"`
+	UserAgent string  `json:"user_agent"`
+	CreatedAt int64   `json:"created_at" doc:"unix seconds"`
+}
+
+// HistoryPage is one page of a user's history, newest first.
+type HistoryPage struct {
+	Entries    []HistoryEntry `json:"entries"`
+	NextBefore int64          `json:"next_before,omitempty" doc:"present when more entries may follow"`
+}
+
+// historyFields are the users columns tracked in profile_history.
+var historyFields = []string{"email", "username", "first_name", "last_name", "phone", "phone_display", "avatar", "role"}
+
+// snapshotProfile captures the tracked fields and custom attributes of a user so that
+// a later snapshot can be diffed against it. Attributes are keyed "attributes.<key>".
+func snapshotProfile(tx *sql.Tx, uid int) (map[string]*string, error) {
+	values := make([]sql.NullString, len(historyFields))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := tx.QueryRow("SELECT "+strings.Join(historyFields, ", ")+" FROM users WHERE id = ?", uid).Scan(dest...); err != nil {
+		return nil, err
+	}
+	snap := make(map[string]*string, len(historyFields))
+	for i, f := range historyFields {
+		if values[i].Valid {
+			v := values[i].String
+			snap[f] = &v
+		} else {
+			snap[f] = nil
+		}
+	}
+	attrs, err := profileattr.LoadValues(tx, uid)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range attrs {
+		s := string(v)
+		snap[attributeFieldPrefix+k] = &s
+	}
+	return snap, nil
+}
+
+// recordProfileChanges appends a history row for every field that differs between
+// the before and after snapshots.
+func recordProfileChanges(tx *sql.Tx, c *fiber.Ctx, uid, actorID int, action string, before, after map[string]*string) error {
+	seen := map[string]bool{}
+	var fields []string
+	for _, snap := range []map[string]*string{before, after} {
+		for f := range snap {
+			if !seen[f] {
+				seen[f] = true
+				fields = append(fields, f)
+			}
+		}
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		oldV, newV := before[f], after[f]
+		// PUT stores cleared fields as "" where PATCH stores NULL; neither is a change
+		if historyValue(oldV) == historyValue(newV) {
+			continue
+		}
+		if err := recordProfileEvent(tx, c, uid, actorID, action, f, oldV, newV); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordProfileEvent appends a single history row. Request metadata is taken from c.
+func recordProfileEvent(q users.Queryer, c *fiber.Ctx, uid, actorID int, action, field string, oldValue, newValue *string) error {
+	return users.RecordEvent(q, users.Event{
+		UserID:    uid,
+		ActorID:   actorID,
+		Action:    action,
+		Field:     field,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+}
+
+// historyValue dereferences a snapshot value, treating NULL as empty.
+func historyValue(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
+// strPtr returns a pointer to s, for history values.
+func strPtr(s string) *string {
+	return &s
+}
+
+// writeHistory answers with a page of a user's history, newest first. The "limit"
+// (default 50, max 200) and "before" (an entry id) query parameters page through it.
+// Entries of admin-only attributes are left out unless admin is set.
+func writeHistory(c *fiber.Ctx, uid int, admin bool) error {
+	limit := c.Query("limit", "50")
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 || n > 200 {
+		return apperr.New(apperr.InvalidRequest, "limit must be between 1 and 200")
+	}
+	var before int64
+	if b := c.Query("before"); b != "" {
+		id, err := strconv.ParseInt(b, 10, 64)
+		if err != nil {
+			return apperr.New(apperr.InvalidRequest, "before must be an entry id")
+		}
+		before = id
+	}
+	entries, err := loadHistory(uid, admin, before, n)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch history")
+	}
+
+	page := HistoryPage{Entries: entries}
+	if len(entries) == n {
+		page.NextBefore = entries[len(entries)-1].ID
+	}
+	return c.JSON(page)
+}
+
+// loadHistory returns up to limit history entries of user uid older than entry
+// before, newest first. before <= 0 starts at the newest entry and limit <= 0 returns
+// all of them.
+func loadHistory(uid int, admin bool, before int64, limit int) ([]HistoryEntry, error) {
+	query := `SELECT id, actor_id, action, field, old_value, new_value, ip, user_agent, created_at
+		FROM profile_history WHERE user_id = ?`
+	args := []interface{}{uid}
+	if before > 0 {
+		query += " AND id < ?"
+		args = append(args, before)
+	}
+	if !admin {
+		query += " AND admin_only = 0"
+	}
+	query += " ORDER BY id DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		var e HistoryEntry
+		var oldV, newV sql.NullString
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.Field, &oldV, &newV, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if oldV.Valid {
+			e.OldValue = &oldV.String
+		}
+		if newV.Valid {
+			e.NewValue = &newV.String
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetProfileHistory returns the change history of the current user's profile.
+func GetProfileHistory(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	return writeHistory(c, uid, false)
+}
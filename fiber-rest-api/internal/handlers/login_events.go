@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"fiber-rest-api/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LoginEvent is one login attempt against an existing account.
+type LoginEvent struct {
+	Success   bool   `json:"success"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// recordLoginEvent stores a login attempt. Failures are only logged: a broken audit
+// insert must not lock users out.
+func recordLoginEvent(c *fiber.Ctx, uid int, success bool) {
+	_, err := db.DB.Exec("INSERT INTO login_events (user_id, success, ip, user_agent, created_at) VALUES (?, ?, ?, ?, ?)",
+		uid, success, c.IP(), c.Get(fiber.HeaderUserAgent), time.Now().Unix())
+	if err != nil {
+		log.Printf("record login event for user %d: %v", uid, err)
+	}
+}
+
+// loadLoginEvents returns every recorded login attempt of user uid, newest first.
+func loadLoginEvents(uid int) ([]LoginEvent, error) {
+	rows, err := db.DB.Query("SELECT success, ip, user_agent, created_at FROM login_events WHERE user_id = ? ORDER BY id DESC", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []LoginEvent{}
+	for rows.Next() {
+		var e LoginEvent
+		if err := rows.Scan(&e.Success, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
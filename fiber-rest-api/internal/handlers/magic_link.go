@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"html/template"
+	"log"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	loginLinkTTL         = 15 * time.Minute
+	loginLinkResendAfter = time.Minute
+	loginCodeMaxAttempts = 5
+)
+
+// MagicLinkRequest is the body of POST /auth/magic-link.
+type MagicLinkRequest struct {
+	Email    string `json:"email" openapi:"required,format=email"`
+	Redirect string `json:"redirect,omitempty" doc:"path on this server that confirming the emailed link redirects to, with the token in the URL fragment (#token=...); without it the link answers with JSON"`
+}
+
+// MagicLinkStarted is the body of a successful POST /auth/magic-link.
+type MagicLinkStarted struct {
+	Message   string `json:"message"`
+	ExpiresIn int    `json:"expires_in" doc:"seconds until the link and code expire"`
+}
+
+// MagicLinkVerify is the body of POST /auth/magic-link/verify: the token of the
+// emailed link, or the address and the code from the same email.
+type MagicLinkVerify struct {
+	Token string `json:"token,omitempty" doc:"token of the emailed link"`
+	Email string `json:"email,omitempty" doc:"address the code was sent to"`
+	Code  string `json:"code,omitempty" doc:"6-digit code from the email" openapi:"example=042917"`
+}
+
+// hashLoginCode binds a sign-in code to the address it was sent to.
+func hashLoginCode(email, code string) string {
+	sum := sha256.Sum256([]byte(email + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartMagicLink emails a single-use sign-in link and code to the address. The
+// answer is the same whether or not the address is registered; the mail tells its
+// owner which it is, and using the link for an unknown address creates an account
+// without a password.
+func StartMagicLink(c *fiber.Ctx) error {
+	var req MagicLinkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	if err := sendLoginLink(c, strings.TrimSpace(req.Email), req.Redirect); err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusAccepted).JSON(MagicLinkStarted{
+		Message:   "sign-in link sent, check your email",
+		ExpiresIn: int(loginLinkTTL.Seconds()),
+	})
+}
+
+// sendLoginLink stores a sign-in link for email, replacing any pending one, and
+// mails it with its code. StartMagicLink and the sign-in page of the provider use
+// it.
+func sendLoginLink(c *fiber.Ctx, email, redirect string) error {
+	if email == "" {
+		return apperr.New(apperr.InvalidRequest, "email required")
+	}
+	if addr, err := netmail.ParseAddress(email); err != nil || addr.Address != email {
+		return apperr.New(apperr.InvalidRequest, "email is not a valid email address")
+	}
+	if err := checkRedirect(redirect); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var createdAt int64
+	err := db.DB.QueryRow("SELECT created_at FROM login_links WHERE email = ?", email).Scan(&createdAt)
+	if err == nil && now.Sub(time.Unix(createdAt, 0)) < loginLinkResendAfter {
+		return apperr.New(apperr.RateLimited, "sign-in link recently sent, try again later")
+	}
+
+	token, tokenHash, err := randomToken()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to generate token")
+	}
+	code, err := randomDigits(6)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to generate code")
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to store sign-in link")
+	}
+	defer tx.Rollback()
+	// a new link supersedes any pending one
+	if _, err := tx.Exec("DELETE FROM login_links WHERE email = ?", email); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to store sign-in link")
+	}
+	_, err = tx.Exec("INSERT INTO login_links (token_hash, email, code_hash, redirect, attempts, created_at, expires_at) VALUES (?, ?, ?, ?, 0, ?, ?)",
+		tokenHash, email, hashLoginCode(email, code), sql.NullString{String: redirect, Valid: redirect != ""}, now.Unix(), now.Add(loginLinkTTL).Unix())
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to store sign-in link")
+	}
+	if err := tx.Commit(); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to store sign-in link")
+	}
+
+	sendLoginLinkMail(email, apperr.Language(c), map[string]interface{}{
+		"Minutes": int(loginLinkTTL.Minutes()),
+		"Link":    baseURL() + V1 + "/auth/magic-link/verify?token=" + url.QueryEscape(token),
+		"Code":    code,
+	})
+	return nil
+}
+
+// sendLoginLinkMail sends the sign-in link, in the recipient's preferences if the
+// address is registered and in lang otherwise. Like sendRegistrationMail it looks
+// the address up and sends in the background, so that the response time does not
+// tell whether the address is registered.
+func sendLoginLinkMail(email, lang string, data map[string]interface{}) {
+	go func() {
+		prefs := defaultPreferences()
+		prefs.Locale = lang
+		user, err := loginLinkUser(email)
+		switch err {
+		case nil:
+			if prefs, err = loadPreferences(user.ID); err != nil {
+				log.Printf("magic link: failed to load preferences of user %d: %v", user.ID, err)
+				return
+			}
+		case users.ErrNotFound:
+			data["NewAccount"] = true
+		default:
+			log.Printf("magic link: failed to query user: %v", err)
+			return
+		}
+		if err := sendUserMail(email, prefs, "login_link", data); err != nil {
+			log.Printf("magic link: failed to send sign-in link: %v", err)
+		}
+	}()
+}
+
+// loginLinkUser returns the account of the address a link was sent to. Like logging
+// in, it compares addresses exactly: the sender proved they receive mail for this
+// address, which need not be true of another that differs only in case.
+func loginLinkUser(email string) (*users.User, error) {
+	return users.GetByEmail(db.DB, email)
+}
+
+// loginLinkStrings are the texts of the page of the emailed link in one language.
+type loginLinkStrings struct {
+	Title    string
+	Continue string
+	SignIn   string
+}
+
+var loginLinkTexts = map[string]loginLinkStrings{
+	"en": {
+		Title:    "Sign in",
+		Continue: "Continue to sign in with the link from your email.",
+		SignIn:   "Sign in",
+	},
+	"th": {
+		Title:    "เข้าสู่ระบบ",
+		Continue: "ดำเนินการต่อเพื่อเข้าสู่ระบบด้วยลิงก์จากอีเมลของคุณ",
+		SignIn:   "เข้าสู่ระบบ",
+	},
+}
+
+// loginLinkPageCSP is the Content-Security-Policy of the page of the emailed link.
+// Its form posts back to this server, which may redirect to a path on it.
+const loginLinkPageCSP = "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+
+var loginLinkPageTemplate = template.Must(template.New("page").Parse(`<!doctype html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.T.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 24rem; margin: 3rem auto; padding: 0 1rem; }
+button { display: block; width: 100%; padding: .5rem; margin-top: .5rem; }
+</style>
+</head>
+<body>
+<h1>{{.T.Title}}</h1>
+<p>{{.T.Continue}}</p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">{{.T.SignIn}}</button>
+</form>
+</body>
+</html>
+`))
+
+// MagicLinkPage answers the emailed link with a page whose button posts its token
+// to VerifyMagicLink. Opening the link does not use it up, so that mail scanners
+// and link previews, which follow links but do not submit forms, leave it working.
+func MagicLinkPage(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return apperr.New(apperr.InvalidRequest, "token required")
+	}
+	lang := apperr.Language(c)
+	t, ok := loginLinkTexts[lang]
+	if !ok {
+		lang, t = "en", loginLinkTexts["en"]
+	}
+	var buf bytes.Buffer
+	err := loginLinkPageTemplate.Execute(&buf, map[string]interface{}{"Lang": lang, "T": t, "Action": c.Path(), "Token": token})
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to render page")
+	}
+	// the token is in the URL of the page
+	c.Set(fiber.HeaderContentSecurityPolicy, loginLinkPageCSP)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+	c.Set(fiber.HeaderContentLanguage, lang)
+	c.Vary(fiber.HeaderAcceptLanguage)
+	c.Type("html")
+	return c.Send(buf.Bytes())
+}
+
+// VerifyMagicLink signs in with the token of an emailed link, posted by the form of
+// MagicLinkPage or as JSON, or with the address and code from the email. Either
+// works once. An address without an account gets one, without a password. The form
+// of a link that was requested with a redirect sends the browser there with the
+// token in the URL fragment.
+func VerifyMagicLink(c *fiber.Ctx) error {
+	var req MagicLinkVerify
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	req.Email, req.Code = strings.TrimSpace(req.Email), strings.TrimSpace(req.Code)
+	if req.Token == "" && (req.Email == "" || req.Code == "") {
+		return apperr.New(apperr.InvalidRequest, "token, or email and code required")
+	}
+
+	var email, redirect string
+	var err error
+	if req.Token != "" {
+		email, redirect, err = redeemLoginToken(req.Token)
+	} else {
+		email, err = redeemLoginCode(c, req.Email, req.Code)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp := TokenResponse{}
+	user, err := loginLinkSignIn(c, email, &resp)
+	if err != nil {
+		return err
+	}
+	if resp.Token, err = issueToken(user.ID, user.Email); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to sign token")
+	}
+
+	if redirect != "" && strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationForm) {
+		// in the fragment, so that the token stays out of server logs and Referer
+		// headers
+		q := url.Values{"token": {resp.Token}}
+		if resp.Created {
+			q.Set("created", "true")
+		}
+		return c.Redirect(redirect+"#"+q.Encode(), fiber.StatusFound)
+	}
+	return c.JSON(resp)
+}
+
+// loginLinkSignIn signs in to the account of the address a used link or code was
+// sent to, creating it if there is none, and sets Created and Restored of resp.
+func loginLinkSignIn(c *fiber.Ctx, email string, resp *TokenResponse) (*users.User, error) {
+	user, err := loginLinkUser(email)
+	if err == users.ErrNotFound {
+		// the link proved the address, so it needs no password
+		if _, err = users.Create(db.DB, users.NewUser{Email: email, EmailVerified: true}); err == nil || err == users.ErrEmailTaken {
+			resp.Created = err == nil
+			user, err = loginLinkUser(email)
+		}
+	}
+	if err != nil {
+		return nil, apperr.Wrap(err, apperr.Internal, "failed to sign in")
+	}
+	if user.Disabled() {
+		return nil, apperr.New(apperr.AccountDisabled, "account disabled")
+	}
+	if err := users.MarkEmailVerified(db.DB, user.ID, email); err != nil {
+		return nil, apperr.Wrap(err, apperr.Internal, "failed to sign in")
+	}
+	recordLoginEvent(c, user.ID, true)
+	// like logging in with a password, this cancels a pending account deletion
+	if resp.Restored, err = restoreAccount(c, user.ID); err != nil {
+		return nil, apperr.Wrap(err, apperr.Internal, "failed to restore account")
+	}
+	return user, nil
+}
+
+// redeemLoginToken uses up the link with the token and returns the address it was
+// sent to and the redirect it was requested with.
+func redeemLoginToken(token string) (email, redirect string, err error) {
+	tokenHash := hashToken(token)
+	var r sql.NullString
+	var expiresAt int64
+	row := db.DB.QueryRow("SELECT email, redirect, expires_at FROM login_links WHERE token_hash = ?", tokenHash)
+	switch err := row.Scan(&email, &r, &expiresAt); err {
+	case sql.ErrNoRows:
+		return "", "", apperr.New(apperr.InvalidCredentials, "invalid or expired sign-in link")
+	case nil:
+	default:
+		return "", "", apperr.Wrap(err, apperr.Internal, "failed to sign in")
+	}
+	if err := consumeLoginLink(tokenHash); err != nil {
+		return "", "", err
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", "", apperr.New(apperr.InvalidCredentials, "invalid or expired sign-in link")
+	}
+	return email, r.String, nil
+}
+
+// redeemLoginCode checks the code sent to email and uses up its link. Wrong codes
+// count against the link, which stops working after loginCodeMaxAttempts of them.
+func redeemLoginCode(c *fiber.Ctx, email, code string) (string, error) {
+	var tokenHash, codeHash, sentTo string
+	var attempts int
+	var expiresAt int64
+	row := db.DB.QueryRow("SELECT token_hash, code_hash, email, attempts, expires_at FROM login_links WHERE email = ?", email)
+	switch err := row.Scan(&tokenHash, &codeHash, &sentTo, &attempts, &expiresAt); err {
+	case sql.ErrNoRows:
+		return "", apperr.New(apperr.InvalidCredentials, "invalid or expired code")
+	case nil:
+	default:
+		return "", apperr.Wrap(err, apperr.Internal, "failed to sign in")
+	}
+	if time.Now().Unix() > expiresAt || attempts >= loginCodeMaxAttempts {
+		db.DB.Exec("DELETE FROM login_links WHERE token_hash = ?", tokenHash)
+		return "", apperr.New(apperr.InvalidCredentials, "invalid or expired code")
+	}
+	if subtle.ConstantTimeCompare([]byte(hashLoginCode(sentTo, code)), []byte(codeHash)) != 1 {
+		db.DB.Exec("UPDATE login_links SET attempts = attempts + 1 WHERE token_hash = ?", tokenHash)
+		if user, err := loginLinkUser(sentTo); err == nil {
+			recordLoginEvent(c, user.ID, false)
+		}
+		return "", apperr.New(apperr.InvalidCredentials, "invalid or expired code")
+	}
+	if err := consumeLoginLink(tokenHash); err != nil {
+		return "", err
+	}
+	return sentTo, nil
+}
+
+// consumeLoginLink deletes a link, failing if a concurrent request used it first.
+func consumeLoginLink(tokenHash string) error {
+	res, err := db.DB.Exec("DELETE FROM login_links WHERE token_hash = ?", tokenHash)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to sign in")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return apperr.New(apperr.InvalidCredentials, "invalid or expired sign-in link")
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package handlers_test
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// loginCode finds the code in the English and Thai mail alike.
+var loginCode = regexp.MustCompile(`(?:code|รหัส): (\d{6})`)
+
+// awaitLoginCode waits for the sign-in mail to the address, which is sent in the
+// background, and returns its code.
+func awaitLoginCode(t testing.TB, to string) string {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		msgs := mailbox.Messages()
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if msgs[i].To == to {
+				if m := loginCode.FindStringSubmatch(msgs[i].Body); m != nil {
+					return m[1]
+				}
+			}
+		}
+	}
+	t.Fatalf("no sign-in code sent to %s", to)
+	return ""
+}
+
+// signInByCode signs in through an emailed code, creating an account without a
+// password for a new address, and returns the token.
+func signInByCode(t testing.TB, email string) string {
+	t.Helper()
+	expect(t, request{method: "POST", path: "/api/v1/auth/magic-link", body: handlers.MagicLinkRequest{Email: email}}, fiber.StatusAccepted, nil)
+	var resp handlers.TokenResponse
+	expect(t, request{method: "POST", path: "/api/v1/auth/magic-link/verify", body: handlers.MagicLinkVerify{Email: email, Code: awaitLoginCode(t, email)}}, fiber.StatusOK, &resp)
+	return resp.Token
+}
+
+// staleToken returns a token of the account issued an hour ago.
+func staleToken(t testing.TB, email string) string {
+	t.Helper()
+	return tokenIssuedAt(t, email, float64(time.Now().Add(-time.Hour).Unix()))
+}
+
+// tokenIssuedAt returns a token of the account with iat, in seconds since the epoch.
+func tokenIssuedAt(t testing.TB, email string, iat float64) string {
+	t.Helper()
+	user, err := users.GetByEmail(db.DB, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   strconv.Itoa(user.ID),
+		"email": email,
+		"iat":   iat,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// Accounts without a password confirm closing the account or changing its email
+// address by having signed in recently.
+func TestPasswordlessReauthentication(t *testing.T) {
+	const email = "passwordless-reauth@example.com"
+	token := signInByCode(t, email)
+	stale := staleToken(t, email)
+
+	change := handlers.EmailChangeRequest{NewEmail: "passwordless-reauth-new@example.com"}
+	_, body := do(t, request{method: "POST", path: "/api/v1/profile/email", token: stale, body: change})
+	if c := problemCode(body); c != "reauthentication_required" {
+		t.Errorf("email change with an old token: code %q, want reauthentication_required", c)
+	}
+	expect(t, request{method: "POST", path: "/api/v1/profile/email", token: token, body: change}, fiber.StatusAccepted, nil)
+
+	_, body = do(t, request{method: "DELETE", path: "/api/v1/profile", token: stale, body: handlers.AccountDeletion{}})
+	if c := problemCode(body); c != "reauthentication_required" {
+		t.Errorf("closing with an old token: code %q, want reauthentication_required", c)
+	}
+	expect(t, request{method: "DELETE", path: "/api/v1/profile", token: token, body: handlers.AccountDeletion{}}, fiber.StatusAccepted, nil)
+}
+
+// Accounts without a password have nothing to put in the body of DELETE /profile.
+func TestPasswordlessDeletionWithoutBody(t *testing.T) {
+	const email = "passwordless-no-body@example.com"
+	token := signInByCode(t, email)
+	expectCode(t, request{method: "DELETE", path: "/api/v1/profile", token: staleToken(t, email)}, fiber.StatusForbidden, "reauthentication_required")
+	var resp handlers.AccountDeletionResponse
+	expect(t, request{method: "DELETE", path: "/api/v1/profile", token: token}, fiber.StatusAccepted, &resp)
+	if resp.DeleteAfter == 0 {
+		t.Errorf("response %+v", resp)
+	}
+}
+
+// Setting the first password of an account without one needs a recent sign-in, like
+// the other changes that would let a stolen token keep the account.
+func TestPasswordlessFirstPassword(t *testing.T) {
+	const email = "passwordless-first-password@example.com"
+	token := signInByCode(t, email)
+	set := handlers.PasswordChange{NewPassword: "f1rst-password"}
+	expectCode(t, request{method: "PUT", path: "/api/v1/profile/password", token: staleToken(t, email), body: set}, fiber.StatusForbidden, "reauthentication_required")
+	var resp handlers.PasswordChangeResponse
+	expect(t, request{method: "PUT", path: "/api/v1/profile/password", token: token, body: set}, fiber.StatusOK, &resp)
+	expect(t, request{method: "POST", path: "/api/v1/auth/login", body: handlers.AuthRequest{Email: email, Password: set.NewPassword}}, fiber.StatusOK, nil)
+
+	// from now on the password is needed, however recent the sign-in
+	expectCode(t, request{method: "PUT", path: "/api/v1/profile/password", token: resp.Token, body: handlers.PasswordChange{NewPassword: "s3cond-password"}}, fiber.StatusBadRequest, "invalid_request")
+}
+
+// A recent sign-in does not stand in for the password of accounts that have one.
+func TestReauthenticationNeedsPassword(t *testing.T) {
+	token := signUp(t, "reauth-password@example.com")
+	expect(t, request{method: "POST", path: "/api/v1/profile/email", token: token, body: handlers.EmailChangeRequest{NewEmail: "reauth-password-new@example.com"}}, fiber.StatusBadRequest, nil)
+	expect(t, request{method: "DELETE", path: "/api/v1/profile", token: token, body: handlers.AccountDeletion{}}, fiber.StatusBadRequest, nil)
+	expect(t, request{method: "DELETE", path: "/api/v1/profile", token: token}, fiber.StatusBadRequest, nil)
+	expect(t, request{method: "DELETE", path: "/api/v1/profile", token: token, body: handlers.AccountDeletion{Password: "wr0ng-password"}}, fiber.StatusUnauthorized, nil)
+}
+
+var loginLink = regexp.MustCompile(`/auth/magic-link/verify\?token=(\S+)`)
+
+// Opening the emailed link shows a page and leaves the link working, so that mail
+// scanners cannot use it up; its form signs in.
+func TestMagicLinkPage(t *testing.T) {
+	const email = "magic-link-page@example.com"
+	expect(t, request{method: "POST", path: "/api/v1/auth/magic-link", body: handlers.MagicLinkRequest{Email: email, Redirect: "/profile/ui"}}, fiber.StatusAccepted, nil)
+	awaitLoginCode(t, email)
+	m := loginLink.FindStringSubmatch(lastMail(t, email).Body)
+	if m == nil {
+		t.Fatal("no link in the mail")
+	}
+	token, err := url.QueryUnescape(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, body := do(t, request{method: "GET", path: "/api/v1/auth/magic-link/verify?token=" + url.QueryEscape(token)})
+		if resp.StatusCode != fiber.StatusOK || !strings.Contains(string(body), `name="token" value="`+token+`"`) {
+			t.Fatalf("GET: status %d, want 200 and a form with the token: %s", resp.StatusCode, body)
+		}
+	}
+
+	form := request{method: "POST", path: "/api/v1/auth/magic-link/verify", body: url.Values{"token": {token}}.Encode(), contentType: fiber.MIMEApplicationForm}
+	resp := expect(t, form, fiber.StatusFound, nil)
+	loc, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q, _ := url.ParseQuery(loc.Fragment); loc.Path != "/profile/ui" || q.Get("token") == "" {
+		t.Errorf("Location %q, want /profile/ui#token=...", loc)
+	}
+	expect(t, form, fiber.StatusUnauthorized, nil)
+}
+
+// Addresses are compared exactly, as when logging in: a link sent to an address that
+// differs from an account's only in case does not sign in to that account.
+func TestMagicLinkExactAddress(t *testing.T) {
+	const email, other = "magic-case@example.com", "Magic-Case@example.com"
+	signUp(t, email)
+	expect(t, request{method: "POST", path: "/api/v1/auth/magic-link", body: handlers.MagicLinkRequest{Email: other}}, fiber.StatusAccepted, nil)
+	code := awaitLoginCode(t, other)
+
+	expect(t, request{method: "POST", path: "/api/v1/auth/magic-link/verify", body: handlers.MagicLinkVerify{Email: email, Code: code}}, fiber.StatusUnauthorized, nil)
+	var resp handlers.TokenResponse
+	expect(t, request{method: "POST", path: "/api/v1/auth/magic-link/verify", body: handlers.MagicLinkVerify{Email: other, Code: code}}, fiber.StatusOK, &resp)
+	if !resp.Created {
+		t.Error("signed in to the account of " + email)
+	}
+	var p handlers.Profile
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: resp.Token}, fiber.StatusOK, &p)
+	if p.Email != other {
+		t.Errorf("signed in as %s, want %s", p.Email, other)
+	}
+}
+
+// Signing in through an emailed code confirms the address; registering does not.
+func TestMagicLinkVerifiesEmail(t *testing.T) {
+	var p handlers.Profile
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: signUp(t, "verified-email@example.com")}, fiber.StatusOK, &p)
+	if p.EmailVerified {
+		t.Error("address verified by registering")
+	}
+	expect(t, request{method: "GET", path: "/api/v1/profile", token: signInByCode(t, "verified-email@example.com")}, fiber.StatusOK, &p)
+	if !p.EmailVerified {
+		t.Error("address not verified by signing in with a code")
+	}
+}
@@ -0,0 +1,177 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/mail"
+	"fiber-rest-api/internal/router"
+	"fiber-rest-api/internal/sms"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const password = "correct-h0rse"
+
+// app serves the routes on a temporary database shared by the tests of the package,
+// which therefore use addresses of their own. Responses are validated against the
+// OpenAPI document.
+var (
+	app      *fiber.App
+	mailbox  *mail.Fake
+	messages *sms.Fake
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "handlers")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	// avatars and exports are written relative to the working directory
+	if err := os.Chdir(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := db.Init(filepath.Join(dir, "data.db")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	mailbox, messages = &mail.Fake{}, &sms.Fake{}
+	handlers.Mail, handlers.SMS = mailbox, messages
+	os.Unsetenv("APP_BASE_URL")
+	os.Setenv("OPENAPI_VALIDATE_RESPONSES", "fail")
+
+	app = fiber.New(fiber.Config{ErrorHandler: apperr.Handler, StreamRequestBody: true, DisablePreParseMultipartForm: true})
+	router.SetupRoutes(app)
+	return m.Run()
+}
+
+// request is a request to app. body is sent as JSON unless it is a string or an
+// io.Reader, which is sent as is with contentType.
+type request struct {
+	method, path string
+	token        string
+	body         interface{}
+	contentType  string
+	header       map[string]string
+}
+
+// do sends r and returns the response with its body read.
+func do(t testing.TB, r request) (*http.Response, []byte) {
+	t.Helper()
+	var body io.Reader
+	contentType := r.contentType
+	switch b := r.body.(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(b)
+	case io.Reader:
+		body = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = bytes.NewReader(data)
+		if contentType == "" {
+			contentType = fiber.MIMEApplicationJSON
+		}
+	}
+	req, err := http.NewRequest(r.method, r.path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ContentLength == 0 && req.Body != nil && req.Body != http.NoBody {
+		// a reader of unknown length
+		req.TransferEncoding = []string{"chunked"}
+	}
+	if contentType != "" {
+		req.Header.Set(fiber.HeaderContentType, contentType)
+	}
+	if r.token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+r.token)
+	}
+	for k, v := range r.header {
+		req.Header.Set(k, v)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, out
+}
+
+// expect sends r and fails the test unless the response has the status. It decodes
+// a JSON body into out when out is not nil.
+func expect(t testing.TB, r request, status int, out interface{}) *http.Response {
+	t.Helper()
+	resp, body := do(t, r)
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", r.method, r.path, resp.StatusCode, status, body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			t.Fatalf("%s %s: %v: %s", r.method, r.path, err, body)
+		}
+	}
+	return resp
+}
+
+// problemCode returns the code of a problem document.
+func problemCode(body []byte) string {
+	var p struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(body, &p)
+	return p.Code
+}
+
+// signUp registers email with password and returns a token of the account.
+func signUp(t testing.TB, email string) string {
+	t.Helper()
+	expect(t, request{method: "POST", path: "/api/v1/auth/register", body: handlers.AuthRequest{Email: email, Password: password}}, fiber.StatusCreated, nil)
+	return login(t, email, password)
+}
+
+// login returns a token of the account.
+func login(t testing.TB, email, password string) string {
+	t.Helper()
+	var resp handlers.TokenResponse
+	expect(t, request{method: "POST", path: "/api/v1/auth/login", body: handlers.AuthRequest{Email: email, Password: password}}, fiber.StatusOK, &resp)
+	return resp.Token
+}
+
+// lastMail returns the newest message sent to the address.
+func lastMail(t testing.TB, to string) mail.Message {
+	t.Helper()
+	msgs := mailbox.Messages()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].To == to {
+			return msgs[i]
+		}
+	}
+	t.Fatalf("no mail to %s", to)
+	return mail.Message{}
+}
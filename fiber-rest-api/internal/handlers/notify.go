@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+
+	"fiber-rest-api/internal/mail"
+)
+
+// mailTemplate is a localized email; Subject and Body are text/template sources.
+type mailTemplate struct {
+	Subject string
+	Body    string
+}
+
+// mailTemplates holds every account email by name and language ("th", "en").
+// Templates can use {{date .Time}} to render a time in the recipient's time zone and
+// date format.
+var mailTemplates = map[string]map[string]mailTemplate{
+	"email_change_confirm": {
+		"en": {
+			Subject: "Confirm your new email address",
+			Body: "Someone asked to change the email address of an account from {{.OldEmail}} to this address.\n\n" +
+				"To confirm, open the link below within {{.Hours}} hours:\n{{.Link}}\n\nIf this wasn't you, ignore this message.\n",
+		},
+		"th": {
+			Subject: "ยืนยันอีเมลใหม่ของคุณ",
+			Body: "มีคำขอเปลี่ยนอีเมลของบัญชีจาก {{.OldEmail}} มาเป็นอีเมลนี้\n\n" +
+				"หากต้องการยืนยัน กรุณาเปิดลิงก์ด้านล่างภายใน {{.Hours}} ชั่วโมง:\n{{.Link}}\n\nหากคุณไม่ได้ทำรายการนี้ โปรดละเว้นข้อความนี้\n",
+		},
+	},
+	"email_change_notice": {
+		"en": {
+			Subject: "Your email address is being changed",
+			Body: "On {{date .Time}} a request was made to change the email address of your account to {{.NewEmail}}.\n" +
+				"The change takes effect once it is confirmed from the new address.\n\n" +
+				"If this wasn't you, change your password immediately.\n",
+		},
+		"th": {
+			Subject: "มีคำขอเปลี่ยนอีเมลของบัญชีคุณ",
+			Body: "เมื่อ {{date .Time}} มีคำขอเปลี่ยนอีเมลของบัญชีคุณเป็น {{.NewEmail}}\n" +
+				"การเปลี่ยนแปลงจะมีผลเมื่อได้รับการยืนยันจากอีเมลใหม่\n\n" +
+				"หากคุณไม่ได้ทำรายการนี้ กรุณาเปลี่ยนรหัสผ่านทันที\n",
+		},
+	},
+	"password_changed": {
+		"en": {
+			Subject: "Your password was changed",
+			Body: "The password of your account was changed on {{date .Time}} from {{.IP}}.\n\n" +
+				"If you did not do this, reset your password and contact support immediately.\n",
+		},
+		"th": {
+			Subject: "รหัสผ่านของคุณถูกเปลี่ยนแล้ว",
+			Body: "รหัสผ่านของบัญชีคุณถูกเปลี่ยนเมื่อ {{date .Time}} จาก {{.IP}}\n\n" +
+				"หากคุณไม่ได้ทำรายการนี้ กรุณารีเซ็ตรหัสผ่านและติดต่อฝ่ายบริการลูกค้าทันที\n",
+		},
+	},
+	"registration_welcome": {
+		"en": {
+			Subject: "Welcome",
+			Body: "An account was created for this address on {{date .Time}}. You can log in now.\n\n" +
+				"If you did not register, someone else used your address; contact support.\n",
+		},
+		"th": {
+			Subject: "ยินดีต้อนรับ",
+			Body: "บัญชีของอีเมลนี้ถูกสร้างเมื่อ {{date .Time}} คุณสามารถเข้าสู่ระบบได้ทันที\n\n" +
+				"หากคุณไม่ได้สมัคร แสดงว่ามีผู้อื่นใช้อีเมลของคุณ กรุณาติดต่อฝ่ายบริการลูกค้า\n",
+		},
+	},
+	"registration_attempt": {
+		"en": {
+			Subject: "Someone tried to register with your address",
+			Body: "On {{date .Time}} someone tried to register a new account with this address from {{.IP}}, " +
+				"but you already have one, so nothing was changed.\n\n" +
+				"If it was you, just log in. If not, you can ignore this message.\n",
+		},
+		"th": {
+			Subject: "มีผู้พยายามสมัครสมาชิกด้วยอีเมลของคุณ",
+			Body: "เมื่อ {{date .Time}} มีผู้พยายามสมัครบัญชีใหม่ด้วยอีเมลนี้จาก {{.IP}} " +
+				"แต่คุณมีบัญชีอยู่แล้ว จึงไม่มีการเปลี่ยนแปลงใด ๆ\n\n" +
+				"หากเป็นคุณ สามารถเข้าสู่ระบบได้เลย หากไม่ใช่ โปรดละเว้นข้อความนี้\n",
+		},
+	},
+	"login_link": {
+		"en": {
+			Subject: "Your sign-in link",
+			Body: "{{if .NewAccount}}There is no account for this address yet. Open the link below within {{.Minutes}} minutes to create one and sign in:" +
+				"{{else}}Open the link below within {{.Minutes}} minutes to sign in:{{end}}\n{{.Link}}\n\n" +
+				"Or enter this code: {{.Code}}\n\nThe link and the code work once. If you did not ask to sign in, ignore this message.\n",
+		},
+		"th": {
+			Subject: "ลิงก์สำหรับเข้าสู่ระบบของคุณ",
+			Body: "{{if .NewAccount}}ยังไม่มีบัญชีของอีเมลนี้ เปิดลิงก์ด้านล่างภายใน {{.Minutes}} นาทีเพื่อสร้างบัญชีและเข้าสู่ระบบ:" +
+				"{{else}}เปิดลิงก์ด้านล่างภายใน {{.Minutes}} นาทีเพื่อเข้าสู่ระบบ:{{end}}\n{{.Link}}\n\n" +
+				"หรือกรอกรหัส: {{.Code}}\n\nลิงก์และรหัสใช้ได้ครั้งเดียว หากคุณไม่ได้ขอเข้าสู่ระบบ โปรดละเว้นข้อความนี้\n",
+		},
+	},
+	"account_deletion_scheduled": {
+		"en": {
+			Subject: "Your account will be deleted",
+			Body: "Your account was closed and will be permanently deleted on {{date .DeleteAfter}}.\n\n" +
+				"Changed your mind? Log in before then and the account is restored.\n",
+		},
+		"th": {
+			Subject: "บัญชีของคุณจะถูกลบ",
+			Body: "บัญชีของคุณถูกปิดแล้วและจะถูกลบอย่างถาวรในวันที่ {{date .DeleteAfter}}\n\n" +
+				"หากเปลี่ยนใจ เพียงเข้าสู่ระบบก่อนวันดังกล่าว บัญชีจะถูกกู้คืน\n",
+		},
+	},
+	"data_export_ready": {
+		"en": {
+			Subject: "Your data export is ready",
+			Body: "The copy of your personal data you requested is ready. Download it within {{.Minutes}} minutes:\n{{.Link}}\n\n" +
+				"If the link has expired, open your profile to get a new one. The archive is deleted on {{date .Expires}}.\n",
+		},
+		"th": {
+			Subject: "ไฟล์ข้อมูลส่วนบุคคลของคุณพร้อมแล้ว",
+			Body: "สำเนาข้อมูลส่วนบุคคลที่คุณขอพร้อมแล้ว กรุณาดาวน์โหลดภายใน {{.Minutes}} นาที:\n{{.Link}}\n\n" +
+				"หากลิงก์หมดอายุ สามารถขอลิงก์ใหม่ได้จากหน้าโปรไฟล์ ไฟล์จะถูกลบในวันที่ {{date .Expires}}\n",
+		},
+	},
+}
+
+// optionalMail names the templates a user turns off with notifications.security_email.
+// Every other template is transactional or an alert of a possible takeover.
+var optionalMail = map[string]bool{
+	"registration_attempt": true,
+}
+
+// sendUserMail renders the named template in the language, time zone and date format
+// of prefs and sends it to the given address, unless the user opted out of it.
+func sendUserMail(to string, prefs Preferences, name string, data map[string]interface{}) error {
+	if optionalMail[name] && !prefs.Notifications.SecurityEmail {
+		return nil
+	}
+	tmpl := mailTemplates[name][prefs.language()]
+	funcs := template.FuncMap{"date": func(t time.Time) string { return prefs.formatTime(t) }}
+
+	render := func(src string) (string, error) {
+		t, err := template.New(name).Funcs(funcs).Parse(src)
+		if err != nil {
+			return "", err
+		}
+		var b bytes.Buffer
+		if err := t.Execute(&b, data); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+
+	subject, err := render(tmpl.Subject)
+	if err != nil {
+		return err
+	}
+	body, err := render(tmpl.Body)
+	if err != nil {
+		return err
+	}
+	return Mail.Send(mail.Message{To: to, Subject: subject, Body: body})
+}
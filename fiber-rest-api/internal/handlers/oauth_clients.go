@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"fmt"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/oauth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OAuthClientRequest is the body of POST /admin/oauth-clients.
+type OAuthClientRequest struct {
+	Name         string   `json:"name" openapi:"required,minLength=1,maxLength=100,example=Wiki"`
+	RedirectURIs []string `json:"redirect_uris" openapi:"required,minItems=1" doc:"absolute https URLs; http is accepted on localhost"`
+	Public       bool     `json:"public" doc:"for apps that cannot keep a secret, such as single-page and mobile apps; they get no secret and must use PKCE"`
+	Trusted      bool     `json:"trusted" doc:"first-party apps whose users are not asked for consent"`
+}
+
+// OAuthClient is a client registered with the OpenID Connect provider.
+type OAuthClient struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Public       bool     `json:"public"`
+	Trusted      bool     `json:"trusted"`
+	CreatedAt    int64    `json:"created_at"`
+	// Secret is only returned when the client is registered.
+	Secret string `json:"secret,omitempty" doc:"only returned on registration; store it, it cannot be shown again"`
+}
+
+// OAuthClientList is the body of GET /admin/oauth-clients.
+type OAuthClientList struct {
+	Clients []OAuthClient `json:"clients"`
+}
+
+// OAuthConsent is an app the user allowed access to their account.
+type OAuthConsent struct {
+	ClientID   string   `json:"client_id"`
+	ClientName string   `json:"client_name"`
+	Scopes     []string `json:"scopes" openapi:"example=openid"`
+	CreatedAt  int64    `json:"created_at"`
+}
+
+// OAuthConsentList is the body of GET /profile/consents.
+type OAuthConsentList struct {
+	Consents []OAuthConsent `json:"consents"`
+}
+
+func oauthClientResponse(c *oauth.Client) OAuthClient {
+	return OAuthClient{
+		ID:           c.ID,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectURIs,
+		Public:       c.Public,
+		Trusted:      c.Trusted,
+		CreatedAt:    c.CreatedAt.Unix(),
+	}
+}
+
+// ListOAuthClients returns the clients registered with the OpenID Connect provider.
+func ListOAuthClients(c *fiber.Ctx) error {
+	clients, err := oauth.ListClients(db.DB)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch clients")
+	}
+	list := OAuthClientList{Clients: []OAuthClient{}}
+	for i := range clients {
+		list.Clients = append(list.Clients, oauthClientResponse(&clients[i]))
+	}
+	return c.JSON(list)
+}
+
+// CreateOAuthClient registers a client. The response is the only place its secret
+// is shown.
+func CreateOAuthClient(c *fiber.Ctx) error {
+	var req OAuthClientRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	for i, uri := range req.RedirectURIs {
+		if err := oauth.CheckRedirectURI(uri); err != nil {
+			return apperr.New(apperr.InvalidRequest, "invalid request").
+				WithFields(apperr.Field("body", fmt.Sprintf("redirect_uris[%d]", i), "must be an https URL, or http on localhost, without a fragment"))
+		}
+	}
+	client, secret, err := oauth.CreateClient(db.DB, oauth.NewClient{
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Public:       req.Public,
+		Trusted:      req.Trusted,
+	})
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to register client")
+	}
+	resp := oauthClientResponse(client)
+	resp.Secret = secret
+	return c.Status(fiber.StatusCreated).JSON(resp)
+}
+
+// DeleteOAuthClient removes a client. Its users' consents go with it, and the access
+// tokens it holds stop working.
+func DeleteOAuthClient(c *fiber.Ctx) error {
+	switch err := oauth.DeleteClient(db.DB, c.Params("id")); err {
+	case nil:
+	case oauth.ErrClientNotFound:
+		return apperr.New(apperr.NotFound, "client not found")
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to delete client")
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// loadConsents returns the apps user uid allowed access to their account.
+func loadConsents(uid int) ([]OAuthConsent, error) {
+	consents, err := oauth.Consents(db.DB, uid)
+	if err != nil {
+		return nil, err
+	}
+	list := []OAuthConsent{}
+	for _, consent := range consents {
+		list = append(list, OAuthConsent{
+			ClientID:   consent.ClientID,
+			ClientName: consent.ClientName,
+			Scopes:     consent.Scopes,
+			CreatedAt:  consent.CreatedAt.Unix(),
+		})
+	}
+	return list, nil
+}
+
+// GetConsents lists the apps the current user allowed access to their account.
+func GetConsents(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	list, err := loadConsents(uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch consents")
+	}
+	return c.JSON(OAuthConsentList{Consents: list})
+}
+
+// RevokeConsent withdraws the current user's consent to an app. Its access tokens
+// stop working, and the user is asked again the next time they sign in to it.
+func RevokeConsent(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	switch err := oauth.RevokeConsent(db.DB, uid, c.Params("client_id")); err {
+	case nil:
+	case oauth.ErrClientNotFound:
+		return apperr.New(apperr.NotFound, "consent not found")
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to revoke consent")
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
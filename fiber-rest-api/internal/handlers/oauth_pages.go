@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/oauth"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// opStrings are the texts of the provider's pages in one language.
+type opStrings struct {
+	SignInTitle    string
+	SignInTo       string // %s is the client name
+	Email          string
+	Password       string
+	SignIn         string
+	SendCode       string
+	CodeSentTo     string // %s is the email address
+	Code           string
+	ResendCode     string
+	ConsentTitle   string
+	ConsentTo      string // %s is the client name
+	SignedInAs     string // %s is the email address
+	Allow          string
+	Deny           string
+	Scopes         map[string]string
+	ErrorTitle     string
+	UnknownClient  string
+	BadRedirectURI string
+	FormExpired    string
+}
+
+var opTexts = map[string]opStrings{
+	"en": {
+		SignInTitle:  "Sign in",
+		SignInTo:     "Sign in to continue to %s",
+		Email:        "Email",
+		Password:     "Password",
+		SignIn:       "Sign in",
+		SendCode:     "Email me a sign-in code instead",
+		CodeSentTo:   "Enter the 6-digit code sent to %s.",
+		Code:         "Code",
+		ResendCode:   "Send a new code",
+		ConsentTitle: "Allow access",
+		ConsentTo:    "%s would like to:",
+		SignedInAs:   "Signed in as %s",
+		Allow:        "Allow",
+		Deny:         "Deny",
+		Scopes: map[string]string{
+			"openid":  "Sign you in with your account",
+			"profile": "See your name, username, profile picture, language and time zone",
+			"email":   "See your email address",
+			"phone":   "See your phone number",
+		},
+		ErrorTitle:     "Sign-in failed",
+		UnknownClient:  "The application that sent you here is not registered.",
+		BadRedirectURI: "The application that sent you here asked to return to an address it has not registered.",
+		FormExpired:    "The form has expired. Go back to the application and try again.",
+	},
+	"th": {
+		SignInTitle:  "เข้าสู่ระบบ",
+		SignInTo:     "เข้าสู่ระบบเพื่อไปยัง %s",
+		Email:        "อีเมล",
+		Password:     "รหัสผ่าน",
+		SignIn:       "เข้าสู่ระบบ",
+		SendCode:     "ส่งรหัสเข้าสู่ระบบทางอีเมลแทน",
+		CodeSentTo:   "กรอกรหัส 6 หลักที่ส่งไปยัง %s",
+		Code:         "รหัส",
+		ResendCode:   "ส่งรหัสใหม่",
+		ConsentTitle: "อนุญาตการเข้าถึง",
+		ConsentTo:    "%s ต้องการ:",
+		SignedInAs:   "เข้าสู่ระบบในชื่อ %s",
+		Allow:        "อนุญาต",
+		Deny:         "ปฏิเสธ",
+		Scopes: map[string]string{
+			"openid":  "ให้คุณเข้าสู่ระบบด้วยบัญชีของคุณ",
+			"profile": "ดูชื่อ ชื่อผู้ใช้ รูปโปรไฟล์ ภาษา และเขตเวลาของคุณ",
+			"email":   "ดูอีเมลของคุณ",
+			"phone":   "ดูหมายเลขโทรศัพท์ของคุณ",
+		},
+		ErrorTitle:     "เข้าสู่ระบบไม่สำเร็จ",
+		UnknownClient:  "แอปพลิเคชันที่ส่งคุณมาที่นี่ไม่ได้ลงทะเบียนไว้",
+		BadRedirectURI: "แอปพลิเคชันที่ส่งคุณมาที่นี่ขอให้ส่งกลับไปยังที่อยู่ที่ไม่ได้ลงทะเบียนไว้",
+		FormExpired:    "แบบฟอร์มหมดอายุแล้ว โปรดกลับไปที่แอปพลิเคชันแล้วลองอีกครั้ง",
+	},
+}
+
+func opText(lang string) opStrings {
+	if t, ok := opTexts[lang]; ok {
+		return t
+	}
+	return opTexts["en"]
+}
+
+// opPageCSP is the Content-Security-Policy of the provider's pages. form-action is
+// left open: the forms redirect on to the client's redirect URI, which browsers
+// check against it too.
+const opPageCSP = "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'; base-uri 'none'"
+
+// opPage is the data of opPageTemplate.
+type opPage struct {
+	Lang    string
+	Title   string
+	Heading string
+	Error   string
+	// Form is set on the sign-in and consent pages
+	Form      bool
+	Params    url.Values
+	CSRFToken string
+	// sign-in; Code is set once a code has been emailed
+	Email string
+	Code  bool
+	// consent
+	Account string
+	Scopes  []string
+	T       opStrings
+}
+
+var opPageTemplate = template.Must(template.New("page").Parse(`<!doctype html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 24rem; margin: 3rem auto; padding: 0 1rem; }
+label, input, button { display: block; width: 100%; box-sizing: border-box; }
+input { margin: .25rem 0 1rem; padding: .5rem; }
+button { padding: .5rem; margin-top: .5rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Account}}<p>{{.}}</p>{{end}}
+{{with .Heading}}<p>{{.}}</p>{{end}}
+{{with .Error}}<p class="error" role="alert">{{.}}</p>{{end}}
+{{if .Form}}<form method="post" action="/oauth/authorize">
+{{range $name, $values := .Params}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+{{if .Scopes}}<ul>
+{{range .Scopes}}<li>{{index $.T.Scopes .}}</li>
+{{end}}</ul>
+<button type="submit" name="action" value="allow">{{.T.Allow}}</button>
+<button type="submit" name="action" value="deny">{{.T.Deny}}</button>
+{{else if .Code}}<input type="hidden" name="email" value="{{.Email}}">
+<label for="code">{{.T.Code}}</label>
+<input id="code" name="code" inputmode="numeric" autocomplete="one-time-code" pattern="[0-9]{6}" required autofocus>
+<button type="submit" name="action" value="code">{{.T.SignIn}}</button>
+<button type="submit" name="action" value="send_code" formnovalidate>{{.T.ResendCode}}</button>
+{{else}}<label for="email">{{.T.Email}}</label>
+<input id="email" name="email" type="email" autocomplete="username" value="{{.Email}}" required autofocus>
+<label for="password">{{.T.Password}}</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+<button type="submit" name="action" value="login">{{.T.SignIn}}</button>
+<button type="submit" name="action" value="send_code" formnovalidate>{{.T.SendCode}}</button>
+{{end}}</form>{{end}}
+</body>
+</html>
+`))
+
+// renderOPPage answers with a page of the provider. The pages must not be cached or
+// framed: they carry the CSRF token, and the forms would be open to clickjacking.
+func renderOPPage(c *fiber.Ctx, status int, page opPage) error {
+	var buf bytes.Buffer
+	if err := opPageTemplate.Execute(&buf, page); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to render page")
+	}
+	c.Set(fiber.HeaderContentSecurityPolicy, opPageCSP)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	c.Set(fiber.HeaderContentLanguage, page.Lang)
+	c.Vary(fiber.HeaderAcceptLanguage)
+	c.Type("html")
+	return c.Status(status).Send(buf.Bytes())
+}
+
+// opErrorPage tells the user that an authorization request cannot be answered.
+func opErrorPage(c *fiber.Ctx, status int, message string) error {
+	lang := apperr.Language(c)
+	t := opText(lang)
+	return renderOPPage(c, status, opPage{Lang: lang, Title: t.ErrorTitle, Error: message, T: t})
+}
+
+// opLoginPage asks the user to sign in to continue to client.
+func opLoginPage(c *fiber.Ctx, status int, client *oauth.Client, req url.Values, email, message string) error {
+	lang := apperr.Language(c)
+	t := opText(lang)
+	token, err := opCSRFToken(c)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to render page")
+	}
+	return renderOPPage(c, status, opPage{
+		Lang:      lang,
+		Title:     t.SignInTitle,
+		Heading:   fmt.Sprintf(t.SignInTo, client.Name),
+		Error:     message,
+		Form:      true,
+		Params:    req,
+		CSRFToken: token,
+		Email:     email,
+		T:         t,
+	})
+}
+
+// opCodePage asks the user for the sign-in code emailed to them.
+func opCodePage(c *fiber.Ctx, status int, client *oauth.Client, req url.Values, email, message string) error {
+	lang := apperr.Language(c)
+	t := opText(lang)
+	token, err := opCSRFToken(c)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to render page")
+	}
+	return renderOPPage(c, status, opPage{
+		Lang:      lang,
+		Title:     t.SignInTitle,
+		Heading:   fmt.Sprintf(t.CodeSentTo, email),
+		Error:     message,
+		Form:      true,
+		Params:    req,
+		CSRFToken: token,
+		Email:     email,
+		Code:      true,
+		T:         t,
+	})
+}
+
+// opConsentPage asks the user to allow client the scopes.
+func opConsentPage(c *fiber.Ctx, client *oauth.Client, req url.Values, user *users.User, scopes []string) error {
+	lang := apperr.Language(c)
+	t := opText(lang)
+	token, err := opCSRFToken(c)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to render page")
+	}
+	return renderOPPage(c, fiber.StatusOK, opPage{
+		Lang:      lang,
+		Title:     t.ConsentTitle,
+		Heading:   fmt.Sprintf(t.ConsentTo, client.Name),
+		Account:   fmt.Sprintf(t.SignedInAs, user.Email),
+		Form:      true,
+		Params:    req,
+		CSRFToken: token,
+		Scopes:    scopes,
+		T:         t,
+	})
+}
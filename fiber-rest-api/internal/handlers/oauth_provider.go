@@ -0,0 +1,744 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/oauth"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// The server is an OpenID Connect provider for the apps registered in
+// oauth_clients: they send users to /oauth/authorize, which signs them in on its own
+// page and asks for their consent, and redeem the code it returns at /oauth/token
+// for an ID token and an access token to /oauth/userinfo. Only the authorization
+// code flow is supported; public clients must use PKCE.
+
+const (
+	// opSessionCookie keeps users signed in to the provider, so that they are not
+	// asked for their password by every app
+	opSessionCookie = "op_session"
+	// opCSRFCookie is compared with the csrf_token field of the sign-in and consent
+	// forms
+	opCSRFCookie = "op_csrf"
+	// opSessionTTL is how long a sign-in at the provider lasts
+	opSessionTTL = 8 * time.Hour
+	// opTokenTTL is the lifetime of access and ID tokens
+	opTokenTTL = time.Hour
+)
+
+// opScopes are the scopes clients can request; the others are ignored.
+var opScopes = []string{"openid", "profile", "email", "phone"}
+
+// authorizeParams are the parameters of an authorization request that the sign-in
+// and consent forms carry along.
+var authorizeParams = []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce",
+	"code_challenge", "code_challenge_method", "prompt", "max_age", "login_hint"}
+
+// OpenIDConfiguration is the provider metadata at /.well-known/openid-configuration.
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	PromptValuesSupported             []string `json:"prompt_values_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	AuthorizationResponseIssParameter bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// JWKSet is the body of GET /oauth/jwks.
+type JWKSet struct {
+	Keys []oauth.JWK `json:"keys"`
+}
+
+// TokenRequest documents the form body of POST /oauth/token.
+type TokenRequest struct {
+	GrantType    string `json:"grant_type" openapi:"required,enum=authorization_code"`
+	Code         string `json:"code" openapi:"required"`
+	RedirectURI  string `json:"redirect_uri" openapi:"required" doc:"as sent to /oauth/authorize"`
+	CodeVerifier string `json:"code_verifier" doc:"PKCE verifier, required when /oauth/authorize was sent a code_challenge"`
+	ClientID     string `json:"client_id" doc:"for public clients, and confidential clients not using HTTP Basic authentication"`
+	ClientSecret string `json:"client_secret" doc:"for confidential clients not using HTTP Basic authentication"`
+}
+
+// OAuthToken is the body of a successful POST /oauth/token.
+type OAuthToken struct {
+	AccessToken string `json:"access_token" doc:"JWT accepted by /oauth/userinfo, not by the API"`
+	TokenType   string `json:"token_type" openapi:"enum=Bearer"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope" openapi:"example=openid profile email"`
+}
+
+// OAuthError is the body of failed token and userinfo requests (RFC 6749, section
+// 5.2, and RFC 6750, section 3).
+type OAuthError struct {
+	Error            string `json:"error" openapi:"enum=invalid_request|invalid_client|invalid_grant|unsupported_grant_type|invalid_token"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// UserInfo is the body of /oauth/userinfo. Claims are included by the scopes the
+// user allowed, and omitted when the profile has no value for them.
+type UserInfo struct {
+	Subject             string `json:"sub" doc:"the user id"`
+	Name                string `json:"name,omitempty"`
+	GivenName           string `json:"given_name,omitempty"`
+	FamilyName          string `json:"family_name,omitempty"`
+	PreferredUsername   string `json:"preferred_username,omitempty"`
+	Picture             string `json:"picture,omitempty"`
+	Locale              string `json:"locale,omitempty"`
+	Zoneinfo            string `json:"zoneinfo,omitempty"`
+	Email               string `json:"email,omitempty"`
+	EmailVerified       *bool  `json:"email_verified,omitempty"`
+	PhoneNumber         string `json:"phone_number,omitempty"`
+	PhoneNumberVerified *bool  `json:"phone_number_verified,omitempty"`
+}
+
+// opSession is the sign-in at the provider kept in opSessionCookie.
+type opSession struct {
+	UserID   int   `json:"u"`
+	AuthTime int64 `json:"t"`
+	Expires  int64 `json:"e"`
+}
+
+// issuer identifies the provider in tokens and metadata.
+func issuer() string {
+	return baseURL()
+}
+
+// OpenIDConfigurationHandler serves the provider metadata clients configure
+// themselves from (OpenID Connect Discovery 1.0).
+func OpenIDConfigurationHandler(c *fiber.Ctx) error {
+	iss := issuer()
+	return c.JSON(OpenIDConfiguration{
+		Issuer:                            iss,
+		AuthorizationEndpoint:             iss + "/oauth/authorize",
+		TokenEndpoint:                     iss + "/oauth/token",
+		UserinfoEndpoint:                  iss + "/oauth/userinfo",
+		JWKSURI:                           iss + "/oauth/jwks",
+		ScopesSupported:                   opScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		PromptValuesSupported:             []string{"none", "login", "consent"},
+		ClaimsSupported: []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "given_name", "family_name",
+			"preferred_username", "picture", "locale", "zoneinfo", "email", "email_verified", "phone_number", "phone_number_verified"},
+		AuthorizationResponseIssParameter: true,
+	})
+}
+
+// JWKS publishes the keys tokens are signed with, including older ones that signed
+// tokens which may still be in use.
+func JWKS(c *fiber.Ctx) error {
+	if _, err := oauth.CurrentKey(db.DB); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to load signing keys")
+	}
+	keys, err := oauth.Keys(db.DB)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to load signing keys")
+	}
+	set := JWKSet{Keys: []oauth.JWK{}}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.JWK())
+	}
+	c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	return c.JSON(set)
+}
+
+// authorizeParam returns a parameter of the authorization request: from the query
+// string of GET requests, or from the sign-in and consent forms.
+func authorizeParam(c *fiber.Ctx, name string) string {
+	if c.Method() == fiber.MethodPost {
+		return c.FormValue(name)
+	}
+	return c.Query(name)
+}
+
+// Authorize is the authorization endpoint. It checks the request of the client,
+// signs the user in unless they are already, asks for their consent unless they gave
+// it before or the client is trusted, and redirects back to the client with a code.
+// The sign-in and consent forms post back to it with the parameters of the request.
+func Authorize(c *fiber.Ctx) error {
+	req := url.Values{}
+	for _, name := range authorizeParams {
+		if v := authorizeParam(c, name); v != "" {
+			req.Set(name, v)
+		}
+	}
+	lang := apperr.Language(c)
+
+	// until the redirect URI is known to belong to the client, errors are shown to
+	// the user rather than sent to it
+	client, err := oauth.GetClient(db.DB, req.Get("client_id"))
+	switch err {
+	case nil:
+	case oauth.ErrClientNotFound:
+		return opErrorPage(c, fiber.StatusBadRequest, opText(lang).UnknownClient)
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to query client")
+	}
+	redirectURI := req.Get("redirect_uri")
+	if !client.AllowsRedirectURI(redirectURI) {
+		return opErrorPage(c, fiber.StatusBadRequest, opText(lang).BadRedirectURI)
+	}
+	fail := func(code, description string) error {
+		return authorizeRedirect(c, redirectURI, url.Values{"error": {code}, "error_description": {description}, "state": {req.Get("state")}})
+	}
+
+	if req.Get("response_type") != "code" {
+		return fail("unsupported_response_type", "only response_type=code is supported")
+	}
+	scopes := supportedScopes(req.Get("scope"))
+	if !containsString(scopes, "openid") {
+		return fail("invalid_scope", "scope must include openid")
+	}
+	switch method, challenge := req.Get("code_challenge_method"), req.Get("code_challenge"); {
+	case challenge == "" && client.Public:
+		return fail("invalid_request", "public clients must use PKCE")
+	case challenge == "" && method != "":
+		return fail("invalid_request", "code_challenge_method without code_challenge")
+	case challenge != "" && method != "S256":
+		return fail("invalid_request", "code_challenge_method must be S256")
+	case challenge != "" && len(challenge) != 43:
+		return fail("invalid_request", "code_challenge must be a base64url-encoded SHA-256 hash")
+	}
+	prompt := strings.Fields(req.Get("prompt"))
+	if containsString(prompt, "none") && len(prompt) > 1 {
+		return fail("invalid_request", "prompt=none cannot be combined with other values")
+	}
+	maxAge := -1
+	if v := req.Get("max_age"); v != "" {
+		if maxAge, err = strconv.Atoi(v); err != nil || maxAge < 0 {
+			return fail("invalid_request", "max_age must be a number of seconds")
+		}
+	}
+
+	action := ""
+	if c.Method() == fiber.MethodPost {
+		action = c.FormValue("action")
+		token := c.Cookies(opCSRFCookie)
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.FormValue("csrf_token"))) != 1 {
+			return opErrorPage(c, fiber.StatusBadRequest, opText(lang).FormExpired)
+		}
+	}
+
+	session, user, err := currentOPSession(c)
+	if err != nil {
+		return err
+	}
+	needLogin := user == nil || containsString(prompt, "login") ||
+		(maxAge >= 0 && time.Since(time.Unix(session.AuthTime, 0)) > time.Duration(maxAge)*time.Second)
+	email := strings.TrimSpace(c.FormValue("email"))
+	if action == "send_code" {
+		// accounts without a password sign in with a code emailed to them
+		if err := sendLoginLink(c, email, ""); err != nil {
+			e := apperr.From(err)
+			switch {
+			case e.Status >= 500:
+				return err
+			case e.Code == apperr.RateLimited:
+				return opCodePage(c, e.Status, client, req, email, e.Message(lang))
+			}
+			return opLoginPage(c, e.Status, client, req, email, e.Message(lang))
+		}
+		return opCodePage(c, fiber.StatusOK, client, req, email, "")
+	}
+	if action == "login" || action == "code" {
+		if action == "login" {
+			user, err = opPasswordSignIn(c, email)
+		} else {
+			user, err = opCodeSignIn(c, email)
+		}
+		if err != nil {
+			e := apperr.From(err)
+			if e.Status >= 500 {
+				return err
+			}
+			if action == "code" && e.Code != apperr.AccountDisabled {
+				return opCodePage(c, e.Status, client, req, email, e.Message(lang))
+			}
+			return opLoginPage(c, e.Status, client, req, email, e.Message(lang))
+		}
+		session = opSession{UserID: user.ID, AuthTime: time.Now().Unix(), Expires: time.Now().Add(opSessionTTL).Unix()}
+		if err := setOPSession(c, session); err != nil {
+			return err
+		}
+		// the user has just signed in, as prompt=login and max_age ask
+		req.Set("prompt", strings.Join(removeString(prompt, "login"), " "))
+		req.Del("max_age")
+		prompt = removeString(prompt, "login")
+		needLogin = false
+	}
+	if needLogin {
+		if containsString(prompt, "none") {
+			return fail("login_required", "the user is not signed in")
+		}
+		return opLoginPage(c, fiber.StatusOK, client, req, req.Get("login_hint"), "")
+	}
+
+	switch action {
+	case "deny":
+		return fail("access_denied", "the user declined")
+	case "allow":
+		if err := oauth.GrantConsent(db.DB, user.ID, client.ID, scopes); err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to save consent")
+		}
+	default:
+		if !client.Trusted {
+			consented, err := oauth.HasConsent(db.DB, user.ID, client.ID, scopes)
+			if err != nil {
+				return apperr.Wrap(err, apperr.Internal, "failed to query consent")
+			}
+			if !consented || containsString(prompt, "consent") {
+				if containsString(prompt, "none") {
+					return fail("consent_required", "the user has not allowed the client access")
+				}
+				return opConsentPage(c, client, req, user, scopes)
+			}
+		}
+	}
+
+	code, err := oauth.IssueCode(db.DB, oauth.Grant{
+		ClientID:            client.ID,
+		UserID:              user.ID,
+		RedirectURI:         redirectURI,
+		Scope:               strings.Join(scopes, " "),
+		Nonce:               req.Get("nonce"),
+		CodeChallenge:       req.Get("code_challenge"),
+		CodeChallengeMethod: req.Get("code_challenge_method"),
+		AuthTime:            time.Unix(session.AuthTime, 0),
+	})
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to issue authorization code")
+	}
+	return authorizeRedirect(c, redirectURI, url.Values{"code": {code}, "state": {req.Get("state")}})
+}
+
+// opPasswordSignIn signs in with the email and password of the sign-in page.
+func opPasswordSignIn(c *fiber.Ctx, email string) (*users.User, error) {
+	password := c.FormValue("password")
+	if email == "" || password == "" {
+		return nil, apperr.New(apperr.InvalidRequest, "email and password required")
+	}
+	user, _, err := passwordLogin(c, email, password)
+	return user, err
+}
+
+// opCodeSignIn signs in with the code emailed by the sign-in page, as
+// VerifyMagicLink does: it creates an account for an unknown address.
+func opCodeSignIn(c *fiber.Ctx, email string) (*users.User, error) {
+	code := strings.TrimSpace(c.FormValue("code"))
+	if email == "" || code == "" {
+		return nil, apperr.New(apperr.InvalidRequest, "email and code required")
+	}
+	if _, err := redeemLoginCode(c, email, code); err != nil {
+		return nil, err
+	}
+	return loginLinkSignIn(c, email, &TokenResponse{})
+}
+
+// authorizeRedirect sends the user back to the client with the result of the
+// authorization request. iss tells clients using several providers which one
+// answered (RFC 9207).
+func authorizeRedirect(c *fiber.Ctx, redirectURI string, result url.Values) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "invalid redirect URI")
+	}
+	q := u.Query()
+	for k, v := range result {
+		if len(v) > 0 && v[0] != "" {
+			q[k] = v
+		}
+	}
+	q.Set("iss", issuer())
+	u.RawQuery = q.Encode()
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Redirect(u.String(), fiber.StatusFound)
+}
+
+// currentOPSession returns the sign-in at the provider and its user. It returns a
+// nil user when there is none, or when the account was disabled, closed or had its
+// password changed since.
+func currentOPSession(c *fiber.Ctx) (opSession, *users.User, error) {
+	var s opSession
+	if !verifyCookieValue(c.Cookies(opSessionCookie), &s) || time.Now().Unix() >= s.Expires {
+		return opSession{}, nil, nil
+	}
+	user, err := users.Get(db.DB, s.UserID)
+	switch err {
+	case nil:
+	case users.ErrNotFound:
+		return opSession{}, nil, nil
+	default:
+		return opSession{}, nil, apperr.Wrap(err, apperr.Internal, "failed to query user")
+	}
+	if !opUserActive(user, time.Unix(s.AuthTime, 0)) {
+		return opSession{}, nil, nil
+	}
+	return s, user, nil
+}
+
+// opUserActive reports whether the provider may still vouch for a user who signed in
+// at authTime, as AuthRequired decides for API tokens.
+func opUserActive(user *users.User, authTime time.Time) bool {
+	if user.Disabled() || !user.DeleteAfter.IsZero() {
+		return false
+	}
+	return user.PasswordChangedAt.IsZero() || !authTime.Before(user.PasswordChangedAt)
+}
+
+// setOPSession signs the browser in to the provider. The cookie lasts until the
+// browser is closed, and at most opSessionTTL.
+func setOPSession(c *fiber.Ctx, s opSession) error {
+	value, err := signCookieValue(s)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to sign in")
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     opSessionCookie,
+		Value:    value,
+		Path:     "/oauth",
+		HTTPOnly: true,
+		Secure:   strings.HasPrefix(baseURL(), "https://"),
+		SameSite: "Lax",
+	})
+	return nil
+}
+
+// opCSRFToken returns the token the forms must echo, setting the cookie holding it
+// on first use.
+func opCSRFToken(c *fiber.Ctx) (string, error) {
+	if token := c.Cookies(opCSRFCookie); token != "" {
+		return token, nil
+	}
+	token, _, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     opCSRFCookie,
+		Value:    token,
+		Path:     "/oauth",
+		HTTPOnly: true,
+		Secure:   strings.HasPrefix(baseURL(), "https://"),
+		SameSite: "Lax",
+	})
+	return token, nil
+}
+
+// supportedScopes returns the scopes of scope the provider knows, in its order.
+func supportedScopes(scope string) []string {
+	requested := oauth.ParseScope(scope)
+	var list []string
+	for _, s := range opScopes {
+		if containsString(requested, s) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	var out []string
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Token is the token endpoint: it redeems an authorization code for an ID token and
+// an access token. Errors are answered as RFC 6749 asks rather than as problem
+// documents, which OAuth client libraries do not understand.
+func Token(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	fail := func(status int, code, description string) error {
+		return c.Status(status).JSON(OAuthError{Error: code, ErrorDescription: description})
+	}
+
+	clientID, secret, basic := c.FormValue("client_id"), c.FormValue("client_secret"), false
+	if id, s, ok := basicAuth(c.Get(fiber.HeaderAuthorization)); ok {
+		clientID, secret, basic = id, s, true
+	}
+	client, err := oauth.GetClient(db.DB, clientID)
+	if err != nil && err != oauth.ErrClientNotFound {
+		return apperr.Wrap(err, apperr.Internal, "failed to query client")
+	}
+	if client == nil || !client.CheckSecret(secret) {
+		if basic {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+		}
+		return fail(fiber.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+	}
+
+	if c.FormValue("grant_type") != "authorization_code" {
+		return fail(fiber.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+	}
+	code := c.FormValue("code")
+	if code == "" {
+		return fail(fiber.StatusBadRequest, "invalid_request", "code required")
+	}
+	grant, err := oauth.RedeemCode(db.DB, code)
+	switch err {
+	case nil:
+	case oauth.ErrInvalidCode:
+		return fail(fiber.StatusBadRequest, "invalid_grant", "invalid or expired code")
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to redeem authorization code")
+	}
+	switch {
+	case grant.ClientID != client.ID:
+		return fail(fiber.StatusBadRequest, "invalid_grant", "code was issued to another client")
+	case grant.RedirectURI != c.FormValue("redirect_uri"):
+		return fail(fiber.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+	case !grant.VerifyPKCE(c.FormValue("code_verifier")):
+		return fail(fiber.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+	}
+	user, err := users.Get(db.DB, grant.UserID)
+	if err != nil && err != users.ErrNotFound {
+		return apperr.Wrap(err, apperr.Internal, "failed to query user")
+	}
+	if user == nil || !opUserActive(user, grant.AuthTime) {
+		return fail(fiber.StatusBadRequest, "invalid_grant", "the user can no longer sign in")
+	}
+
+	key, err := oauth.CurrentKey(db.DB)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to load signing keys")
+	}
+	now := time.Now()
+	sub := strconv.Itoa(user.ID)
+	accessToken, err := signOPToken(key, "at+jwt", jwt.MapClaims{
+		"iss":       issuer(),
+		"sub":       sub,
+		"aud":       issuer() + "/oauth/userinfo",
+		"client_id": client.ID,
+		"scope":     grant.Scope,
+		"iat":       now.Unix(),
+		"exp":       now.Add(opTokenTTL).Unix(),
+		"auth_time": grant.AuthTime.Unix(),
+	})
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to sign token")
+	}
+	// at_hash binds the ID token to the access token (OpenID Connect Core 1.0,
+	// section 3.1.3.6)
+	sum := sha256.Sum256([]byte(accessToken))
+	idClaims := jwt.MapClaims{
+		"iss":       issuer(),
+		"sub":       sub,
+		"aud":       client.ID,
+		"azp":       client.ID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(opTokenTTL).Unix(),
+		"auth_time": grant.AuthTime.Unix(),
+		"at_hash":   base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]),
+	}
+	if grant.Nonce != "" {
+		idClaims["nonce"] = grant.Nonce
+	}
+	info, err := loadUserInfo(user.ID, oauth.ParseScope(grant.Scope))
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch user")
+	}
+	for k, v := range info.claims() {
+		idClaims[k] = v
+	}
+	idToken, err := signOPToken(key, "JWT", idClaims)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to sign token")
+	}
+	return c.JSON(OAuthToken{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(opTokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       grant.Scope,
+	})
+}
+
+// basicAuth decodes the client credentials of an HTTP Basic Authorization header,
+// which RFC 6749 has URL-encoded before they are joined.
+func basicAuth(header string) (id, secret string, ok bool) {
+	scheme, encoded, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "basic") {
+		return "", "", false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(string(raw), ":")
+	if !ok {
+		return "", "", false
+	}
+	if id, err = url.QueryUnescape(id); err != nil {
+		return "", "", false
+	}
+	if secret, err = url.QueryUnescape(secret); err != nil {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+func signOPToken(key *oauth.SigningKey, typ string, claims jwt.MapClaims) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = key.ID
+	t.Header["typ"] = typ
+	return t.SignedString(key.Key)
+}
+
+// loadUserInfo returns the claims about user uid that scopes allow.
+func loadUserInfo(uid int, scopes []string) (*UserInfo, error) {
+	info := &UserInfo{Subject: strconv.Itoa(uid)}
+	profile, _, err := loadProfile(uid, false)
+	if err != nil {
+		return nil, err
+	}
+	if containsString(scopes, "profile") {
+		prefs, err := loadPreferences(uid)
+		if err != nil {
+			return nil, err
+		}
+		info.Name = strings.TrimSpace(profile.FirstName + " " + profile.LastName)
+		info.GivenName, info.FamilyName = profile.FirstName, profile.LastName
+		info.PreferredUsername = profile.Username
+		if profile.Avatar != "" {
+			info.Picture = baseURL() + profile.Avatar
+		}
+		info.Locale, info.Zoneinfo = prefs.Locale, prefs.Timezone
+	}
+	if containsString(scopes, "email") {
+		info.Email = profile.Email
+		info.EmailVerified = &profile.EmailVerified
+	}
+	if containsString(scopes, "phone") && profile.Phone != "" {
+		info.PhoneNumber = profile.Phone
+		info.PhoneNumberVerified = &profile.PhoneVerified
+	}
+	return info, nil
+}
+
+// claims returns the claims of info other than sub, for the ID token.
+func (info *UserInfo) claims() map[string]interface{} {
+	m := map[string]interface{}{}
+	for k, v := range map[string]string{
+		"name": info.Name, "given_name": info.GivenName, "family_name": info.FamilyName,
+		"preferred_username": info.PreferredUsername, "picture": info.Picture, "locale": info.Locale,
+		"zoneinfo": info.Zoneinfo, "email": info.Email, "phone_number": info.PhoneNumber,
+	} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	if info.EmailVerified != nil {
+		m["email_verified"] = *info.EmailVerified
+	}
+	if info.PhoneNumberVerified != nil {
+		m["phone_number_verified"] = *info.PhoneNumberVerified
+	}
+	return m
+}
+
+// opAccessClaims are the claims of an access token issued by Token.
+type opAccessClaims struct {
+	jwt.RegisteredClaims
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+}
+
+// Userinfo returns the claims about the user an access token was issued for. Tokens
+// stop working once the user is disabled, closes the account or changes the
+// password, and once the client is removed or the user revokes its consent.
+func Userinfo(c *fiber.Ctx) error {
+	fail := func(description string) error {
+		c.Set(fiber.HeaderWWWAuthenticate, fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, description))
+		return c.Status(fiber.StatusUnauthorized).JSON(OAuthError{Error: "invalid_token", ErrorDescription: description})
+	}
+	scheme, raw, _ := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !strings.EqualFold(scheme, "bearer") || raw == "" {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="oauth"`)
+		return c.Status(fiber.StatusUnauthorized).JSON(OAuthError{Error: "invalid_request", ErrorDescription: "access token required"})
+	}
+
+	var claims opAccessClaims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256"}))
+	_, err := parser.ParseWithClaims(raw, &claims, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != "at+jwt" {
+			return nil, fmt.Errorf("not an access token")
+		}
+		kid, _ := t.Header["kid"].(string)
+		return oauth.PublicKey(db.DB, kid)
+	})
+	if err != nil || claims.Issuer != issuer() {
+		return fail("invalid or expired access token")
+	}
+	uid, err := strconv.Atoi(claims.Subject)
+	if err != nil || claims.IssuedAt == nil {
+		return fail("invalid access token")
+	}
+	user, err := users.Get(db.DB, uid)
+	if err != nil && err != users.ErrNotFound {
+		return apperr.Wrap(err, apperr.Internal, "failed to query user")
+	}
+	if user == nil || !opUserActive(user, claims.IssuedAt.Time) {
+		return fail("access token revoked")
+	}
+	client, err := oauth.GetClient(db.DB, claims.ClientID)
+	if err != nil && err != oauth.ErrClientNotFound {
+		return apperr.Wrap(err, apperr.Internal, "failed to query client")
+	}
+	scopes := oauth.ParseScope(claims.Scope)
+	if client == nil {
+		return fail("access token revoked")
+	}
+	if !client.Trusted {
+		consented, err := oauth.HasConsent(db.DB, uid, client.ID, scopes)
+		if err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to query consent")
+		}
+		if !consented {
+			return fail("access token revoked")
+		}
+	}
+
+	info, err := loadUserInfo(uid, scopes)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch user")
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.JSON(info)
+}
@@ -0,0 +1,681 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/oidc"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The OpenID Connect provider is tested end to end: the relying party of
+// internal/oidc signs in through the routes, and the browser is simulated by
+// filling in the sign-in and consent forms with a cookie jar.
+
+// opServer is where the browser and the relying party find the routes; it matches
+// the default APP_BASE_URL the issuer is built from.
+const opServer = "http://localhost:3000"
+
+// callback is the redirect URI of the clients; nothing listens there, the browser
+// stops when it is sent to it.
+const callback = "http://localhost:8080/callback"
+
+// appTransport sends the relying party's requests to app.
+type appTransport struct{}
+
+func (appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return app.Test(req, -1)
+}
+
+// provider holds the accounts and clients of one test: an admin, alice, who signs
+// in to the clients, and a confidential, a public and a trusted client.
+type provider struct {
+	admin, aliceEmail, aliceToken    string
+	alice                            int
+	confidential, public, firstParty handlers.OAuthClient
+}
+
+// newProvider creates the accounts, with addresses derived from name, and registers
+// the clients.
+func newProvider(t *testing.T, name string) *provider {
+	t.Helper()
+	p := &provider{aliceEmail: name + "-alice@example.com"}
+	if _, err := users.Create(db.DB, users.NewUser{Email: name + "-admin@example.com", Password: password, Role: users.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if p.alice, err = users.Create(db.DB, users.NewUser{Email: p.aliceEmail, Password: password, FirstName: "Alice", LastName: "Liddell"}); err != nil {
+		t.Fatal(err)
+	}
+	p.admin, p.aliceToken = login(t, name+"-admin@example.com", password), login(t, p.aliceEmail, password)
+	for _, reg := range []struct {
+		client *handlers.OAuthClient
+		req    handlers.OAuthClientRequest
+	}{
+		{&p.confidential, handlers.OAuthClientRequest{Name: name + " Wiki", RedirectURIs: []string{callback}}},
+		{&p.public, handlers.OAuthClientRequest{Name: name + " Mobile", RedirectURIs: []string{callback}, Public: true}},
+		{&p.firstParty, handlers.OAuthClientRequest{Name: name + " Intranet", RedirectURIs: []string{callback}, Trusted: true}},
+	} {
+		expect(t, request{method: "POST", path: "/api/v1/admin/oauth-clients", token: p.admin, body: reg.req}, fiber.StatusCreated, reg.client)
+	}
+	return p
+}
+
+// page is the response that ended a flow.
+type page struct {
+	status   int
+	location string
+	header   http.Header
+	body     []byte
+}
+
+// redirected returns the query the browser was sent back to the client with.
+func (r *page) redirected(t *testing.T) url.Values {
+	t.Helper()
+	if r.status != http.StatusFound || !strings.HasPrefix(r.location, callback+"?") {
+		t.Fatalf("status %d, location %q: %s", r.status, r.location, r.body)
+	}
+	u, err := url.Parse(r.location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
+
+// code returns the authorization code the browser was sent back with.
+func (r *page) code(t *testing.T, state string) string {
+	t.Helper()
+	q := r.redirected(t)
+	if q.Get("state") != state || q.Get("iss") != opServer || q.Get("code") == "" {
+		t.Fatalf("redirected to %q", r.location)
+	}
+	return q.Get("code")
+}
+
+// redirectError fails unless the browser was sent back with the error.
+func (r *page) redirectError(t *testing.T, code string) {
+	t.Helper()
+	if q := r.redirected(t); q.Get("error") != code || q.Get("iss") != opServer {
+		t.Fatalf("redirected to %q, want error %s", r.location, code)
+	}
+}
+
+var hiddenInput = regexp.MustCompile(`<input type="hidden" name="([^"]+)" value="([^"]*)">`)
+
+// form returns the hidden fields of the sign-in or consent page.
+func (r *page) form(t *testing.T) url.Values {
+	t.Helper()
+	if r.status != http.StatusOK && r.status != http.StatusUnauthorized {
+		t.Fatalf("status %d: %s", r.status, r.body)
+	}
+	form := url.Values{}
+	for _, m := range hiddenInput.FindAllSubmatch(r.body, -1) {
+		form.Add(html.UnescapeString(string(m[1])), html.UnescapeString(string(m[2])))
+	}
+	if form.Get("csrf_token") == "" {
+		t.Fatalf("no form on the page: %s", r.body)
+	}
+	return form
+}
+
+// isLogin and isConsent tell the pages apart by their submit buttons.
+func (r *page) isLogin() bool {
+	return r.status == http.StatusOK && bytes.Contains(r.body, []byte(`name="action" value="login"`))
+}
+
+func (r *page) isConsent() bool {
+	return r.status == http.StatusOK && bytes.Contains(r.body, []byte(`name="action" value="allow"`))
+}
+
+// browser keeps the cookies of the provider's pages.
+type browser struct {
+	jar *cookiejar.Jar
+}
+
+func newBrowser() *browser {
+	jar, _ := cookiejar.New(nil)
+	return &browser{jar: jar}
+}
+
+// get and post send a request to the server and follow its redirects until a
+// response that is not one or leaves it, e.g. back to the client.
+func (b *browser) get(t *testing.T, target string) *page {
+	t.Helper()
+	return b.do(t, http.MethodGet, target, nil)
+}
+
+func (b *browser) do(t *testing.T, method, target string, form url.Values) *page {
+	t.Helper()
+	for hops := 0; hops < 10; hops++ {
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !u.IsAbs() {
+			u, _ = url.Parse(opServer + target)
+		}
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+		req, err := http.NewRequest(method, u.String(), body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if form != nil {
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+		}
+		for _, cookie := range b.jar.Cookies(u) {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.jar.SetCookies(u, resp.Cookies())
+
+		location := resp.Header.Get(fiber.HeaderLocation)
+		next, err := u.Parse(location)
+		if resp.StatusCode != http.StatusFound || err != nil || next.Scheme+"://"+next.Host != opServer {
+			return &page{status: resp.StatusCode, location: location, header: resp.Header, body: data}
+		}
+		method, target, form = http.MethodGet, next.String(), nil
+	}
+	t.Fatal("too many redirects")
+	return nil
+}
+
+// submit posts the form of page with the action and the extra fields.
+func (b *browser) submit(t *testing.T, r *page, action string, fields url.Values) *page {
+	t.Helper()
+	form := r.form(t)
+	form.Set("action", action)
+	for k, v := range fields {
+		form[k] = v
+	}
+	return b.do(t, http.MethodPost, "/oauth/authorize", form)
+}
+
+// signIn fills in the sign-in page as alice.
+func (p *provider) signIn(t *testing.T, b *browser, r *page) *page {
+	t.Helper()
+	return b.submit(t, r, "login", url.Values{"email": {p.aliceEmail}, "password": {password}})
+}
+
+// relyingParty signs in to client with the relying party of internal/oidc.
+func relyingParty(client handlers.OAuthClient, scopes ...string) *oidc.Provider {
+	return &oidc.Provider{
+		Name:         client.Name,
+		Issuer:       opServer,
+		ClientID:     client.ID,
+		ClientSecret: client.Secret,
+		Scopes:       scopes,
+		HTTPClient:   &http.Client{Transport: appTransport{}},
+	}
+}
+
+// authorize starts a sign-in of rp and returns the URL to send the browser to, with
+// the extra parameters.
+func authorize(t *testing.T, rp *oidc.Provider, extra url.Values) (string, oidc.AuthRequest) {
+	t.Helper()
+	r, err := oidc.NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := rp.AuthURL(context.Background(), callback, r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extra) > 0 {
+		u += "&" + extra.Encode()
+	}
+	return u, r
+}
+
+// session signs alice in to the confidential client in a new browser, giving
+// consent to email and profile, and returns the browser.
+func (p *provider) session(t *testing.T) *browser {
+	t.Helper()
+	b := newBrowser()
+	rp := relyingParty(p.confidential, "email", "profile")
+	u, r := authorize(t, rp, nil)
+	res := b.get(t, u)
+	if !res.isLogin() {
+		t.Fatalf("no sign-in page: status %d: %s", res.status, res.body)
+	}
+	if res = p.signIn(t, b, res); !res.isConsent() || !bytes.Contains(res.body, []byte("See your email address")) {
+		t.Fatalf("no consent page: status %d: %s", res.status, res.body)
+	}
+	code := b.submit(t, res, "allow", nil).code(t, r.State)
+	claims, err := rp.Exchange(context.Background(), callback, code, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// alice registered with a password, and nothing has confirmed her address
+	if claims.Subject != strconv.Itoa(p.alice) || claims.Email != p.aliceEmail || claims.EmailVerified || claims.GivenName != "Alice" {
+		t.Fatalf("claims %+v", claims)
+	}
+	return b
+}
+
+// sessionCode returns a code of the confidential client from a browser signed in
+// with session, with the AuthRequest it answers.
+func (p *provider) sessionCode(t *testing.T, b *browser) (string, oidc.AuthRequest) {
+	t.Helper()
+	u, r := authorize(t, relyingParty(p.confidential, "email", "profile"), nil)
+	return b.get(t, u).code(t, r.State), r
+}
+
+// exchange redeems a code at the token endpoint with the form, authenticating the
+// client with HTTP Basic if basicUser is set.
+func exchange(t *testing.T, form url.Values, basicUser, basicPassword string) (*http.Response, []byte) {
+	t.Helper()
+	header := map[string]string{}
+	if basicUser != "" {
+		req := http.Request{Header: http.Header{}}
+		req.SetBasicAuth(url.QueryEscape(basicUser), url.QueryEscape(basicPassword))
+		header[fiber.HeaderAuthorization] = req.Header.Get(fiber.HeaderAuthorization)
+	}
+	return do(t, request{method: "POST", path: "/oauth/token", body: form.Encode(), contentType: fiber.MIMEApplicationForm, header: header})
+}
+
+// expectOAuthError fails unless the response is an OAuthError with the status and
+// code.
+func expectOAuthError(t *testing.T, resp *http.Response, body []byte, status int, code string) {
+	t.Helper()
+	var e handlers.OAuthError
+	json.Unmarshal(body, &e)
+	if resp.StatusCode != status || e.Error != code {
+		t.Fatalf("status %d, want %d with error %s: %s", resp.StatusCode, status, code, body)
+	}
+}
+
+// publicToken signs alice in to the public client with the scope in b and returns
+// the token response.
+func (p *provider) publicToken(t *testing.T, b *browser, scope string) handlers.OAuthToken {
+	t.Helper()
+	r, err := oidc.NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.public.ID},
+		"redirect_uri":          {callback},
+		"scope":                 {scope},
+		"state":                 {r.State},
+		"code_challenge":        {r.Challenge()},
+		"code_challenge_method": {"S256"},
+	}
+	res := b.get(t, "/oauth/authorize?"+q.Encode())
+	if res.isLogin() {
+		res = p.signIn(t, b, res)
+	}
+	if res.isConsent() {
+		res = b.submit(t, res, "allow", nil)
+	}
+	resp, body := exchange(t, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {res.code(t, r.State)},
+		"redirect_uri":  {callback},
+		"client_id":     {p.public.ID},
+		"code_verifier": {r.Verifier},
+	}, "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token: status %d: %s", resp.StatusCode, body)
+	}
+	var tok handlers.OAuthToken
+	if err := json.Unmarshal(body, &tok); err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+// userinfo calls the userinfo endpoint with the access token.
+func userinfo(t *testing.T, accessToken string) (*http.Response, []byte) {
+	t.Helper()
+	return do(t, request{method: "GET", path: "/oauth/userinfo", token: accessToken})
+}
+
+func TestOAuthClientRegistration(t *testing.T) {
+	p := newProvider(t, "op-clients")
+	for _, c := range []handlers.OAuthClient{p.confidential, p.public, p.firstParty} {
+		if (c.Secret == "") != c.Public {
+			t.Errorf("client %s registered with secret %q", c.Name, c.Secret)
+		}
+	}
+
+	bad := handlers.OAuthClientRequest{Name: "Evil", RedirectURIs: []string{"http://evil.example.com/cb"}}
+	expect(t, request{method: "POST", path: "/api/v1/admin/oauth-clients", token: p.admin, body: bad}, fiber.StatusBadRequest, nil)
+	expect(t, request{method: "POST", path: "/api/v1/admin/oauth-clients", token: p.aliceToken, body: bad}, fiber.StatusForbidden, nil)
+
+	var list handlers.OAuthClientList
+	expect(t, request{method: "GET", path: "/api/v1/admin/oauth-clients", token: p.admin}, fiber.StatusOK, &list)
+	listed := 0
+	for _, c := range list.Clients {
+		if c.Secret != "" {
+			t.Errorf("secret of %s listed", c.Name)
+		}
+		if c.ID == p.confidential.ID || c.ID == p.public.ID || c.ID == p.firstParty.ID {
+			listed++
+		}
+	}
+	if listed != 3 {
+		t.Errorf("%d of the 3 clients listed", listed)
+	}
+}
+
+func TestOpenIDDiscovery(t *testing.T) {
+	var config handlers.OpenIDConfiguration
+	expect(t, request{method: "GET", path: "/.well-known/openid-configuration"}, fiber.StatusOK, &config)
+	if config.Issuer != opServer || config.TokenEndpoint != opServer+"/oauth/token" || config.JWKSURI != opServer+"/oauth/jwks" {
+		t.Errorf("configuration %+v", config)
+	}
+	var keys handlers.JWKSet
+	expect(t, request{method: "GET", path: "/oauth/jwks"}, fiber.StatusOK, &keys)
+	if len(keys.Keys) == 0 || keys.Keys[0].Kty != "RSA" || keys.Keys[0].Kid == "" {
+		t.Errorf("keys %+v", keys.Keys)
+	}
+}
+
+// Signed in and with consent given, the browser goes straight back to the client,
+// unless the client asks for the password again.
+func TestOAuthSessionKept(t *testing.T) {
+	p := newProvider(t, "op-session")
+	b := p.session(t)
+	rp := relyingParty(p.confidential, "email", "profile")
+	code, r := p.sessionCode(t, b)
+	if _, err := rp.Exchange(context.Background(), callback, code, r); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := authorize(t, rp, url.Values{"prompt": {"login"}})
+	if res := b.get(t, u); !res.isLogin() {
+		t.Errorf("prompt=login: status %d: %s", res.status, res.body)
+	}
+}
+
+func TestOAuthWrongPassword(t *testing.T) {
+	p := newProvider(t, "op-wrong-password")
+	b := newBrowser()
+	u, _ := authorize(t, relyingParty(p.confidential), nil)
+	res := b.submit(t, b.get(t, u), "login", url.Values{"email": {p.aliceEmail}, "password": {"wrong"}})
+	if res.status != http.StatusUnauthorized {
+		t.Fatalf("status %d: %s", res.status, res.body)
+	}
+	if !bytes.Contains(res.body, []byte(`role="alert"`)) || !bytes.Contains(res.body, []byte(`value="`+p.aliceEmail+`"`)) {
+		t.Errorf("sign-in page without the error: %s", res.body)
+	}
+}
+
+// Accounts without a password sign in with a code emailed from the sign-in page,
+// which, like the emailed links, creates the account of an unknown address.
+func TestOAuthCodeSignIn(t *testing.T) {
+	p := newProvider(t, "op-code")
+	const email = "op-code-new@example.com"
+	rp := relyingParty(p.confidential, "email")
+	u, r := authorize(t, rp, nil)
+	b := newBrowser()
+	res := b.submit(t, b.get(t, u), "send_code", url.Values{"email": {email}})
+	if res.status != http.StatusOK || !bytes.Contains(res.body, []byte(`name="action" value="code"`)) {
+		t.Fatalf("no code page: status %d: %s", res.status, res.body)
+	}
+	code := awaitLoginCode(t, email)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if res = b.submit(t, res, "code", url.Values{"code": {wrong}}); res.status != http.StatusUnauthorized || !bytes.Contains(res.body, []byte(`role="alert"`)) {
+		t.Fatalf("wrong code: status %d: %s", res.status, res.body)
+	}
+	if res = b.submit(t, res, "code", url.Values{"code": {code}}); !res.isConsent() {
+		t.Fatalf("no consent page: status %d: %s", res.status, res.body)
+	}
+	claims, err := rp.Exchange(context.Background(), callback, b.submit(t, res, "allow", nil).code(t, r.State), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != email || !claims.EmailVerified {
+		t.Errorf("claims %+v", claims)
+	}
+}
+
+func TestOAuthPromptNone(t *testing.T) {
+	p := newProvider(t, "op-prompt-none")
+	u, _ := authorize(t, relyingParty(p.confidential), url.Values{"prompt": {"none"}})
+	newBrowser().get(t, u).redirectError(t, "login_required")
+
+	// signed in, but the scope was not allowed yet
+	b := p.session(t)
+	u, _ = authorize(t, relyingParty(p.confidential, "phone"), url.Values{"prompt": {"none"}})
+	b.get(t, u).redirectError(t, "consent_required")
+}
+
+func TestOAuthConsentDenied(t *testing.T) {
+	p := newProvider(t, "op-denied")
+	b := newBrowser()
+	u, _ := authorize(t, relyingParty(p.confidential, "phone"), nil)
+	res := p.signIn(t, b, b.get(t, u))
+	if !res.isConsent() {
+		t.Fatalf("no consent page: status %d: %s", res.status, res.body)
+	}
+	b.submit(t, res, "deny", nil).redirectError(t, "access_denied")
+}
+
+func TestOAuthTrustedClient(t *testing.T) {
+	p := newProvider(t, "op-trusted")
+	b := newBrowser()
+	u, r := authorize(t, relyingParty(p.firstParty, "email"), nil)
+	// no consent page
+	p.signIn(t, b, b.get(t, u)).code(t, r.State)
+}
+
+func TestOAuthBadClient(t *testing.T) {
+	p := newProvider(t, "op-bad-client")
+	// errors that cannot be sent back to the client are shown to the user
+	for _, q := range []url.Values{
+		{"response_type": {"code"}, "client_id": {"nope"}, "redirect_uri": {callback}, "scope": {"openid"}},
+		{"response_type": {"code"}, "client_id": {p.confidential.ID}, "redirect_uri": {"http://localhost:8080/other"}, "scope": {"openid"}},
+	} {
+		if res := newBrowser().get(t, "/oauth/authorize?"+q.Encode()); res.status != http.StatusBadRequest || res.location != "" {
+			t.Errorf("%s: status %d, location %q", q.Encode(), res.status, res.location)
+		}
+	}
+
+	// with a registered redirect URI, errors go back to the client
+	q := url.Values{"response_type": {"token"}, "client_id": {p.confidential.ID}, "redirect_uri": {callback}, "scope": {"openid"}}
+	newBrowser().get(t, "/oauth/authorize?"+q.Encode()).redirectError(t, "unsupported_response_type")
+	q.Set("response_type", "code")
+	q.Set("scope", "email")
+	newBrowser().get(t, "/oauth/authorize?"+q.Encode()).redirectError(t, "invalid_scope")
+}
+
+func TestOAuthFormCSRF(t *testing.T) {
+	p := newProvider(t, "op-csrf")
+	b := newBrowser()
+	u, _ := authorize(t, relyingParty(p.confidential), nil)
+	form := b.get(t, u).form(t)
+	form.Set("csrf_token", "forged")
+	form.Set("action", "login")
+	form.Set("email", p.aliceEmail)
+	form.Set("password", password)
+	res := b.do(t, http.MethodPost, "/oauth/authorize", form)
+	if res.status != http.StatusBadRequest {
+		t.Fatalf("status %d: %s", res.status, res.body)
+	}
+	for _, cookie := range (&http.Response{Header: res.header}).Cookies() {
+		if cookie.Name == "op_session" {
+			t.Error("signed in with a forged form")
+		}
+	}
+}
+
+func TestOAuthPublicClientPKCE(t *testing.T) {
+	p := newProvider(t, "op-pkce")
+	q := url.Values{"response_type": {"code"}, "client_id": {p.public.ID}, "redirect_uri": {callback}, "scope": {"openid"}}
+	newBrowser().get(t, "/oauth/authorize?"+q.Encode()).redirectError(t, "invalid_request")
+	q.Set("code_challenge", "abc")
+	q.Set("code_challenge_method", "plain")
+	newBrowser().get(t, "/oauth/authorize?"+q.Encode()).redirectError(t, "invalid_request")
+
+	// a code redeemed with another verifier
+	b := newBrowser()
+	p.publicToken(t, b, "openid")
+	r, err := oidc.NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Set("state", r.State)
+	q.Set("code_challenge", r.Challenge())
+	q.Set("code_challenge_method", "S256")
+	code := b.get(t, "/oauth/authorize?"+q.Encode()).code(t, r.State)
+	other, err := oidc.NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, body := exchange(t, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {callback},
+		"client_id":     {p.public.ID},
+		"code_verifier": {other.Verifier},
+	}, "", "")
+	expectOAuthError(t, resp, body, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestOAuthCodeUsedOnce(t *testing.T) {
+	p := newProvider(t, "op-code-reuse")
+	code, r := p.sessionCode(t, p.session(t))
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {callback},
+		"code_verifier": {r.Verifier},
+	}
+	// HTTP Basic authentication, the default of the spec
+	if resp, body := exchange(t, form, p.confidential.ID, p.confidential.Secret); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	resp, body := exchange(t, form, p.confidential.ID, p.confidential.Secret)
+	expectOAuthError(t, resp, body, http.StatusBadRequest, "invalid_grant")
+}
+
+func TestOAuthClientAuthentication(t *testing.T) {
+	p := newProvider(t, "op-client-auth")
+	code, r := p.sessionCode(t, p.session(t))
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {callback},
+		"code_verifier": {r.Verifier},
+	}
+	resp, body := exchange(t, form, p.confidential.ID, "wrong")
+	expectOAuthError(t, resp, body, http.StatusUnauthorized, "invalid_client")
+	if h := resp.Header.Get(fiber.HeaderWWWAuthenticate); !strings.HasPrefix(h, "Basic") {
+		t.Errorf("WWW-Authenticate %q", h)
+	}
+
+	// a code of one client redeemed by another
+	form.Set("client_id", p.firstParty.ID)
+	form.Set("client_secret", p.firstParty.Secret)
+	resp, body = exchange(t, form, "", "")
+	expectOAuthError(t, resp, body, http.StatusBadRequest, "invalid_grant")
+}
+
+// Userinfo has the claims of the scopes allowed, and only accepts access tokens.
+func TestOAuthUserinfo(t *testing.T) {
+	p := newProvider(t, "op-userinfo")
+	tok := p.publicToken(t, newBrowser(), "openid email")
+	resp, body := userinfo(t, tok.AccessToken)
+	var info handlers.UserInfo
+	if err := json.Unmarshal(body, &info); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if info.Subject != strconv.Itoa(p.alice) || info.Email != p.aliceEmail || info.EmailVerified == nil || *info.EmailVerified || info.GivenName != "" {
+		t.Errorf("userinfo %+v for scope %q", info, tok.Scope)
+	}
+	if err := users.MarkEmailVerified(db.DB, p.alice, p.aliceEmail); err != nil {
+		t.Fatal(err)
+	}
+	_, body = userinfo(t, tok.AccessToken)
+	if err := json.Unmarshal(body, &info); err != nil || info.EmailVerified == nil || !*info.EmailVerified {
+		t.Errorf("userinfo %s after the address was confirmed", body)
+	}
+
+	// an ID token is no access token
+	resp, body = userinfo(t, tok.IDToken)
+	expectOAuthError(t, resp, body, http.StatusUnauthorized, "invalid_token")
+	if h := resp.Header.Get(fiber.HeaderWWWAuthenticate); !strings.HasPrefix(h, "Bearer") {
+		t.Errorf("WWW-Authenticate %q", h)
+	}
+}
+
+func TestOAuthConsentRevoked(t *testing.T) {
+	p := newProvider(t, "op-revoke")
+	tok := p.publicToken(t, newBrowser(), "openid")
+	b := newBrowser()
+	u, r := authorize(t, relyingParty(p.firstParty), nil)
+	p.signIn(t, b, b.get(t, u)).code(t, r.State)
+
+	var list handlers.OAuthConsentList
+	expect(t, request{method: "GET", path: "/api/v1/profile/consents", token: p.aliceToken}, fiber.StatusOK, &list)
+	found := false
+	for _, consent := range list.Consents {
+		if consent.ClientID == p.firstParty.ID {
+			t.Error("consent listed for the trusted client")
+		}
+		found = found || consent.ClientID == p.public.ID
+	}
+	if !found {
+		t.Errorf("consents %+v", list.Consents)
+	}
+
+	expect(t, request{method: "DELETE", path: "/api/v1/profile/consents/" + p.public.ID, token: p.aliceToken}, fiber.StatusNoContent, nil)
+	resp, body := userinfo(t, tok.AccessToken)
+	expectOAuthError(t, resp, body, http.StatusUnauthorized, "invalid_token")
+	expect(t, request{method: "DELETE", path: "/api/v1/profile/consents/" + p.public.ID, token: p.aliceToken}, fiber.StatusNotFound, nil)
+}
+
+func TestOAuthPasswordChangeEndsAccess(t *testing.T) {
+	p := newProvider(t, "op-password")
+	tok := p.publicToken(t, newBrowser(), "openid profile")
+	if resp, body := userinfo(t, tok.AccessToken); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	// password changes are recorded to the second, like the sign-in time
+	time.Sleep(time.Second)
+	if _, err := users.SetPassword(db.DB, p.alice, password); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := userinfo(t, tok.AccessToken)
+	expectOAuthError(t, resp, body, http.StatusUnauthorized, "invalid_token")
+}
+
+func TestOAuthClientDeleted(t *testing.T) {
+	p := newProvider(t, "op-delete-client")
+	tok := p.publicToken(t, newBrowser(), "openid")
+	expect(t, request{method: "DELETE", path: "/api/v1/admin/oauth-clients/" + p.public.ID, token: p.admin}, fiber.StatusNoContent, nil)
+	resp, body := userinfo(t, tok.AccessToken)
+	expectOAuthError(t, resp, body, http.StatusUnauthorized, "invalid_token")
+	expect(t, request{method: "DELETE", path: "/api/v1/admin/oauth-clients/" + p.public.ID, token: p.admin}, fiber.StatusNotFound, nil)
+}
@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/oidc"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OIDCProviders are the OpenID Connect providers users can sign in with. main sets
+// them from the environment.
+var OIDCProviders []*oidc.Provider
+
+const (
+	// oidcCookie carries the state of a sign-in from its start to the callback
+	oidcCookie = "oidc_login"
+	// a sign-in must come back from the provider within this long
+	oidcLoginTTL = 10 * time.Minute
+	// oidcTimeout bounds the requests to the provider made by one callback
+	oidcTimeout = 15 * time.Second
+)
+
+// OIDCProvider is a provider in GET /auth/oidc.
+type OIDCProvider struct {
+	Name     string `json:"name" openapi:"example=google"`
+	LoginURL string `json:"login_url" doc:"opening it in the browser starts the sign-in"`
+}
+
+// OIDCProviderList is the body of GET /auth/oidc.
+type OIDCProviderList struct {
+	Providers []OIDCProvider `json:"providers"`
+}
+
+// LinkedIdentity is an account at a provider that the user can sign in with.
+type LinkedIdentity struct {
+	Provider  string `json:"provider" openapi:"example=google"`
+	Email     string `json:"email" doc:"address the provider reported when the identity was linked"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// IdentityList is the body of GET /profile/identities.
+type IdentityList struct {
+	Identities []LinkedIdentity `json:"identities"`
+}
+
+// AuthorizationURL is the body of POST /profile/identities/{provider}.
+type AuthorizationURL struct {
+	AuthorizationURL string `json:"authorization_url" doc:"open it in the same browser to link the account at the provider"`
+}
+
+// oidcLogin is the state of a sign-in kept in oidcCookie. UserID is set when a
+// signed-in user links a provider rather than signing in with it.
+type oidcLogin struct {
+	Provider string           `json:"p"`
+	Request  oidc.AuthRequest `json:"r"`
+	UserID   int              `json:"u,omitempty"`
+	Redirect string           `json:"d,omitempty"`
+	Expires  int64            `json:"e"`
+}
+
+// oidcProvider returns the configured provider with the given name.
+func oidcProvider(name string) (*oidc.Provider, error) {
+	for _, p := range OIDCProviders {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, apperr.New(apperr.NotFound, "unknown provider")
+}
+
+// oidcRedirectURI is the callback the provider sends users back to. It must be
+// registered with the provider.
+func oidcRedirectURI(p *oidc.Provider) string {
+	return baseURL() + V1 + "/auth/oidc/" + p.Name + "/callback"
+}
+
+// oidcRedirect checks the redirect query parameter with checkRedirect.
+func oidcRedirect(c *fiber.Ctx) (string, error) {
+	r := c.Query("redirect")
+	if err := checkRedirect(r); err != nil {
+		return "", err
+	}
+	return r, nil
+}
+
+// checkRedirect accepts an empty redirect or a path on this server, so that tokens
+// passed to the redirect are never handed to another site.
+func checkRedirect(r string) error {
+	if r != "" && (!strings.HasPrefix(r, "/") || strings.HasPrefix(r, "//") || strings.ContainsAny(r, "\\#")) {
+		return apperr.New(apperr.InvalidRequest, "redirect must be a path on this server")
+	}
+	return nil
+}
+
+// verifyOIDCLogin decodes the state of a sign-in, reporting false when it was
+// tampered with or has expired.
+func verifyOIDCLogin(value string) (oidcLogin, bool) {
+	var l oidcLogin
+	if !verifyCookieValue(value, &l) {
+		return l, false
+	}
+	return l, time.Now().Unix() < l.Expires
+}
+
+// setOIDCCookie stores the state of a sign-in, or clears it when value is empty.
+// The cookie is only sent to the callback; SameSite=Lax lets it through on the
+// top-level redirect back from the provider.
+func setOIDCCookie(c *fiber.Ctx, value string) {
+	cookie := &fiber.Cookie{
+		Name:     oidcCookie,
+		Value:    value,
+		Path:     V1 + "/auth/oidc",
+		HTTPOnly: true,
+		Secure:   strings.HasPrefix(baseURL(), "https://"),
+		SameSite: "Lax",
+		Expires:  time.Now().Add(oidcLoginTTL),
+	}
+	if value == "" {
+		cookie.Expires = time.Unix(0, 0)
+	}
+	c.Cookie(cookie)
+}
+
+// startOIDCLogin stores a new sign-in in the cookie and returns the URL of the
+// provider to send the user to.
+func startOIDCLogin(c *fiber.Ctx, p *oidc.Provider, uid int, redirect, loginHint string) (string, error) {
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		return "", apperr.Wrap(err, apperr.Internal, "failed to start sign-in")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+	defer cancel()
+	authURL, err := p.AuthURL(ctx, oidcRedirectURI(p), req, loginHint)
+	if err != nil {
+		return "", apperr.Wrap(err, apperr.ProviderError, "sign-in with the provider failed")
+	}
+	value, err := signCookieValue(oidcLogin{
+		Provider: p.Name,
+		Request:  req,
+		UserID:   uid,
+		Redirect: redirect,
+		Expires:  time.Now().Add(oidcLoginTTL).Unix(),
+	})
+	if err != nil {
+		return "", apperr.Wrap(err, apperr.Internal, "failed to start sign-in")
+	}
+	setOIDCCookie(c, value)
+	return authURL, nil
+}
+
+// ListOIDCProviders returns the providers users can sign in with.
+func ListOIDCProviders(c *fiber.Ctx) error {
+	list := OIDCProviderList{Providers: []OIDCProvider{}}
+	for _, p := range OIDCProviders {
+		list.Providers = append(list.Providers, OIDCProvider{Name: p.Name, LoginURL: V1 + "/auth/oidc/" + p.Name})
+	}
+	return c.JSON(list)
+}
+
+// StartOIDCLogin redirects the browser to the provider to sign in. The callback
+// answers with a token, or passes it to the redirect path in the URL fragment.
+func StartOIDCLogin(c *fiber.Ctx) error {
+	p, err := oidcProvider(c.Params("provider"))
+	if err != nil {
+		return err
+	}
+	redirect, err := oidcRedirect(c)
+	if err != nil {
+		return err
+	}
+	authURL, err := startOIDCLogin(c, p, 0, redirect, c.Query("login_hint"))
+	if err != nil {
+		return err
+	}
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// OIDCCallback finishes a sign-in when the provider redirects back. A known
+// identity signs in to its account; an unknown one creates an account without a
+// password, unless its email address is registered already: taking over that
+// account would need proof that its owner and the provider's user are the same, so
+// the owner has to link the provider from their profile instead. For a sign-in
+// started from POST /profile/identities/{provider} the identity is linked to the
+// user who started it.
+func OIDCCallback(c *fiber.Ctx) error {
+	p, err := oidcProvider(c.Params("provider"))
+	if err != nil {
+		return err
+	}
+	// the state is single use, whatever the outcome
+	login, ok := verifyOIDCLogin(c.Cookies(oidcCookie))
+	setOIDCCookie(c, "")
+	if !ok || login.Provider != p.Name || c.Query("state") != login.Request.State {
+		return apperr.New(apperr.InvalidState, "sign-in was not started here or has expired")
+	}
+	switch e := c.Query("error"); e {
+	case "":
+	case "access_denied":
+		return apperr.New(apperr.AuthorizationDenied, "sign-in was declined at the provider")
+	default:
+		return apperr.Wrap(fmt.Errorf("%s: %s %s", p.Name, e, c.Query("error_description")), apperr.ProviderError, "sign-in with the provider failed")
+	}
+	code := c.Query("code")
+	if code == "" {
+		return apperr.New(apperr.InvalidRequest, "authorization code required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+	defer cancel()
+	claims, err := p.Exchange(ctx, oidcRedirectURI(p), code, login.Request)
+	if err != nil {
+		return apperr.Wrap(fmt.Errorf("%s: %w", p.Name, err), apperr.ProviderError, "sign-in with the provider failed")
+	}
+
+	var user *users.User
+	resp := TokenResponse{}
+	if login.UserID != 0 {
+		user, err = linkIdentity(c, p, login.UserID, claims)
+		resp.Linked = true
+	} else {
+		user, resp.Created, err = oidcUser(c, p, claims)
+	}
+	if err != nil {
+		return err
+	}
+	if user.Disabled() {
+		return apperr.New(apperr.AccountDisabled, "account disabled")
+	}
+	if login.UserID == 0 {
+		recordLoginEvent(c, user.ID, true)
+		// like logging in with a password, this cancels a pending account deletion
+		if resp.Restored, err = restoreAccount(c, user.ID); err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to restore account")
+		}
+	}
+	if resp.Token, err = issueToken(user.ID, user.Email); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to sign token")
+	}
+
+	if login.Redirect != "" {
+		// in the fragment, so that the token stays out of server logs and Referer
+		// headers
+		q := url.Values{"token": {resp.Token}}
+		if resp.Created {
+			q.Set("created", "true")
+		}
+		return c.Redirect(login.Redirect+"#"+q.Encode(), fiber.StatusFound)
+	}
+	return c.JSON(resp)
+}
+
+// oidcUser returns the account the identity signs in to, creating it on first use,
+// and reports whether it did.
+func oidcUser(c *fiber.Ctx, p *oidc.Provider, claims *oidc.Claims) (*users.User, bool, error) {
+	user, err := users.GetByIdentity(db.DB, p.Name, claims.Subject)
+	switch err {
+	case nil:
+		return user, false, nil
+	case users.ErrNotFound:
+	default:
+		return nil, false, apperr.Wrap(err, apperr.Internal, "failed to query user")
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, false, apperr.New(apperr.EmailUnverified, "provider reported no verified email address")
+	}
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName = claims.Name
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, false, apperr.Wrap(err, apperr.Internal, "failed to create user")
+	}
+	defer tx.Rollback()
+	uid, err := users.Create(tx, users.NewUser{Email: claims.Email, FirstName: firstName, LastName: lastName, EmailVerified: true})
+	switch err {
+	case nil:
+	case users.ErrEmailTaken:
+		return nil, false, apperr.New(apperr.EmailTaken, "email already registered, sign in and link the provider from your profile")
+	default:
+		return nil, false, apperr.Wrap(err, apperr.Internal, "failed to create user")
+	}
+	if err := addIdentity(tx, c, p, uid, claims); err != nil {
+		return nil, false, err
+	}
+	user, err = users.Get(tx, uid)
+	if err != nil {
+		return nil, false, apperr.Wrap(err, apperr.Internal, "failed to create user")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, apperr.Wrap(err, apperr.Internal, "failed to create user")
+	}
+	return user, true, nil
+}
+
+// linkIdentity links the identity to user uid. Linking it again is not an error.
+func linkIdentity(c *fiber.Ctx, p *oidc.Provider, uid int, claims *oidc.Claims) (*users.User, error) {
+	user, err := users.Get(db.DB, uid)
+	switch err {
+	case nil:
+	case users.ErrNotFound:
+		return nil, apperr.New(apperr.InvalidToken, "user not found")
+	default:
+		return nil, apperr.Wrap(err, apperr.Internal, "failed to query user")
+	}
+	if owner, err := users.GetByIdentity(db.DB, p.Name, claims.Subject); err == nil && owner.ID == uid {
+		return user, nil
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, apperr.Wrap(err, apperr.Internal, "failed to link identity")
+	}
+	defer tx.Rollback()
+	if err := addIdentity(tx, c, p, uid, claims); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, apperr.Wrap(err, apperr.Internal, "failed to link identity")
+	}
+	return user, nil
+}
+
+// addIdentity links the identity to user uid and records it in the history.
+func addIdentity(tx users.Queryer, c *fiber.Ctx, p *oidc.Provider, uid int, claims *oidc.Claims) error {
+	err := users.LinkIdentity(tx, users.Identity{UserID: uid, Provider: p.Name, Subject: claims.Subject, Email: claims.Email})
+	switch err {
+	case nil:
+	case users.ErrIdentityLinked:
+		if _, err := users.GetByIdentity(tx, p.Name, claims.Subject); err == nil {
+			return apperr.New(apperr.IdentityLinked, "provider account is linked to another user")
+		}
+		return apperr.Newf(apperr.IdentityLinked, "a %s account is already linked", p.Name)
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to link identity")
+	}
+	if err := recordProfileEvent(tx, c, uid, uid, "identity.link", "identity", nil, strPtr(p.Name)); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to record history")
+	}
+	return nil
+}
+
+// StartIdentityLink starts linking a provider to the current user. It answers with
+// the URL to open rather than redirecting, since it is called with a bearer token;
+// the sign-in must finish in the same browser, which keeps its state in a cookie.
+func StartIdentityLink(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	p, err := oidcProvider(c.Params("provider"))
+	if err != nil {
+		return err
+	}
+	redirect, err := oidcRedirect(c)
+	if err != nil {
+		return err
+	}
+	authURL, err := startOIDCLogin(c, p, uid, redirect, "")
+	if err != nil {
+		return err
+	}
+	return c.JSON(AuthorizationURL{AuthorizationURL: authURL})
+}
+
+// loadIdentities returns the identities linked to user uid.
+func loadIdentities(uid int) ([]LinkedIdentity, error) {
+	ids, err := users.Identities(db.DB, uid)
+	if err != nil {
+		return nil, err
+	}
+	list := []LinkedIdentity{}
+	for _, id := range ids {
+		list = append(list, LinkedIdentity{Provider: id.Provider, Email: id.Email, CreatedAt: id.CreatedAt.Unix()})
+	}
+	return list, nil
+}
+
+// GetIdentities lists the providers linked to the current user.
+func GetIdentities(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	list, err := loadIdentities(uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch identities")
+	}
+	return c.JSON(IdentityList{Identities: list})
+}
+
+// UnlinkIdentity removes a provider from the current user. The last identity of an
+// account without a password stays, or its owner could no longer sign in.
+func UnlinkIdentity(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	provider := c.Params("provider")
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to unlink identity")
+	}
+	defer tx.Rollback()
+	user, err := users.Get(tx, uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch user")
+	}
+	ids, err := users.Identities(tx, uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to unlink identity")
+	}
+	if !user.HasPassword() && len(ids) == 1 && ids[0].Provider == provider {
+		return apperr.New(apperr.LastLoginMethod, "set a password before unlinking the last one")
+	}
+	switch err := users.UnlinkIdentity(tx, uid, provider); err {
+	case nil:
+	case users.ErrNotFound:
+		return apperr.New(apperr.NotFound, "identity not linked")
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to unlink identity")
+	}
+	if err := recordProfileEvent(tx, c, uid, uid, "identity.unlink", "identity", strPtr(provider), nil); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to record history")
+	}
+	if err := tx.Commit(); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to unlink identity")
+	}
+	// SendStatus would write the status text as a body
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"time"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PasswordChange is the body of PUT /profile/password.
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password" doc:"required unless the account has no password yet, which needs a recent sign-in instead"`
+	NewPassword     string `json:"new_password" openapi:"required,minLength=8"`
+}
+
+// PasswordChangeResponse is the body of a successful password change. Token replaces
+// the tokens revoked by the change.
+type PasswordChangeResponse struct {
+	Message string `json:"message"`
+	Token   string `json:"token"`
+}
+
+// ChangePassword replaces the current user's password after checking the current
+// one, or sets the first password of an account without one after a recent sign-in
+// (see checkReauthentication). Tokens issued before the change are revoked; a fresh token is returned
+// so the calling session stays signed in, and a security notice is emailed to the
+// user.
+func ChangePassword(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+
+	var req PasswordChange
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	if req.NewPassword == "" {
+		return apperr.New(apperr.InvalidRequest, "new_password required")
+	}
+
+	user, err := users.Get(db.DB, uid)
+	switch err {
+	case users.ErrNotFound:
+		return apperr.New(apperr.NotFound, "user not found")
+	case nil:
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch user")
+	}
+	if user.HasPassword() {
+		if req.CurrentPassword == "" {
+			return apperr.New(apperr.InvalidRequest, "current_password required")
+		}
+		if !user.PasswordMatches(req.CurrentPassword) {
+			return apperr.New(apperr.InvalidCredentials, "invalid current password")
+		}
+	} else if err := checkReauthentication(c, user, ""); err != nil {
+		return err
+	}
+	email := user.Email
+	if err := users.CheckPasswordPolicy(req.NewPassword, email); err != nil {
+		return err
+	}
+	if req.NewPassword == req.CurrentPassword {
+		return apperr.New(apperr.InvalidRequest, "new password must differ from the current one")
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update password")
+	}
+	defer tx.Rollback()
+	changedAt, err := users.SetPassword(tx, uid, req.NewPassword)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update password")
+	}
+	// only the fact of the change is recorded, never the hashes
+	if err := recordProfileEvent(tx, c, uid, uid, "password.change", "password", nil, nil); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to record history")
+	}
+	if err := tx.Commit(); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update password")
+	}
+
+	// always sent regardless of notification settings, like the email change notice:
+	// it is the owner's chance to notice an account takeover
+	prefs, err := loadPreferences(uid)
+	if err != nil {
+		prefs = defaultPreferences()
+	}
+	sendUserMail(email, prefs, "password_changed", map[string]interface{}{
+		"Time": changedAt,
+		"IP":   c.IP(),
+	})
+
+	// tokens from the millisecond of the change are revoked with the older ones
+	time.Sleep(time.Until(changedAt.Add(time.Millisecond)))
+	signed, err := issueToken(uid, email)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to sign token")
+	}
+	return c.JSON(PasswordChangeResponse{Message: "password changed", Token: signed})
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/sms"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SMS delivers phone verification codes. main replaces it with the configured provider.
+var SMS sms.Sender = sms.LogSender{}
+
+const (
+	phoneCodeTTL         = 10 * time.Minute
+	phoneCodeResendAfter = time.Minute
+	phoneCodeMaxAttempts = 5
+)
+
+// PhoneVerificationConfirm is the body of POST /profile/phone/verification/confirm.
+type PhoneVerificationConfirm struct {
+	Code string `json:"code" openapi:"required"`
+}
+
+// PhoneVerificationStarted is the body of a successful POST /profile/phone/verification.
+type PhoneVerificationStarted struct {
+	Message   string `json:"message"`
+	ExpiresIn int    `json:"expires_in" doc:"seconds until the code expires"`
+}
+
+// randomDigits returns n cryptographically random decimal digits.
+func randomDigits(n int) (string, error) {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(byte('0' + d.Int64()))
+	}
+	return b.String(), nil
+}
+
+// hashPhoneCode binds a verification code to the user and number it was issued for.
+func hashPhoneCode(uid int, phone, code string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s:%s", uid, phone, code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartPhoneVerification sends a one-time code by SMS to the user's current phone number.
+func StartPhoneVerification(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+
+	var phone sql.NullString
+	var verifiedAt sql.NullInt64
+	switch err := db.DB.QueryRow("SELECT phone, phone_verified_at FROM users WHERE id = ?", uid).Scan(&phone, &verifiedAt); err {
+	case sql.ErrNoRows:
+		return apperr.New(apperr.NotFound, "user not found")
+	case nil:
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch user")
+	}
+	if phone.String == "" {
+		return apperr.New(apperr.PhoneMissing, "no phone number on profile")
+	}
+	if verifiedAt.Valid {
+		return apperr.New(apperr.PhoneAlreadyVerified, "phone already verified")
+	}
+
+	now := time.Now()
+	var createdAt int64
+	err := db.DB.QueryRow("SELECT created_at FROM phone_verifications WHERE user_id = ? AND phone = ?", uid, phone.String).Scan(&createdAt)
+	if err == nil && now.Sub(time.Unix(createdAt, 0)) < phoneCodeResendAfter {
+		return apperr.New(apperr.RateLimited, "verification code recently sent, try again later")
+	}
+
+	code, err := randomDigits(6)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to generate code")
+	}
+	_, err = db.DB.Exec(`INSERT INTO phone_verifications (user_id, phone, code_hash, attempts, created_at, expires_at)
+		VALUES (?, ?, ?, 0, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET phone = excluded.phone, code_hash = excluded.code_hash,
+			attempts = 0, created_at = excluded.created_at, expires_at = excluded.expires_at`,
+		uid, phone.String, hashPhoneCode(uid, phone.String, code), now.Unix(), now.Add(phoneCodeTTL).Unix())
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to store verification code")
+	}
+
+	msg := fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, int(phoneCodeTTL.Minutes()))
+	if err := SMS.Send(phone.String, msg); err != nil {
+		return apperr.New(apperr.DeliveryFailed, "failed to send verification code")
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(PhoneVerificationStarted{
+		Message:   "verification code sent",
+		ExpiresIn: int(phoneCodeTTL.Seconds()),
+	})
+}
+
+// ConfirmPhoneVerification checks the code sent by StartPhoneVerification and marks the phone verified.
+func ConfirmPhoneVerification(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+
+	var req PhoneVerificationConfirm
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	code := strings.TrimSpace(req.Code)
+	if code == "" {
+		return apperr.New(apperr.InvalidRequest, "code required")
+	}
+
+	var phone, codeHash string
+	var attempts int
+	var expiresAt int64
+	row := db.DB.QueryRow(`SELECT v.phone, v.code_hash, v.attempts, v.expires_at
+		FROM phone_verifications v JOIN users u ON u.id = v.user_id
+		WHERE v.user_id = ? AND u.phone = v.phone`, uid)
+	switch err := row.Scan(&phone, &codeHash, &attempts, &expiresAt); err {
+	case sql.ErrNoRows:
+		return apperr.New(apperr.VerificationNotPending, "no pending verification for current phone")
+	case nil:
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch verification")
+	}
+	if time.Now().Unix() > expiresAt || attempts >= phoneCodeMaxAttempts {
+		db.DB.Exec("DELETE FROM phone_verifications WHERE user_id = ?", uid)
+		return apperr.New(apperr.VerificationExpired, "verification code expired, request a new one")
+	}
+	if subtle.ConstantTimeCompare([]byte(hashPhoneCode(uid, phone, code)), []byte(codeHash)) != 1 {
+		db.DB.Exec("UPDATE phone_verifications SET attempts = attempts + 1 WHERE user_id = ?", uid)
+		return apperr.New(apperr.InvalidVerificationCode, "invalid verification code")
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to verify phone")
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE users SET phone_verified_at = ?, version = version + 1 WHERE id = ? AND phone = ?", time.Now().Unix(), uid, phone)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to verify phone")
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if err := recordProfileEvent(tx, c, uid, uid, "phone.verify", "phone_verified", nil, &phone); err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to record history")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to verify phone")
+	}
+	db.DB.Exec("DELETE FROM phone_verifications WHERE user_id = ?", uid)
+
+	return GetProfile(c)
+}
@@ -0,0 +1,122 @@
+package handlers_test
+
+import (
+	"regexp"
+	"testing"
+
+	"fiber-rest-api/internal/handlers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var smsCode = regexp.MustCompile(`code is (\d{6})`)
+
+// lastCode returns the code of the newest SMS sent to the number.
+func lastCode(t *testing.T, to string) string {
+	t.Helper()
+	msgs := messages.Messages()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].To == to {
+			if m := smsCode.FindStringSubmatch(msgs[i].Body); m != nil {
+				return m[1]
+			}
+		}
+	}
+	t.Fatalf("no code sent to %s", to)
+	return ""
+}
+
+func TestProfilePhoneNormalized(t *testing.T) {
+	token := signUp(t, "phone-normalized@example.com")
+
+	var p handlers.Profile
+	expect(t, request{method: "PATCH", path: "/api/v1/profile", token: token, body: map[string]string{"phone": "081-234 5678"}}, fiber.StatusOK, &p)
+	if p.Phone != "+66812345678" || p.PhoneDisplay != "081-234 5678" {
+		t.Errorf("phone %q, display %q; want +66812345678, 081-234 5678", p.Phone, p.PhoneDisplay)
+	}
+	expect(t, request{method: "PUT", path: "/api/v1/profile", token: token, body: map[string]string{"phone": "+66 81 234 5678"}}, fiber.StatusOK, &p)
+	if p.Phone != "+66812345678" || p.PhoneDisplay != "+66 81 234 5678" {
+		t.Errorf("phone %q, display %q; want +66812345678, +66 81 234 5678", p.Phone, p.PhoneDisplay)
+	}
+
+	for _, bad := range []string{"081-234-567x", "0812"} {
+		resp, body := do(t, request{method: "PATCH", path: "/api/v1/profile", token: token, body: map[string]string{"phone": bad}})
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("phone %q: status %d, want 400: %s", bad, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestPhoneVerification(t *testing.T) {
+	token := signUp(t, "phone-verification@example.com")
+	start := request{method: "POST", path: "/api/v1/profile/phone/verification", token: token}
+
+	_, body := do(t, start)
+	if code := problemCode(body); code != "phone_missing" {
+		t.Errorf("without a phone: code %q, want phone_missing", code)
+	}
+
+	expect(t, request{method: "PATCH", path: "/api/v1/profile", token: token, body: map[string]string{"phone": "089 876 5432"}}, fiber.StatusOK, nil)
+	var started handlers.PhoneVerificationStarted
+	expect(t, start, fiber.StatusAccepted, &started)
+	if started.ExpiresIn <= 0 {
+		t.Errorf("expires_in %d", started.ExpiresIn)
+	}
+	// the code goes to the normalized number
+	code := lastCode(t, "+66898765432")
+	expect(t, start, fiber.StatusTooManyRequests, nil)
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	confirm := func(code string) request {
+		return request{method: "POST", path: "/api/v1/profile/phone/verification/confirm", token: token, body: handlers.PhoneVerificationConfirm{Code: code}}
+	}
+	_, body = do(t, confirm(wrong))
+	if c := problemCode(body); c != "invalid_verification_code" {
+		t.Errorf("wrong code: code %q, want invalid_verification_code", c)
+	}
+
+	var p handlers.Profile
+	expect(t, confirm(code), fiber.StatusOK, &p)
+	if !p.PhoneVerified {
+		t.Error("phone not verified after the right code")
+	}
+	_, body = do(t, start)
+	if c := problemCode(body); c != "phone_already_verified" {
+		t.Errorf("verified phone: code %q, want phone_already_verified", c)
+	}
+
+	// the same number written differently stays verified, another one does not
+	expect(t, request{method: "PATCH", path: "/api/v1/profile", token: token, body: map[string]string{"phone": "+66898765432"}}, fiber.StatusOK, &p)
+	if !p.PhoneVerified {
+		t.Error("verification lost when the same number was entered again")
+	}
+	expect(t, request{method: "PATCH", path: "/api/v1/profile", token: token, body: map[string]string{"phone": "0898765433"}}, fiber.StatusOK, &p)
+	if p.PhoneVerified {
+		t.Error("new number is verified")
+	}
+}
+
+func TestPhoneVerificationAttempts(t *testing.T) {
+	token := signUp(t, "phone-attempts@example.com")
+	expect(t, request{method: "PATCH", path: "/api/v1/profile", token: token, body: map[string]string{"phone": "0811111111"}}, fiber.StatusOK, nil)
+	expect(t, request{method: "POST", path: "/api/v1/profile/phone/verification", token: token}, fiber.StatusAccepted, nil)
+	code := lastCode(t, "+66811111111")
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	confirm := func(code string) request {
+		return request{method: "POST", path: "/api/v1/profile/phone/verification/confirm", token: token, body: handlers.PhoneVerificationConfirm{Code: code}}
+	}
+	for i := 0; i < 5; i++ {
+		expect(t, confirm(wrong), fiber.StatusBadRequest, nil)
+	}
+	// once the attempts are used up even the right code is refused
+	_, body := do(t, confirm(code))
+	if c := problemCode(body); c != "verification_expired" {
+		t.Errorf("after 5 wrong codes: code %q, want verification_expired", c)
+	}
+}
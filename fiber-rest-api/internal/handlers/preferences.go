@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NotificationSettings holds the user's notification opt-ins. Transactional messages
+// (verification codes, confirmation links) and alerts of a possible account takeover
+// (password and email address changes, account closure) are always sent.
+type NotificationSettings struct {
+	SecurityEmail bool `json:"security_email" openapi:"default=true"` // security mail other than the alerts above, see optionalMail
+}
+
+// Preferences is the body of GET/PUT /profile/preferences.
+type Preferences struct {
+	Locale        string               `json:"locale" doc:"BCP 47 language tag" openapi:"default=th,example=th"`
+	Timezone      string               `json:"timezone" doc:"IANA time zone" openapi:"default=Asia/Bangkok,example=Asia/Bangkok"`
+	DateFormat    string               `json:"date_format" openapi:"enum=DD/MM/YYYY|MM/DD/YYYY|YYYY-MM-DD,default=DD/MM/YYYY"`
+	Notifications NotificationSettings `json:"notifications"`
+}
+
+// dateFormats maps the supported date_format values to Go layouts.
+var dateFormats = map[string]string{
+	"DD/MM/YYYY": "02/01/2006",
+	"MM/DD/YYYY": "01/02/2006",
+	"YYYY-MM-DD": "2006-01-02",
+}
+
+// bcp47Re accepts language[-script][-region][-variant...] tags such as "th", "en-US" or "zh-Hant-TW".
+var bcp47Re = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z]{4})?(-([a-zA-Z]{2}|[0-9]{3}))?(-([a-zA-Z0-9]{5,8}|[0-9][a-zA-Z0-9]{3}))*$`)
+
+func defaultPreferences() Preferences {
+	return Preferences{
+		Locale:        "th",
+		Timezone:      "Asia/Bangkok",
+		DateFormat:    "DD/MM/YYYY",
+		Notifications: NotificationSettings{SecurityEmail: true},
+	}
+}
+
+// validate checks the preferences and returns a client-facing message, "" if valid.
+func (p Preferences) validate() string {
+	if !bcp47Re.MatchString(p.Locale) {
+		return "locale must be a BCP 47 language tag, e.g. th or en-US"
+	}
+	if p.Timezone == "" || p.Timezone == "Local" {
+		return "timezone must be an IANA time zone, e.g. Asia/Bangkok"
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return "timezone must be an IANA time zone, e.g. Asia/Bangkok"
+	}
+	if _, ok := dateFormats[p.DateFormat]; !ok {
+		return "date_format must be one of DD/MM/YYYY, MM/DD/YYYY, YYYY-MM-DD"
+	}
+	return ""
+}
+
+// language returns the UI/email language for the preferences: "th" or "en".
+func (p Preferences) language() string {
+	if strings.HasPrefix(strings.ToLower(p.Locale), "th") {
+		return "th"
+	}
+	return "en"
+}
+
+// formatTime renders t in the user's time zone and date format.
+func (p Preferences) formatTime(t time.Time) string {
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	layout, ok := dateFormats[p.DateFormat]
+	if !ok {
+		layout = dateFormats[defaultPreferences().DateFormat]
+	}
+	return t.In(loc).Format(layout + " 15:04 MST")
+}
+
+// loadPreferences returns the stored preferences of a user, or the defaults.
+func loadPreferences(uid int) (Preferences, error) {
+	p := defaultPreferences()
+	row := db.DB.QueryRow(`SELECT locale, timezone, date_format, notify_security_email
+		FROM user_preferences WHERE user_id = ?`, uid)
+	err := row.Scan(&p.Locale, &p.Timezone, &p.DateFormat, &p.Notifications.SecurityEmail)
+	if err == sql.ErrNoRows {
+		return defaultPreferences(), nil
+	}
+	return p, err
+}
+
+// GetPreferences returns the current user's preferences.
+func GetPreferences(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	p, err := loadPreferences(uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch preferences")
+	}
+	return c.JSON(p)
+}
+
+// UpdatePreferences replaces the current user's preferences. Fields missing from the
+// body are reset to their defaults.
+func UpdatePreferences(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+
+	p := defaultPreferences()
+	if err := c.BodyParser(&p); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	p.Locale = strings.TrimSpace(p.Locale)
+	p.Timezone = strings.TrimSpace(p.Timezone)
+	if msg := p.validate(); msg != "" {
+		return apperr.New(apperr.InvalidRequest, msg)
+	}
+
+	_, err := db.DB.Exec(`INSERT INTO user_preferences (user_id, locale, timezone, date_format, notify_security_email, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET locale = excluded.locale, timezone = excluded.timezone, date_format = excluded.date_format,
+			notify_security_email = excluded.notify_security_email, updated_at = excluded.updated_at`,
+		uid, p.Locale, p.Timezone, p.DateFormat, p.Notifications.SecurityEmail, time.Now().Unix())
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update preferences")
+	}
+	return c.JSON(p)
+}
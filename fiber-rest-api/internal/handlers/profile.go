@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/phone"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// patchableProfileFields lists the profile columns PatchProfile may touch, in the
+// order they are written to the UPDATE statement.
+var patchableProfileFields = []string{"first_name", "last_name", "phone"}
+
+// attributesField is the PatchProfile member holding a merge patch of custom attributes.
+const attributesField = "attributes"
+
+// currentUserID returns the user id stored in locals by AuthRequired.
+func currentUserID(c *fiber.Ctx) (int, bool) {
+	uid, ok := c.Locals("user_id").(int)
+	return uid, ok
+}
+
+// profileETag formats a users.version value as a strong entity tag.
+func profileETag(version int) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+// ifMatchVersions reads the If-Match header of a profile write: the write goes ahead
+// if the profile is at any of the versions it lists (RFC 9110). conditional is false
+// when the header is absent (or "*"), in which case the write is unconditional. A
+// header that names no valid profile version can never match, so it is reported as
+// version 0. If-Match uses the strong comparison, so weak tags never match either.
+// ok is false when PROFILE_REQUIRE_IF_MATCH is enabled and the header is missing;
+// callers should then respond with preconditionRequired.
+func ifMatchVersions(c *fiber.Ctx) (versions []int, conditional bool, ok bool) {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if header == "" {
+		return nil, false, os.Getenv("PROFILE_REQUIRE_IF_MATCH") != "true"
+	}
+	if header == "*" {
+		return nil, false, true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, `"v`) || !strings.HasSuffix(tag, `"`) {
+			continue
+		}
+		if v, err := strconv.Atoi(tag[2 : len(tag)-1]); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		versions = []int{0}
+	}
+	return versions, true, true
+}
+
+// versionCondition returns the WHERE condition, and its arguments, that limits a
+// profile write to the versions read by ifMatchVersions.
+func versionCondition(versions []int) (string, []interface{}) {
+	args := make([]interface{}, len(versions))
+	for i, v := range versions {
+		args[i] = v
+	}
+	return " AND version IN (?" + strings.Repeat(", ?", len(versions)-1) + ")", args
+}
+
+func preconditionRequired(c *fiber.Ctx) error {
+	return apperr.New(apperr.PreconditionRequired, "If-Match header required")
+}
+
+// preconditionFailed answers 412 with the current representation (and its ETag)
+// so the client can merge its edits and retry.
+func preconditionFailed(c *fiber.Ctx, uid int, admin bool) error {
+	c.Status(fiber.StatusPreconditionFailed)
+	return writeProfile(c, uid, admin)
+}
+
+// validateProfileField checks a single profile field value and returns a
+// client-facing error message, or "" when the value is acceptable.
+func validateProfileField(field, value string) string {
+	switch field {
+	case "first_name", "last_name":
+		if len(value) > 100 {
+			return field + " too long (max 100)"
+		}
+	case "phone":
+		if len(value) > 20 {
+			return "phone too long (max 20)"
+		}
+		if _, msg := normalizeProfilePhone(value); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// normalizeProfilePhone converts a user-entered phone number to E.164. An empty
+// value is allowed and stays empty. msg is a client-facing error, "" on success.
+func normalizeProfilePhone(value string) (normalized string, msg string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", ""
+	}
+	normalized, err := phone.Normalize(value, phone.Region())
+	switch err {
+	case nil:
+		return normalized, ""
+	case phone.ErrInvalidChars:
+		return "", "phone contains invalid characters"
+	default:
+		return "", "phone is not a valid phone number"
+	}
+}
+
+// PatchProfile applies a JSON Merge Patch (RFC 7396) to the current user's profile.
+// Omitted fields are left untouched and an explicit null clears the field.
+func PatchProfile(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	return patchProfile(c, uid, uid, false)
+}
+
+// patchProfile applies a merge patch to the profile of user uid on behalf of actorID,
+// recording the changes in the profile history. admin allows admin-only attributes to
+// be edited and shown.
+func patchProfile(c *fiber.Ctx, uid, actorID int, admin bool) error {
+	ct := strings.ToLower(strings.TrimSpace(strings.SplitN(c.Get(fiber.HeaderContentType), ";", 2)[0]))
+	if ct != "application/merge-patch+json" && ct != fiber.MIMEApplicationJSON {
+		return apperr.New(apperr.UnsupportedMediaType, "content type must be application/merge-patch+json")
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &patch); err != nil || patch == nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+
+	allowed := make(map[string]bool, len(patchableProfileFields))
+	for _, f := range patchableProfileFields {
+		allowed[f] = true
+	}
+	for field := range patch {
+		if !allowed[field] && field != attributesField {
+			return apperr.Newf(apperr.InvalidRequest, "unknown field: %s", field)
+		}
+	}
+
+	var sets []string
+	var args []interface{}
+	for _, field := range patchableProfileFields {
+		raw, present := patch[field]
+		if !present {
+			continue
+		}
+		if string(raw) == "null" {
+			sets = append(sets, field+" = NULL")
+			if field == "phone" {
+				sets = append(sets, "phone_display = NULL", "phone_verified_at = NULL")
+			}
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return apperr.Newf(apperr.InvalidRequest, "%s must be a string or null", field)
+		}
+		if msg := validateProfileField(field, value); msg != "" {
+			return apperr.New(apperr.InvalidRequest, msg)
+		}
+		if field == "phone" {
+			// store E.164 for lookups plus the form the user typed for display;
+			// verification is kept only while the normalized number stays the same
+			normalized, _ := normalizeProfilePhone(value)
+			sets = append(sets, "phone = ?", "phone_display = ?", "phone_verified_at = CASE WHEN phone IS ? THEN phone_verified_at ELSE NULL END")
+			args = append(args, normalized, strings.TrimSpace(value), normalized)
+			continue
+		}
+		sets = append(sets, field+" = ?")
+		args = append(args, strings.TrimSpace(value))
+	}
+
+	versions, conditional, ok := ifMatchVersions(c)
+	if !ok {
+		return preconditionRequired(c)
+	}
+
+	// "attributes": null removes every attribute the caller may edit, like null
+	// clears any other member
+	var attributes map[string]json.RawMessage
+	raw, clearAttributes := patch[attributesField]
+	if clearAttributes && string(raw) != "null" {
+		clearAttributes = false
+		if err := json.Unmarshal(raw, &attributes); err != nil {
+			return apperr.New(apperr.InvalidRequest, "attributes must be an object")
+		}
+	}
+
+	if len(sets) > 0 || len(attributes) > 0 || clearAttributes {
+		tx, err := db.DB.Begin()
+		if err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to update profile")
+		}
+		defer tx.Rollback()
+
+		before, err := snapshotProfile(tx, uid)
+		if err == sql.ErrNoRows {
+			return apperr.New(apperr.NotFound, "user not found")
+		}
+		if err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to update profile")
+		}
+
+		sets = append(sets, "version = version + 1")
+		query := "UPDATE users SET " + strings.Join(sets, ", ") + " WHERE id = ?"
+		args = append(args, uid)
+		if conditional {
+			cond, versionArgs := versionCondition(versions)
+			query += cond
+			args = append(args, versionArgs...)
+		}
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to update profile")
+		}
+		if n, _ := res.RowsAffected(); n == 0 && conditional {
+			tx.Rollback()
+			return preconditionFailed(c, uid, admin)
+		}
+		if len(attributes) > 0 || clearAttributes {
+			msg, err := applyAttributeChanges(tx, uid, admin, attributes, clearAttributes)
+			if err != nil {
+				return apperr.Wrap(err, apperr.Internal, "failed to update attributes")
+			}
+			if msg != "" {
+				return apperr.New(apperr.InvalidRequest, msg)
+			}
+		}
+		after, err := snapshotProfile(tx, uid)
+		if err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to update profile")
+		}
+		action := "profile.patch"
+		if actorID != uid {
+			action = "admin.patch"
+		}
+		if err := recordProfileChanges(tx, c, uid, actorID, action, before, after); err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to record history")
+		}
+		if err := tx.Commit(); err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to update profile")
+		}
+	} else if conditional {
+		// an empty patch still has to respect the precondition
+		cond, args := versionCondition(versions)
+		var matched int
+		err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?"+cond, append([]interface{}{uid}, args...)...).Scan(&matched)
+		if err == nil && matched == 0 {
+			return preconditionFailed(c, uid, admin)
+		}
+	}
+
+	return writeProfile(c, uid, admin)
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/profileattr"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// isAdmin reports whether the user has the admin role.
+func isAdmin(uid int) (bool, error) {
+	user, err := users.Get(db.DB, uid)
+	if err != nil {
+		if err == users.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return user.Role == users.RoleAdmin, nil
+}
+
+// AdminRequired is middleware, used after AuthRequired, that only lets admins through.
+func AdminRequired(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	admin, err := isAdmin(uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to query user")
+	}
+	if !admin {
+		return apperr.New(apperr.Forbidden, "admin role required")
+	}
+	return c.Next()
+}
+
+// profileAttributeValues returns the attribute values of a user that the viewer may see.
+func profileAttributeValues(uid int, admin bool) (map[string]json.RawMessage, error) {
+	defs, err := profileattr.LoadAll(db.DB)
+	if err != nil {
+		return nil, err
+	}
+	values, err := profileattr.LoadValues(db.DB, uid)
+	if err != nil {
+		return nil, err
+	}
+	visible := map[string]json.RawMessage{}
+	for _, d := range defs {
+		if v, ok := values[d.Key]; ok && d.VisibleTo(admin) {
+			visible[d.Key] = v
+		}
+	}
+	return visible, nil
+}
+
+// applyAttributeChanges validates and stores attribute values inside tx. A null value
+// removes the attribute. With replace set, editable attributes missing from changes are
+// removed as well (PUT semantics). msg is a client-facing validation error.
+func applyAttributeChanges(tx *sql.Tx, uid int, admin bool, changes map[string]json.RawMessage, replace bool) (msg string, err error) {
+	defs, err := profileattr.LoadAll(tx)
+	if err != nil {
+		return "", err
+	}
+	byKey := make(map[string]profileattr.Definition, len(defs))
+	for _, d := range defs {
+		if d.VisibleTo(admin) {
+			byKey[d.Key] = d
+		}
+	}
+	for key := range changes {
+		if _, ok := byKey[key]; !ok {
+			return "unknown attribute: " + key, nil
+		}
+	}
+
+	for _, d := range byKey {
+		raw, present := changes[d.Key]
+		if !present && !replace {
+			continue
+		}
+		if !present || string(raw) == "null" {
+			if d.Required {
+				return d.Key + " is required", nil
+			}
+			if _, err := tx.Exec("DELETE FROM profile_attribute_values WHERE user_id = ? AND key = ?", uid, d.Key); err != nil {
+				return "", err
+			}
+			continue
+		}
+		value, verr := d.Validate(raw)
+		if verr != nil {
+			return verr.Error(), nil
+		}
+		_, err := tx.Exec(`INSERT INTO profile_attribute_values (user_id, key, value) VALUES (?, ?, ?)
+			ON CONFLICT(user_id, key) DO UPDATE SET value = excluded.value`, uid, d.Key, value)
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// ListProfileAttributes returns every custom profile attribute definition.
+func ListProfileAttributes(c *fiber.Ctx) error {
+	defs, err := profileattr.LoadAll(db.DB)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch attributes")
+	}
+	if defs == nil {
+		defs = []profileattr.Definition{}
+	}
+	return c.JSON(defs)
+}
+
+// CreateProfileAttribute defines a new custom profile attribute.
+func CreateProfileAttribute(c *fiber.Ctx) error {
+	var d profileattr.Definition
+	if err := c.BodyParser(&d); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	if err := d.Check(); err != nil {
+		return apperr.New(apperr.InvalidRequest, err.Error())
+	}
+	_, err := db.DB.Exec(`INSERT INTO profile_attributes (key, label, type, required, min, max, pattern, options, visibility, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.Key, d.Label, d.Type, d.Required, d.Min, d.Max, d.Pattern, profileattr.NullableOptions(d.Options), d.Visibility, time.Now().Unix())
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return apperr.New(apperr.AttributeExists, "attribute already exists")
+		}
+		return apperr.New(apperr.Internal, "failed to create attribute")
+	}
+	return c.Status(fiber.StatusCreated).JSON(d)
+}
+
+// UpdateProfileAttribute replaces the definition of an attribute. The key cannot change.
+// Stored values are kept; they are re-validated the next time they are written.
+func UpdateProfileAttribute(c *fiber.Ctx) error {
+	var d profileattr.Definition
+	if err := c.BodyParser(&d); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	d.Key = c.Params("key")
+	if err := d.Check(); err != nil {
+		return apperr.New(apperr.InvalidRequest, err.Error())
+	}
+	res, err := db.DB.Exec(`UPDATE profile_attributes SET label = ?, type = ?, required = ?, min = ?, max = ?, pattern = ?, options = ?, visibility = ?
+		WHERE key = ?`,
+		d.Label, d.Type, d.Required, d.Min, d.Max, d.Pattern, profileattr.NullableOptions(d.Options), d.Visibility, d.Key)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update attribute")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return apperr.New(apperr.NotFound, "attribute not found")
+	}
+	return c.JSON(d)
+}
+
+// DeleteProfileAttribute removes an attribute definition together with all stored
+// values. The removal of each value is recorded in the owner's profile history.
+func DeleteProfileAttribute(c *fiber.Ctx) error {
+	actorID, _ := currentUserID(c)
+	key := c.Params("key")
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to delete attribute")
+	}
+	defer tx.Rollback()
+
+	type removed struct {
+		uid   int
+		value string
+	}
+	rows, err := tx.Query("SELECT user_id, value FROM profile_attribute_values WHERE key = ?", key)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to delete attribute")
+	}
+	var values []removed
+	for rows.Next() {
+		var r removed
+		if err := rows.Scan(&r.uid, &r.value); err != nil {
+			rows.Close()
+			return apperr.Wrap(err, apperr.Internal, "failed to delete attribute")
+		}
+		values = append(values, r)
+	}
+	rows.Close()
+
+	// recorded before the definition goes away so admin-only values stay hidden
+	for _, r := range values {
+		if err := recordProfileEvent(tx, c, r.uid, actorID, "admin.attribute_delete", attributeFieldPrefix+key, strPtr(r.value), nil); err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to record history")
+		}
+	}
+	res, err := tx.Exec("DELETE FROM profile_attributes WHERE key = ?", key)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to delete attribute")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return apperr.New(apperr.NotFound, "attribute not found")
+	}
+	if err := tx.Commit(); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to delete attribute")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
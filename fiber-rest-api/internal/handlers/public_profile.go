@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/profileattr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Visibility values accepted by PUT /profile/visibility.
+const (
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+)
+
+// shareableFields are the built-in profile fields the owner can make public. Email and
+// phone are listed too but, like everything else, stay private unless shared explicitly.
+var shareableFields = []string{"first_name", "last_name", "avatar", "email", "phone"}
+
+// attributeFieldPrefix prefixes custom attribute keys in the profile_visibility table.
+const attributeFieldPrefix = "attributes."
+
+var usernameRe = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// reservedUsernames cannot be claimed because they collide with routes or could be
+// used to impersonate staff. Compared case-insensitively.
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "root": true, "system": true, "support": true,
+	"help": true, "security": true, "staff": true, "moderator": true, "api": true,
+	"auth": true, "login": true, "logout": true, "register": true, "profile": true,
+	"users": true, "user": true, "me": true, "docs": true, "uploads": true,
+	"settings": true, "null": true, "undefined": true, "anonymous": true, "www": true,
+}
+
+// UsernameUpdate is the body of PUT /profile/username.
+type UsernameUpdate struct {
+	Username string `json:"username" openapi:"required" pattern:"^[A-Za-z0-9_]{3,30}$"`
+}
+
+// VisibilitySettings is the body of GET/PUT /profile/visibility. Each value is
+// "public" or "private".
+type VisibilitySettings struct {
+	Fields     map[string]string `json:"fields" doc:"first_name, last_name, avatar, email or phone mapped to public or private"`
+	Attributes map[string]string `json:"attributes" doc:"custom attribute keys mapped to public or private"`
+}
+
+// PublicProfile is what other users see of a profile: the username plus the fields
+// the owner made public.
+type PublicProfile struct {
+	Username   string                     `json:"username" openapi:"required"`
+	FirstName  string                     `json:"first_name,omitempty"`
+	LastName   string                     `json:"last_name,omitempty"`
+	Avatar     string                     `json:"avatar,omitempty"`
+	Email      string                     `json:"email,omitempty"`
+	Phone      string                     `json:"phone,omitempty"`
+	Attributes map[string]json.RawMessage `json:"attributes,omitempty"`
+}
+
+// checkUsername returns a client-facing message if username cannot be used, "" otherwise.
+func checkUsername(username string) string {
+	if !usernameRe.MatchString(username) {
+		return "username must be 3-30 letters, digits or underscores"
+	}
+	if reservedUsernames[strings.ToLower(username)] {
+		return "username is reserved"
+	}
+	return ""
+}
+
+// UpdateUsername sets the current user's handle. Handles are unique ignoring case.
+func UpdateUsername(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	var req UsernameUpdate
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+	username := strings.TrimSpace(req.Username)
+	if msg := checkUsername(username); msg != "" {
+		return apperr.New(apperr.InvalidRequest, msg)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update username")
+	}
+	defer tx.Rollback()
+
+	var old sql.NullString
+	if err := tx.QueryRow("SELECT username FROM users WHERE id = ?", uid).Scan(&old); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update username")
+	}
+	if _, err := tx.Exec("UPDATE users SET username = ?, version = version + 1 WHERE id = ?", username, uid); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return apperr.New(apperr.UsernameTaken, "username already taken")
+		}
+		return apperr.New(apperr.Internal, "failed to update username")
+	}
+	if !old.Valid || old.String != username {
+		var oldValue *string
+		if old.Valid {
+			oldValue = &old.String
+		}
+		if err := recordProfileEvent(tx, c, uid, uid, "username.update", "username", oldValue, &username); err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to record history")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update username")
+	}
+	return GetProfile(c)
+}
+
+// publicFields returns the set of profile_visibility fields the user made public.
+func publicFields(uid int) (map[string]bool, error) {
+	rows, err := db.DB.Query("SELECT field FROM profile_visibility WHERE user_id = ?", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	public := map[string]bool{}
+	for rows.Next() {
+		var field string
+		if err := rows.Scan(&field); err != nil {
+			return nil, err
+		}
+		public[field] = true
+	}
+	return public, rows.Err()
+}
+
+// shareableAttributes returns the custom attributes that admins allow to be public.
+func shareableAttributes() ([]profileattr.Definition, error) {
+	defs, err := profileattr.LoadAll(db.DB)
+	if err != nil {
+		return nil, err
+	}
+	var out []profileattr.Definition
+	for _, d := range defs {
+		if d.Visibility == profileattr.VisibilityPublic {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// loadVisibility builds the complete visibility settings of a user.
+func loadVisibility(uid int) (VisibilitySettings, error) {
+	public, err := publicFields(uid)
+	if err != nil {
+		return VisibilitySettings{}, err
+	}
+	defs, err := shareableAttributes()
+	if err != nil {
+		return VisibilitySettings{}, err
+	}
+	vs := VisibilitySettings{Fields: map[string]string{}, Attributes: map[string]string{}}
+	for _, f := range shareableFields {
+		vs.Fields[f] = visibilityPrivate
+		if public[f] {
+			vs.Fields[f] = visibilityPublic
+		}
+	}
+	for _, d := range defs {
+		vs.Attributes[d.Key] = visibilityPrivate
+		if public[attributeFieldPrefix+d.Key] {
+			vs.Attributes[d.Key] = visibilityPublic
+		}
+	}
+	return vs, nil
+}
+
+// GetVisibility returns which profile fields the current user shares publicly.
+func GetVisibility(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	vs, err := loadVisibility(uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch visibility")
+	}
+	return c.JSON(vs)
+}
+
+// UpdateVisibility replaces the current user's visibility settings. Fields that are
+// not listed become private.
+func UpdateVisibility(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return apperr.New(apperr.Unauthenticated, "unauthorized")
+	}
+	var req VisibilitySettings
+	if err := c.BodyParser(&req); err != nil {
+		return apperr.New(apperr.MalformedBody, "invalid request body")
+	}
+
+	shareable := map[string]bool{}
+	for _, f := range shareableFields {
+		shareable[f] = true
+	}
+	defs, err := shareableAttributes()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch attributes")
+	}
+	shareableAttr := map[string]bool{}
+	for _, d := range defs {
+		shareableAttr[d.Key] = true
+	}
+
+	var public []string
+	for field, v := range req.Fields {
+		if !shareable[field] {
+			return apperr.Newf(apperr.InvalidRequest, "field cannot be shared: %s", field)
+		}
+		if v != visibilityPublic && v != visibilityPrivate {
+			return apperr.New(apperr.InvalidRequest, "visibility must be public or private")
+		}
+		if v == visibilityPublic {
+			public = append(public, field)
+		}
+	}
+	for key, v := range req.Attributes {
+		if !shareableAttr[key] {
+			return apperr.Newf(apperr.InvalidRequest, "attribute cannot be shared: %s", key)
+		}
+		if v != visibilityPublic && v != visibilityPrivate {
+			return apperr.New(apperr.InvalidRequest, "visibility must be public or private")
+		}
+		if v == visibilityPublic {
+			public = append(public, attributeFieldPrefix+key)
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update visibility")
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM profile_visibility WHERE user_id = ?", uid); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update visibility")
+	}
+	for _, field := range public {
+		if _, err := tx.Exec("INSERT INTO profile_visibility (user_id, field) VALUES (?, ?)", uid, field); err != nil {
+			return apperr.Wrap(err, apperr.Internal, "failed to update visibility")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to update visibility")
+	}
+	return GetVisibility(c)
+}
+
+// GetPublicProfile returns the fields a user has made public, looked up by username
+// (case-insensitive). Accounts pending deletion or disabled by an operator are not
+// found. It does not require authentication.
+func GetPublicProfile(c *fiber.Ctx) error {
+	var uid int
+	var username string
+	var email string
+	var firstName, lastName, phone, avatar sql.NullString
+	row := db.DB.QueryRow(`SELECT id, username, email, first_name, last_name, phone, avatar
+		FROM users WHERE username = ? COLLATE NOCASE AND delete_after IS NULL AND disabled_at IS NULL`, c.Params("username"))
+	switch err := row.Scan(&uid, &username, &email, &firstName, &lastName, &phone, &avatar); err {
+	case sql.ErrNoRows:
+		return apperr.New(apperr.NotFound, "user not found")
+	case nil:
+	default:
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch user")
+	}
+
+	public, err := publicFields(uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch visibility")
+	}
+
+	out := PublicProfile{Username: username}
+	values := map[string]string{
+		"first_name": firstName.String,
+		"last_name":  lastName.String,
+		"email":      email,
+		"phone":      phone.String,
+	}
+	if avatar.String != "" {
+		values["avatar"] = "/uploads/" + avatar.String
+	}
+	fields := map[string]*string{
+		"first_name": &out.FirstName,
+		"last_name":  &out.LastName,
+		"avatar":     &out.Avatar,
+		"email":      &out.Email,
+		"phone":      &out.Phone,
+	}
+	for _, f := range shareableFields {
+		if public[f] {
+			*fields[f] = values[f]
+		}
+	}
+
+	defs, err := shareableAttributes()
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch attributes")
+	}
+	stored, err := profileattr.LoadValues(db.DB, uid)
+	if err != nil {
+		return apperr.Wrap(err, apperr.Internal, "failed to fetch attributes")
+	}
+	attributes := map[string]json.RawMessage{}
+	for _, d := range defs {
+		if v, ok := stored[d.Key]; ok && public[attributeFieldPrefix+d.Key] {
+			attributes[d.Key] = v
+		}
+	}
+	if len(attributes) > 0 {
+		out.Attributes = attributes
+	}
+	return c.JSON(out)
+}
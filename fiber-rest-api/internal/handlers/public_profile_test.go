@@ -0,0 +1,33 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A disabled account has no public profile, like one pending deletion.
+func TestPublicProfileOfDisabledAccount(t *testing.T) {
+	const email = "public-disabled@example.com"
+	token := signUp(t, email)
+	expect(t, request{method: "PUT", path: "/api/v1/profile/username", token: token, body: handlers.UsernameUpdate{Username: "Public_Disabled"}}, fiber.StatusOK, nil)
+
+	var p handlers.PublicProfile
+	expect(t, request{method: "GET", path: "/api/v1/users/public_disabled"}, fiber.StatusOK, &p)
+	if p.Username != "Public_Disabled" {
+		t.Errorf("username %q", p.Username)
+	}
+
+	user, err := users.GetByEmail(db.DB, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.SetDisabled(db.DB, user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, request{method: "GET", path: "/api/v1/users/Public_Disabled"}, fiber.StatusNotFound, nil)
+}
@@ -5,7 +5,7 @@ import (
 )
 
 func GetRoot(c *fiber.Ctx) error {
-    return c.JSON(fiber.Map{
-        "message": "hello world",
+    return c.JSON(MessageResponse{
+        Message: "hello world",
     })
 }
\ No newline at end of file
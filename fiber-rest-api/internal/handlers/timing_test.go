@@ -0,0 +1,132 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"fiber-rest-api/internal/handlers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// timingRounds is the number of measured requests per kind of address.
+	timingRounds = 30
+	// timingTolerance is the largest accepted difference between the medians,
+	// relative to the smaller one.
+	timingTolerance = 0.25
+)
+
+// The auth endpoints must not answer noticeably faster or slower for registered
+// addresses than for unknown ones, which would let anyone find out who has an
+// account. Requests for both kinds are interleaved and their medians compared. Other
+// tests running at the same time skew the measurements, so it only runs with
+// TIMING_TESTS=1.
+func TestResponseTimesHideRegisteredAddresses(t *testing.T) {
+	if os.Getenv("TIMING_TESTS") != "1" || testing.Short() {
+		t.Skip("timing test: set TIMING_TESTS=1 to run it")
+	}
+	const registered = "timing-registered@example.com"
+	signUp(t, registered)
+
+	n := 0
+	newEmail := func() string {
+		n++
+		return fmt.Sprintf("timing-new%d@example.com", n)
+	}
+	checks := []struct {
+		name     string
+		uniform  bool
+		path     string
+		known    func() string
+		unknown  func() string
+		password string
+		// statuses expected for the known and the unknown address
+		knownStatus, unknownStatus int
+	}{
+		{"login", false, "/api/v1/auth/login", func() string { return registered }, func() string { return "timing-nobody@example.com" },
+			"wr0ng-password", fiber.StatusUnauthorized, fiber.StatusUnauthorized},
+		{"register", false, "/api/v1/auth/register", func() string { return registered }, newEmail,
+			password, fiber.StatusConflict, fiber.StatusCreated},
+		{"register uniform", true, "/api/v1/auth/register", func() string { return registered }, newEmail,
+			password, fiber.StatusAccepted, fiber.StatusAccepted},
+	}
+
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			if check.uniform {
+				os.Setenv("REGISTRATION_UNIFORM_RESPONSE", "true")
+				defer os.Unsetenv("REGISTRATION_UNIFORM_RESPONSE")
+			}
+			var known, unknown []time.Duration
+			var knownBody, unknownBody []byte
+			// a few unmeasured rounds first, so that one-time work such as the dummy
+			// hash of Login is not counted
+			for i := -3; i < timingRounds; i++ {
+				for _, k := range []struct {
+					email  func() string
+					status int
+					times  *[]time.Duration
+					body   *[]byte
+				}{{check.known, check.knownStatus, &known, &knownBody}, {check.unknown, check.unknownStatus, &unknown, &unknownBody}} {
+					// let background work of the previous request, such as
+					// registration mail, finish first
+					time.Sleep(5 * time.Millisecond)
+					email := k.email()
+					start := time.Now()
+					resp, body := do(t, request{method: "POST", path: check.path, body: handlers.AuthRequest{Email: email, Password: check.password}})
+					elapsed := time.Since(start)
+					if resp.StatusCode != k.status {
+						t.Fatalf("%s: status %d, want %d: %s", email, resp.StatusCode, k.status, body)
+					}
+					if i >= 0 {
+						*k.times = append(*k.times, elapsed)
+						*k.body = body
+					}
+				}
+			}
+
+			if check.uniform && !bytes.Equal(withoutRequestID(knownBody), withoutRequestID(unknownBody)) {
+				t.Errorf("bodies differ: %s / %s", knownBody, unknownBody)
+			}
+			a, b := median(known), median(unknown)
+			diff := float64(a-b) / float64(shorter(a, b))
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > timingTolerance {
+				t.Errorf("registered %v, unknown %v: more than %.0f%% apart", a, b, timingTolerance*100)
+			}
+		})
+	}
+}
+
+// withoutRequestID drops the request_id of problem documents, the one field that
+// differs between any two responses.
+func withoutRequestID(body []byte) []byte {
+	var doc map[string]interface{}
+	if json.Unmarshal(body, &doc) != nil {
+		return body
+	}
+	delete(doc, "request_id")
+	out, _ := json.Marshal(doc)
+	return out
+}
+
+func median(d []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), d...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+func shorter(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
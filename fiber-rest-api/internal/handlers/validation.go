@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/openapi"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RejectRequest answers a request that does not match its OpenAPI operation, listing
+// every invalid field.
+func RejectRequest(c *fiber.Ctx, status int, message string, fields []openapi.FieldError) error {
+	code := apperr.InvalidRequest
+	switch status {
+	case fiber.StatusUnsupportedMediaType:
+		code = apperr.UnsupportedMediaType
+	case fiber.StatusInternalServerError:
+		code = apperr.Internal
+	}
+	return apperr.New(code, message).WithFields(problemFields(fields)...)
+}
+
+// LogResponseMismatch logs a response that does not match its OpenAPI operation.
+func LogResponseMismatch(c *fiber.Ctx, fields []openapi.FieldError) error {
+	log.Printf("openapi: %s %s answered %d contrary to the spec: %s",
+		c.Method(), c.OriginalURL(), c.Response().StatusCode(), describeFields(fields))
+	return nil
+}
+
+// FailResponseMismatch replaces a response that does not match its OpenAPI operation
+// with a 500 describing the mismatch, so tests cannot miss it.
+func FailResponseMismatch(c *fiber.Ctx, fields []openapi.FieldError) error {
+	LogResponseMismatch(c, fields)
+	c.Response().ResetBody()
+	return apperr.New(apperr.Internal, "response does not match the OpenAPI spec").WithFields(problemFields(fields)...)
+}
+
+// problemFields converts validation errors, keeping their formats for translation.
+func problemFields(fields []openapi.FieldError) []apperr.FieldError {
+	out := make([]apperr.FieldError, len(fields))
+	for i, f := range fields {
+		format, args := f.Format()
+		out[i] = apperr.Field(f.In, f.Field, format, args...)
+	}
+	return out
+}
+
+func describeFields(fields []openapi.FieldError) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		if f.Field == "" {
+			parts[i] = f.Message
+		} else {
+			parts[i] = f.Field + " " + f.Message
+		}
+	}
+	return strings.Join(parts, "; ")
+}
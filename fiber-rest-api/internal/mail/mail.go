@@ -0,0 +1,101 @@
+// Package mail defines the interface used to send email and the providers
+// bundled with the server.
+package mail
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers email messages.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// LogSender writes messages to the standard logger instead of delivering them.
+// It is the default provider for local development.
+type LogSender struct{}
+
+func (LogSender) Send(msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// Fake records sent messages in memory so tests can inspect them.
+type Fake struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (f *Fake) Send(msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of every message sent so far.
+func (f *Fake) Messages() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Message(nil), f.messages...)
+}
+
+// SMTPSender delivers messages through an SMTP relay.
+type SMTPSender struct {
+	Addr string // host:port
+	From string
+	Auth smtp.Auth
+}
+
+func (s SMTPSender) Send(msg Message) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	// headers are ASCII; subjects in Thai are sent as RFC 2047 encoded-words
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(msg.Body)
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{msg.To}, []byte(b.String()))
+}
+
+// FromEnv returns the provider named by MAIL_PROVIDER ("log", "fake" or "smtp"),
+// defaulting to log. The smtp provider reads SMTP_ADDR, SMTP_FROM and optionally
+// SMTP_USERNAME/SMTP_PASSWORD.
+func FromEnv() (Sender, error) {
+	switch p := os.Getenv("MAIL_PROVIDER"); p {
+	case "", "log":
+		return LogSender{}, nil
+	case "fake":
+		return &Fake{}, nil
+	case "smtp":
+		addr, from := os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_FROM")
+		if addr == "" || from == "" {
+			return nil, fmt.Errorf("SMTP_ADDR and SMTP_FROM are required for the smtp mail provider")
+		}
+		s := SMTPSender{Addr: addr, From: from}
+		if user := os.Getenv("SMTP_USERNAME"); user != "" {
+			host := addr
+			if i := strings.LastIndex(addr, ":"); i >= 0 {
+				host = addr[:i]
+			}
+			s.Auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_PROVIDER %q", p)
+	}
+}
@@ -0,0 +1,214 @@
+// Package oauth is the repository behind the server's OpenID Connect provider,
+// shared by the HTTP handlers and cmd/admin: the registered client applications,
+// the consent users gave them, pending authorization codes and the keys tokens are
+// signed with.
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrClientNotFound = errors.New("client not found")
+	ErrInvalidCode    = errors.New("invalid or expired authorization code")
+)
+
+// Queryer is satisfied by *sql.DB and *sql.Tx.
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Client is an application registered to sign users in.
+type Client struct {
+	ID           string
+	Name         string
+	RedirectURIs []string
+	// Public clients, such as single-page or mobile apps, cannot keep a secret and
+	// must use PKCE instead.
+	Public bool
+	// Trusted clients, typically first-party apps, skip the consent screen.
+	Trusted   bool
+	CreatedAt time.Time
+
+	secretHash string
+}
+
+// NewClient holds the fields of a client to register.
+type NewClient struct {
+	Name         string
+	RedirectURIs []string
+	Public       bool
+	Trusted      bool
+}
+
+// CheckRedirectURI returns an error unless uri may be registered: an absolute https
+// URL without a fragment, or http on a loopback address for development.
+func CheckRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("redirect URI %q is not an absolute URL", uri)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect URI %q has a fragment", uri)
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("redirect URI %q must use https", uri)
+		}
+	default:
+		return fmt.Errorf("redirect URI %q must use https", uri)
+	}
+	return nil
+}
+
+// CreateClient registers a client and returns it with its secret, which is only
+// stored hashed: it cannot be shown again. Public clients get no secret.
+func CreateClient(q Queryer, nc NewClient) (*Client, string, error) {
+	if strings.TrimSpace(nc.Name) == "" {
+		return nil, "", errors.New("client name required")
+	}
+	if len(nc.RedirectURIs) == 0 {
+		return nil, "", errors.New("at least one redirect URI required")
+	}
+	for _, uri := range nc.RedirectURIs {
+		if err := CheckRedirectURI(uri); err != nil {
+			return nil, "", err
+		}
+	}
+	uris, err := json.Marshal(nc.RedirectURIs)
+	if err != nil {
+		return nil, "", err
+	}
+	c := &Client{
+		ID:           randomString(16),
+		Name:         strings.TrimSpace(nc.Name),
+		RedirectURIs: nc.RedirectURIs,
+		Public:       nc.Public,
+		Trusted:      nc.Trusted,
+		CreatedAt:    time.Unix(time.Now().Unix(), 0),
+	}
+	var secret string
+	var secretHash interface{}
+	if !nc.Public {
+		secret = randomString(32)
+		c.secretHash = hashSecret(secret)
+		secretHash = c.secretHash
+	}
+	_, err = q.Exec("INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, trusted, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		c.ID, c.Name, secretHash, string(uris), c.Trusted, c.CreatedAt.Unix())
+	if err != nil {
+		return nil, "", err
+	}
+	return c, secret, nil
+}
+
+const clientColumns = "id, name, secret_hash, redirect_uris, trusted, created_at"
+
+func scanClient(row interface{ Scan(...interface{}) error }) (*Client, error) {
+	var c Client
+	var secretHash sql.NullString
+	var uris string
+	var createdAt int64
+	if err := row.Scan(&c.ID, &c.Name, &secretHash, &uris, &c.Trusted, &createdAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(uris), &c.RedirectURIs); err != nil {
+		return nil, fmt.Errorf("client %s: redirect_uris: %w", c.ID, err)
+	}
+	c.secretHash, c.Public = secretHash.String, !secretHash.Valid
+	c.CreatedAt = time.Unix(createdAt, 0)
+	return &c, nil
+}
+
+// GetClient returns the client with the given id.
+func GetClient(q Queryer, id string) (*Client, error) {
+	c, err := scanClient(q.QueryRow("SELECT "+clientColumns+" FROM oauth_clients WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrClientNotFound
+	}
+	return c, err
+}
+
+// ListClients returns every client, oldest first.
+func ListClients(q Queryer) ([]Client, error) {
+	rows, err := q.Query("SELECT " + clientColumns + " FROM oauth_clients ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Client{}
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *c)
+	}
+	return list, rows.Err()
+}
+
+// DeleteClient removes a client with the consents and codes issued to it. Tokens it
+// holds stop working at the userinfo endpoint.
+func DeleteClient(q Queryer, id string) error {
+	res, err := q.Exec("DELETE FROM oauth_clients WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+// CheckSecret reports whether secret authenticates the client. Public clients have
+// no secret and must not send one.
+func (c *Client) CheckSecret(secret string) bool {
+	if c.Public {
+		return secret == ""
+	}
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(c.secretHash)) == 1
+}
+
+// AllowsRedirectURI reports whether uri is one of the registered redirect URIs.
+// They are compared as strings, as OAuth 2.0 Security Best Current Practice asks.
+func (c *Client) AllowsRedirectURI(uri string) bool {
+	for _, r := range c.RedirectURIs {
+		if r == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// hashSecret hashes client secrets and codes. They are long and random, so a fast
+// hash is enough.
+func hashSecret(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes, base64url-encoded.
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,93 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"time"
+)
+
+// CodeTTL is how long an authorization code can be redeemed.
+const CodeTTL = time.Minute
+
+// Grant is what an authorization code stands for: a user's sign-in at a client.
+type Grant struct {
+	ClientID    string
+	UserID      int
+	RedirectURI string
+	Scope       string
+	Nonce       string
+	// CodeChallenge and CodeChallengeMethod are the PKCE challenge the client sent
+	// to the authorization endpoint, if any.
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// AuthTime is when the user last entered their credentials.
+	AuthTime time.Time
+}
+
+// IssueCode stores g and returns the one-time code that redeems it.
+func IssueCode(q Queryer, g Grant) (string, error) {
+	code := randomString(32)
+	_, err := q.Exec(`INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scope, nonce,
+		code_challenge, code_challenge_method, auth_time, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		hashSecret(code), g.ClientID, g.UserID, g.RedirectURI, g.Scope, nullString(g.Nonce),
+		nullString(g.CodeChallenge), nullString(g.CodeChallengeMethod), g.AuthTime.Unix(), time.Now().Add(CodeTTL).Unix())
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// RedeemCode deletes the code and returns its grant, so that it can only be used
+// once. Expired codes are cleaned up on the way.
+func RedeemCode(q Queryer, code string) (*Grant, error) {
+	now := time.Now().Unix()
+	if _, err := q.Exec("DELETE FROM oauth_codes WHERE expires_at <= ?", now); err != nil {
+		return nil, err
+	}
+	var g Grant
+	var nonce, challenge, method sql.NullString
+	var authTime int64
+	err := q.QueryRow(`SELECT client_id, user_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, auth_time
+		FROM oauth_codes WHERE code_hash = ?`, hashSecret(code)).
+		Scan(&g.ClientID, &g.UserID, &g.RedirectURI, &g.Scope, &nonce, &challenge, &method, &authTime)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := q.Exec("DELETE FROM oauth_codes WHERE code_hash = ?", hashSecret(code))
+	if err != nil {
+		return nil, err
+	}
+	// a concurrent request redeemed it first
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrInvalidCode
+	}
+	g.Nonce, g.CodeChallenge, g.CodeChallengeMethod = nonce.String, challenge.String, method.String
+	g.AuthTime = time.Unix(authTime, 0)
+	return &g, nil
+}
+
+// VerifyPKCE reports whether verifier answers the grant's PKCE challenge (RFC 7636).
+// A grant without a challenge requires no verifier, and gets none.
+func (g *Grant) VerifyPKCE(verifier string) bool {
+	if g.CodeChallenge == "" {
+		return verifier == ""
+	}
+	if g.CodeChallengeMethod != "S256" || len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(want), []byte(g.CodeChallenge)) == 1
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
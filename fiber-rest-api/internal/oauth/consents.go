@@ -0,0 +1,103 @@
+package oauth
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Consent records the scopes a user allowed a client.
+type Consent struct {
+	ClientID   string
+	ClientName string
+	Scopes     []string
+	CreatedAt  time.Time
+}
+
+// ParseScope splits a space-separated scope parameter, dropping duplicates.
+func ParseScope(scope string) []string {
+	seen := map[string]bool{}
+	var list []string
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// HasConsent reports whether user uid allowed the client every one of scopes.
+func HasConsent(q Queryer, uid int, clientID string, scopes []string) (bool, error) {
+	var scope string
+	err := q.QueryRow("SELECT scope FROM oauth_consents WHERE user_id = ? AND client_id = ?", uid, clientID).Scan(&scope)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	granted := map[string]bool{}
+	for _, s := range ParseScope(scope) {
+		granted[s] = true
+	}
+	for _, s := range scopes {
+		if !granted[s] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// GrantConsent adds scopes to what user uid allowed the client.
+func GrantConsent(q Queryer, uid int, clientID string, scopes []string) error {
+	var scope string
+	err := q.QueryRow("SELECT scope FROM oauth_consents WHERE user_id = ? AND client_id = ?", uid, clientID).Scan(&scope)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	merged := ParseScope(scope + " " + strings.Join(scopes, " "))
+	sort.Strings(merged)
+	_, err = q.Exec(`INSERT INTO oauth_consents (user_id, client_id, scope, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET scope = excluded.scope`,
+		uid, clientID, strings.Join(merged, " "), time.Now().Unix())
+	return err
+}
+
+// Consents returns the consents user uid gave, by client name.
+func Consents(q Queryer, uid int) ([]Consent, error) {
+	rows, err := q.Query(`SELECT c.client_id, k.name, c.scope, c.created_at FROM oauth_consents c
+		JOIN oauth_clients k ON k.id = c.client_id WHERE c.user_id = ? ORDER BY k.name, c.client_id`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Consent{}
+	for rows.Next() {
+		var c Consent
+		var scope string
+		var createdAt int64
+		if err := rows.Scan(&c.ClientID, &c.ClientName, &scope, &createdAt); err != nil {
+			return nil, err
+		}
+		c.Scopes = ParseScope(scope)
+		c.CreatedAt = time.Unix(createdAt, 0)
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// RevokeConsent withdraws the consent user uid gave the client. The client's access
+// tokens stop working at the userinfo endpoint, and the user is asked again the next
+// time they sign in to it.
+func RevokeConsent(q Queryer, uid int, clientID string) error {
+	res, err := q.Exec("DELETE FROM oauth_consents WHERE user_id = ? AND client_id = ?", uid, clientID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// SigningKey is an RSA key that signs ID and access tokens.
+type SigningKey struct {
+	ID        string
+	Key       *rsa.PrivateKey
+	CreatedAt time.Time
+}
+
+// JWK is the public half of a signing key as a JSON Web Key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// CurrentKey returns the newest signing key, creating the first one when there is
+// none yet. Rotating keys is adding a newer one with RotateKey; older keys stay
+// published so that the tokens they signed can still be verified.
+func CurrentKey(q Queryer) (*SigningKey, error) {
+	k, err := scanKey(q.QueryRow("SELECT id, private_key, created_at FROM oauth_signing_keys ORDER BY created_at DESC, rowid DESC LIMIT 1"))
+	if err == sql.ErrNoRows {
+		return RotateKey(q)
+	}
+	return k, err
+}
+
+// RotateKey creates a signing key, which signs from now on.
+func RotateKey(q Queryer) (*SigningKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	k := &SigningKey{ID: randomString(8), Key: key, CreatedAt: time.Unix(time.Now().Unix(), 0)}
+	block := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	_, err = q.Exec("INSERT INTO oauth_signing_keys (id, private_key, created_at) VALUES (?, ?, ?)",
+		k.ID, string(block), k.CreatedAt.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Keys returns every signing key, newest first.
+func Keys(q Queryer) ([]SigningKey, error) {
+	rows, err := q.Query("SELECT id, private_key, created_at FROM oauth_signing_keys ORDER BY created_at DESC, rowid DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []SigningKey
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *k)
+	}
+	return list, rows.Err()
+}
+
+// PublicKey returns the public key with the given id, for verifying tokens.
+func PublicKey(q Queryer, id string) (*rsa.PublicKey, error) {
+	k, err := scanKey(q.QueryRow("SELECT id, private_key, created_at FROM oauth_signing_keys WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k.Key.PublicKey, nil
+}
+
+// JWK returns the public half of k.
+func (k *SigningKey) JWK() JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: k.ID,
+		N:   base64.RawURLEncoding.EncodeToString(k.Key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.Key.E)).Bytes()),
+	}
+}
+
+func scanKey(row interface{ Scan(...interface{}) error }) (*SigningKey, error) {
+	var k SigningKey
+	var private string
+	var createdAt int64
+	if err := row.Scan(&k.ID, &private, &createdAt); err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(private))
+	if block == nil {
+		return nil, errors.New("signing key " + k.ID + ": not PEM")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", k.ID, err)
+	}
+	k.Key, k.CreatedAt = key, time.Unix(createdAt, 0)
+	return &k, nil
+}
@@ -0,0 +1,240 @@
+package oauth_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/oauth"
+	"fiber-rest-api/internal/users"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "oauth")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	if err := db.Init(filepath.Join(dir, "data.db")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	return m.Run()
+}
+
+// newUser creates a user for grants and consents to belong to.
+func newUser(t *testing.T, email string) int {
+	t.Helper()
+	uid, err := users.Create(db.DB, users.NewUser{Email: email, Password: "correct-h0rse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uid
+}
+
+// newClient registers a confidential client redirecting to http://localhost:8080/callback.
+func newClient(t *testing.T, name string) (*oauth.Client, string) {
+	t.Helper()
+	c, secret, err := oauth.CreateClient(db.DB, oauth.NewClient{Name: name, RedirectURIs: []string{"http://localhost:8080/callback"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, secret
+}
+
+func TestCheckRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri string
+		ok  bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"http://localhost:8080/callback", true},
+		{"http://127.0.0.1/cb", true},
+		{"http://[::1]:3000/cb", true},
+		{"http://app.example.com/callback", false},
+		{"https://app.example.com/callback#frag", false},
+		{"/callback", false},
+		{"com.example.app:/callback", false},
+		{"javascript:alert(1)", false},
+	}
+	for _, tt := range tests {
+		if err := oauth.CheckRedirectURI(tt.uri); (err == nil) != tt.ok {
+			t.Errorf("CheckRedirectURI(%q) = %v, want ok %v", tt.uri, err, tt.ok)
+		}
+	}
+}
+
+func TestClients(t *testing.T) {
+	c, secret := newClient(t, " Wiki ")
+	if secret == "" || c.Name != "Wiki" || c.Public {
+		t.Fatalf("client %+v, secret %q", c, secret)
+	}
+	got, err := oauth.GetClient(db.DB, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CheckSecret(secret) || got.CheckSecret("") || got.CheckSecret(secret+"x") {
+		t.Error("secret not checked")
+	}
+	if !got.AllowsRedirectURI("http://localhost:8080/callback") || got.AllowsRedirectURI("http://localhost:8080/callback/") {
+		t.Error("redirect URIs not compared exactly")
+	}
+
+	public, secret, err := oauth.CreateClient(db.DB, oauth.NewClient{Name: "Mobile", RedirectURIs: []string{"http://localhost/cb"}, Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err = oauth.GetClient(db.DB, public.ID); err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" || !got.Public || !got.CheckSecret("") || got.CheckSecret("anything") {
+		t.Errorf("public client %+v, secret %q", got, secret)
+	}
+
+	for _, nc := range []oauth.NewClient{
+		{Name: " ", RedirectURIs: []string{"https://app.example.com/cb"}},
+		{Name: "No redirect"},
+		{Name: "Evil", RedirectURIs: []string{"http://evil.example.com/cb"}},
+	} {
+		if _, _, err := oauth.CreateClient(db.DB, nc); err == nil {
+			t.Errorf("registered %+v", nc)
+		}
+	}
+
+	if err := oauth.DeleteClient(db.DB, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oauth.GetClient(db.DB, c.ID); err != oauth.ErrClientNotFound {
+		t.Errorf("deleted client: %v", err)
+	}
+	if err := oauth.DeleteClient(db.DB, c.ID); err != oauth.ErrClientNotFound {
+		t.Errorf("deleted twice: %v", err)
+	}
+}
+
+func TestCodes(t *testing.T) {
+	c, _ := newClient(t, "Codes")
+	verifier := "a-verifier-long-enough-for-rfc-7636-at-least-43-chars"
+	sum := sha256.Sum256([]byte(verifier))
+	g := oauth.Grant{
+		ClientID: c.ID, UserID: newUser(t, "codes@example.com"), RedirectURI: "http://localhost:8080/callback",
+		Scope: "openid email", Nonce: "n", CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]), CodeChallengeMethod: "S256", AuthTime: time.Unix(1700000000, 0),
+	}
+	code, err := oauth.IssueCode(db.DB, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := oauth.RedeemCode(db.DB, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, g) {
+		t.Errorf("grant %+v, want %+v", *got, g)
+	}
+	if !got.VerifyPKCE(verifier) || got.VerifyPKCE(verifier+"x") || got.VerifyPKCE("") {
+		t.Error("PKCE verifier not checked")
+	}
+	if _, err := oauth.RedeemCode(db.DB, code); err != oauth.ErrInvalidCode {
+		t.Errorf("code redeemed twice: %v", err)
+	}
+	if _, err := oauth.RedeemCode(db.DB, "made-up"); err != oauth.ErrInvalidCode {
+		t.Errorf("unknown code: %v", err)
+	}
+
+	// without a challenge no verifier may be sent
+	plain := oauth.Grant{}
+	if !plain.VerifyPKCE("") || plain.VerifyPKCE(verifier) {
+		t.Error("grant without a challenge")
+	}
+	plain = oauth.Grant{CodeChallenge: verifier, CodeChallengeMethod: "plain"}
+	if plain.VerifyPKCE(verifier) {
+		t.Error("plain PKCE accepted")
+	}
+}
+
+func TestConsents(t *testing.T) {
+	uid := newUser(t, "consents@example.com")
+	c, _ := newClient(t, "Consents")
+	if ok, err := oauth.HasConsent(db.DB, uid, c.ID, []string{"openid"}); err != nil || ok {
+		t.Fatalf("consent before any: %v, %v", ok, err)
+	}
+	if err := oauth.GrantConsent(db.DB, uid, c.ID, []string{"openid", "email"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := oauth.GrantConsent(db.DB, uid, c.ID, []string{"profile", "email"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := oauth.HasConsent(db.DB, uid, c.ID, []string{"openid", "email", "profile"}); !ok {
+		t.Error("consents not merged")
+	}
+	if ok, _ := oauth.HasConsent(db.DB, uid, c.ID, []string{"openid", "phone"}); ok {
+		t.Error("consent to a scope never allowed")
+	}
+	list, err := oauth.Consents(db.DB, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ClientName != "Consents" || !reflect.DeepEqual(list[0].Scopes, []string{"email", "openid", "profile"}) {
+		t.Errorf("consents %+v", list)
+	}
+
+	if err := oauth.RevokeConsent(db.DB, uid, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := oauth.HasConsent(db.DB, uid, c.ID, []string{"openid"}); ok {
+		t.Error("consent kept after revoking it")
+	}
+	if err := oauth.RevokeConsent(db.DB, uid, c.ID); err != oauth.ErrClientNotFound {
+		t.Errorf("revoked twice: %v", err)
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	if got := oauth.ParseScope("  openid email  openid profile "); !reflect.DeepEqual(got, []string{"openid", "email", "profile"}) {
+		t.Errorf("ParseScope = %q", got)
+	}
+}
+
+// Rotated keys stay published, so tokens they signed can still be verified.
+func TestKeys(t *testing.T) {
+	first, err := oauth.CurrentKey(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := oauth.CurrentKey(db.DB); err != nil || again.ID != first.ID {
+		t.Fatalf("current key changed without rotation: %v", err)
+	}
+	second, err := oauth.RotateKey(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, err := oauth.CurrentKey(db.DB); err != nil || current.ID != second.ID {
+		t.Errorf("current key is not the rotated one: %v", err)
+	}
+	keys, err := oauth.Keys(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != second.ID || keys[1].ID != first.ID {
+		t.Errorf("%d keys, want %s then %s", len(keys), second.ID, first.ID)
+	}
+	pub, err := oauth.PublicKey(db.DB, first.ID)
+	if err != nil || pub.N.Cmp(first.Key.N) != 0 {
+		t.Errorf("public key of the old key: %v", err)
+	}
+	if jwk := first.JWK(); jwk.Kid != first.ID || jwk.Kty != "RSA" || jwk.Alg != "RS256" || jwk.E != "AQAB" {
+		t.Errorf("JWK %+v", jwk)
+	}
+}
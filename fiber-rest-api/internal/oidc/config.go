@@ -0,0 +1,54 @@
+package oidc
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// wellKnownIssuers are the issuers of providers that can be configured by name alone.
+var wellKnownIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+	"line":   "https://access.line.me",
+}
+
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// FromEnv returns the providers named in OIDC_PROVIDERS, a comma-separated list
+// such as "google,line", or none when it is unset. Each provider NAME reads
+// OIDC_NAME_CLIENT_ID, OIDC_NAME_CLIENT_SECRET, OIDC_NAME_ISSUER (optional for
+// google and line) and OIDC_NAME_SCOPES (default "openid email profile").
+func FromEnv() ([]*Provider, error) {
+	var providers []*Provider
+	seen := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !validName.MatchString(name) || seen[name] {
+			return nil, fmt.Errorf("invalid or repeated provider name %q in OIDC_PROVIDERS", name)
+		}
+		seen[name] = true
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		p := &Provider{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if p.Issuer == "" {
+			p.Issuer = wellKnownIssuers[name]
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("provider %s needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
@@ -0,0 +1,232 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Claims are the verified claims of an ID token the relying party uses.
+type Claims struct {
+	// Subject identifies the user at the provider; unlike the email address it
+	// never changes.
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	GivenName     string
+	FamilyName    string
+}
+
+// idTokenClaims is the payload of an ID token.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string       `json:"nonce"`
+	AuthorizedParty string       `json:"azp"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+	Name            string       `json:"name"`
+	GivenName       string       `json:"given_name"`
+	FamilyName      string       `json:"family_name"`
+}
+
+// flexibleBool accepts true and "true": some providers send email_verified as a
+// string.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		*b = flexibleBool(v)
+	case string:
+		*b = v == "true"
+	}
+	return nil
+}
+
+// signingMethods are the ID token algorithms accepted. HS256 tokens are signed
+// with the client secret (OpenID Connect Core 1.0, section 10.1); the others with
+// a key from the provider's JWKS.
+var signingMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "HS256"}
+
+// clockSkew is how far the clocks of the provider and the server may disagree.
+const clockSkew = time.Minute
+
+// VerifyIDToken checks the signature of raw and that it was issued by the provider,
+// for this client and for the sign-in attempt with the given nonce, and is not
+// expired.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	m, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var c idTokenClaims
+	parser := jwt.NewParser(jwt.WithValidMethods(signingMethods), jwt.WithoutClaimsValidation())
+	_, err = parser.ParseWithClaims(raw, &c, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			if p.ClientSecret == "" {
+				return nil, errors.New("HS256 needs a client secret")
+			}
+			return []byte(p.ClientSecret), nil
+		}
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, m.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("id token: %w", err)
+	}
+
+	now := time.Now()
+	switch {
+	case c.Issuer != m.Issuer:
+		return nil, fmt.Errorf("id token: issuer is %q, want %q", c.Issuer, m.Issuer)
+	case !c.VerifyAudience(p.ClientID, true):
+		return nil, errors.New("id token: not issued for this client")
+	case len(c.Audience) > 1 && c.AuthorizedParty != p.ClientID:
+		return nil, errors.New("id token: azp does not name this client")
+	case c.ExpiresAt == nil || !c.VerifyExpiresAt(now.Add(-clockSkew), true):
+		return nil, errors.New("id token: expired")
+	case c.IssuedAt == nil || !c.VerifyIssuedAt(now.Add(clockSkew), true):
+		return nil, errors.New("id token: issued in the future")
+	case c.Subject == "":
+		return nil, errors.New("id token: no subject")
+	case c.Nonce != nonce:
+		return nil, errors.New("id token: nonce does not match")
+	}
+	return &Claims{
+		Subject:       c.Subject,
+		Email:         c.Email,
+		EmailVerified: bool(c.EmailVerified),
+		Name:          c.Name,
+		GivenName:     c.GivenName,
+		FamilyName:    c.FamilyName,
+	}, nil
+}
+
+// key returns the public key with the given id from the provider's JWKS. The set is
+// fetched again when it is older than metadataTTL, or when it lacks kid and was not
+// fetched within the last minute, which picks up rotated keys without letting
+// tokens with made-up key ids flood the provider.
+func (p *Provider) key(ctx context.Context, jwksURI, kid string) (interface{}, error) {
+	p.mu.Lock()
+	keys, age := p.keys, time.Since(p.keysFetched)
+	p.mu.Unlock()
+	if k, ok := lookupKey(keys, kid); ok && age < metadataTTL {
+		return k, nil
+	}
+	if keys != nil && age < time.Minute {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, "", &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys = map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	p.mu.Lock()
+	p.keys, p.keysFetched = keys, time.Now()
+	p.mu.Unlock()
+
+	if k, ok := lookupKey(keys, kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// lookupKey finds kid in keys; a token without kid may use the only key there is.
+func lookupKey(keys map[string]interface{}, kid string) (interface{}, bool) {
+	if k, ok := keys[kid]; ok {
+		return k, true
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// jwk is an RSA or EC public key of a JSON Web Key Set (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,328 @@
+// Package mock is a stand-in OpenID Connect provider for development and for the
+// tests of internal/oidc. It implements discovery, the authorization code flow
+// with PKCE, a JWKS with one RSA key and the userinfo endpoint, and signs users in
+// without a password: the authorization endpoint asks only for an email address,
+// or takes it from login_hint and redirects back at once.
+package mock
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// User is an account at the provider.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// Provider serves the provider endpoints under Issuer, which must be the URL it is
+// reachable at. Only the registered client may use it.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// OmitEmail leaves the email address out of ID tokens, so that relying
+	// parties have to read it from the userinfo endpoint.
+	OmitEmail bool
+
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu     sync.Mutex
+	users  map[string]User // by email
+	codes  map[string]grant
+	tokens map[string]string // access token -> email
+}
+
+// grant is an issued authorization code, waiting to be redeemed.
+type grant struct {
+	email, clientID, redirectURI, nonce, challenge string
+	expires                                        time.Time
+}
+
+const codeTTL = time.Minute
+
+// New returns a provider with a fresh signing key.
+func New(issuer, clientID, clientSecret string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		keyID:        randomString()[:8],
+		users:        map[string]User{},
+		codes:        map[string]grant{},
+		tokens:       map[string]string{},
+	}, nil
+}
+
+// AddUser adds or replaces a user. Users signing in with an unknown address are
+// created on the fly with a verified email.
+func (p *Provider) AddUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users[strings.ToLower(u.Email)] = u
+}
+
+// user returns the user with the given email address, creating it if needed.
+func (p *Provider) user(email string) User {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	email = strings.ToLower(email)
+	u, ok := p.users[email]
+	if !ok {
+		sum := sha256.Sum256([]byte(email))
+		u = User{Subject: fmt.Sprintf("%x", sum[:8]), Email: email, EmailVerified: true}
+		p.users[email] = u
+	}
+	return u
+}
+
+// Handler returns the provider endpoints.
+func (p *Provider) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/userinfo", p.userinfo)
+	return mux
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"userinfo_endpoint":                     p.Issuer + "/userinfo",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_post", "client_secret_basic"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><title>Mock provider</title></head>
+<body style="font-family: sans-serif; max-width: 400px; margin: 3rem auto">
+<h1>Mock provider</h1>
+<p>Sign in to <b>{{.ClientID}}</b> as:</p>
+<form method="get" action="/authorize">
+{{range $k, $v := .Query}}{{if ne $k "login_hint"}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">{{end}}{{end}}
+<input name="login_hint" type="email" placeholder="email address" required autofocus>
+<button>Continue</button>
+</form>
+</body></html>`))
+
+// authorize approves the request for the user named by login_hint, asking for an
+// address first when there is none.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	// errors about the client or redirect URI must not be sent to the redirect URI
+	if q.Get("client_id") != p.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(redirectURI); err != nil || !u.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	fail := func(code, description string) {
+		back := url.Values{"error": {code}, "error_description": {description}, "state": {q.Get("state")}}
+		http.Redirect(w, r, redirectURI+"?"+back.Encode(), http.StatusFound)
+	}
+	switch {
+	case q.Get("response_type") != "code":
+		fail("unsupported_response_type", "only the authorization code flow is supported")
+		return
+	case !strings.Contains(" "+q.Get("scope")+" ", " openid "):
+		fail("invalid_scope", "scope must include openid")
+		return
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		fail("invalid_request", "PKCE with S256 is required")
+		return
+	}
+
+	email := q.Get("login_hint")
+	if email == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, map[string]interface{}{"ClientID": p.ClientID, "Query": q})
+		return
+	}
+	// "deny" lets relying parties try what happens when a user declines
+	if email == "deny" {
+		fail("access_denied", "the user declined")
+		return
+	}
+	p.user(email)
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = grant{
+		email:       strings.ToLower(email),
+		clientID:    p.ClientID,
+		redirectURI: redirectURI,
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		expires:     time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+	back := url.Values{"code": {code}, "state": {q.Get("state")}}
+	http.Redirect(w, r, redirectURI+"?"+back.Encode(), http.StatusFound)
+}
+
+// token redeems an authorization code for an ID token and an access token.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fail := func(status int, code, description string) {
+		writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+	}
+	if err := r.ParseForm(); err != nil {
+		fail(http.StatusBadRequest, "invalid_request", "malformed form")
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) != 1 {
+		fail(http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		fail(http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	// codes are single use, even when the request fails
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(g.expires):
+		fail(http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	case g.redirectURI != r.PostForm.Get("redirect_uri"):
+		fail(http.StatusBadRequest, "invalid_grant", "redirect_uri does not match")
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		fail(http.StatusBadRequest, "invalid_grant", "code_verifier does not match")
+		return
+	}
+
+	u := p.user(g.email)
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": p.Issuer,
+		"sub": u.Subject,
+		"aud": g.clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	if !p.OmitEmail {
+		claims["email"], claims["email_verified"] = u.Email, u.EmailVerified
+	}
+	if u.GivenName != "" {
+		claims["given_name"] = u.GivenName
+	}
+	if u.FamilyName != "" {
+		claims["family_name"] = u.FamilyName
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = p.keyID
+	idToken, err := t.SignedString(p.key)
+	if err != nil {
+		fail(http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	accessToken := randomString()
+	p.mu.Lock()
+	p.tokens[accessToken] = u.Email
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": p.keyID,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+func (p *Provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	email, ok := p.tokens[token]
+	p.mu.Unlock()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	u := p.user(email)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sub":            u.Subject,
+		"email":          u.Email,
+		"email_verified": u.EmailVerified,
+		"given_name":     u.GivenName,
+		"family_name":    u.FamilyName,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
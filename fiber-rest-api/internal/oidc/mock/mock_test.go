@@ -0,0 +1,194 @@
+package mock_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"fiber-rest-api/internal/oidc"
+	"fiber-rest-api/internal/oidc/mock"
+)
+
+const redirectURI = "http://localhost:3000/api/v1/auth/oidc/mock/callback"
+
+// start serves a fresh mock provider and returns it with a relying party of it.
+func start(t *testing.T) (*mock.Provider, *oidc.Provider) {
+	t.Helper()
+	var provider *mock.Provider
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider.Handler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	var err error
+	if provider, err = mock.New(ts.URL, "client", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	return provider, &oidc.Provider{Name: "mock", Issuer: ts.URL, ClientID: "client", ClientSecret: "s3cret", Scopes: []string{"email", "profile"}}
+}
+
+// authorize sends the user of loginHint through the authorization endpoint and
+// returns the query the provider redirects back with.
+func authorize(t *testing.T, rp *oidc.Provider, r oidc.AuthRequest, loginHint string) url.Values {
+	t.Helper()
+	target, err := rp.AuthURL(context.Background(), redirectURI, r, loginHint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the redirect back is the answer, not something to follow
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(location, redirectURI+"?") {
+		t.Fatalf("status %d, redirect to %q", resp.StatusCode, location)
+	}
+	u, _ := url.Parse(location)
+	q := u.Query()
+	if q.Get("state") != r.State {
+		t.Fatalf("state %q, want %q", q.Get("state"), r.State)
+	}
+	return q
+}
+
+func newAuthRequest(t *testing.T) oidc.AuthRequest {
+	t.Helper()
+	r, err := oidc.NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestSignIn(t *testing.T) {
+	provider, rp := start(t)
+	provider.AddUser(mock.User{Subject: "alice-1", Email: "Alice@example.com", EmailVerified: true, GivenName: "Alice", FamilyName: "Liddell"})
+	r := newAuthRequest(t)
+	code := authorize(t, rp, r, "alice@example.com").Get("code")
+	claims, err := rp.Exchange(context.Background(), redirectURI, code, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := oidc.Claims{Subject: "alice-1", Email: "Alice@example.com", EmailVerified: true, GivenName: "Alice", FamilyName: "Liddell"}
+	if *claims != want {
+		t.Errorf("claims %+v, want %+v", *claims, want)
+	}
+
+	// codes work once
+	if _, err := rp.Exchange(context.Background(), redirectURI, code, r); err == nil {
+		t.Error("code redeemed twice")
+	}
+}
+
+// Unknown addresses become verified users with a stable subject.
+func TestUnknownUser(t *testing.T) {
+	_, rp := start(t)
+	var subjects []string
+	for i := 0; i < 2; i++ {
+		r := newAuthRequest(t)
+		claims, err := rp.Exchange(context.Background(), redirectURI, authorize(t, rp, r, "new@example.com").Get("code"), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.Email != "new@example.com" || !claims.EmailVerified {
+			t.Errorf("claims %+v", *claims)
+		}
+		subjects = append(subjects, claims.Subject)
+	}
+	if subjects[0] == "" || subjects[0] != subjects[1] {
+		t.Errorf("subjects %q", subjects)
+	}
+}
+
+func TestEmailFromUserinfo(t *testing.T) {
+	provider, rp := start(t)
+	provider.OmitEmail = true
+	r := newAuthRequest(t)
+	claims, err := rp.Exchange(context.Background(), redirectURI, authorize(t, rp, r, "heidi@example.com").Get("code"), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "heidi@example.com" || !claims.EmailVerified {
+		t.Errorf("claims %+v", *claims)
+	}
+}
+
+func TestDenied(t *testing.T) {
+	_, rp := start(t)
+	q := authorize(t, rp, newAuthRequest(t), "deny")
+	if q.Get("error") != "access_denied" || q.Get("code") != "" {
+		t.Errorf("redirected back with %v", q)
+	}
+}
+
+// The token endpoint checks what the authorization request was bound to.
+func TestExchangeChecks(t *testing.T) {
+	provider, rp := start(t)
+	tests := []struct {
+		name   string
+		change func(r *oidc.AuthRequest, rp *oidc.Provider, redirect *string)
+	}{
+		{"code verifier", func(r *oidc.AuthRequest, rp *oidc.Provider, redirect *string) { r.Verifier = "forged" }},
+		{"nonce", func(r *oidc.AuthRequest, rp *oidc.Provider, redirect *string) { r.Nonce = "forged" }},
+		{"redirect URI", func(r *oidc.AuthRequest, rp *oidc.Provider, redirect *string) { *redirect += "/other" }},
+		{"client secret", func(r *oidc.AuthRequest, rp *oidc.Provider, redirect *string) { rp.ClientSecret = "wrong" }},
+	}
+	for _, tt := range tests {
+		r := newAuthRequest(t)
+		code := authorize(t, rp, r, "bob@example.com").Get("code")
+		other := &oidc.Provider{Name: rp.Name, Issuer: provider.Issuer, ClientID: rp.ClientID, ClientSecret: rp.ClientSecret}
+		redirect := redirectURI
+		tt.change(&r, other, &redirect)
+		if _, err := other.Exchange(context.Background(), redirect, code, r); err == nil {
+			t.Errorf("%s: exchange succeeded", tt.name)
+		}
+	}
+}
+
+// Requests the provider cannot redirect back are refused at the authorization
+// endpoint; the others are sent back with an error.
+func TestAuthorizeErrors(t *testing.T) {
+	provider, _ := start(t)
+	valid := url.Values{
+		"response_type": {"code"}, "client_id": {"client"}, "redirect_uri": {redirectURI}, "scope": {"openid"},
+		"state": {"s"}, "code_challenge": {"c"}, "code_challenge_method": {"S256"}, "login_hint": {"bob@example.com"},
+	}
+	tests := []struct {
+		name, param, value string
+		status             int
+		err                string
+	}{
+		{"unknown client", "client_id", "other", http.StatusBadRequest, ""},
+		{"relative redirect", "redirect_uri", "/callback", http.StatusBadRequest, ""},
+		{"implicit flow", "response_type", "token", http.StatusFound, "unsupported_response_type"},
+		{"without openid", "scope", "email", http.StatusFound, "invalid_scope"},
+		{"plain PKCE", "code_challenge_method", "plain", http.StatusFound, "invalid_request"},
+	}
+	for _, tt := range tests {
+		q := url.Values{}
+		for k, v := range valid {
+			q[k] = v
+		}
+		q.Set(tt.param, tt.value)
+		w := httptest.NewRecorder()
+		provider.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/authorize?"+q.Encode(), nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.err != "" {
+			u, _ := url.Parse(w.Header().Get("Location"))
+			if got := u.Query().Get("error"); got != tt.err {
+				t.Errorf("%s: error %q, want %q", tt.name, got, tt.err)
+			}
+		}
+	}
+}
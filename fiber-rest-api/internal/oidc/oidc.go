@@ -0,0 +1,264 @@
+// Package oidc is an OpenID Connect relying party. It sends users to a provider with
+// the authorization code flow and PKCE, exchanges the code and verifies the ID token
+// that comes back. Provider metadata is read through discovery and the signing keys
+// from the provider's JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider is an OpenID Connect provider the server signs users in with.
+type Provider struct {
+	// Name identifies the provider in URLs and in linked identities, e.g. "google".
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Scopes requested; openid is always included.
+	Scopes []string
+	// HTTPClient defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+
+	mu          sync.Mutex
+	metadata    *Metadata
+	metadataAt  time.Time
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// Metadata is the part of a provider's discovery document the relying party uses.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// discovery documents and keys are fetched again after this long
+const metadataTTL = time.Hour
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+func (p *Provider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return defaultClient
+}
+
+// Discover returns the provider metadata from <issuer>/.well-known/openid-configuration,
+// cached for an hour.
+func (p *Provider) Discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	if p.metadata != nil && time.Since(p.metadataAt) < metadataTTL {
+		m := p.metadata
+		p.mu.Unlock()
+		return m, nil
+	}
+	p.mu.Unlock()
+
+	var m Metadata
+	if err := p.getJSON(ctx, strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", "", &m); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	// the document must describe the issuer it was fetched from (OpenID Connect
+	// Discovery 1.0, section 4.3)
+	if m.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovery: issuer is %q, want %q", m.Issuer, p.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery: authorization_endpoint, token_endpoint or jwks_uri missing")
+	}
+
+	p.mu.Lock()
+	p.metadata, p.metadataAt = &m, time.Now()
+	p.mu.Unlock()
+	return &m, nil
+}
+
+// AuthRequest holds the values of one sign-in attempt. The relying party keeps them
+// (e.g. in a cookie) until the provider redirects back.
+type AuthRequest struct {
+	State    string
+	Nonce    string
+	Verifier string // PKCE code verifier
+}
+
+// NewAuthRequest returns an AuthRequest with fresh random values.
+func NewAuthRequest() (AuthRequest, error) {
+	var r AuthRequest
+	for _, v := range []*string{&r.State, &r.Nonce, &r.Verifier} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return AuthRequest{}, err
+		}
+		*v = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return r, nil
+}
+
+// Challenge returns the S256 PKCE code challenge of the verifier.
+func (r AuthRequest) Challenge() string {
+	sum := sha256.Sum256([]byte(r.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthURL returns the URL of the provider's authorization endpoint to send the user
+// to. loginHint, when set, pre-fills the account at the provider.
+func (p *Provider) AuthURL(ctx context.Context, redirectURI string, r AuthRequest, loginHint string) (string, error) {
+	m, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", p.scope())
+	q.Set("state", r.State)
+	q.Set("nonce", r.Nonce)
+	q.Set("code_challenge", r.Challenge())
+	q.Set("code_challenge_method", "S256")
+	if loginHint != "" {
+		q.Set("login_hint", loginHint)
+	}
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+func (p *Provider) scope() string {
+	scopes := []string{"openid"}
+	for _, s := range p.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// Exchange redeems the authorization code the provider redirected back with and
+// returns the verified claims of the ID token. When the ID token carries no email
+// address and the provider has a userinfo endpoint, the address is read from there.
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code string, r AuthRequest) (*Claims, error) {
+	m, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", r.Verifier)
+	// client_secret_post: supported by the providers this is used with, and
+	// unlike client_secret_basic needs no extra encoding of the credentials
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var tok struct {
+		IDToken          string `json:"id_token"`
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.do(req, &tok)
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	if status != http.StatusOK || tok.Error != "" {
+		return nil, fmt.Errorf("token: status %d: %s %s", status, tok.Error, tok.ErrorDescription)
+	}
+	if tok.IDToken == "" {
+		return nil, errors.New("token: no id_token in response")
+	}
+
+	claims, err := p.VerifyIDToken(ctx, tok.IDToken, r.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Email == "" && m.UserinfoEndpoint != "" && tok.AccessToken != "" {
+		if err := p.userinfo(ctx, m.UserinfoEndpoint, tok.AccessToken, claims); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// userinfo fills the email address of claims from the userinfo endpoint. The
+// response must be about the same subject as the ID token.
+func (p *Provider) userinfo(ctx context.Context, endpoint, accessToken string, claims *Claims) error {
+	var info struct {
+		Subject       string       `json:"sub"`
+		Email         string       `json:"email"`
+		EmailVerified flexibleBool `json:"email_verified"`
+	}
+	if err := p.getJSON(ctx, endpoint, accessToken, &info); err != nil {
+		return fmt.Errorf("userinfo: %w", err)
+	}
+	if info.Subject != claims.Subject {
+		return fmt.Errorf("userinfo: subject %q does not match the ID token", info.Subject)
+	}
+	claims.Email, claims.EmailVerified = info.Email, bool(info.EmailVerified)
+	return nil
+}
+
+// getJSON GETs url, with a bearer token if given, and decodes the JSON response.
+func (p *Provider) getJSON(ctx context.Context, url, bearer string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	status, err := p.do(req, v)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, status)
+	}
+	return nil
+}
+
+// do sends req and decodes the JSON body of the response into v, whatever the
+// status, which it returns.
+func (p *Provider) do(req *http.Request, v interface{}) (int, error) {
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("%s %s: %v", req.Method, req.URL, err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,365 @@
+package oidc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/mail"
+	"fiber-rest-api/internal/oidc"
+	"fiber-rest-api/internal/oidc/mock"
+	"fiber-rest-api/internal/router"
+	"fiber-rest-api/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// server is where the simulated browser finds the routes; it matches the default
+// APP_BASE_URL the callback URL is built from.
+const server = "http://localhost:3000"
+
+// The sign-in flows run end to end against the mock provider: the routes are served
+// in-process on a temporary database, and the browser is simulated by following the
+// redirects between them and the provider with a cookie jar. Responses are
+// validated against the OpenAPI document.
+var (
+	app      *fiber.App
+	provider *mock.Provider
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "oidc")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := db.Init(filepath.Join(dir, "data.db")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	handlers.Mail = &mail.Fake{}
+	os.Unsetenv("APP_BASE_URL")
+	os.Unsetenv("REGISTRATION_UNIFORM_RESPONSE")
+	os.Setenv("OPENAPI_VALIDATE_RESPONSES", "fail")
+
+	// the provider learns its URL only once it listens
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider.Handler().ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	if provider, err = mock.New(ts.URL, "fiber-rest-api", "s3cret"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	provider.AddUser(mock.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true, GivenName: "Alice", FamilyName: "Liddell"})
+	provider.AddUser(mock.User{Subject: "eve-1", Email: "eve@example.com"})
+	handlers.OIDCProviders = []*oidc.Provider{{
+		Name:         "mock",
+		Issuer:       ts.URL,
+		ClientID:     "fiber-rest-api",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"openid", "email", "profile"},
+	}}
+
+	app = fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	router.SetupRoutes(app)
+	return m.Run()
+}
+
+// browser follows redirects between the server and the provider, keeping cookies.
+type browser struct {
+	jar *cookiejar.Jar
+}
+
+func newBrowser() *browser {
+	jar, _ := cookiejar.New(nil)
+	return &browser{jar: jar}
+}
+
+// result is the response that ended a flow.
+type result struct {
+	status   int
+	location string
+	body     []byte
+}
+
+// decode reads the JSON body into v.
+func (r *result) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		t.Fatalf("status %d, body %s: %v", r.status, r.body, err)
+	}
+}
+
+// expect fails unless the response has the status and, for problem documents, the
+// error code.
+func (r *result) expect(t *testing.T, status int, code apperr.Code) {
+	t.Helper()
+	if r.status != status {
+		t.Fatalf("status %d, want %d: %s", r.status, status, r.body)
+	}
+	if code != "" {
+		var p apperr.Problem
+		r.decode(t, &p)
+		if p.Code != code {
+			t.Fatalf("code %s, want %s", p.Code, code)
+		}
+	}
+}
+
+// do sends a request to the server or, for absolute URLs elsewhere, the provider,
+// and follows redirects until a response that is not one or leaves both.
+func (b *browser) do(t *testing.T, method, target, token string, body interface{}) *result {
+	t.Helper()
+	for hops := 0; hops < 10; hops++ {
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !u.IsAbs() {
+			u, _ = url.Parse(server + target)
+		}
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader = bytes.NewReader(data)
+		}
+		req, err := http.NewRequest(method, u.String(), reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != nil {
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		}
+		if token != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		}
+		for _, cookie := range b.jar.Cookies(u) {
+			req.AddCookie(cookie)
+		}
+
+		var resp *http.Response
+		if server == u.Scheme+"://"+u.Host {
+			resp, err = app.Test(req, -1)
+		} else {
+			resp, err = http.DefaultTransport.RoundTrip(req)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.jar.SetCookies(u, resp.Cookies())
+
+		location := resp.Header.Get(fiber.HeaderLocation)
+		if resp.StatusCode != http.StatusFound || strings.HasPrefix(location, "/profile/") {
+			return &result{status: resp.StatusCode, location: location, body: data}
+		}
+		next, err := u.Parse(location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		method, target, token, body = http.MethodGet, next.String(), "", nil
+	}
+	t.Fatal("too many redirects")
+	return nil
+}
+
+// signIn signs in with the mock provider as email.
+func signIn(t *testing.T, email string) *result {
+	t.Helper()
+	return newBrowser().do(t, http.MethodGet, handlers.V1+"/auth/oidc/mock?login_hint="+url.QueryEscape(email), "", nil)
+}
+
+// token signs in as email, failing unless the response is a token.
+func token(t *testing.T, email string) handlers.TokenResponse {
+	t.Helper()
+	res := signIn(t, email)
+	res.expect(t, http.StatusOK, "")
+	var tok handlers.TokenResponse
+	res.decode(t, &tok)
+	return tok
+}
+
+// profile returns the profile of the token's user.
+func profile(t *testing.T, token string) handlers.Profile {
+	t.Helper()
+	res := newBrowser().do(t, http.MethodGet, handlers.V1+"/profile", token, nil)
+	res.expect(t, http.StatusOK, "")
+	var p handlers.Profile
+	res.decode(t, &p)
+	return p
+}
+
+// passwordToken registers email with a password and logs in with it.
+func passwordToken(t *testing.T, email string) string {
+	t.Helper()
+	creds := handlers.AuthRequest{Email: email, Password: "correct-h0rse"}
+	newBrowser().do(t, http.MethodPost, handlers.V1+"/auth/register", "", creds).expect(t, http.StatusCreated, "")
+	res := newBrowser().do(t, http.MethodPost, handlers.V1+"/auth/login", "", creds)
+	res.expect(t, http.StatusOK, "")
+	var tok handlers.TokenResponse
+	res.decode(t, &tok)
+	return tok.Token
+}
+
+// link links the provider account of email to the user of token.
+func link(t *testing.T, token, email string) *result {
+	t.Helper()
+	b := newBrowser()
+	res := b.do(t, http.MethodPost, handlers.V1+"/profile/identities/mock", token, nil)
+	res.expect(t, http.StatusOK, "")
+	var start handlers.AuthorizationURL
+	res.decode(t, &start)
+	// what the sign-in form of the provider submits
+	return b.do(t, http.MethodGet, start.AuthorizationURL+"&login_hint="+url.QueryEscape(email), "", nil)
+}
+
+func TestFirstSignInCreatesAccount(t *testing.T) {
+	tok := token(t, "alice@example.com")
+	if !tok.Created {
+		t.Error("created not set")
+	}
+	p := profile(t, tok.Token)
+	if p.Email != "alice@example.com" || p.FirstName != "Alice" || p.LastName != "Liddell" {
+		t.Errorf("profile %+v does not match the provider's claims", p)
+	}
+
+	// the second sign-in finds it
+	again := token(t, "alice@example.com")
+	if q := profile(t, again.Token); again.Created || q.ID != p.ID {
+		t.Errorf("signed in to user %d (created %v), want %d", q.ID, again.Created, p.ID)
+	}
+}
+
+// A provider account with the address of a registered user does not take it over.
+func TestRegisteredEmail(t *testing.T) {
+	passwordToken(t, "bob@example.com")
+	signIn(t, "bob@example.com").expect(t, http.StatusConflict, apperr.EmailTaken)
+}
+
+func TestLinkFromProfile(t *testing.T) {
+	bearer := passwordToken(t, "carol@example.com")
+	res := link(t, bearer, "carol@example.com")
+	res.expect(t, http.StatusOK, "")
+	var tok handlers.TokenResponse
+	res.decode(t, &tok)
+	if !tok.Linked {
+		t.Error("linked not set")
+	}
+	owner := profile(t, bearer)
+	if p := profile(t, token(t, "carol@example.com").Token); p.ID != owner.ID {
+		t.Errorf("sign-in after linking found user %d, want %d", p.ID, owner.ID)
+	}
+
+	res = newBrowser().do(t, http.MethodGet, handlers.V1+"/profile/identities", bearer, nil)
+	var list handlers.IdentityList
+	res.decode(t, &list)
+	if len(list.Identities) != 1 || list.Identities[0].Provider != "mock" || list.Identities[0].Email != "carol@example.com" {
+		t.Errorf("identities %+v", list.Identities)
+	}
+	newBrowser().do(t, http.MethodDelete, handlers.V1+"/profile/identities/mock", bearer, nil).expect(t, http.StatusNoContent, "")
+	newBrowser().do(t, http.MethodDelete, handlers.V1+"/profile/identities/mock", bearer, nil).expect(t, http.StatusNotFound, apperr.NotFound)
+}
+
+func TestIdentityLinkedElsewhere(t *testing.T) {
+	token(t, "dora@example.com")
+	bearer := passwordToken(t, "dan@example.com")
+	link(t, bearer, "dora@example.com").expect(t, http.StatusConflict, apperr.IdentityLinked)
+}
+
+// The only way to sign in cannot be unlinked until the account has a password.
+func TestLastLoginMethod(t *testing.T) {
+	tok := token(t, "ivan@example.com").Token
+	newBrowser().do(t, http.MethodDelete, handlers.V1+"/profile/identities/mock", tok, nil).expect(t, http.StatusConflict, apperr.LastLoginMethod)
+
+	// an account without a password sets its first one without current_password
+	res := newBrowser().do(t, http.MethodPut, handlers.V1+"/profile/password", tok, handlers.PasswordChange{NewPassword: "first-passw0rd"})
+	res.expect(t, http.StatusOK, "")
+	var changed handlers.PasswordChangeResponse
+	res.decode(t, &changed)
+	newBrowser().do(t, http.MethodDelete, handlers.V1+"/profile/identities/mock", changed.Token, nil).expect(t, http.StatusNoContent, "")
+}
+
+func TestRedirect(t *testing.T) {
+	res := newBrowser().do(t, http.MethodGet, handlers.V1+"/auth/oidc/mock?redirect=/profile/ui&login_hint=frank@example.com", "", nil)
+	res.expect(t, http.StatusFound, "")
+	path, fragment, _ := strings.Cut(res.location, "#")
+	q, err := url.ParseQuery(fragment)
+	if err != nil || path != "/profile/ui" || q.Get("token") == "" || q.Get("created") != "true" {
+		t.Fatalf("redirected to %q", res.location)
+	}
+	profile(t, q.Get("token"))
+
+	newBrowser().do(t, http.MethodGet, handlers.V1+"/auth/oidc/mock?redirect=//evil.example.com/", "", nil).expect(t, http.StatusBadRequest, apperr.InvalidRequest)
+}
+
+func TestState(t *testing.T) {
+	// a callback without the cookie, e.g. opened in another browser
+	newBrowser().do(t, http.MethodGet, handlers.V1+"/auth/oidc/mock/callback?state=x&code=y", "", nil).expect(t, http.StatusBadRequest, apperr.InvalidState)
+
+	// a callback with the cookie of a sign-in but another state
+	b := newBrowser()
+	start, err := app.Test(httptest.NewRequest(http.MethodGet, handlers.V1+"/auth/oidc/mock", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start.Body.Close()
+	u, _ := url.Parse(server)
+	b.jar.SetCookies(u, start.Cookies())
+	b.do(t, http.MethodGet, handlers.V1+"/auth/oidc/mock/callback?state=forged&code=y", "", nil).expect(t, http.StatusBadRequest, apperr.InvalidState)
+}
+
+func TestDeclinedAtProvider(t *testing.T) {
+	signIn(t, "deny").expect(t, http.StatusForbidden, apperr.AuthorizationDenied)
+}
+
+func TestUnverifiedEmail(t *testing.T) {
+	signIn(t, "eve@example.com").expect(t, http.StatusForbidden, apperr.EmailUnverified)
+}
+
+func TestDisabledAccount(t *testing.T) {
+	p := profile(t, token(t, "grace@example.com").Token)
+	if _, err := users.SetDisabled(db.DB, p.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	signIn(t, "grace@example.com").expect(t, http.StatusForbidden, apperr.AccountDisabled)
+}
+
+// Without an address in the ID token it is read from the userinfo endpoint.
+func TestEmailFromUserinfo(t *testing.T) {
+	provider.OmitEmail = true
+	defer func() { provider.OmitEmail = false }()
+	tok := token(t, "heidi@example.com")
+	if p := profile(t, tok.Token); !tok.Created || p.Email != "heidi@example.com" {
+		t.Errorf("created %v with email %q", tok.Created, p.Email)
+	}
+}
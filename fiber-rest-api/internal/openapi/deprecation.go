@@ -0,0 +1,63 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Deprecation marks an operation as deprecated. Its responses then carry a
+// Deprecation header (RFC 9745), a Sunset header (RFC 8594) when Sunset is set, and a
+// Link to the successor when Successor is set.
+type Deprecation struct {
+	Since  time.Time
+	Sunset time.Time
+	// Successor is the path of the replacing operation, e.g. /api/v1/users/:username.
+	// Its parameters are filled from the request.
+	Successor string
+}
+
+// describe explains the deprecation in the operation description.
+func (d Deprecation) describe(method string) string {
+	text := "Deprecated since " + d.Since.UTC().Format("2006-01-02")
+	if !d.Sunset.IsZero() {
+		text += " and removed after " + d.Sunset.UTC().Format("2006-01-02")
+	}
+	if d.Successor != "" {
+		text += fmt.Sprintf("; use %s %s", method, openAPIPath(d.Successor))
+	}
+	return text + "."
+}
+
+// headers returns middleware adding the deprecation headers to every response.
+func (d Deprecation) headers() fiber.Handler {
+	deprecation := fmt.Sprintf("@%d", d.Since.Unix())
+	var sunset string
+	if !d.Sunset.IsZero() {
+		sunset = d.Sunset.UTC().Format(http.TimeFormat)
+	}
+	return func(c *fiber.Ctx) error {
+		c.Set("Deprecation", deprecation)
+		if sunset != "" {
+			c.Set("Sunset", sunset)
+		}
+		if d.Successor != "" {
+			c.Append(fiber.HeaderLink, fmt.Sprintf(`<%s>; rel="successor-version"`, expandPath(d.Successor, c)))
+		}
+		return c.Next()
+	}
+}
+
+// expandPath fills the :name parameters of a route path from the request.
+func expandPath(path string, c *fiber.Ctx) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") {
+			segments[i] = c.Params(strings.TrimSuffix(s[1:], "?"))
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,236 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// withValidation wraps the handlers of the route documented as method on path with
+// the request and response validators enabled on s. The request validator runs just
+// before the last handler, after authentication, so unauthenticated clients get 401
+// rather than validation details.
+func (s *Spec) withValidation(method, path string, handlers []fiber.Handler) []fiber.Handler {
+	op := s.paths[openAPIPath(path)][strings.ToLower(method)].(Schema)
+	out := handlers
+	if s.Reject != nil && len(handlers) > 0 {
+		last := len(handlers) - 1
+		out = make([]fiber.Handler, 0, len(handlers)+2)
+		out = append(out, handlers[:last]...)
+		out = append(out, s.requestValidator(op), handlers[last])
+	}
+	if s.ResponseMismatch != nil {
+		out = append([]fiber.Handler{s.responseValidator(op)}, out...)
+	}
+	return out
+}
+
+// withScopeCheck inserts Spec.CheckScope for op just before the last handler, after
+// the authentication middleware that establishes the credentials it checks.
+func (s *Spec) withScopeCheck(op Operation, handlers []fiber.Handler) []fiber.Handler {
+	if s.CheckScope == nil || !op.Auth || len(handlers) == 0 {
+		return handlers
+	}
+	scope := op.Scope
+	check := func(c *fiber.Ctx) error {
+		if err := s.CheckScope(c, scope); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+	last := len(handlers) - 1
+	out := make([]fiber.Handler, 0, len(handlers)+1)
+	out = append(out, handlers[:last]...)
+	return append(out, check, handlers[last])
+}
+
+// requestValidator checks the parameters and JSON body of requests against op. A
+// JSON body in a media type op does not list is rejected with 415.
+func (s *Spec) requestValidator(op Schema) fiber.Handler {
+	var params []Schema
+	for _, p := range asList(op["parameters"]) {
+		if param, ok := s.component("parameters", p); ok {
+			params = append(params, param)
+		}
+	}
+	body, hasBody := asSchema(op["requestBody"])
+
+	return func(c *fiber.Ctx) error {
+		var errs []FieldError
+		for _, p := range params {
+			name, _ := p["name"].(string)
+			in, _ := p["in"].(string)
+			var raw string
+			switch in {
+			case "path":
+				raw = c.Params(name)
+			case "query":
+				raw = c.Query(name)
+			case "header":
+				raw = c.Get(name)
+			}
+			if raw == "" {
+				if p["required"] == true {
+					errs = append(errs, FieldError{In: in, Field: name, Message: "is required"})
+				}
+				continue
+			}
+			schema, _ := asSchema(p["schema"])
+			v := s.newValidator(in)
+			if err := v.param(schema, raw, name); err != nil {
+				return s.validationFailed(c, err)
+			}
+			errs = append(errs, v.errs...)
+		}
+
+		// a body still streaming belongs to an operation with BodyStream
+		if hasBody && !c.Request().IsBodyStream() {
+			raw := c.Body()
+			content, _ := asSchema(body["content"])
+			if len(bytes.TrimSpace(raw)) == 0 {
+				if body["required"] == true {
+					errs = append(errs, FieldError{In: "body", Message: "request body is required"})
+				}
+			} else {
+				mediaType := parseMediaType(c.Get(fiber.HeaderContentType))
+				media, ok := matchContent(content, mediaType)
+				switch {
+				case !ok && isJSON(mediaType):
+					return s.Reject(c, fiber.StatusUnsupportedMediaType, "unsupported content type", nil)
+				case !ok:
+					// only JSON is validated; other bodies, such as the form posts
+					// the handlers accepted before validation existed, are left to
+					// the handler
+				case isJSON(mediaType):
+					v := s.newValidator("body")
+					if err := v.json(media, raw); err != nil {
+						return s.validationFailed(c, err)
+					}
+					errs = append(errs, v.errs...)
+				}
+			}
+		}
+
+		if len(errs) > 0 {
+			return s.Reject(c, fiber.StatusBadRequest, "invalid request", errs)
+		}
+		return c.Next()
+	}
+}
+
+// validationFailed answers a request that could not be validated, typically because
+// a schema extension could not read the database.
+func (s *Spec) validationFailed(c *fiber.Ctx, err error) error {
+	log.Printf("openapi: validate %s %s: %v", c.Method(), c.Path(), err)
+	return s.Reject(c, fiber.StatusInternalServerError, "failed to validate request", nil)
+}
+
+// responseValidator checks, after the rest of the route has run, that the status
+// code is documented and that JSON bodies match their schema. Errors returned by the
+// route are rendered by the app's error handler first, so their bodies are checked too.
+func (s *Spec) responseValidator(op Schema) fiber.Handler {
+	responses, _ := asSchema(op["responses"])
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				return err
+			}
+		}
+		status := c.Response().StatusCode()
+		resp, ok := s.component("responses", responses[strconv.Itoa(status)])
+		if !ok {
+			resp, ok = s.component("responses", responses["default"])
+		}
+		if !ok {
+			return s.ResponseMismatch(c, []FieldError{{In: "response", Message: fmt.Sprintf("status %d is not documented", status)}})
+		}
+		// reading a streamed body would buffer it; its content type is still checked
+		streamed := c.Response().IsBodyStream()
+		var body []byte
+		if !streamed {
+			if body = c.Response().Body(); len(body) == 0 {
+				return nil
+			}
+		}
+		content, _ := asSchema(resp["content"])
+		mediaType := parseMediaType(string(c.Response().Header.ContentType()))
+		media, ok := matchContent(content, mediaType)
+		if !ok {
+			return s.ResponseMismatch(c, []FieldError{{In: "response", Message: fmt.Sprintf("content type %s is not documented for status %d", mediaType, status)}})
+		}
+		if streamed || !isJSON(mediaType) {
+			return nil
+		}
+		v := s.newValidator("response")
+		if err := v.json(media, body); err != nil {
+			log.Printf("openapi: validate response of %s %s: %v", c.Method(), c.Path(), err)
+			return nil
+		}
+		if len(v.errs) > 0 {
+			return s.ResponseMismatch(c, v.errs)
+		}
+		return nil
+	}
+}
+
+// json decodes raw and validates it against the schema of media, a media type object.
+func (v *validator) json(media Schema, raw []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		v.fail("", "malformed JSON")
+		return nil
+	}
+	schema, ok := asSchema(media["schema"])
+	if !ok {
+		return nil
+	}
+	return v.value(schema, value, "")
+}
+
+// component returns x, following its $ref into the components of the given kind.
+func (s *Spec) component(kind string, x interface{}) (Schema, bool) {
+	schema, ok := asSchema(x)
+	if !ok {
+		return nil, false
+	}
+	ref, isRef := schema["$ref"].(string)
+	if !isRef {
+		return schema, true
+	}
+	return asSchema(s.components[kind][strings.TrimPrefix(ref, "#/components/"+kind+"/")])
+}
+
+// matchContent finds the media type object of content that covers mediaType,
+// honouring ranges such as "image/*" and "*/*".
+func matchContent(content Schema, mediaType string) (Schema, bool) {
+	candidates := []string{mediaType, "*/*"}
+	if i := strings.Index(mediaType, "/"); i > 0 {
+		candidates = []string{mediaType, mediaType[:i] + "/*", "*/*"}
+	}
+	for _, c := range candidates {
+		if media, ok := asSchema(content[c]); ok {
+			return media, true
+		}
+	}
+	return nil, false
+}
+
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == fiber.MIMEApplicationJSON || strings.HasSuffix(mediaType, "+json")
+}
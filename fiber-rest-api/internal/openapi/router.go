@@ -0,0 +1,130 @@
+package openapi
+
+import (
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Router registers routes on a Fiber router and documents them in a Spec at the same
+// time, so a route cannot be added without its operation.
+type Router struct {
+	fiber      fiber.Router
+	spec       *Spec
+	prefix     string
+	deprecated *Deprecation
+}
+
+// NewRouter wraps r, documenting its routes in spec.
+func NewRouter(r fiber.Router, spec *Spec) *Router {
+	return &Router{fiber: r, spec: spec}
+}
+
+// Add registers handlers for method on path and documents the route with op. The
+// validators and scope check enabled on the spec must be configured before routes
+// are added.
+func (r *Router) Add(method, path string, op Operation, handlers ...fiber.Handler) {
+	if r.deprecated != nil && op.Deprecation == nil {
+		d := *r.deprecated
+		if d.Successor != "" {
+			d.Successor += path
+		}
+		op.Deprecation = &d
+	}
+	if op.Deprecation != nil {
+		handlers = append([]fiber.Handler{op.Deprecation.headers()}, handlers...)
+	}
+	if op.BodyStream {
+		handlers = append([]fiber.Handler{closeUnread}, handlers...)
+	} else {
+		handlers = append([]fiber.Handler{readBody}, handlers...)
+	}
+	r.spec.Add(method, r.prefix+path, op)
+	handlers = r.spec.withScopeCheck(op, handlers)
+	r.fiber.Add(method, path, r.spec.withValidation(method, r.prefix+path, handlers)...)
+}
+
+// Get registers a GET route.
+func (r *Router) Get(path string, op Operation, handlers ...fiber.Handler) {
+	r.Add(fiber.MethodGet, path, op, handlers...)
+}
+
+// Post registers a POST route.
+func (r *Router) Post(path string, op Operation, handlers ...fiber.Handler) {
+	r.Add(fiber.MethodPost, path, op, handlers...)
+}
+
+// Put registers a PUT route.
+func (r *Router) Put(path string, op Operation, handlers ...fiber.Handler) {
+	r.Add(fiber.MethodPut, path, op, handlers...)
+}
+
+// Patch registers a PATCH route.
+func (r *Router) Patch(path string, op Operation, handlers ...fiber.Handler) {
+	r.Add(fiber.MethodPatch, path, op, handlers...)
+}
+
+// Delete registers a DELETE route.
+func (r *Router) Delete(path string, op Operation, handlers ...fiber.Handler) {
+	r.Add(fiber.MethodDelete, path, op, handlers...)
+}
+
+// Use registers middleware. It is not part of the document.
+func (r *Router) Use(handlers ...fiber.Handler) {
+	args := make([]interface{}, len(handlers))
+	for i, h := range handlers {
+		args[i] = h
+	}
+	r.fiber.Use(args...)
+	r.spec.IgnoreMiddleware(handlers...)
+}
+
+// Group returns a Router for routes under prefix that run handlers first.
+func (r *Router) Group(prefix string, handlers ...fiber.Handler) *Router {
+	r.spec.IgnoreMiddleware(handlers...)
+	return &Router{fiber: r.fiber.Group(prefix, handlers...), spec: r.spec, prefix: r.prefix + prefix, deprecated: r.deprecated}
+}
+
+// Deprecated returns a Router whose routes are deprecated as d unless their operation
+// sets its own Deprecation. d.Successor is a path prefix: each route links to it
+// followed by the route's path, e.g. /api/v1 for aliases of version 1 at the root.
+func (r *Router) Deprecated(d Deprecation) *Router {
+	return &Router{fiber: r.fiber, spec: r.spec, prefix: r.prefix, deprecated: &d}
+}
+
+// Static serves files from root under prefix. The files are not part of the document.
+func (r *Router) Static(prefix, root string) {
+	r.fiber.Static(prefix, root)
+	r.spec.Ignore(r.prefix + prefix)
+}
+
+// readBody reads a request body the server streams into memory, answering 413 if it
+// exceeds the app's BodyLimit, as the server does when it does not stream.
+func readBody(c *fiber.Ctx) error {
+	if !c.Request().IsBodyStream() {
+		return c.Next()
+	}
+	limit := c.App().Config().BodyLimit
+	body, err := io.ReadAll(io.LimitReader(c.Context().RequestBodyStream(), int64(limit)+1))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "failed to read request body")
+	}
+	if len(body) > limit {
+		// the rest of the body would be read as the next request
+		c.Context().SetConnectionClose()
+		return fiber.ErrRequestEntityTooLarge
+	}
+	c.Request().SetBody(body)
+	return c.Next()
+}
+
+// closeUnread closes the connection after an operation with BodyStream: its handler
+// may have answered before reading the whole body, and the rest would be read as the
+// next request.
+func closeUnread(c *fiber.Ctx) error {
+	err := c.Next()
+	if c.Request().IsBodyStream() {
+		c.Context().SetConnectionClose()
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package openapi
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Routes collects documented routes without registering them, so that one set can be
+// mounted on several Routers: an API version under its prefix and as deprecated
+// aliases, or as the base of the next version. Adding a route that already exists
+// replaces it, so a new version is a Clone of the previous one with its changed
+// routes added again.
+type Routes struct {
+	prefix   string
+	handlers []fiber.Handler
+	list     *[]route
+}
+
+type route struct {
+	method   string
+	path     string
+	op       Operation
+	handlers []fiber.Handler
+}
+
+// NewRoutes returns an empty set of routes.
+func NewRoutes() *Routes {
+	return &Routes{list: new([]route)}
+}
+
+// Add documents handlers for method on path with op, replacing any route with the
+// same method and path.
+func (rs *Routes) Add(method, path string, op Operation, handlers ...fiber.Handler) {
+	rt := route{
+		method:   method,
+		path:     rs.prefix + path,
+		op:       op,
+		handlers: append(append([]fiber.Handler{}, rs.handlers...), handlers...),
+	}
+	for i, existing := range *rs.list {
+		if existing.method == rt.method && existing.path == rt.path {
+			(*rs.list)[i] = rt
+			return
+		}
+	}
+	*rs.list = append(*rs.list, rt)
+}
+
+// Get adds a GET route.
+func (rs *Routes) Get(path string, op Operation, handlers ...fiber.Handler) {
+	rs.Add(fiber.MethodGet, path, op, handlers...)
+}
+
+// Post adds a POST route.
+func (rs *Routes) Post(path string, op Operation, handlers ...fiber.Handler) {
+	rs.Add(fiber.MethodPost, path, op, handlers...)
+}
+
+// Put adds a PUT route.
+func (rs *Routes) Put(path string, op Operation, handlers ...fiber.Handler) {
+	rs.Add(fiber.MethodPut, path, op, handlers...)
+}
+
+// Patch adds a PATCH route.
+func (rs *Routes) Patch(path string, op Operation, handlers ...fiber.Handler) {
+	rs.Add(fiber.MethodPatch, path, op, handlers...)
+}
+
+// Delete adds a DELETE route.
+func (rs *Routes) Delete(path string, op Operation, handlers ...fiber.Handler) {
+	rs.Add(fiber.MethodDelete, path, op, handlers...)
+}
+
+// Remove drops the route for method on path, e.g. an operation a new version no
+// longer offers.
+func (rs *Routes) Remove(method, path string) {
+	kept := (*rs.list)[:0]
+	for _, rt := range *rs.list {
+		if rt.method != method || rt.path != rs.prefix+path {
+			kept = append(kept, rt)
+		}
+	}
+	*rs.list = kept
+}
+
+// Group returns a view of rs adding routes under prefix that run handlers first.
+func (rs *Routes) Group(prefix string, handlers ...fiber.Handler) *Routes {
+	return &Routes{
+		prefix:   rs.prefix + prefix,
+		handlers: append(append([]fiber.Handler{}, rs.handlers...), handlers...),
+		list:     rs.list,
+	}
+}
+
+// Clone returns a copy of rs that can be changed without affecting rs.
+func (rs *Routes) Clone() *Routes {
+	list := append([]route{}, *rs.list...)
+	return &Routes{list: &list}
+}
+
+// Mount registers every route of rs on r.
+func (r *Router) Mount(rs *Routes) {
+	for _, rt := range *rs.list {
+		r.Add(rt.method, rt.path, rt.op, rt.handlers...)
+	}
+}
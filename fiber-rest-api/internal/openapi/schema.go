@@ -0,0 +1,239 @@
+// Package openapi builds an OpenAPI 3.0 document from route registrations and the
+// Go types used for request and response bodies.
+//
+// Struct fields are described with tags next to their json tag:
+//
+//	doc:"human readable description"
+//	openapi:"required,nullable,format=email,enum=a|b,default=a,example=a,minimum=1,maximum=9,minLength=1,maxLength=9"
+//	pattern:"^[a-z]+$"
+//
+// pattern has its own tag because regular expressions may contain commas.
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema object as used by OpenAPI 3.0. A Schema value can be used
+// anywhere a sample body is expected to describe a body verbatim.
+type Schema map[string]interface{}
+
+// Describer lets a type provide its own schema instead of the reflected one.
+type Describer interface {
+	OpenAPISchema() map[string]interface{}
+}
+
+// Namer lets a type choose its component name. By default the Go type name is used.
+type Namer interface {
+	OpenAPIName() string
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	describerType  = reflect.TypeOf((*Describer)(nil)).Elem()
+	namerType      = reflect.TypeOf((*Namer)(nil)).Elem()
+)
+
+// schemaOf returns the schema for sample v. Named struct and map types become
+// components of s and are returned as references.
+func (s *Spec) schemaOf(v interface{}) Schema {
+	if schema, ok := v.(Schema); ok {
+		return schema
+	}
+	return s.schemaFor(reflect.TypeOf(v))
+}
+
+func (s *Spec) schemaFor(t reflect.Type) Schema {
+	if t == nil {
+		return Schema{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return Schema{"type": "string", "format": "date-time"}
+	}
+	if t == rawMessageType {
+		// any JSON value
+		return Schema{}
+	}
+	if name := componentName(t); name != "" {
+		if _, done := s.schemas[name]; !done {
+			// reserve the name first so self-referencing types terminate
+			s.schemas[name] = Schema{}
+			s.schemas[name] = s.inlineSchema(t)
+		}
+		return Schema{"$ref": "#/components/schemas/" + name}
+	}
+	return s.inlineSchema(t)
+}
+
+// componentName returns the component name of t, or "" if t is described inline.
+func componentName(t reflect.Type) string {
+	if t.Name() == "" || t.PkgPath() == "" {
+		return ""
+	}
+	if t.Kind() != reflect.Struct && t.Kind() != reflect.Map && !t.Implements(describerType) {
+		return ""
+	}
+	if t.Implements(namerType) {
+		return reflect.Zero(t).Interface().(Namer).OpenAPIName()
+	}
+	return t.Name()
+}
+
+func (s *Spec) inlineSchema(t reflect.Type) Schema {
+	if t.Implements(describerType) {
+		return Schema(reflect.Zero(t).Interface().(Describer).OpenAPISchema())
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "format": "byte"}
+		}
+		return Schema{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		schema := Schema{"type": "object"}
+		if elem := s.schemaFor(t.Elem()); len(elem) > 0 {
+			schema["additionalProperties"] = elem
+		}
+		return schema
+	case reflect.Struct:
+		return s.structSchema(t)
+	}
+	return Schema{}
+}
+
+func (s *Spec) structSchema(t reflect.Type) Schema {
+	props := Schema{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			// embedded struct: promote its properties
+			embedded := s.structSchema(indirect(f.Type))
+			if p, ok := embedded["properties"].(Schema); ok {
+				for k, v := range p {
+					props[k] = v
+				}
+			}
+			if r, ok := embedded["required"].([]string); ok {
+				required = append(required, r...)
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fieldSchema, isRequired := s.fieldSchema(f)
+		props[name] = fieldSchema
+		if isRequired {
+			required = append(required, name)
+		}
+	}
+	schema := Schema{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// fieldSchema applies the doc, openapi and pattern tags of f to the schema of its type.
+func (s *Spec) fieldSchema(f reflect.StructField) (Schema, bool) {
+	base := s.schemaFor(f.Type)
+	schema := Schema{}
+	if ref, ok := base["$ref"]; ok {
+		// siblings of $ref are ignored in OpenAPI 3.0, so wrap it when annotating
+		if f.Tag.Get("doc") == "" && f.Tag.Get("openapi") == "" {
+			return base, false
+		}
+		schema["allOf"] = []interface{}{Schema{"$ref": ref}}
+	} else {
+		for k, v := range base {
+			schema[k] = v
+		}
+	}
+	if d := f.Tag.Get("doc"); d != "" {
+		schema["description"] = d
+	}
+	if p := f.Tag.Get("pattern"); p != "" {
+		schema["pattern"] = p
+	}
+	required := false
+	typ, _ := schema["type"].(string)
+	for _, opt := range strings.Split(f.Tag.Get("openapi"), ",") {
+		key, value, hasValue := strings.Cut(opt, "=")
+		switch {
+		case key == "":
+		case key == "required":
+			required = true
+		case !hasValue:
+			// flags such as nullable, readOnly, writeOnly
+			schema[key] = true
+		case key == "enum":
+			var values []interface{}
+			for _, v := range strings.Split(value, "|") {
+				values = append(values, typedValue(typ, v))
+			}
+			schema["enum"] = values
+		case key == "format":
+			schema["format"] = value
+		case key == "minLength" || key == "maxLength" || key == "minItems" || key == "maxItems":
+			n, _ := strconv.Atoi(value)
+			schema[key] = n
+		case key == "minimum" || key == "maximum":
+			schema[key] = typedValue("number", value)
+		default:
+			// default, example
+			schema[key] = typedValue(typ, value)
+		}
+	}
+	return schema, required
+}
+
+// typedValue converts a tag value to the JSON type of the schema it belongs to.
+func typedValue(typ, v string) interface{} {
+	switch typ {
+	case "integer":
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	case "number":
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,398 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Spec collects operations and components and renders them as an OpenAPI 3.0 document.
+// It is built once at startup and must not be modified while it is being served.
+type Spec struct {
+	Title       string
+	Version     string
+	Description string
+	// ErrorBody describes the body of 4xx and 5xx responses that do not set one, in
+	// ErrorMediaType (application/json by default).
+	ErrorBody      interface{}
+	ErrorMediaType string
+	// AuthScheme names the security scheme required by operations with Auth set.
+	AuthScheme string
+	// AuthResponses are added to operations with Auth set that do not document their
+	// statuses, for answers the authentication middleware gives on its own.
+	AuthResponses []Response
+	// CheckScope, when set, runs before the handler of every operation with Auth
+	// set, after the authentication middleware, and answers requests whose
+	// credentials lack the operation's Scope. A nil error lets the request through.
+	CheckScope func(c *fiber.Ctx, scope string) error
+	// Reject answers requests whose parameters or body do not match their operation:
+	// status is 400, 415 for an undocumented body media type, or 500 if validation
+	// itself failed. Requests are only validated when Reject is set.
+	Reject func(c *fiber.Ctx, status int, message string, fields []FieldError) error
+	// ResponseMismatch is called after a response whose status or JSON body does not
+	// match its operation, e.g. to log it or to fail tests. Responses are only
+	// validated when it is set.
+	ResponseMismatch func(c *fiber.Ctx, fields []FieldError) error
+
+	schemas    map[string]Schema
+	components map[string]map[string]interface{}
+	paths      map[string]map[string]interface{}
+	ignored    map[string]bool
+	middleware map[uintptr]bool
+	extensions map[string]func(Schema) (Schema, error)
+}
+
+// Operation documents one method on one path.
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Auth marks operations that require Spec.AuthScheme.
+	Auth bool
+	// Scope is the permission restricted credentials need for an operation with
+	// Auth set; see Spec.CheckScope.
+	Scope  string
+	Params []Param
+	// Body is a sample value (or a Schema) of the request body. BodyTypes lists its
+	// media types and defaults to application/json. BodyOptional lets requests leave
+	// the body out. BodyStream leaves a body the server streams (fiber.Config
+	// StreamRequestBody) to the handler, unread and unvalidated; other routes read it
+	// up to the app's BodyLimit.
+	Body         interface{}
+	BodyTypes    []string
+	BodyOptional bool
+	BodyStream   bool
+	Responses    []Response
+	// Deprecation marks the operation as deprecated; routes registered through a
+	// Router add the matching response headers.
+	Deprecation *Deprecation
+}
+
+// Param documents a path, query or header parameter. Path parameters missing from
+// Params are added as required strings.
+type Param struct {
+	// Ref names a component parameter; the other fields are ignored when set.
+	Ref         string
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	// Schema is a sample value (or a Schema) of the parameter; strings by default.
+	Schema interface{}
+}
+
+// Response documents one status code of an operation.
+type Response struct {
+	Status      int
+	Description string
+	// Body is a sample value (or a Schema) of the response body. Error statuses
+	// without a body use Spec.ErrorBody.
+	Body interface{}
+	// MediaType of Body, application/json by default.
+	MediaType string
+	// MediaTypes lists further media types Body may be sent in, e.g. formats chosen
+	// by a parameter.
+	MediaTypes []string
+	// Headers names component headers sent with the response.
+	Headers []string
+	// Ref names a component response; the other fields are ignored when set.
+	Ref string
+}
+
+// New returns an empty Spec.
+func New(title, version string) *Spec {
+	return &Spec{
+		Title:      title,
+		Version:    version,
+		schemas:    map[string]Schema{},
+		components: map[string]map[string]interface{}{},
+		paths:      map[string]map[string]interface{}{},
+		ignored:    map[string]bool{},
+		middleware: map[uintptr]bool{},
+		extensions: map[string]func(Schema) (Schema, error){},
+	}
+}
+
+// AddSchema registers the schema of sample v under name, for types that are not
+// referenced by any operation or whose name differs from the Go type.
+func (s *Spec) AddSchema(name string, v interface{}) {
+	if schema, ok := v.(Schema); ok {
+		s.schemas[name] = schema
+		return
+	}
+	s.schemas[name] = s.inlineSchema(indirect(reflect.TypeOf(v)))
+}
+
+// Extend registers fn to complete the schema called name from runtime state, such as
+// database contents, whenever the document is rendered or used for validation. fn
+// receives a shallow copy of the static schema and must not modify nested values.
+func (s *Spec) Extend(name string, fn func(Schema) (Schema, error)) {
+	s.extensions[name] = fn
+}
+
+// schema returns the component schema called name, extended if necessary.
+func (s *Spec) schema(name string) (Schema, error) {
+	base, ok := s.schemas[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q", name)
+	}
+	fn, ok := s.extensions[name]
+	if !ok {
+		return base, nil
+	}
+	cp := make(Schema, len(base))
+	for k, v := range base {
+		cp[k] = v
+	}
+	return fn(cp)
+}
+
+// AddComponent registers a component of the given kind ("parameters", "headers",
+// "responses" or "securitySchemes"). Samples in the "schema" of parameters and
+// headers and in the "body" of responses are converted like operation bodies.
+func (s *Spec) AddComponent(kind, name string, v Schema) {
+	if s.components[kind] == nil {
+		s.components[kind] = map[string]interface{}{}
+	}
+	switch kind {
+	case "parameters", "headers":
+		if sample, ok := v["schema"]; ok {
+			v["schema"] = s.schemaOf(sample)
+		}
+	case "responses":
+		if body, ok := v["body"]; ok {
+			delete(v, "body")
+			v["content"] = Schema{"application/json": Schema{"schema": s.schemaOf(body)}}
+		}
+	}
+	s.components[kind][name] = v
+}
+
+// Ignore excludes every route registered on path, such as a static directory, from
+// Undocumented.
+func (s *Spec) Ignore(path string) {
+	s.ignored[path] = true
+}
+
+// IgnoreMiddleware excludes routes made up only of the given handlers, as registered
+// by Use and Group, from Undocumented.
+func (s *Spec) IgnoreMiddleware(handlers ...fiber.Handler) {
+	for _, h := range handlers {
+		s.middleware[reflect.ValueOf(h).Pointer()] = true
+	}
+}
+
+func (s *Spec) isMiddleware(r *fiber.Route) bool {
+	for _, h := range r.Handlers {
+		if !s.middleware[reflect.ValueOf(h).Pointer()] {
+			return false
+		}
+	}
+	return len(r.Handlers) > 0
+}
+
+// Add documents method on path, a Fiber route path such as "/users/:id".
+func (s *Spec) Add(method, path string, op Operation) {
+	oasPath := openAPIPath(path)
+	if s.paths[oasPath] == nil {
+		s.paths[oasPath] = map[string]interface{}{}
+	}
+	s.paths[oasPath][strings.ToLower(method)] = s.operation(method, path, op)
+}
+
+var pathParamRe = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// openAPIPath converts Fiber path parameters (":id") to OpenAPI templates ("{id}").
+func openAPIPath(path string) string {
+	return pathParamRe.ReplaceAllString(path, "{$1}")
+}
+
+func (s *Spec) operation(method, path string, op Operation) Schema {
+	out := Schema{"summary": op.Summary}
+	description := op.Description
+	if op.Auth && op.Scope != "" {
+		description = strings.TrimSpace(description + "\n\nScope: `" + op.Scope + "`.")
+	}
+	if d := op.Deprecation; d != nil {
+		out["deprecated"] = true
+		description = strings.TrimSpace(d.describe(method) + "\n\n" + description)
+	}
+	if description != "" {
+		out["description"] = description
+	}
+	if len(op.Tags) > 0 {
+		out["tags"] = op.Tags
+	}
+	if op.Auth && s.AuthScheme != "" {
+		out["security"] = []Schema{{s.AuthScheme: []string{}}}
+	}
+
+	params := op.Params
+	for _, m := range pathParamRe.FindAllStringSubmatch(path, -1) {
+		declared := false
+		for _, p := range op.Params {
+			declared = declared || (p.In == "path" && p.Name == m[1])
+		}
+		if !declared {
+			params = append([]Param{{Name: m[1], In: "path"}}, params...)
+		}
+	}
+	if len(params) > 0 {
+		var list []Schema
+		for _, p := range params {
+			list = append(list, s.param(p))
+		}
+		out["parameters"] = list
+	}
+
+	if op.Body != nil {
+		types := op.BodyTypes
+		if len(types) == 0 {
+			types = []string{fiber.MIMEApplicationJSON}
+		}
+		content := Schema{}
+		for _, t := range types {
+			content[t] = Schema{"schema": s.schemaOf(op.Body)}
+		}
+		out["requestBody"] = Schema{"required": !op.BodyOptional, "content": content}
+	}
+
+	responses := Schema{}
+	for _, r := range op.Responses {
+		responses[strconv.Itoa(r.Status)] = s.response(r)
+	}
+	if op.Auth {
+		for _, r := range s.AuthResponses {
+			s.addResponse(responses, r)
+		}
+	}
+	// bodies over the limit are refused before the handlers run (see readBody)
+	if op.Body != nil {
+		s.addResponse(responses, Response{Status: http.StatusRequestEntityTooLarge, Description: "request body too large"})
+	}
+	// answers the request validator may give on its own
+	if s.Reject != nil {
+		if len(params) > 0 || op.Body != nil {
+			s.addResponse(responses, Response{Status: http.StatusBadRequest, Description: "invalid request"})
+		}
+		if op.Body != nil {
+			s.addResponse(responses, Response{Status: http.StatusUnsupportedMediaType, Description: "unsupported content type"})
+		}
+	}
+	out["responses"] = responses
+	return out
+}
+
+// addResponse adds r to responses unless its status is already documented.
+func (s *Spec) addResponse(responses Schema, r Response) {
+	if _, ok := responses[strconv.Itoa(r.Status)]; !ok {
+		responses[strconv.Itoa(r.Status)] = s.response(r)
+	}
+}
+
+func (s *Spec) param(p Param) Schema {
+	if p.Ref != "" {
+		return Schema{"$ref": "#/components/parameters/" + p.Ref}
+	}
+	out := Schema{"name": p.Name, "in": p.In, "required": p.Required || p.In == "path"}
+	if p.Description != "" {
+		out["description"] = p.Description
+	}
+	if p.Schema != nil {
+		out["schema"] = s.schemaOf(p.Schema)
+	} else {
+		out["schema"] = Schema{"type": "string"}
+	}
+	return out
+}
+
+func (s *Spec) response(r Response) Schema {
+	if r.Ref != "" {
+		return Schema{"$ref": "#/components/responses/" + r.Ref}
+	}
+	description := r.Description
+	if description == "" {
+		description = http.StatusText(r.Status)
+	}
+	out := Schema{"description": description}
+	if len(r.Headers) > 0 {
+		headers := Schema{}
+		for _, h := range r.Headers {
+			headers[h] = Schema{"$ref": "#/components/headers/" + h}
+		}
+		out["headers"] = headers
+	}
+	body, mediaType := r.Body, r.MediaType
+	if body == nil && r.Status >= 400 {
+		body, mediaType = s.ErrorBody, s.ErrorMediaType
+	}
+	if body != nil {
+		if mediaType == "" {
+			mediaType = fiber.MIMEApplicationJSON
+		}
+		content := Schema{mediaType: Schema{"schema": s.schemaOf(body)}}
+		for _, t := range r.MediaTypes {
+			content[t] = Schema{"schema": s.schemaOf(body)}
+		}
+		out["content"] = content
+	}
+	return out
+}
+
+// MarshalJSON renders the OpenAPI document.
+func (s *Spec) MarshalJSON() ([]byte, error) {
+	info := Schema{"title": s.Title, "version": s.Version}
+	if s.Description != "" {
+		info["description"] = s.Description
+	}
+	schemas := make(Schema, len(s.schemas))
+	for name := range s.schemas {
+		schema, err := s.schema(name)
+		if err != nil {
+			return nil, err
+		}
+		schemas[name] = schema
+	}
+	components := Schema{"schemas": schemas}
+	for kind, c := range s.components {
+		components[kind] = c
+	}
+	return json.Marshal(Schema{
+		"openapi":    "3.0.3",
+		"info":       info,
+		"paths":      s.paths,
+		"components": components,
+	})
+}
+
+// Undocumented lists the routes registered on app ("GET /profile") that have no
+// operation in s. HEAD routes, which Fiber adds for every GET, middleware and
+// ignored paths are skipped.
+func (s *Spec) Undocumented(app *fiber.App) []string {
+	seen := map[string]bool{}
+	var missing []string
+	for _, routes := range app.Stack() {
+		for _, r := range routes {
+			if r.Method == fiber.MethodHead || s.ignored[r.Path] || s.isMiddleware(r) {
+				continue
+			}
+			route := r.Method + " " + r.Path
+			if seen[route] {
+				continue
+			}
+			seen[route] = true
+			if _, ok := s.paths[openAPIPath(r.Path)][strings.ToLower(r.Method)]; !ok {
+				missing = append(missing, route)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,378 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError describes one invalid value of a request or response.
+type FieldError struct {
+	In      string `json:"in" openapi:"enum=body|query|path|header|response"`
+	Field   string `json:"field,omitempty" doc:"path of the value, e.g. attributes.team or options[0]; empty for the whole body"`
+	Message string `json:"message"`
+
+	format string
+	args   []interface{}
+}
+
+// Format returns the format and arguments Message was built from, for callers that
+// translate messages. Errors built without a format return Message itself.
+func (f FieldError) Format() (string, []interface{}) {
+	if f.format == "" {
+		return f.Message, nil
+	}
+	return f.format, f.args
+}
+
+// validator checks values against schemas of one Spec. Component schemas are
+// resolved once per validator, so a validator must only be used for one request.
+type validator struct {
+	spec     *Spec
+	in       string
+	resolved map[string]Schema
+	errs     []FieldError
+}
+
+func (s *Spec) newValidator(in string) *validator {
+	return &validator{spec: s, in: in, resolved: map[string]Schema{}}
+}
+
+func (v *validator) fail(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{In: v.in, Field: field, Message: fmt.Sprintf(format, args...), format: format, args: args})
+}
+
+// resolve follows a $ref to a component schema.
+func (v *validator) resolve(schema Schema) (Schema, error) {
+	ref, ok := schema["$ref"].(string)
+	if !ok {
+		return schema, nil
+	}
+	name := strings.TrimPrefix(ref, "#/components/schemas/")
+	if r, ok := v.resolved[name]; ok {
+		return r, nil
+	}
+	r, err := v.spec.schema(name)
+	if err != nil {
+		return nil, err
+	}
+	v.resolved[name] = r
+	return r, nil
+}
+
+// value checks a decoded JSON value (as produced by a json.Decoder with UseNumber)
+// against schema. Problems are collected in v.errs; the error is only set when the
+// schema itself cannot be resolved.
+func (v *validator) value(schema Schema, value interface{}, field string) error {
+	schema, err := v.resolve(schema)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		if schema["nullable"] != true && (schema["type"] != nil || schema["allOf"] != nil) {
+			v.fail(field, "must not be null")
+		}
+		return nil
+	}
+	for _, sub := range asList(schema["allOf"]) {
+		if s, ok := asSchema(sub); ok {
+			if err := v.value(s, value, field); err != nil {
+				return err
+			}
+		}
+	}
+	if enum := asList(schema["enum"]); len(enum) > 0 && !inEnum(enum, value) {
+		v.fail(field, "must be one of: %s", joinValues(enum))
+		return nil
+	}
+
+	switch schema["type"] {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			v.fail(field, "must be a string")
+			return nil
+		}
+		v.checkString(schema, s, field)
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			v.fail(field, "must be an integer")
+			return nil
+		}
+		f, err := n.Float64()
+		if err != nil || f != math.Trunc(f) {
+			v.fail(field, "must be an integer")
+			return nil
+		}
+		v.checkNumber(schema, f, field)
+	case "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			v.fail(field, "must be a number")
+			return nil
+		}
+		f, err := n.Float64()
+		if err != nil {
+			v.fail(field, "must be a number")
+			return nil
+		}
+		v.checkNumber(schema, f, field)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(field, "must be a boolean")
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.fail(field, "must be an array")
+			return nil
+		}
+		if n, ok := asFloat(schema["minItems"]); ok && float64(len(items)) < n {
+			v.fail(field, "must have at least %v items", n)
+		}
+		if n, ok := asFloat(schema["maxItems"]); ok && float64(len(items)) > n {
+			v.fail(field, "must have at most %v items", n)
+		}
+		if itemSchema, ok := asSchema(schema["items"]); ok {
+			for i, item := range items {
+				if err := v.value(itemSchema, item, fmt.Sprintf("%s[%d]", field, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(field, "must be an object")
+			return nil
+		}
+		return v.object(schema, obj, field)
+	}
+	return nil
+}
+
+func (v *validator) object(schema Schema, obj map[string]interface{}, field string) error {
+	props, _ := asSchema(schema["properties"])
+	for _, name := range asStrings(schema["required"]) {
+		if _, ok := obj[name]; !ok {
+			v.fail(join(field, name), "is required")
+		}
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if p, ok := asSchema(props[k]); ok {
+			if err := v.value(p, obj[k], join(field, k)); err != nil {
+				return err
+			}
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				v.fail(join(field, k), "unknown field")
+			}
+		default:
+			if p, ok := asSchema(extra); ok {
+				if err := v.value(p, obj[k], join(field, k)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (v *validator) checkString(schema Schema, s, field string) {
+	n := float64(utf8.RuneCountInString(s))
+	if min, ok := asFloat(schema["minLength"]); ok && n < min {
+		if min == 1 {
+			v.fail(field, "must not be empty")
+		} else {
+			v.fail(field, "must be at least %v characters", min)
+		}
+	}
+	if max, ok := asFloat(schema["maxLength"]); ok && n > max {
+		v.fail(field, "must be at most %v characters", max)
+	}
+	if p, ok := schema["pattern"].(string); ok && p != "" {
+		if re, err := compilePattern(p); err == nil && !re.MatchString(s) {
+			v.fail(field, "must match %s", p)
+		}
+	}
+	switch schema["format"] {
+	case "email":
+		if a, err := mail.ParseAddress(s); err != nil || a.Address != s {
+			v.fail(field, "must be an email address")
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			v.fail(field, "must be a date (YYYY-MM-DD)")
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			v.fail(field, "must be an RFC 3339 date-time")
+		}
+	}
+}
+
+func (v *validator) checkNumber(schema Schema, f float64, field string) {
+	if min, ok := asFloat(schema["minimum"]); ok && f < min {
+		v.fail(field, "must be at least %v", min)
+	}
+	if max, ok := asFloat(schema["maximum"]); ok && f > max {
+		v.fail(field, "must be at most %v", max)
+	}
+}
+
+// param converts a path, query or header parameter to the type of its schema and
+// validates it.
+func (v *validator) param(schema Schema, raw, name string) error {
+	schema, err := v.resolve(schema)
+	if err != nil {
+		return err
+	}
+	var value interface{} = raw
+	switch schema["type"] {
+	case "integer":
+		if _, err := strconv.ParseInt(raw, 10, 64); err != nil {
+			v.fail(name, "must be an integer")
+			return nil
+		}
+		value = json.Number(raw)
+	case "number":
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			v.fail(name, "must be a number")
+			return nil
+		}
+		value = json.Number(raw)
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			v.fail(name, "must be a boolean")
+			return nil
+		}
+		value = b
+	}
+	return v.value(schema, value, name)
+}
+
+var (
+	patternsMu sync.Mutex
+	patterns   = map[string]*regexp.Regexp{}
+)
+
+func compilePattern(p string) (*regexp.Regexp, error) {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+	if re, ok := patterns[p]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, err
+	}
+	patterns[p] = re
+	return re, nil
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+// The helpers below accept both the types produced by reflection (Schema, []string,
+// int) and those of hand-written or decoded schemas (map[string]interface{},
+// []interface{}, float64).
+
+func asSchema(x interface{}) (Schema, bool) {
+	switch s := x.(type) {
+	case Schema:
+		return s, true
+	case map[string]interface{}:
+		return Schema(s), true
+	}
+	return nil, false
+}
+
+func asList(x interface{}) []interface{} {
+	switch l := x.(type) {
+	case []interface{}:
+		return l
+	case []string:
+		out := make([]interface{}, len(l))
+		for i, s := range l {
+			out[i] = s
+		}
+		return out
+	case []Schema:
+		out := make([]interface{}, len(l))
+		for i, s := range l {
+			out[i] = s
+		}
+		return out
+	}
+	return nil
+}
+
+func asStrings(x interface{}) []string {
+	var out []string
+	for _, v := range asList(x) {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func asFloat(x interface{}) (float64, bool) {
+	switch n := x.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if a, ok := asFloat(e); ok {
+			if b, ok := asFloat(value); ok && a == b {
+				return true
+			}
+			continue
+		}
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
+
+func joinValues(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, ", ")
+}
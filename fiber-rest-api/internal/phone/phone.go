@@ -0,0 +1,103 @@
+// Package phone normalizes user-entered telephone numbers to E.164.
+package phone
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// DefaultRegion is used for numbers written in national format when no region is given.
+const DefaultRegion = "TH"
+
+// Region is the region assumed for numbers entered in national format:
+// PHONE_DEFAULT_REGION, or DefaultRegion.
+func Region() string {
+	if r := os.Getenv("PHONE_DEFAULT_REGION"); r != "" {
+		return r
+	}
+	return DefaultRegion
+}
+
+var (
+	ErrEmpty         = errors.New("phone number is empty")
+	ErrInvalidChars  = errors.New("phone contains invalid characters")
+	ErrUnknownRegion = errors.New("unknown phone region")
+	ErrInvalidLength = errors.New("phone number has an invalid length")
+)
+
+// region describes how national numbers of a country are written.
+type region struct {
+	countryCode string
+	trunkPrefix string // dropped when converting a national number, e.g. "0" in Thailand
+	minDigits   int    // national significant number length bounds
+	maxDigits   int
+}
+
+var regions = map[string]region{
+	"TH": {countryCode: "66", trunkPrefix: "0", minDigits: 8, maxDigits: 9},
+	"US": {countryCode: "1", trunkPrefix: "1", minDigits: 10, maxDigits: 10},
+	"GB": {countryCode: "44", trunkPrefix: "0", minDigits: 9, maxDigits: 10},
+	"SG": {countryCode: "65", minDigits: 8, maxDigits: 8},
+	"MY": {countryCode: "60", trunkPrefix: "0", minDigits: 8, maxDigits: 10},
+	"JP": {countryCode: "81", trunkPrefix: "0", minDigits: 9, maxDigits: 10},
+}
+
+// Normalize converts raw into E.164 form ("+66812345678"). Numbers starting with
+// "+" or the international prefix "00" are taken as international; anything else
+// is read as a national number of defaultRegion. Spaces, dashes, dots and
+// brackets are ignored.
+func Normalize(raw, defaultRegion string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", ErrEmpty
+	}
+
+	var digits strings.Builder
+	international := false
+	for i, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			international = true
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			// formatting only
+		default:
+			return "", ErrInvalidChars
+		}
+	}
+	number := digits.String()
+	if !international && strings.HasPrefix(number, "00") {
+		international = true
+		number = number[2:]
+	}
+
+	if international {
+		// E.164 allows at most 15 digits including the country code
+		if len(number) < 8 || len(number) > 15 {
+			return "", ErrInvalidLength
+		}
+		for _, reg := range regions {
+			if strings.HasPrefix(number, reg.countryCode) {
+				national := strings.TrimPrefix(number, reg.countryCode)
+				if len(national) < reg.minDigits || len(national) > reg.maxDigits {
+					return "", ErrInvalidLength
+				}
+			}
+		}
+		return "+" + number, nil
+	}
+
+	reg, ok := regions[strings.ToUpper(defaultRegion)]
+	if !ok {
+		return "", ErrUnknownRegion
+	}
+	if reg.trunkPrefix != "" {
+		number = strings.TrimPrefix(number, reg.trunkPrefix)
+	}
+	if len(number) < reg.minDigits || len(number) > reg.maxDigits {
+		return "", ErrInvalidLength
+	}
+	return "+" + reg.countryCode + number, nil
+}
@@ -0,0 +1,50 @@
+package phone
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw, region string
+		want        string
+		err         error
+	}{
+		{"081-234 5678", "TH", "+66812345678", nil},
+		{"0812345678", "th", "+66812345678", nil},
+		{"(02) 123-4567", "TH", "+6621234567", nil},
+		{"+66 81 234 5678", "TH", "+66812345678", nil},
+		{"0066812345678", "TH", "+66812345678", nil},
+		{"+66812345678", "US", "+66812345678", nil},
+		{"(415) 555-2671", "US", "+14155552671", nil},
+		{"07911 123456", "GB", "+447911123456", nil},
+		{"8123 4567", "SG", "+6581234567", nil},
+		{"  081.234.5678 ", "TH", "+66812345678", nil},
+		{"", "TH", "", ErrEmpty},
+		{"   ", "TH", "", ErrEmpty},
+		{"081-234-567x", "TH", "", ErrInvalidChars},
+		{"66+812345678", "TH", "", ErrInvalidChars},
+		{"0812", "TH", "", ErrInvalidLength},
+		{"08123456789", "TH", "", ErrInvalidLength},
+		{"+6681234", "TH", "", ErrInvalidLength},
+		{"+66 8123 456 789 0", "TH", "", ErrInvalidLength},
+		{"+1234567890123456", "TH", "", ErrInvalidLength},
+		{"0812345678", "XX", "", ErrUnknownRegion},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw, tt.region)
+		if got != tt.want || err != tt.err {
+			t.Errorf("Normalize(%q, %q) = %q, %v; want %q, %v", tt.raw, tt.region, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// Numbers written differently normalize to the same value, which is what lets the
+// profile compare them.
+func TestNormalizeSameNumber(t *testing.T) {
+	forms := []string{"081-234 5678", "+66812345678", "0066 81 234 5678", "(081) 234-5678"}
+	for _, f := range forms {
+		got, err := Normalize(f, DefaultRegion)
+		if err != nil || got != "+66812345678" {
+			t.Errorf("Normalize(%q) = %q, %v; want +66812345678", f, got, err)
+		}
+	}
+}
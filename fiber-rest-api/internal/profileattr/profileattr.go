@@ -0,0 +1,286 @@
+// Package profileattr implements admin-defined custom profile attributes: their
+// definitions, validation of submitted values and their JSON Schema.
+package profileattr
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Attribute types.
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeDate    = "date" // YYYY-MM-DD
+	TypeEnum    = "enum"
+)
+
+// Visibility levels.
+const (
+	// VisibilityPrivate values are visible to and editable by the owner and admins.
+	VisibilityPrivate = "private"
+	// VisibilityPublic values may additionally be shown to other users.
+	VisibilityPublic = "public"
+	// VisibilityAdmin values are visible to and editable by admins only.
+	VisibilityAdmin = "admin"
+)
+
+var keyRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// reservedKeys are names of built-in profile fields that attributes may not shadow.
+var reservedKeys = map[string]bool{
+	"id": true, "email": true, "username": true, "first_name": true, "last_name": true, "phone": true,
+	"phone_display": true, "phone_verified": true, "avatar": true, "attributes": true,
+}
+
+// Definition describes a custom profile attribute.
+type Definition struct {
+	Key        string   `json:"key" openapi:"required" pattern:"^[a-z][a-z0-9_]{0,49}$"`
+	Label      string   `json:"label"`
+	Type       string   `json:"type" openapi:"required,enum=string|integer|number|boolean|date|enum"`
+	Required   bool     `json:"required"`
+	Min        *float64 `json:"min,omitempty" doc:"minimum length (string) or value (integer/number)"`
+	Max        *float64 `json:"max,omitempty" doc:"maximum length (string) or value (integer/number)"`
+	Pattern    string   `json:"pattern,omitempty" doc:"regular expression for string values"`
+	Options    []string `json:"options,omitempty" doc:"allowed values for enum"`
+	Visibility string   `json:"visibility" openapi:"enum=private|public|admin,default=private"`
+}
+
+// OpenAPIName is the name of Definition in the API documentation.
+func (Definition) OpenAPIName() string {
+	return "AttributeDefinition"
+}
+
+// Check validates the definition itself.
+func (d *Definition) Check() error {
+	if !keyRe.MatchString(d.Key) {
+		return errors.New("key must be lowercase letters, digits and underscores, starting with a letter (max 50)")
+	}
+	if reservedKeys[d.Key] {
+		return fmt.Errorf("key %q is reserved", d.Key)
+	}
+	switch d.Type {
+	case TypeString, TypeInteger, TypeNumber, TypeBoolean, TypeDate:
+	case TypeEnum:
+		if len(d.Options) == 0 {
+			return errors.New("enum attributes need options")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", d.Type)
+	}
+	if d.Type != TypeEnum && len(d.Options) > 0 {
+		return errors.New("options are only allowed for enum attributes")
+	}
+	if d.Pattern != "" {
+		if d.Type != TypeString {
+			return errors.New("pattern is only allowed for string attributes")
+		}
+		if _, err := regexp.Compile(d.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	}
+	if (d.Min != nil || d.Max != nil) && d.Type != TypeString && d.Type != TypeInteger && d.Type != TypeNumber {
+		return errors.New("min/max are only allowed for string, integer and number attributes")
+	}
+	if d.Min != nil && d.Max != nil && *d.Min > *d.Max {
+		return errors.New("min must not exceed max")
+	}
+	switch d.Visibility {
+	case "":
+		d.Visibility = VisibilityPrivate
+	case VisibilityPrivate, VisibilityPublic, VisibilityAdmin:
+	default:
+		return fmt.Errorf("unknown visibility %q", d.Visibility)
+	}
+	if d.Label == "" {
+		d.Label = d.Key
+	}
+	return nil
+}
+
+// VisibleTo reports whether values of the attribute are shown to (and editable by)
+// the profile owner, as opposed to admins only.
+func (d Definition) VisibleTo(admin bool) bool {
+	return admin || d.Visibility != VisibilityAdmin
+}
+
+// Validate checks a submitted JSON value against the definition and returns it in
+// canonical JSON form for storage.
+func (d Definition) Validate(raw json.RawMessage) (string, error) {
+	switch d.Type {
+	case TypeString, TypeDate, TypeEnum:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", fmt.Errorf("%s must be a string", d.Key)
+		}
+		s = strings.TrimSpace(s)
+		switch d.Type {
+		case TypeDate:
+			if _, err := time.Parse("2006-01-02", s); err != nil {
+				return "", fmt.Errorf("%s must be a date (YYYY-MM-DD)", d.Key)
+			}
+		case TypeEnum:
+			if !contains(d.Options, s) {
+				return "", fmt.Errorf("%s must be one of: %s", d.Key, strings.Join(d.Options, ", "))
+			}
+		default:
+			n := float64(utf8.RuneCountInString(s))
+			if d.Min != nil && n < *d.Min {
+				return "", fmt.Errorf("%s too short (min %g)", d.Key, *d.Min)
+			}
+			if d.Max != nil && n > *d.Max {
+				return "", fmt.Errorf("%s too long (max %g)", d.Key, *d.Max)
+			}
+			if d.Pattern != "" && !regexp.MustCompile(d.Pattern).MatchString(s) {
+				return "", fmt.Errorf("%s has an invalid format", d.Key)
+			}
+		}
+		b, _ := json.Marshal(s)
+		return string(b), nil
+	case TypeInteger, TypeNumber:
+		var n float64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return "", fmt.Errorf("%s must be a %s", d.Key, d.Type)
+		}
+		if d.Type == TypeInteger && n != float64(int64(n)) {
+			return "", fmt.Errorf("%s must be an integer", d.Key)
+		}
+		if d.Min != nil && n < *d.Min {
+			return "", fmt.Errorf("%s must be at least %g", d.Key, *d.Min)
+		}
+		if d.Max != nil && n > *d.Max {
+			return "", fmt.Errorf("%s must be at most %g", d.Key, *d.Max)
+		}
+		b, _ := json.Marshal(n)
+		return string(b), nil
+	case TypeBoolean:
+		var v bool
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return "", fmt.Errorf("%s must be a boolean", d.Key)
+		}
+		b, _ := json.Marshal(v)
+		return string(b), nil
+	}
+	return "", fmt.Errorf("%s has unknown type %q", d.Key, d.Type)
+}
+
+// Schema returns the OpenAPI (JSON Schema) description of the attribute's values.
+func (d Definition) Schema() map[string]interface{} {
+	s := map[string]interface{}{"title": d.Label}
+	switch d.Type {
+	case TypeString:
+		s["type"] = "string"
+		if d.Min != nil {
+			s["minLength"] = int(*d.Min)
+		}
+		if d.Max != nil {
+			s["maxLength"] = int(*d.Max)
+		}
+		if d.Pattern != "" {
+			s["pattern"] = d.Pattern
+		}
+	case TypeInteger, TypeNumber:
+		s["type"] = d.Type
+		if d.Min != nil {
+			s["minimum"] = *d.Min
+		}
+		if d.Max != nil {
+			s["maximum"] = *d.Max
+		}
+	case TypeBoolean:
+		s["type"] = "boolean"
+	case TypeDate:
+		s["type"] = "string"
+		s["format"] = "date"
+	case TypeEnum:
+		s["type"] = "string"
+		s["enum"] = d.Options
+	}
+	// vendor extension so clients (e.g. the profile UI) can tell which
+	// attributes the owner may edit
+	s["x-visibility"] = d.Visibility
+	return s
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// queryer is satisfied by *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// LoadAll returns every attribute definition ordered by key.
+func LoadAll(q queryer) ([]Definition, error) {
+	rows, err := q.Query("SELECT key, label, type, required, min, max, pattern, options, visibility FROM profile_attributes ORDER BY key")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var defs []Definition
+	for rows.Next() {
+		var d Definition
+		var min, max sql.NullFloat64
+		var pattern, options sql.NullString
+		if err := rows.Scan(&d.Key, &d.Label, &d.Type, &d.Required, &min, &max, &pattern, &options, &d.Visibility); err != nil {
+			return nil, err
+		}
+		if min.Valid {
+			d.Min = &min.Float64
+		}
+		if max.Valid {
+			d.Max = &max.Float64
+		}
+		d.Pattern = pattern.String
+		if options.String != "" {
+			if err := json.Unmarshal([]byte(options.String), &d.Options); err != nil {
+				return nil, err
+			}
+		}
+		defs = append(defs, d)
+	}
+	return defs, rows.Err()
+}
+
+// LoadValues returns the stored attribute values of a user keyed by attribute key.
+func LoadValues(q queryer, uid int) (map[string]json.RawMessage, error) {
+	rows, err := q.Query("SELECT key, value FROM profile_attribute_values WHERE user_id = ?", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string]json.RawMessage{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[key] = json.RawMessage(value)
+	}
+	return values, rows.Err()
+}
+
+// NullableOptions encodes enum options for storage.
+func NullableOptions(options []string) interface{} {
+	if len(options) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(options)
+	return string(b)
+}
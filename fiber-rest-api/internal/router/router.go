@@ -1,29 +1,154 @@
 package router
 
 import (
+	"time"
+
+	"fiber-rest-api/internal/apperr"
 	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/openapi"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
 
+// legacyRoutes deprecates the unversioned paths of the version 1 routes.
+var legacyRoutes = openapi.Deprecation{
+	Since:     time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC),
+	Sunset:    time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC),
+	Successor: handlers.V1,
+}
+
+// SetupRoutes registers every route on app and documents it in handlers.APISpec.
 func SetupRoutes(app *fiber.App) {
-	app.Get("/", handlers.GetRoot)
+	spec := newSpec()
+	handlers.APISpec = spec
+	r := openapi.NewRouter(app, spec)
+
+	// every response carries X-Request-ID, echoed from the request when the client sent one
+	r.Use(requestid.New(requestid.Config{ContextKey: apperr.RequestIDKey}))
 
-	// auth endpoints
-	app.Post("/auth/register", handlers.Register)
-	app.Post("/auth/login", handlers.Login)
+	r.Get("/", openapi.Operation{
+		Summary:   "Health check",
+		Responses: []openapi.Response{{Status: 200, Description: "server is up", Body: handlers.MessageResponse{}}},
+	}, handlers.GetRoot)
+
+	// The API lives under /api/v1. Its routes are also served at the root, where they
+	// were before versioning, as deprecated aliases until legacyRoutes.Sunset.
+	v1 := apiV1()
+	r.Group(handlers.V1).Mount(v1)
+	r.Deprecated(legacyRoutes).Mount(v1)
 
-	// profile endpoints (protected)
-	app.Get("/profile", handlers.AuthRequired, handlers.GetProfile)
-	app.Put("/profile", handlers.AuthRequired, handlers.UpdateProfile)
-	app.Post("/profile/avatar", handlers.AuthRequired, handlers.UploadAvatar)
 	// minimal UI to edit profile
-	app.Get("/profile/ui", handlers.ProfileUI)
+	r.Get("/profile/ui", openapi.Operation{
+		Summary:   "Profile editor",
+		Tags:      []string{"ui"},
+		Responses: []openapi.Response{{Status: 200, Description: "HTML page", Body: html, MediaType: fiber.MIMETextHTML}},
+	}, handlers.ProfileUI)
+
+	// OpenID Connect provider for the apps registered in oauth_clients. Not versioned:
+	// clients find the endpoints in the discovery document.
+	r.Get("/.well-known/openid-configuration", openapi.Operation{
+		Summary:   "OpenID Connect provider metadata",
+		Tags:      []string{"oidc"},
+		Responses: []openapi.Response{{Status: 200, Description: "discovery document", Body: handlers.OpenIDConfiguration{}}},
+	}, handlers.OpenIDConfigurationHandler)
+	r.Get("/oauth/jwks", openapi.Operation{
+		Summary:     "Keys that sign ID and access tokens",
+		Description: "Cacheable for an hour. Keys stay published after rotation while tokens they signed may be in use.",
+		Tags:        []string{"oidc"},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "JSON Web Key Set", Body: handlers.JWKSet{}},
+			serverError,
+		},
+	}, handlers.JWKS)
+	authorizeResponses := []openapi.Response{
+		{Status: 200, Description: "sign-in, sign-in code or consent page", Body: html, MediaType: fiber.MIMETextHTML},
+		{Status: 302, Description: "back to the client's redirect_uri with code and state, or error and error_description", Headers: []string{"Location"}},
+		{Status: 400, Description: "page explaining that the client or redirect_uri is unknown, or that the form expired", Body: html, MediaType: fiber.MIMETextHTML},
+		{Status: 401, Description: "sign-in page: wrong email or password, or sign-in code page: wrong or expired code", Body: html, MediaType: fiber.MIMETextHTML},
+		{Status: 403, Description: "sign-in page: account disabled", Body: html, MediaType: fiber.MIMETextHTML},
+		{Status: 429, Description: "sign-in code page: a code was emailed less than a minute ago", Body: html, MediaType: fiber.MIMETextHTML},
+		serverError,
+	}
+	r.Get("/oauth/authorize", openapi.Operation{
+		Summary:     "Authorization endpoint",
+		Description: "Authorization code flow of OpenID Connect Core 1.0. Signs the user in on its own page unless they are already, asks for consent unless they gave it or the client is trusted, then redirects to redirect_uri. Errors about the client or redirect_uri are shown to the user; the others are sent to the client.",
+		Tags:        []string{"oidc"},
+		Params: []openapi.Param{
+			{Name: "client_id", In: "query"},
+			{Name: "redirect_uri", In: "query", Description: "one of the client's registered redirect URIs, compared exactly"},
+			{Name: "response_type", In: "query", Description: "code; other values are sent back to the client as unsupported_response_type"},
+			{Name: "scope", In: "query", Description: "space-separated; must include openid. profile, email and phone add claims; others are ignored"},
+			{Name: "state", In: "query"},
+			{Name: "nonce", In: "query", Description: "copied to the ID token"},
+			{Name: "code_challenge", In: "query", Description: "PKCE (RFC 7636); required for public clients"},
+			{Name: "code_challenge_method", In: "query", Description: "S256; other values are sent back to the client as invalid_request"},
+			{Name: "prompt", In: "query", Description: "none, or login and/or consent"},
+			{Name: "max_age", In: "query", Description: "seconds since the user last entered their password, beyond which they must again"},
+			{Name: "login_hint", In: "query", Description: "email address to pre-fill"},
+		},
+		Responses: authorizeResponses,
+	}, handlers.Authorize)
+	r.Post("/oauth/authorize", openapi.Operation{
+		Summary:     "Submit the sign-in or consent page",
+		Description: "The forms of the pages served by GET /oauth/authorize post the parameters of the authorization request back with action (login, send_code, code, allow or deny) and csrf_token, which must match the op_csrf cookie.",
+		Tags:        []string{"oidc"},
+		Body:        openapi.Schema{"type": "object"},
+		BodyTypes:   []string{fiber.MIMEApplicationForm},
+		Responses:   authorizeResponses,
+	}, handlers.Authorize)
+	r.Post("/oauth/token", openapi.Operation{
+		Summary:     "Token endpoint",
+		Description: "Redeems an authorization code for an RS256 ID token and an access token to /oauth/userinfo, both valid for an hour. Confidential clients authenticate with HTTP Basic or client_id and client_secret in the body; public clients send client_id and the PKCE code_verifier.",
+		Tags:        []string{"oidc"},
+		Body:        handlers.TokenRequest{},
+		BodyTypes:   []string{fiber.MIMEApplicationForm},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "tokens", Body: handlers.OAuthToken{}},
+			{Status: 400, Description: "invalid, expired or used code, wrong redirect_uri or code_verifier", Body: handlers.OAuthError{}},
+			{Status: 401, Description: "unknown client or wrong secret", Body: handlers.OAuthError{}},
+			serverError,
+		},
+	}, handlers.Token)
+	userinfo := openapi.Operation{
+		Summary:     "Claims about the signed-in user",
+		Description: "Takes an access token from /oauth/token as bearer token. The claims depend on the scopes the user allowed. Tokens are revoked when the account is disabled or closed, its password changes, the client is removed or the user revokes its consent.",
+		Tags:        []string{"oidc"},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "claims", Body: handlers.UserInfo{}},
+			{Status: 401, Description: "missing, invalid, expired or revoked access token", Body: handlers.OAuthError{}},
+			serverError,
+		},
+	}
+	r.Get("/oauth/userinfo", userinfo, handlers.Userinfo)
+	r.Post("/oauth/userinfo", userinfo, handlers.Userinfo)
 
 	// serve uploaded avatars
-	app.Static("/uploads", "./uploads")
+	r.Static("/uploads", "./uploads")
 
 	// swagger
-	app.Get("/docs/swagger.json", handlers.SwaggerJSON)
-	app.Get("/docs", handlers.SwaggerUI)
+	r.Get("/docs/swagger.json", openapi.Operation{
+		Summary: "This document",
+		Tags:    []string{"ui"},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "OpenAPI 3.0 document", Body: openapi.Schema{"type": "object"}},
+			serverError,
+		},
+	}, handlers.SwaggerJSON)
+	r.Get("/docs", openapi.Operation{
+		Summary:   "Swagger UI",
+		Tags:      []string{"ui"},
+		Responses: []openapi.Response{{Status: 200, Description: "HTML page", Body: html, MediaType: fiber.MIMETextHTML}},
+	}, handlers.SwaggerUI)
+	r.Get("/docs/assets/:name", openapi.Operation{
+		Summary: "Swagger UI asset",
+		Tags:    []string{"ui"},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "script, stylesheet or icon, cacheable for a year", Body: binary, MediaType: "*/*"},
+			{Status: 404, Description: "asset not found"},
+		},
+	}, handlers.SwaggerAsset)
+
+	// must stay last: answers unmatched requests with a problem document
+	r.Use(apperr.NoRoute)
 }
@@ -0,0 +1,19 @@
+package router_test
+
+import (
+	"testing"
+
+	"fiber-rest-api/internal/router"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every route must be in the OpenAPI document. Routes are only registered, never
+// served, so no database is needed.
+func TestSpecCoversRoutes(t *testing.T) {
+	app := fiber.New()
+	router.SetupRoutes(app)
+	if err := router.CheckSpec(app); err != nil {
+		t.Fatal(err)
+	}
+}
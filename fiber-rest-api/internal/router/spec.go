@@ -0,0 +1,113 @@
+package router
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"fiber-rest-api/internal/apikeys"
+	"fiber-rest-api/internal/apperr"
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/openapi"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// responses and parameters shared by many operations
+var (
+	unauthorized         = openapi.Response{Status: fiber.StatusUnauthorized, Description: "unauthorized"}
+	forbidden            = openapi.Response{Status: fiber.StatusForbidden, Description: "admin role required, account disabled, or API key without the scope"}
+	preconditionFailed   = openapi.Response{Status: fiber.StatusPreconditionFailed, Ref: "PreconditionFailed"}
+	preconditionRequired = openapi.Response{Status: fiber.StatusPreconditionRequired, Description: "If-Match header required"}
+	serverError          = openapi.Response{Status: fiber.StatusInternalServerError, Description: "internal error"}
+
+	ifMatch       = openapi.Param{Ref: "IfMatch"}
+	historyLimit  = openapi.Param{Ref: "HistoryLimit"}
+	historyBefore = openapi.Param{Ref: "HistoryBefore"}
+	userID        = openapi.Param{Name: "id", In: "path", Schema: 0}
+
+	// scopes API keys need; operations with Auth and without a scope cannot be
+	// called with a key
+	profileRead  = string(apikeys.ScopeProfileRead)
+	profileWrite = string(apikeys.ScopeProfileWrite)
+	adminScope   = string(apikeys.ScopeAdmin)
+
+	mergePatchTypes = []string{"application/merge-patch+json", fiber.MIMEApplicationJSON}
+	binary          = openapi.Schema{"type": "string", "format": "binary"}
+	html            = openapi.Schema{"type": "string"}
+)
+
+// newSpec returns a spec holding the components referenced by the operations in
+// SetupRoutes.
+func newSpec() *openapi.Spec {
+	spec := openapi.New("Fiber REST API", "1.0.0")
+	spec.ErrorBody = apperr.Problem{}
+	spec.ErrorMediaType = apperr.MIMEProblemJSON
+	spec.AuthScheme = "bearerAuth"
+	spec.AuthResponses = []openapi.Response{{Status: fiber.StatusForbidden, Description: "account disabled, or API key without the scope"}}
+	spec.CheckScope = handlers.CheckScope
+	spec.Extend("ProfileAttributes", handlers.ProfileAttributesSchema)
+	spec.Reject = handlers.RejectRequest
+	// response validation is meant for tests and staging
+	switch mode := os.Getenv("OPENAPI_VALIDATE_RESPONSES"); mode {
+	case "":
+	case "log":
+		spec.ResponseMismatch = handlers.LogResponseMismatch
+	case "fail":
+		spec.ResponseMismatch = handlers.FailResponseMismatch
+	default:
+		log.Printf("invalid OPENAPI_VALIDATE_RESPONSES %q, responses are not validated", mode)
+	}
+
+	spec.AddComponent("securitySchemes", "bearerAuth", openapi.Schema{
+		"type":         "http",
+		"scheme":       "bearer",
+		"bearerFormat": "JWT",
+		"description":  "A token from POST /auth/login, or an API key from POST /profile/api-keys (starting with pat_), which may only call operations with its scopes.",
+	})
+	spec.AddComponent("headers", "ETag", openapi.Schema{
+		"description": `Current profile version, e.g. "v3"`,
+		"schema":      "",
+	})
+	spec.AddComponent("headers", "Location", openapi.Schema{
+		"description": "URL to continue at",
+		"schema":      "",
+	})
+	spec.AddComponent("parameters", "IfMatch", openapi.Schema{
+		"name":        "If-Match",
+		"in":          "header",
+		"required":    false,
+		"description": "ETag from GET /profile. The write is rejected with 412 if the profile has changed since, or if the tag is weak (W/...): If-Match uses the strong comparison. Required when the server runs with PROFILE_REQUIRE_IF_MATCH=true.",
+		"schema":      "",
+	})
+	spec.AddComponent("parameters", "HistoryLimit", openapi.Schema{
+		"name":     "limit",
+		"in":       "query",
+		"required": false,
+		"schema":   openapi.Schema{"type": "integer", "minimum": 1, "maximum": 200, "default": 50},
+	})
+	spec.AddComponent("parameters", "HistoryBefore", openapi.Schema{
+		"name":        "before",
+		"in":          "query",
+		"required":    false,
+		"description": "only return entries older than this entry id",
+		"schema":      int64(0),
+	})
+	spec.AddComponent("responses", "PreconditionFailed", openapi.Schema{
+		"description": "profile was modified since the given ETag; the body is the current profile",
+		"headers":     openapi.Schema{"ETag": openapi.Schema{"$ref": "#/components/headers/ETag"}},
+		"body":        handlers.Profile{},
+	})
+	return spec
+}
+
+// CheckSpec reports routes registered on app that are missing from handlers.APISpec.
+// Routes registered through openapi.Router are documented by construction; this
+// catches any added to app directly.
+func CheckSpec(app *fiber.App) error {
+	if missing := handlers.APISpec.Undocumented(app); len(missing) > 0 {
+		return fmt.Errorf("routes missing from the OpenAPI spec: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
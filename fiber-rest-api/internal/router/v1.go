@@ -0,0 +1,722 @@
+package router
+
+import (
+	"strings"
+
+	"fiber-rest-api/internal/handlers"
+	"fiber-rest-api/internal/openapi"
+	"fiber-rest-api/internal/profileattr"
+	"fiber-rest-api/internal/userio"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiV1 returns the routes of version 1 of the API, relative to its prefix.
+//
+// A version 2 starts as apiV1().Clone() and adds again only the routes whose
+// contract changes, with new handlers; both versions are then mounted side by side
+// and the version 1 operations that were replaced get a Deprecation naming their
+// successor.
+func apiV1() *openapi.Routes {
+	r := openapi.NewRoutes()
+
+	// auth endpoints
+	r.Post("/auth/register", openapi.Operation{
+		Summary: "Register a new user",
+		Tags:    []string{"auth"},
+		Body:    handlers.AuthRequest{},
+		Responses: []openapi.Response{
+			{Status: 201, Description: "registered", Body: handlers.MessageResponse{}},
+			{Status: 202, Description: "with REGISTRATION_UNIFORM_RESPONSE, whether or not the email was already registered; the owner of the address is told by email", Body: handlers.MessageResponse{}},
+			{Status: 400, Description: "missing fields"},
+			{Status: 409, Description: "email already registered (not with REGISTRATION_UNIFORM_RESPONSE)"},
+			serverError,
+		},
+	}, handlers.Register)
+	r.Post("/auth/login", openapi.Operation{
+		Summary:     "Login and receive JWT",
+		Description: "Logging in during the grace period of a closed account restores it. In Swagger UI, a successful \"Try it out\" authorizes the other operations with the returned token.",
+		Tags:        []string{"auth"},
+		Body:        handlers.AuthRequest{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "token returned", Body: handlers.TokenResponse{}},
+			{Status: 400, Description: "missing fields"},
+			{Status: 401, Description: "invalid credentials"},
+			{Status: 403, Description: "account disabled by an operator"},
+			serverError,
+		},
+	}, handlers.Login)
+
+	// passwordless sign-in with an emailed link or code
+	r.Post("/auth/magic-link", openapi.Operation{
+		Summary:     "Email a sign-in link and code",
+		Description: "Sends a single-use link and a 6-digit code, valid for 15 minutes, that sign in to the account of the address without its password. The answer is the same whether or not the address is registered; for an unknown address the email says that using it creates an account without a password. A new request replaces the pending link.",
+		Tags:        []string{"auth"},
+		Body:        handlers.MagicLinkRequest{},
+		Responses: []openapi.Response{
+			{Status: 202, Description: "link and code sent", Body: handlers.MagicLinkStarted{}},
+			{Status: 400, Description: "invalid email address, or redirect is not a path on this server"},
+			{Status: 429, Description: "a link was sent to the address less than a minute ago"},
+			serverError,
+		},
+	}, handlers.StartMagicLink)
+	r.Get("/auth/magic-link/verify", openapi.Operation{
+		Summary:     "Page of the emailed link",
+		Description: "The link in the email. Opening it does not use it up: the page's button posts the token to POST /auth/magic-link/verify, so that mail scanners following the link leave it working.",
+		Tags:        []string{"auth"},
+		Params:      []openapi.Param{{Name: "token", In: "query", Required: true}},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "page with a form that signs in", Body: html, MediaType: fiber.MIMETextHTML},
+			{Status: 400, Description: "token missing"},
+		},
+	}, handlers.MagicLinkPage)
+	r.Post("/auth/magic-link/verify", openapi.Operation{
+		Summary:     "Sign in with an emailed link or code",
+		Description: "Takes the token of the link, or the address and the code. Five wrong codes use up the link. The form of the link's page posts the token; for links requested with a redirect it is answered by sending the browser there with the token in the URL fragment (#token=...). Signing in to an unknown address creates its account; during the grace period of a closed account it restores the account.",
+		Tags:        []string{"auth"},
+		Body:        handlers.MagicLinkVerify{},
+		BodyTypes:   []string{fiber.MIMEApplicationJSON, fiber.MIMEApplicationForm},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "signed in", Body: handlers.TokenResponse{}},
+			{Status: 302, Description: "signed in through the form; redirect to the path given when the link was requested, with the token in the fragment", Headers: []string{"Location"}},
+			{Status: 400, Description: "neither token nor email and code given"},
+			{Status: 401, Description: "invalid, used or expired link or code"},
+			{Status: 403, Description: "account disabled by an operator"},
+			serverError,
+		},
+	}, handlers.VerifyMagicLink)
+
+	// sign-in with OpenID Connect providers; these run in the browser, which follows
+	// the redirects to and from the provider
+	r.Get("/auth/oidc", openapi.Operation{
+		Summary:     "List the identity providers users can sign in with",
+		Description: "Configured with OIDC_PROVIDERS; the list is empty when none are.",
+		Tags:        []string{"auth"},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "providers", Body: handlers.OIDCProviderList{}},
+		},
+	}, handlers.ListOIDCProviders)
+	r.Get("/auth/oidc/:provider", openapi.Operation{
+		Summary:     "Sign in with an identity provider",
+		Description: "Redirects the browser to the provider, keeping the state of the sign-in in a cookie. The provider sends the user back to /auth/oidc/{provider}/callback.",
+		Tags:        []string{"auth"},
+		Params: []openapi.Param{
+			{Name: "redirect", In: "query", Description: "path on this server the callback redirects to, with the token in the URL fragment (#token=...); without it the callback answers with JSON"},
+			{Name: "login_hint", In: "query", Description: "email address to pre-fill at the provider"},
+		},
+		Responses: []openapi.Response{
+			{Status: 302, Description: "redirect to the provider", Headers: []string{"Location"}},
+			{Status: 400, Description: "redirect is not a path on this server"},
+			{Status: 404, Description: "unknown provider"},
+			{Status: 502, Description: "provider unreachable or misconfigured"},
+			serverError,
+		},
+	}, handlers.StartOIDCLogin)
+	r.Get("/auth/oidc/:provider/callback", openapi.Operation{
+		Summary:     "Finish signing in with an identity provider",
+		Description: "The provider redirects here. A known identity signs in to its account. An unknown one creates an account without a password, unless an account with its email address exists: its owner has to sign in and link the provider from the profile. Sign-ins started by POST /profile/identities/{provider} link the identity instead. Logging in during the grace period of a closed account restores it.",
+		Tags:        []string{"auth"},
+		Params: []openapi.Param{
+			{Name: "state", In: "query", Required: true},
+			{Name: "code", In: "query"},
+			{Name: "error", In: "query", Description: "set by the provider when the sign-in failed"},
+			{Name: "error_description", In: "query"},
+		},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "signed in", Body: handlers.TokenResponse{}},
+			{Status: 302, Description: "signed in; redirect to the path given when the sign-in started, with the token in the fragment", Headers: []string{"Location"}},
+			{Status: 400, Description: "state missing, wrong or expired"},
+			{Status: 403, Description: "declined at the provider, no verified email address, or account disabled"},
+			{Status: 404, Description: "unknown provider"},
+			{Status: 409, Description: "email already registered, or the identity is linked to another account"},
+			{Status: 502, Description: "code exchange or ID token verification failed"},
+			serverError,
+		},
+	}, handlers.OIDCCallback)
+
+	// profile endpoints (protected)
+	r.Get("/profile", openapi.Operation{
+		Summary: "Get current user's profile",
+		Tags:    []string{"profile"},
+		Auth:    true,
+		Scope:   profileRead,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "profile returned", Body: handlers.Profile{}, Headers: []string{"ETag"}},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.GetProfile)
+	r.Put("/profile", openapi.Operation{
+		Summary:     "Replace current user's profile",
+		Description: "Full replacement: first_name, last_name and phone are all overwritten and fields missing from the body are stored as empty strings.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Scope:       profileWrite,
+		Params:      []openapi.Param{ifMatch},
+		Body:        handlers.ProfileUpdate{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "updated profile", Body: handlers.Profile{}, Headers: []string{"ETag"}},
+			{Status: 400, Description: "validation error"},
+			unauthorized,
+			preconditionFailed,
+			preconditionRequired,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.UpdateProfile)
+	r.Patch("/profile", openapi.Operation{
+		Summary:     "Partially update current user's profile",
+		Description: "JSON Merge Patch (RFC 7396): omitted fields are left untouched and null clears a field.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Scope:       profileWrite,
+		Params:      []openapi.Param{ifMatch},
+		Body:        handlers.ProfilePatch{},
+		BodyTypes:   mergePatchTypes,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "updated profile", Body: handlers.Profile{}, Headers: []string{"ETag"}},
+			{Status: 400, Description: "validation error or unknown field"},
+			unauthorized,
+			preconditionFailed,
+			{Status: 415, Description: "unsupported content type"},
+			preconditionRequired,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.PatchProfile)
+	r.Delete("/profile", openapi.Operation{
+		Summary:      "Close the current user's account",
+		Description:  "Requires the password; accounts without one must have signed in within the last 10 minutes instead, and can leave the body out. All tokens stop working and the account is erased when the grace period (ACCOUNT_DELETION_GRACE, default 30 days) ends. Logging in before then restores it.",
+		Tags:         []string{"profile"},
+		Auth:         true,
+		Body:         handlers.AccountDeletion{},
+		BodyOptional: true,
+		Responses: []openapi.Response{
+			{Status: 202, Description: "deletion scheduled", Body: handlers.AccountDeletionResponse{}},
+			{Status: 400, Description: "password required"},
+			{Status: 401, Description: "unauthorized or invalid password"},
+			{Status: 403, Description: "account without a password signed in more than 10 minutes ago"},
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.DeleteAccount)
+	r.Post("/profile/avatar", openapi.Operation{
+		Summary:   "Upload avatar for current user",
+		Tags:      []string{"profile"},
+		Auth:      true,
+		Scope:     profileWrite,
+		Params:    []openapi.Param{ifMatch},
+		Body:      handlers.AvatarUpload{},
+		BodyTypes: []string{fiber.MIMEMultipartForm},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "avatar uploaded", Body: handlers.AvatarResponse{}, Headers: []string{"ETag"}},
+			{Status: 400, Description: "invalid file"},
+			unauthorized,
+			preconditionFailed,
+			preconditionRequired,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.UploadAvatar)
+	r.Post("/profile/phone/verification", openapi.Operation{
+		Summary: "Send an SMS verification code to the profile phone number",
+		Tags:    []string{"profile"},
+		Auth:    true,
+		Scope:   profileWrite,
+		Responses: []openapi.Response{
+			{Status: 202, Description: "code sent", Body: handlers.PhoneVerificationStarted{}},
+			{Status: 400, Description: "no phone number on profile"},
+			unauthorized,
+			{Status: 409, Description: "phone already verified"},
+			{Status: 429, Description: "code recently sent"},
+			serverError,
+			{Status: 502, Description: "SMS provider failed"},
+		},
+	}, handlers.AuthRequired, handlers.StartPhoneVerification)
+	r.Post("/profile/phone/verification/confirm", openapi.Operation{
+		Summary: "Confirm the SMS verification code",
+		Tags:    []string{"profile"},
+		Auth:    true,
+		Scope:   profileWrite,
+		Body:    handlers.PhoneVerificationConfirm{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "phone verified, profile returned", Body: handlers.Profile{}},
+			{Status: 400, Description: "invalid or expired code"},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.ConfirmPhoneVerification)
+	r.Put("/profile/username", openapi.Operation{
+		Summary:     "Set the current user's username",
+		Description: "3-30 letters, digits or underscores; unique ignoring case; reserved words (admin, support, ...) are rejected.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Scope:       profileWrite,
+		Body:        handlers.UsernameUpdate{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "updated profile", Body: handlers.Profile{}},
+			{Status: 400, Description: "invalid or reserved username"},
+			unauthorized,
+			{Status: 409, Description: "username already taken"},
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.UpdateUsername)
+	r.Get("/profile/visibility", openapi.Operation{
+		Summary: "Get which profile fields are public",
+		Tags:    []string{"profile"},
+		Auth:    true,
+		Scope:   profileRead,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "visibility settings", Body: handlers.VisibilitySettings{}},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.GetVisibility)
+	r.Put("/profile/visibility", openapi.Operation{
+		Summary:     "Replace which profile fields are public",
+		Description: "Fields not listed become private. Only custom attributes an admin defined with visibility public can be shared.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Scope:       profileWrite,
+		Body:        handlers.VisibilitySettings{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "visibility settings", Body: handlers.VisibilitySettings{}},
+			{Status: 400, Description: "field cannot be shared"},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.UpdateVisibility)
+	r.Get("/profile/preferences", openapi.Operation{
+		Summary: "Get current user's preferences",
+		Tags:    []string{"profile"},
+		Auth:    true,
+		Scope:   profileRead,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "preferences (defaults if never set)", Body: handlers.Preferences{}},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.GetPreferences)
+	r.Put("/profile/preferences", openapi.Operation{
+		Summary:     "Replace current user's preferences",
+		Description: "Fields left out are reset to their defaults. Emails sent to the user use the chosen language, time zone and date format.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Scope:       profileWrite,
+		Body:        handlers.Preferences{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "updated preferences", Body: handlers.Preferences{}},
+			{Status: 400, Description: "validation error"},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.UpdatePreferences)
+	r.Put("/profile/password", openapi.Operation{
+		Summary:     "Change the current user's password",
+		Description: "Verifies current_password. Accounts without a password (created through an emailed link or an identity provider) set their first one without it, with a token from a sign-in in the last 10 minutes. Applies the password policy (at least 8 characters and at most 1024 bytes, at least one letter and one digit, not the email address). Tokens issued before the change are revoked; the response carries a new token.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Body:        handlers.PasswordChange{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "password changed", Body: handlers.PasswordChangeResponse{}},
+			{Status: 400, Description: "password policy violation"},
+			{Status: 401, Description: "unauthorized or invalid current password"},
+			{Status: 403, Description: "the account has no password and the token is not from a recent sign-in"},
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.ChangePassword)
+	r.Get("/profile/identities", openapi.Operation{
+		Summary: "List the identity providers linked to the current user",
+		Tags:    []string{"profile"},
+		Auth:    true,
+		Scope:   profileRead,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "linked identities", Body: handlers.IdentityList{}},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.GetIdentities)
+	r.Post("/profile/identities/:provider", openapi.Operation{
+		Summary:     "Start linking an identity provider to the current user",
+		Description: "Open authorization_url in the same browser; the callback links the identity and answers with a new token. redirect works as for GET /auth/oidc/{provider}.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Params:      []openapi.Param{{Name: "redirect", In: "query"}},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "URL of the provider", Body: handlers.AuthorizationURL{}},
+			{Status: 400, Description: "redirect is not a path on this server"},
+			unauthorized,
+			{Status: 404, Description: "unknown provider"},
+			{Status: 502, Description: "provider unreachable or misconfigured"},
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.StartIdentityLink)
+	r.Delete("/profile/identities/:provider", openapi.Operation{
+		Summary: "Unlink an identity provider from the current user",
+		Tags:    []string{"profile"},
+		Auth:    true,
+		Responses: []openapi.Response{
+			{Status: 204, Description: "unlinked"},
+			unauthorized,
+			{Status: 404, Description: "provider not linked"},
+			{Status: 409, Description: "the only way to sign in to an account without a password"},
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.UnlinkIdentity)
+	r.Get("/profile/consents", openapi.Operation{
+		Summary: "List the apps the current user allowed access to their account",
+		Tags:    []string{"profile"},
+		Auth:    true,
+		Scope:   profileRead,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "consents given on the provider's consent page", Body: handlers.OAuthConsentList{}},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.GetConsents)
+	r.Delete("/profile/consents/:client_id", openapi.Operation{
+		Summary:     "Revoke the current user's consent to an app",
+		Description: "The app's access tokens stop working at /oauth/userinfo, and the user is asked for consent again at the next sign-in.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Responses: []openapi.Response{
+			{Status: 204, Description: "revoked"},
+			unauthorized,
+			{Status: 404, Description: "no consent given to the app"},
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.RevokeConsent)
+	// API keys are managed with a token from a login only, so a leaked key cannot
+	// be used to create others
+	r.Get("/profile/api-keys", openapi.Operation{
+		Summary: "List the current user's API keys",
+		Tags:    []string{"profile"},
+		Auth:    true,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "keys, newest first, expired ones included", Body: handlers.APIKeyList{}},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.ListAPIKeys)
+	r.Post("/profile/api-keys", openapi.Operation{
+		Summary:     "Create an API key",
+		Description: "Scripts send the key as a bearer token instead of logging in. It may only call the operations whose scope it was granted, and stays valid across password changes until it expires or is revoked.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Body:        handlers.APIKeyRequest{},
+		Responses: []openapi.Response{
+			{Status: 201, Description: "created; the response holds the key", Body: handlers.APIKey{}},
+			{Status: 400, Description: "validation error"},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.CreateAPIKey)
+	r.Delete("/profile/api-keys/:id", openapi.Operation{
+		Summary: "Revoke an API key",
+		Tags:    []string{"profile"},
+		Auth:    true,
+		Params:  []openapi.Param{{Name: "id", In: "path", Schema: int64(0)}},
+		Responses: []openapi.Response{
+			{Status: 204, Description: "revoked"},
+			unauthorized,
+			{Status: 404, Description: "key not found"},
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.RevokeAPIKey)
+	r.Post("/profile/email", openapi.Operation{
+		Summary:     "Request an email address change",
+		Description: "Requires the current password; accounts without one must have signed in within the last 10 minutes instead. A confirmation link is sent to the new address and a notice to the current one; the address changes only after confirmation.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Body:        handlers.EmailChangeRequest{},
+		Responses: []openapi.Response{
+			{Status: 202, Description: "confirmation sent", Body: handlers.MessageResponse{}},
+			{Status: 400, Description: "validation error"},
+			{Status: 401, Description: "unauthorized or invalid password"},
+			{Status: 403, Description: "account without a password signed in more than 10 minutes ago"},
+			{Status: 409, Description: "email already registered"},
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.RequestEmailChange)
+	r.Get("/profile/history", openapi.Operation{
+		Summary:     "List changes made to the current user's profile",
+		Description: "Newest first. Pass next_before from a page as before to fetch the next one.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Scope:       profileRead,
+		Params:      []openapi.Param{historyLimit, historyBefore},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "history page", Body: handlers.HistoryPage{}},
+			{Status: 400, Description: "invalid paging parameters"},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.GetProfileHistory)
+	r.Post("/profile/export", openapi.Operation{
+		Summary:     "Request an archive of all personal data",
+		Description: "Builds a ZIP with the profile, preferences, linked identity providers, avatars, login history and profile history in the background and emails a download link when it is ready. While an export is in progress the same export is returned.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Scope:       profileRead,
+		Responses: []openapi.Response{
+			{Status: 202, Description: "export started; Location points at its status", Body: handlers.DataExport{}},
+			unauthorized,
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.RequestExport)
+	r.Get("/profile/export/:id", openapi.Operation{
+		Summary:     "Get the status of a personal data export",
+		Description: "Once ready, download_url is a freshly signed link valid for one hour.",
+		Tags:        []string{"profile"},
+		Auth:        true,
+		Scope:       profileRead,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "export status", Body: handlers.DataExport{}},
+			unauthorized,
+			{Status: 404, Description: "export not found"},
+			{Status: 410, Description: "export expired"},
+			serverError,
+		},
+	}, handlers.AuthRequired, handlers.GetExport)
+	// export downloads are authorized by the signed link, not a session
+	r.Get("/exports/:id", openapi.Operation{
+		Summary:     "Download a personal data export",
+		Description: "Authorized by the signed link from the email or from GET /profile/export/{id}; no bearer token needed.",
+		Tags:        []string{"profile"},
+		Params: []openapi.Param{
+			{Name: "expires", In: "query", Required: true, Schema: int64(0)},
+			{Name: "signature", In: "query", Required: true},
+		},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "ZIP archive", Body: binary, MediaType: "application/zip"},
+			{Status: 403, Description: "invalid signature"},
+			{Status: 404, Description: "export not found"},
+			{Status: 410, Description: "link or export expired"},
+			serverError,
+		},
+	}, handlers.DownloadExport)
+	// confirmation links are opened from the email, so these are not behind AuthRequired
+	r.Get("/profile/email/confirm", openapi.Operation{
+		Summary: "Confirm an email change from the emailed link",
+		Tags:    []string{"profile"},
+		Params:  []openapi.Param{{Name: "token", In: "query", Required: true}},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "email changed; tokens issued for the old address are revoked", Body: handlers.EmailChangeResult{}},
+			{Status: 400, Description: "invalid or expired token"},
+			{Status: 409, Description: "email already registered"},
+			serverError,
+		},
+	}, handlers.ConfirmEmailChange)
+	r.Post("/profile/email/confirm", openapi.Operation{
+		Summary: "Confirm an email change",
+		Tags:    []string{"profile"},
+		Body:    handlers.EmailChangeConfirm{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "email changed; tokens issued for the old address are revoked", Body: handlers.EmailChangeResult{}},
+			{Status: 400, Description: "invalid or expired token"},
+			{Status: 409, Description: "email already registered"},
+			serverError,
+		},
+	}, handlers.ConfirmEmailChange)
+	// public profiles
+	r.Get("/users/:username", openapi.Operation{
+		Summary:     "Get a user's public profile",
+		Description: "Returns the username plus only the fields the owner made public. Username lookup ignores case. Accounts pending deletion or disabled are not found.",
+		Tags:        []string{"users"},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "public profile", Body: handlers.PublicProfile{}},
+			{Status: 404, Description: "user not found"},
+			serverError,
+		},
+	}, handlers.GetPublicProfile)
+
+	// admin endpoints (protected, admin role)
+	admin := r.Group("/admin", handlers.AuthRequired, handlers.AdminRequired)
+	admin.Get("/profile-attributes", openapi.Operation{
+		Summary: "List custom profile attribute definitions",
+		Tags:    []string{"admin"},
+		Auth:    true,
+		Scope:   adminScope,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "definitions", Body: []profileattr.Definition{}},
+			unauthorized,
+			forbidden,
+			serverError,
+		},
+	}, handlers.ListProfileAttributes)
+	admin.Post("/profile-attributes", openapi.Operation{
+		Summary: "Define a custom profile attribute",
+		Tags:    []string{"admin"},
+		Auth:    true,
+		Scope:   adminScope,
+		Body:    profileattr.Definition{},
+		Responses: []openapi.Response{
+			{Status: 201, Description: "created", Body: profileattr.Definition{}},
+			{Status: 400, Description: "invalid definition"},
+			unauthorized,
+			forbidden,
+			{Status: 409, Description: "attribute already exists"},
+			serverError,
+		},
+	}, handlers.CreateProfileAttribute)
+	admin.Put("/profile-attributes/:key", openapi.Operation{
+		Summary: "Replace a custom profile attribute definition",
+		Tags:    []string{"admin"},
+		Auth:    true,
+		Scope:   adminScope,
+		Body:    profileattr.Definition{},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "updated", Body: profileattr.Definition{}},
+			{Status: 400, Description: "invalid definition"},
+			unauthorized,
+			forbidden,
+			{Status: 404, Description: "attribute not found"},
+			serverError,
+		},
+	}, handlers.UpdateProfileAttribute)
+	admin.Delete("/profile-attributes/:key", openapi.Operation{
+		Summary: "Delete a custom profile attribute and all stored values",
+		Tags:    []string{"admin"},
+		Auth:    true,
+		Scope:   adminScope,
+		Responses: []openapi.Response{
+			{Status: 204, Description: "deleted"},
+			unauthorized,
+			forbidden,
+			{Status: 404, Description: "attribute not found"},
+			serverError,
+		},
+	}, handlers.DeleteProfileAttribute)
+	admin.Get("/oauth-clients", openapi.Operation{
+		Summary: "List the apps registered with the OpenID Connect provider",
+		Tags:    []string{"admin"},
+		Auth:    true,
+		Scope:   adminScope,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "clients, without secrets", Body: handlers.OAuthClientList{}},
+			unauthorized,
+			forbidden,
+			serverError,
+		},
+	}, handlers.ListOAuthClients)
+	admin.Post("/oauth-clients", openapi.Operation{
+		Summary:     "Register an app with the OpenID Connect provider",
+		Description: "Confidential clients get a secret, returned only in this response. Public clients get none and must use PKCE.",
+		Tags:        []string{"admin"},
+		Auth:        true,
+		Scope:       adminScope,
+		Body:        handlers.OAuthClientRequest{},
+		Responses: []openapi.Response{
+			{Status: 201, Description: "registered", Body: handlers.OAuthClient{}},
+			{Status: 400, Description: "validation error"},
+			unauthorized,
+			forbidden,
+			serverError,
+		},
+	}, handlers.CreateOAuthClient)
+	admin.Delete("/oauth-clients/:id", openapi.Operation{
+		Summary:     "Remove an app from the OpenID Connect provider",
+		Description: "Its consents and pending codes are deleted, and its access tokens stop working.",
+		Tags:        []string{"admin"},
+		Auth:        true,
+		Scope:       adminScope,
+		Responses: []openapi.Response{
+			{Status: 204, Description: "removed"},
+			unauthorized,
+			forbidden,
+			{Status: 404, Description: "client not found"},
+			serverError,
+		},
+	}, handlers.DeleteOAuthClient)
+	// registered before /users/:id, which would match them otherwise
+	admin.Get("/users/export", openapi.Operation{
+		Summary:     "Export all users as CSV or JSON Lines",
+		Description: "Password hashes are never exported. columns is a comma-separated subset of " + strings.Join(userio.Columns, ", ") + ".",
+		Tags:        []string{"admin"},
+		Auth:        true,
+		Scope:       adminScope,
+		Params: []openapi.Param{
+			{Name: "format", In: "query", Description: "csv (default) or jsonl", Schema: openapi.Schema{"type": "string", "enum": []string{string(userio.CSV), string(userio.JSONL)}}},
+			{Name: "columns", In: "query", Description: "columns to export, all by default"},
+		},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "users, one per row or line", Body: openapi.Schema{"type": "string"}, MediaType: userio.MIMECSV, MediaTypes: []string{userio.MIMEJSONL}},
+			{Status: 400, Description: "unknown format or column"},
+			unauthorized,
+			forbidden,
+			serverError,
+		},
+	}, handlers.ExportUsers)
+	admin.Post("/users/import", openapi.Operation{
+		Summary: "Import users from CSV or JSON Lines",
+		Description: "Columns are email, password or password_hash (bcrypt or argon2 hashes are stored as they are), role, first_name and last_name. " +
+			"Existing emails are skipped, or updated with on_conflict=update. Rows are reported individually; dry_run=true reports without changing anything. " +
+			"The body is read as it arrives and may be up to USER_IMPORT_MAX_BYTES (default 64 MiB) long.",
+		Tags:  []string{"admin"},
+		Auth:  true,
+		Scope: adminScope,
+		Params: []openapi.Param{
+			{Name: "dry_run", In: "query", Description: "validate and report only", Schema: false},
+			{Name: "on_conflict", In: "query", Description: "what to do with existing emails", Schema: openapi.Schema{"type": "string", "enum": []string{userio.OnConflictSkip, userio.OnConflictUpdate}}},
+		},
+		Body:       openapi.Schema{"type": "string"},
+		BodyTypes:  []string{userio.MIMECSV, userio.MIMEJSONL},
+		BodyStream: true,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "import report", Body: userio.ImportReport{}},
+			{Status: 400, Description: "invalid header or parameters"},
+			unauthorized,
+			forbidden,
+			{Status: 413, Description: "body larger than USER_IMPORT_MAX_BYTES; batches read before are kept"},
+			{Status: 415, Description: "unsupported content type"},
+			serverError,
+		},
+	}, handlers.ImportUsers)
+	admin.Get("/users/:id", openapi.Operation{
+		Summary: "Get any user's profile, including admin-only attributes",
+		Tags:    []string{"admin"},
+		Auth:    true,
+		Scope:   adminScope,
+		Params:  []openapi.Param{userID},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "profile returned", Body: handlers.Profile{}, Headers: []string{"ETag"}},
+			unauthorized,
+			forbidden,
+			{Status: 404, Description: "user not found"},
+			serverError,
+		},
+	}, handlers.AdminGetUser)
+	admin.Patch("/users/:id", openapi.Operation{
+		Summary:     "Partially update any user's profile",
+		Description: "Same rules as PATCH /profile; admin-only attributes may be set too. Changes are recorded with the admin as actor.",
+		Tags:        []string{"admin"},
+		Auth:        true,
+		Scope:       adminScope,
+		Params:      []openapi.Param{userID, ifMatch},
+		Body:        handlers.ProfilePatch{},
+		BodyTypes:   mergePatchTypes,
+		Responses: []openapi.Response{
+			{Status: 200, Description: "updated profile", Body: handlers.Profile{}, Headers: []string{"ETag"}},
+			{Status: 400, Description: "validation error or unknown field"},
+			unauthorized,
+			forbidden,
+			{Status: 404, Description: "user not found"},
+			preconditionFailed,
+			{Status: 415, Description: "unsupported content type"},
+			preconditionRequired,
+			serverError,
+		},
+	}, handlers.AdminPatchUser)
+	admin.Get("/users/:id/history", openapi.Operation{
+		Summary: "List all changes made to any user's profile",
+		Tags:    []string{"admin"},
+		Auth:    true,
+		Scope:   adminScope,
+		Params:  []openapi.Param{userID, historyLimit, historyBefore},
+		Responses: []openapi.Response{
+			{Status: 200, Description: "history page", Body: handlers.HistoryPage{}},
+			{Status: 400, Description: "invalid paging parameters"},
+			unauthorized,
+			forbidden,
+			serverError,
+		},
+	}, handlers.AdminUserHistory)
+
+	return r
+}
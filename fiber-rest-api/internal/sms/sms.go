@@ -0,0 +1,62 @@
+// Package sms defines the interface used to deliver text messages and the
+// providers bundled with the server.
+package sms
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// Sender delivers a text message to an E.164 phone number.
+type Sender interface {
+	Send(to, message string) error
+}
+
+// LogSender writes messages to the standard logger instead of delivering them.
+// It is the default provider for local development.
+type LogSender struct{}
+
+func (LogSender) Send(to, message string) error {
+	log.Printf("sms to %s: %s", to, message)
+	return nil
+}
+
+// Message is a text message captured by Fake.
+type Message struct {
+	To   string
+	Body string
+}
+
+// Fake records sent messages in memory so tests can inspect them.
+type Fake struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (f *Fake) Send(to, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, Message{To: to, Body: message})
+	return nil
+}
+
+// Messages returns a copy of every message sent so far.
+func (f *Fake) Messages() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Message(nil), f.messages...)
+}
+
+// FromEnv returns the provider named by SMS_PROVIDER ("log" or "fake"), defaulting to log.
+func FromEnv() (Sender, error) {
+	switch p := os.Getenv("SMS_PROVIDER"); p {
+	case "", "log":
+		return LogSender{}, nil
+	case "fake":
+		return &Fake{}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_PROVIDER %q", p)
+	}
+}
@@ -0,0 +1,113 @@
+package userio
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"fiber-rest-api/internal/users"
+)
+
+// Columns lists the columns Export can write, in their default order. Password
+// hashes are never exported.
+var Columns = []string{"id", "email", "username", "role", "first_name", "last_name", "phone", "disabled_at", "delete_after"}
+
+// columnValues returns the value of each column for a user. Times are RFC 3339 and
+// nil when unset.
+var columnValues = map[string]func(u *users.User) interface{}{
+	"id":           func(u *users.User) interface{} { return u.ID },
+	"email":        func(u *users.User) interface{} { return u.Email },
+	"username":     func(u *users.User) interface{} { return u.Username },
+	"role":         func(u *users.User) interface{} { return u.Role },
+	"first_name":   func(u *users.User) interface{} { return u.FirstName },
+	"last_name":    func(u *users.User) interface{} { return u.LastName },
+	"phone":        func(u *users.User) interface{} { return u.Phone },
+	"disabled_at":  func(u *users.User) interface{} { return timeValue(u.DisabledAt) },
+	"delete_after": func(u *users.User) interface{} { return timeValue(u.DeleteAfter) },
+}
+
+func timeValue(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// CheckColumns returns an error naming the first column Export does not know.
+func CheckColumns(columns []string) error {
+	for _, c := range columns {
+		if columnValues[c] == nil {
+			return fmt.Errorf("unknown column %q", c)
+		}
+	}
+	return nil
+}
+
+// Export writes every user to w in format, with the given columns (Columns when
+// empty), and returns the number of users written. CSV starts with a header row;
+// JSON Lines objects use the column names as keys. Users are read and written one
+// at a time.
+func Export(q users.Queryer, w io.Writer, format Format, columns []string) (int, error) {
+	if len(columns) == 0 {
+		columns = Columns
+	}
+	if err := CheckColumns(columns); err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(w)
+	var write func(values []interface{}) error
+	switch format {
+	case CSV:
+		cw := csv.NewWriter(bw)
+		if err := cw.Write(columns); err != nil {
+			return 0, err
+		}
+		record := make([]string, len(columns))
+		write = func(values []interface{}) error {
+			for i, v := range values {
+				switch v := v.(type) {
+				case nil:
+					record[i] = ""
+				case int:
+					record[i] = strconv.Itoa(v)
+				default:
+					record[i] = v.(string)
+				}
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+	case JSONL:
+		enc := json.NewEncoder(bw)
+		write = func(values []interface{}) error {
+			obj := make(map[string]interface{}, len(values))
+			for i, v := range values {
+				obj[columns[i]] = v
+			}
+			return enc.Encode(obj)
+		}
+	default:
+		return 0, fmt.Errorf("unknown format %q", format)
+	}
+
+	n := 0
+	values := make([]interface{}, len(columns))
+	err := users.Each(q, func(u *users.User) error {
+		for i, c := range columns {
+			values[i] = columnValues[c](u)
+		}
+		n++
+		return write(values)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
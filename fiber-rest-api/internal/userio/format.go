@@ -0,0 +1,62 @@
+// Package userio moves user accounts in and out of the database in bulk, as CSV or
+// JSON Lines. It backs both the admin endpoints and cmd/admin.
+package userio
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Format is a file format for users.
+type Format string
+
+// Formats.
+const (
+	CSV Format = "csv"
+	// JSONL is JSON Lines: one JSON object per line.
+	JSONL Format = "jsonl"
+)
+
+// Media types of the formats.
+const (
+	MIMECSV   = "text/csv"
+	MIMEJSONL = "application/x-ndjson"
+)
+
+// ParseFormat returns the format named s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case CSV, JSONL:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q (use csv or jsonl)", s)
+}
+
+// FormatOf guesses the format of a file from its extension, defaulting to CSV.
+func FormatOf(name string) Format {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jsonl", ".ndjson":
+		return JSONL
+	}
+	return CSV
+}
+
+// FormatOfMediaType returns the format sent with a Content-Type media type.
+func FormatOfMediaType(mediaType string) (Format, bool) {
+	switch mediaType {
+	case MIMECSV:
+		return CSV, true
+	case MIMEJSONL:
+		return JSONL, true
+	}
+	return "", false
+}
+
+// MediaType returns the Content-Type of f.
+func (f Format) MediaType() string {
+	if f == JSONL {
+		return MIMEJSONL
+	}
+	return MIMECSV + "; charset=utf-8"
+}
@@ -0,0 +1,408 @@
+package userio
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	netmail "net/mail"
+	"sort"
+	"strings"
+
+	"fiber-rest-api/internal/users"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// importColumns are the columns Import reads; email is required, and new accounts
+// need password (in clear text, checked against the password policy) or
+// password_hash (bcrypt, or argon2 in the PHC string format).
+var importColumns = map[string]bool{"email": true, "password": true, "password_hash": true, "role": true, "first_name": true, "last_name": true}
+
+// Conflict policies: what Import does with rows whose email is already registered.
+const (
+	OnConflictSkip   = "skip"
+	OnConflictUpdate = "update"
+)
+
+// batchSize is the number of rows written per transaction. Passwords are hashed
+// before a batch starts so the database is not locked while they are hashed.
+const batchSize = 100
+
+// maxNameLength matches the limit of first_name and last_name in the API.
+const maxNameLength = 100
+
+// Record is an account read for import. Empty fields were not given; on update they
+// are left unchanged.
+type Record struct {
+	Line int
+	users.NewUser
+}
+
+// RowError is a row that could not be read; the rows after it still can.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Reader reads records to import.
+type Reader interface {
+	// Next returns the next record, a *RowError for a row that cannot be read, or
+	// io.EOF after the last row.
+	Next() (Record, error)
+}
+
+// NewReader returns a Reader of r in format. A CSV file starts with a header row
+// naming its columns; JSON Lines objects use the column names as keys.
+func NewReader(r io.Reader, format Format) (Reader, error) {
+	switch format {
+	case CSV:
+		return newCSVReader(r)
+	case JSONL:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 1<<20)
+		return &jsonlReader{sc: sc}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type csvReader struct {
+	cr    *csv.Reader
+	index map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty file")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !importColumns[name] {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		index[name] = i
+	}
+	if _, ok := index["email"]; !ok {
+		return nil, errors.New(`missing column "email"`)
+	}
+	return &csvReader{cr: cr, index: index}, nil
+}
+
+func (r *csvReader) Next() (Record, error) {
+	row, err := r.cr.Read()
+	if err != nil {
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			return Record{}, &RowError{Line: perr.Line, Err: perr.Err}
+		}
+		return Record{}, err
+	}
+	line, _ := r.cr.FieldPos(0)
+	get := func(name string) string {
+		if i, ok := r.index[name]; ok {
+			return row[i]
+		}
+		return ""
+	}
+	return newRecord(line, get), nil
+}
+
+type jsonlReader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func (r *jsonlReader) Next() (Record, error) {
+	for r.sc.Scan() {
+		r.line++
+		raw := bytes.TrimSpace(r.sc.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return Record{}, &RowError{Line: r.line, Err: errors.New("malformed JSON")}
+		}
+		values := map[string]string{}
+		for k, v := range obj {
+			if !importColumns[k] {
+				return Record{}, &RowError{Line: r.line, Err: fmt.Errorf("unknown field %q", k)}
+			}
+			s, ok := v.(string)
+			if !ok && v != nil {
+				return Record{}, &RowError{Line: r.line, Err: fmt.Errorf("%s must be a string", k)}
+			}
+			values[k] = s
+		}
+		return newRecord(r.line, func(name string) string { return values[name] }), nil
+	}
+	if err := r.sc.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+// newRecord builds a record from column values, trimming all but the password.
+func newRecord(line int, get func(string) string) Record {
+	return Record{Line: line, NewUser: users.NewUser{
+		Email:        strings.TrimSpace(get("email")),
+		Password:     get("password"),
+		PasswordHash: strings.TrimSpace(get("password_hash")),
+		Role:         strings.TrimSpace(get("role")),
+		FirstName:    strings.TrimSpace(get("first_name")),
+		LastName:     strings.TrimSpace(get("last_name")),
+	}}
+}
+
+// ImportOptions controls Import.
+type ImportOptions struct {
+	// DryRun checks every row, including against existing accounts, without
+	// changing anything.
+	DryRun bool
+	// OnConflict is OnConflictSkip (the default) or OnConflictUpdate.
+	OnConflict string
+	// Audit provides ActorID, IP and UserAgent of the history entries of updates.
+	Audit users.Event
+}
+
+// RowResult reports a row that was not imported as is.
+type RowResult struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email,omitempty"`
+	Status string `json:"status" openapi:"enum=skipped|failed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport summarizes an import.
+type ImportReport struct {
+	DryRun    bool        `json:"dry_run"`
+	Created   int         `json:"created"`
+	Updated   int         `json:"updated"`
+	Unchanged int         `json:"unchanged" doc:"existing accounts the row matched already"`
+	Skipped   int         `json:"skipped" doc:"existing accounts left alone by on_conflict=skip"`
+	Failed    int         `json:"failed"`
+	Rows      []RowResult `json:"rows" doc:"every skipped or failed row, in line order"`
+}
+
+func (rep *ImportReport) fail(line int, email string, err error) {
+	rep.Failed++
+	rep.Rows = append(rep.Rows, RowResult{Line: line, Email: email, Status: "failed", Error: err.Error()})
+}
+
+// dryRunHash stands in for the hashes of clear-text passwords in a dry run, which
+// would otherwise be computed only to be thrown away.
+var dryRunHash, _ = bcrypt.GenerateFromPassword([]byte("dry run"), bcrypt.MinCost)
+
+// Import creates, and with OnConflictUpdate updates, the accounts read from r. Rows
+// that fail are reported and skipped; the import stops only on a read or database
+// error, keeping the batches already written. Updates are recorded in the profile
+// history as action user.import; a password change revokes the user's tokens.
+func Import(conn *sql.DB, r Reader, opts ImportOptions) (*ImportReport, error) {
+	if opts.OnConflict == "" {
+		opts.OnConflict = OnConflictSkip
+	}
+	if opts.OnConflict != OnConflictSkip && opts.OnConflict != OnConflictUpdate {
+		return nil, fmt.Errorf("unknown conflict policy %q", opts.OnConflict)
+	}
+	rep := &ImportReport{DryRun: opts.DryRun, Rows: []RowResult{}}
+	seen := map[string]int{}
+	for {
+		batch, done, err := readBatch(r, rep, seen, opts.DryRun)
+		if err != nil {
+			return rep, err
+		}
+		if len(batch) > 0 {
+			if err := writeBatch(conn, batch, rep, opts); err != nil {
+				return rep, err
+			}
+		}
+		if done {
+			// rows failing validation are reported as they are read, the others
+			// when their batch is written
+			sort.SliceStable(rep.Rows, func(i, j int) bool { return rep.Rows[i].Line < rep.Rows[j].Line })
+			return rep, nil
+		}
+	}
+}
+
+// readBatch reads up to batchSize valid records, reporting the invalid ones, and
+// hashes their clear-text passwords into PasswordHash.
+func readBatch(r Reader, rep *ImportReport, seen map[string]int, dryRun bool) ([]Record, bool, error) {
+	var batch []Record
+	for len(batch) < batchSize {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return batch, true, nil
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			rep.fail(rowErr.Line, "", rowErr.Err)
+			continue
+		}
+		if err != nil {
+			return batch, false, err
+		}
+		if err := validate(rec); err != nil {
+			rep.fail(rec.Line, rec.Email, err)
+			continue
+		}
+		if first, ok := seen[rec.Email]; ok {
+			rep.fail(rec.Line, rec.Email, fmt.Errorf("duplicate of line %d", first))
+			continue
+		}
+		seen[rec.Email] = rec.Line
+		if rec.Password != "" {
+			hash := string(dryRunHash)
+			if !dryRun {
+				if hash, err = users.HashPassword(rec.Password); err != nil {
+					return batch, false, err
+				}
+			}
+			rec.PasswordHash = hash
+		}
+		batch = append(batch, rec)
+	}
+	return batch, false, nil
+}
+
+// validate checks a record on its own; whether the account exists is checked when
+// it is written.
+func validate(rec Record) error {
+	if rec.Email == "" {
+		return errors.New("email is required")
+	}
+	if addr, err := netmail.ParseAddress(rec.Email); err != nil || addr.Address != rec.Email {
+		return errors.New("email is not a valid email address")
+	}
+	if rec.Password != "" && rec.PasswordHash != "" {
+		return errors.New("give password or password_hash, not both")
+	}
+	if rec.Password != "" {
+		if err := users.CheckPasswordPolicy(rec.Password, rec.Email); err != nil {
+			return err
+		}
+	}
+	if rec.PasswordHash != "" {
+		if err := users.CheckHash(rec.PasswordHash); err != nil {
+			return fmt.Errorf("password_hash: %v", err)
+		}
+	}
+	if rec.Role != "" && !users.ValidRole(rec.Role) {
+		return fmt.Errorf("unknown role %q", rec.Role)
+	}
+	if len(rec.FirstName) > maxNameLength || len(rec.LastName) > maxNameLength {
+		return fmt.Errorf("first_name/last_name too long (max %d)", maxNameLength)
+	}
+	return nil
+}
+
+// writeBatch applies a batch in one transaction, rolled back in a dry run.
+func writeBatch(conn *sql.DB, batch []Record, rep *ImportReport, opts ImportOptions) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, rec := range batch {
+		existing, err := users.GetByEmail(tx, rec.Email)
+		switch {
+		case err == users.ErrNotFound:
+			if rec.PasswordHash == "" {
+				rep.fail(rec.Line, rec.Email, errors.New("password or password_hash is required for a new account"))
+				continue
+			}
+			if _, err := users.Create(tx, rec.NewUser); err != nil {
+				return fmt.Errorf("line %d: %w", rec.Line, err)
+			}
+			rep.Created++
+		case err != nil:
+			return err
+		case opts.OnConflict == OnConflictSkip:
+			rep.Skipped++
+			rep.Rows = append(rep.Rows, RowResult{Line: rec.Line, Email: rec.Email, Status: "skipped", Error: "email already registered"})
+		default:
+			changed, err := update(tx, existing, rec, opts.Audit)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", rec.Line, err)
+			}
+			if changed {
+				rep.Updated++
+			} else {
+				rep.Unchanged++
+			}
+		}
+	}
+	if opts.DryRun {
+		return nil
+	}
+	return tx.Commit()
+}
+
+// update applies the given fields of rec to an existing account and reports whether
+// anything changed.
+func update(tx *sql.Tx, u *users.User, rec Record, audit users.Event) (bool, error) {
+	changed := false
+	event := func(field string, oldValue, newValue *string) error {
+		changed = true
+		ev := audit
+		ev.UserID, ev.Action, ev.Field, ev.OldValue, ev.NewValue = u.ID, "user.import", field, oldValue, newValue
+		return users.RecordEvent(tx, ev)
+	}
+
+	// setting the same password again would needlessly revoke the user's tokens
+	samePassword := rec.PasswordHash == u.PasswordHash() || (rec.Password != "" && u.PasswordMatches(rec.Password))
+	if rec.PasswordHash != "" && !samePassword {
+		if _, err := users.SetPasswordHash(tx, u.ID, rec.PasswordHash); err != nil {
+			return false, err
+		}
+		// only the fact of the change is recorded, never the hashes
+		if err := event("password", nil, nil); err != nil {
+			return false, err
+		}
+	}
+	if rec.Role != "" && rec.Role != u.Role {
+		if err := users.SetRole(tx, u.ID, rec.Role); err != nil {
+			return false, err
+		}
+		if err := event("role", &u.Role, &rec.Role); err != nil {
+			return false, err
+		}
+	}
+	firstName, lastName := u.FirstName, u.LastName
+	if rec.FirstName != "" {
+		firstName = rec.FirstName
+	}
+	if rec.LastName != "" {
+		lastName = rec.LastName
+	}
+	if firstName != u.FirstName || lastName != u.LastName {
+		if err := users.SetName(tx, u.ID, firstName, lastName); err != nil {
+			return false, err
+		}
+		if firstName != u.FirstName {
+			if err := event("first_name", &u.FirstName, &firstName); err != nil {
+				return false, err
+			}
+		}
+		if lastName != u.LastName {
+			if err := event("last_name", &u.LastName, &lastName); err != nil {
+				return false, err
+			}
+		}
+	}
+	return changed, nil
+}
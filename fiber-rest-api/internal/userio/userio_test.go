@@ -0,0 +1,194 @@
+package userio_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"fiber-rest-api/internal/db"
+	"fiber-rest-api/internal/userio"
+	"fiber-rest-api/internal/users"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "userio")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	if err := db.Init(filepath.Join(dir, "data.db")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	return m.Run()
+}
+
+// hash is a cheap password_hash for rows that create accounts.
+var hash = func() string {
+	h, err := bcrypt.GenerateFromPassword([]byte("correct-h0rse"), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(h)
+}()
+
+// readAll returns the records of r and the lines of the rows that could not be read.
+func readAll(t *testing.T, r userio.Reader) ([]userio.Record, []int) {
+	t.Helper()
+	var records []userio.Record
+	var failed []int
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records, failed
+		}
+		var rowErr *userio.RowError
+		if errors.As(err, &rowErr) {
+			failed = append(failed, rowErr.Line)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// count returns the number of accounts whose email ends in suffix.
+func count(t *testing.T, suffix string) int {
+	t.Helper()
+	var n int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email LIKE ?", "%"+suffix).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestReadCSV(t *testing.T) {
+	data := "\ufeffEmail, First_Name,password\n" +
+		" a@example.com ,Ann,s3cret pass \n" +
+		"b@example.com,Bob\n" +
+		"c@example.com,,\n"
+	r, err := userio.NewReader(strings.NewReader(data), userio.CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, failed := readAll(t, r)
+	want := []userio.Record{
+		{Line: 2, NewUser: users.NewUser{Email: "a@example.com", FirstName: "Ann", Password: "s3cret pass "}},
+		{Line: 4, NewUser: users.NewUser{Email: "c@example.com"}},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records %+v, want %+v", records, want)
+	}
+	if !reflect.DeepEqual(failed, []int{3}) {
+		t.Errorf("failed lines %v, want [3]", failed)
+	}
+
+	for _, header := range []string{"", "first_name\n", "email,phone\n"} {
+		if _, err := userio.NewReader(strings.NewReader(header), userio.CSV); err == nil {
+			t.Errorf("header %q accepted", header)
+		}
+	}
+}
+
+func TestReadJSONL(t *testing.T) {
+	data := `{"email": "a@example.com", "role": "admin", "password_hash": null}` + "\n" +
+		"\n" +
+		`{"email": "b@example.com"` + "\n" +
+		`{"email": "c@example.com", "phone": "+66812345678"}` + "\n" +
+		`{"email": "d@example.com", "first_name": 1}` + "\n" +
+		`{"email": " e@example.com ", "last_name": "Eve"}`
+	r, err := userio.NewReader(strings.NewReader(data), userio.JSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, failed := readAll(t, r)
+	want := []userio.Record{
+		{Line: 1, NewUser: users.NewUser{Email: "a@example.com", Role: "admin"}},
+		{Line: 6, NewUser: users.NewUser{Email: "e@example.com", LastName: "Eve"}},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records %+v, want %+v", records, want)
+	}
+	if !reflect.DeepEqual(failed, []int{3, 4, 5}) {
+		t.Errorf("failed lines %v, want [3 4 5]", failed)
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	uid, err := users.Create(db.DB, users.NewUser{Email: "existing@dry-run.example.com", PasswordHash: hash, FirstName: "Old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := "email,password_hash,first_name\n" +
+		"new@dry-run.example.com," + hash + ",New\n" +
+		"existing@dry-run.example.com,,Changed\n" +
+		"not an address,,\n"
+	for _, dryRun := range []bool{true, false} {
+		r, err := userio.NewReader(strings.NewReader(data), userio.CSV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rep, err := userio.Import(db.DB, r, userio.ImportOptions{DryRun: dryRun, OnConflict: userio.OnConflictUpdate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.DryRun != dryRun || rep.Created != 1 || rep.Updated != 1 || rep.Failed != 1 || len(rep.Rows) != 1 || rep.Rows[0].Line != 4 {
+			t.Errorf("dry run %v: report %+v", dryRun, rep)
+		}
+
+		u, err := users.Get(db.DB, uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantName, wantCount := "Old", 1
+		if !dryRun {
+			wantName, wantCount = "Changed", 2
+		}
+		if u.FirstName != wantName || count(t, "@dry-run.example.com") != wantCount {
+			t.Errorf("dry run %v: first name %q and %d accounts, want %q and %d", dryRun, u.FirstName, count(t, "@dry-run.example.com"), wantName, wantCount)
+		}
+	}
+}
+
+// failingReader returns n records, then an error that stops the import.
+type failingReader struct {
+	n, line int
+}
+
+func (r *failingReader) Next() (userio.Record, error) {
+	if r.line == r.n {
+		return userio.Record{}, errors.New("connection reset")
+	}
+	r.line++
+	return userio.Record{Line: r.line, NewUser: users.NewUser{
+		Email:        fmt.Sprintf("user%d@batches.example.com", r.line),
+		PasswordHash: hash,
+	}}, nil
+}
+
+// Rows are written in batches of 100, so an import that stops keeps the batches
+// written before.
+func TestImportBatches(t *testing.T) {
+	rep, err := userio.Import(db.DB, &failingReader{n: 250}, userio.ImportOptions{})
+	if err == nil {
+		t.Fatal("read error not returned")
+	}
+	if rep.Created != 200 || count(t, "@batches.example.com") != 200 {
+		t.Errorf("created %d, stored %d, want 200", rep.Created, count(t, "@batches.example.com"))
+	}
+}
@@ -0,0 +1,236 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher turns passwords into encoded hashes that name their algorithm and carry
+// its parameters, so hashes made with older parameters or by another algorithm can
+// still be verified and then replaced.
+type Hasher interface {
+	// Hash returns the encoded hash of password, with a fresh salt.
+	Hash(password string) (string, error)
+	// Identify reports whether encoded claims to be a hash of this algorithm.
+	Identify(encoded string) bool
+	// Check returns an error unless Verify can use encoded.
+	Check(encoded string) error
+	// Verify reports whether password matches encoded. Malformed hashes match
+	// nothing.
+	Verify(encoded, password string) bool
+	// NeedsRehash reports whether encoded was made with other parameters than Hash
+	// uses now.
+	NeedsRehash(encoded string) bool
+}
+
+// DefaultHasher hashes new passwords. cmd/server and cmd/admin replace it with
+// HasherFromEnv.
+var DefaultHasher Hasher = DefaultArgon2id
+
+// verifiers are the hashers stored hashes are tried against, after DefaultHasher:
+// argon2 hashes with any parameters, and bcrypt hashes from before argon2id was the
+// default.
+var verifiers = []Hasher{Argon2id{}, Bcrypt{}}
+
+// hasherOf returns the hasher that can verify encoded, or nil.
+func hasherOf(encoded string) Hasher {
+	if DefaultHasher.Identify(encoded) {
+		return DefaultHasher
+	}
+	for _, h := range verifiers {
+		if h.Identify(encoded) {
+			return h
+		}
+	}
+	return nil
+}
+
+// Argon2id hashes passwords with argon2id (RFC 9106) into PHC strings such as
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>. It also verifies argon2i hashes,
+// which it always considers in need of a rehash.
+type Argon2id struct {
+	Memory     uint32 // KiB
+	Time       uint32 // passes over the memory
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// DefaultArgon2id uses the parameters OWASP recommends for argon2id.
+var DefaultArgon2id = Argon2id{Memory: 19 * 1024, Time: 2, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+// Limits on the parameters of hashes Argon2id accepts, so that an imported hash
+// cannot make each login allocate gigabytes or run for minutes.
+const (
+	maxArgon2Memory = 1024 * 1024 // 1 GiB
+	maxArgon2Time   = 64
+)
+
+func (h Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (Argon2id) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$") || strings.HasPrefix(encoded, "$argon2i$")
+}
+
+func (Argon2id) Check(encoded string) error {
+	_, err := parseArgon2(encoded)
+	return err
+}
+
+func (Argon2id) Verify(encoded, password string) bool {
+	a, err := parseArgon2(encoded)
+	return err == nil && a.matches(password)
+}
+
+func (h Argon2id) NeedsRehash(encoded string) bool {
+	a, err := parseArgon2(encoded)
+	if err != nil {
+		return true
+	}
+	return a.variant != "argon2id" || a.memory != h.Memory || a.time != h.Time || a.threads != h.Threads ||
+		uint32(len(a.salt)) != h.SaltLength || uint32(len(a.key)) != h.KeyLength
+}
+
+// argon2Hash is a decoded argon2i or argon2id PHC string.
+type argon2Hash struct {
+	variant string
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2(s string) (*argon2Hash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 || parts[0] != "" {
+		return nil, fmt.Errorf("malformed argon2 hash")
+	}
+	a := &argon2Hash{variant: parts[1]}
+	if a.variant != "argon2id" && a.variant != "argon2i" {
+		return nil, fmt.Errorf("unsupported argon2 variant %q", a.variant)
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.memory, &a.time, &a.threads); err != nil {
+		return nil, fmt.Errorf("malformed argon2 parameters %q", parts[3])
+	}
+	if a.memory == 0 || a.time == 0 || a.threads == 0 {
+		return nil, fmt.Errorf("malformed argon2 parameters %q", parts[3])
+	}
+	if a.memory > maxArgon2Memory || a.time > maxArgon2Time {
+		return nil, fmt.Errorf("argon2 parameters %q exceed m=%d,t=%d", parts[3], maxArgon2Memory, maxArgon2Time)
+	}
+	var err error
+	if a.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("malformed argon2 salt")
+	}
+	if a.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(a.key) == 0 {
+		return nil, fmt.Errorf("malformed argon2 key")
+	}
+	return a, nil
+}
+
+func (a *argon2Hash) matches(password string) bool {
+	derive := argon2.IDKey
+	if a.variant == "argon2i" {
+		derive = argon2.Key
+	}
+	key := derive([]byte(password), a.salt, a.time, a.memory, a.threads, uint32(len(a.key)))
+	return subtle.ConstantTimeCompare(key, a.key) == 1
+}
+
+// Bcrypt hashes passwords with bcrypt. It is kept to verify hashes stored before
+// argon2id became the default, and those imported from other systems.
+type Bcrypt struct {
+	Cost int
+}
+
+// bcryptMaxBytes is the longest password bcrypt uses entirely; it ignores the rest.
+const bcryptMaxBytes = 72
+
+var errBcryptTooLong = errors.New("bcrypt: password longer than 72 bytes")
+
+func (h Bcrypt) Hash(password string) (string, error) {
+	if len(password) > bcryptMaxBytes {
+		return "", errBcryptTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (Bcrypt) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (Bcrypt) Check(encoded string) error {
+	if _, err := bcrypt.Cost([]byte(encoded)); err != nil {
+		return fmt.Errorf("malformed bcrypt hash")
+	}
+	return nil
+}
+
+// Verify checks the first 72 bytes of longer passwords, the part bcrypt hashed when
+// the stored hash was made; logging in then replaces the hash with one of the whole
+// password.
+func (Bcrypt) Verify(encoded, password string) bool {
+	if len(password) > bcryptMaxBytes {
+		password = password[:bcryptMaxBytes]
+	}
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+func (h Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.Cost
+}
+
+// HasherFromEnv returns DefaultArgon2id with the parameters set in
+// PASSWORD_ARGON2_MEMORY (KiB), PASSWORD_ARGON2_TIME and PASSWORD_ARGON2_THREADS.
+// Raising them upgrades each stored hash at the user's next login.
+func HasherFromEnv() (Hasher, error) {
+	h := DefaultArgon2id
+	params := []struct {
+		name string
+		max  uint64
+		set  func(uint64)
+	}{
+		{"PASSWORD_ARGON2_MEMORY", maxArgon2Memory, func(v uint64) { h.Memory = uint32(v) }},
+		{"PASSWORD_ARGON2_TIME", maxArgon2Time, func(v uint64) { h.Time = uint32(v) }},
+		{"PASSWORD_ARGON2_THREADS", 255, func(v uint64) { h.Threads = uint8(v) }},
+	}
+	for _, p := range params {
+		s := os.Getenv(p.name)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || v == 0 || v > p.max {
+			return nil, fmt.Errorf("invalid %s %q (1 to %d)", p.name, s, p.max)
+		}
+		p.set(v)
+	}
+	if h.Memory < 8*uint32(h.Threads) {
+		return nil, fmt.Errorf("PASSWORD_ARGON2_MEMORY must be at least 8 KiB per thread")
+	}
+	return h, nil
+}
@@ -0,0 +1,31 @@
+package users
+
+import (
+	"time"
+
+	"fiber-rest-api/internal/profileattr"
+)
+
+// Event is a change recorded in the append-only profile history.
+type Event struct {
+	UserID int
+	// ActorID is the user who made the change, or 0 for an operator using cmd/admin.
+	ActorID   int
+	Action    string
+	Field     string
+	OldValue  *string
+	NewValue  *string
+	IP        string
+	UserAgent string
+}
+
+// RecordEvent appends ev to the profile history. Rows about admin-only attributes
+// are flagged at write time so they stay hidden from the owner even after the
+// attribute definition changes or is deleted.
+func RecordEvent(q Queryer, ev Event) error {
+	_, err := q.Exec(`INSERT INTO profile_history (user_id, actor_id, action, field, old_value, new_value, ip, user_agent, created_at, admin_only)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, EXISTS (SELECT 1 FROM profile_attributes WHERE ? = 'attributes.' || key AND visibility = ?))`,
+		ev.UserID, ev.ActorID, ev.Action, ev.Field, ev.OldValue, ev.NewValue, ev.IP, ev.UserAgent, time.Now().Unix(),
+		ev.Field, profileattr.VisibilityAdmin)
+	return err
+}
@@ -0,0 +1,69 @@
+package users
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrIdentityLinked is returned when linking an identity that belongs to an account
+// already, or a second identity of the same provider to one account.
+var ErrIdentityLinked = errors.New("identity already linked")
+
+// Identity links an account to the user's account at an OpenID Connect provider.
+type Identity struct {
+	UserID   int
+	Provider string
+	// Subject identifies the user at the provider.
+	Subject string
+	// Email is the address the provider reported when the identity was linked.
+	Email     string
+	CreatedAt time.Time
+}
+
+// GetByIdentity returns the user the identity is linked to.
+func GetByIdentity(q Queryer, provider, subject string) (*User, error) {
+	return getBy(q, "id = (SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?)", provider, subject)
+}
+
+// LinkIdentity links id.Provider and id.Subject to user id.UserID.
+func LinkIdentity(q Queryer, id Identity) error {
+	_, err := q.Exec("INSERT INTO user_identities (provider, subject, user_id, email, created_at) VALUES (?, ?, ?, ?, ?)",
+		id.Provider, id.Subject, id.UserID, nullString(id.Email), time.Now().Unix())
+	if err != nil && strings.Contains(err.Error(), "UNIQUE") {
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+// Identities returns the identities linked to user uid, by provider.
+func Identities(q Queryer, uid int) ([]Identity, error) {
+	rows, err := q.Query("SELECT provider, subject, COALESCE(email, ''), created_at FROM user_identities WHERE user_id = ? ORDER BY provider", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Identity{}
+	for rows.Next() {
+		id := Identity{UserID: uid}
+		var createdAt int64
+		if err := rows.Scan(&id.Provider, &id.Subject, &id.Email, &createdAt); err != nil {
+			return nil, err
+		}
+		id.CreatedAt = time.Unix(createdAt, 0)
+		list = append(list, id)
+	}
+	return list, rows.Err()
+}
+
+// UnlinkIdentity removes the identity of provider from user uid.
+func UnlinkIdentity(q Queryer, uid int, provider string) error {
+	res, err := q.Exec("DELETE FROM user_identities WHERE user_id = ? AND provider = ?", uid, provider)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package users
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"fiber-rest-api/internal/apperr"
+)
+
+const (
+	minPasswordLength = 8
+	// argon2id hashes passwords of any length; the limit only bounds the work a
+	// single request can ask for
+	maxPasswordBytes = 1024
+)
+
+// HashPassword hashes a password for storage with DefaultHasher.
+func HashPassword(password string) (string, error) {
+	return DefaultHasher.Hash(password)
+}
+
+// CheckPassword reports whether password matches hash, an encoded hash of
+// DefaultHasher or of any algorithm in verifiers: argon2id or argon2i in the PHC
+// string format ($argon2id$v=19$m=65536,t=3,p=4$salt$key), or bcrypt.
+func CheckPassword(hash, password string) bool {
+	h := hasherOf(hash)
+	return h != nil && h.Verify(hash, password)
+}
+
+// CheckHash returns an error unless hash is a password hash CheckPassword can verify.
+func CheckHash(hash string) error {
+	h := hasherOf(hash)
+	if h == nil {
+		return fmt.Errorf("not a bcrypt or argon2 hash")
+	}
+	return h.Check(hash)
+}
+
+// dummy caches a hash of DefaultHasher for DummyPasswordCheck, made again when
+// DefaultHasher changes.
+var dummy struct {
+	sync.Mutex
+	hasher Hasher
+	hash   string
+}
+
+// DummyPasswordCheck verifies password against a hash that matches nothing, made
+// with the parameters of DefaultHasher. Callers without an account to check, such
+// as Login with an unknown email, use it to take as long as a real check.
+func DummyPasswordCheck(password string) {
+	dummy.Lock()
+	if dummy.hasher != DefaultHasher {
+		hash, err := DefaultHasher.Hash("dummy password")
+		if err != nil {
+			dummy.Unlock()
+			return
+		}
+		dummy.hasher, dummy.hash = DefaultHasher, hash
+	}
+	hash := dummy.hash
+	dummy.Unlock()
+	CheckPassword(hash, password)
+}
+
+// NeedsRehash reports whether hash should be replaced by one made with
+// DefaultHasher: it comes from another algorithm or used other parameters.
+func NeedsRehash(hash string) bool {
+	return !DefaultHasher.Identify(hash) || DefaultHasher.NeedsRehash(hash)
+}
+
+// CheckPasswordPolicy returns a weak_password error describing why password is not
+// acceptable for the account with the given email, or nil if it is.
+func CheckPasswordPolicy(password, email string) *apperr.Error {
+	if len([]rune(password)) < minPasswordLength {
+		return apperr.Newf(apperr.WeakPassword, "password too short (min %d characters)", minPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return apperr.Newf(apperr.WeakPassword, "password too long (max %d bytes)", maxPasswordBytes)
+	}
+	var letter, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	if !letter || !digit {
+		return apperr.New(apperr.WeakPassword, "password must contain at least one letter and one digit")
+	}
+	if email != "" && strings.EqualFold(password, email) {
+		return apperr.New(apperr.WeakPassword, "password must not be the email address")
+	}
+	return nil
+}
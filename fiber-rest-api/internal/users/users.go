@@ -0,0 +1,332 @@
+// Package users is the repository of user accounts, shared by the HTTP handlers and
+// cmd/admin: creating accounts, reading them, the changes operators make to roles,
+// passwords and the disabled flag, and the provider identities linked to accounts.
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Roles.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Roles lists every valid role.
+var Roles = []string{RoleUser, RoleAdmin}
+
+var (
+	ErrNotFound    = errors.New("user not found")
+	ErrEmailTaken  = errors.New("email already registered")
+	ErrInvalidRole = errors.New("invalid role")
+)
+
+// Queryer is satisfied by *sql.DB and *sql.Tx.
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// User is an account. Times are zero when unset.
+type User struct {
+	ID                int
+	Email             string
+	Username          string
+	Role              string
+	FirstName         string
+	LastName          string
+	Phone             string
+	PasswordChangedAt time.Time
+	DeleteAfter       time.Time
+	DisabledAt        time.Time
+
+	passwordHash string
+}
+
+// Disabled reports whether an operator has disabled the account.
+func (u *User) Disabled() bool {
+	return !u.DisabledAt.IsZero()
+}
+
+// HasPassword reports whether the account has a password. Accounts created through
+// an identity provider or an emailed sign-in link have none until their owner sets
+// one.
+func (u *User) HasPassword() bool {
+	return u.passwordHash != ""
+}
+
+// PasswordMatches reports whether password is the password of the account. For an
+// account without a password it is false, after taking as long as a real check.
+func (u *User) PasswordMatches(password string) bool {
+	if !u.HasPassword() {
+		DummyPasswordCheck(password)
+		return false
+	}
+	return CheckPassword(u.passwordHash, password)
+}
+
+// PasswordHash returns the stored hash of the password, e.g. to compare it with an
+// imported one.
+func (u *User) PasswordHash() string {
+	return u.passwordHash
+}
+
+const columns = "id, email, username, role, first_name, last_name, phone, password, password_changed_at, delete_after, disabled_at"
+
+func scan(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var u User
+	var username, firstName, lastName, phone, passwordHash sql.NullString
+	var passwordChangedAt, deleteAfter, disabledAt sql.NullInt64
+	if err := row.Scan(&u.ID, &u.Email, &username, &u.Role, &firstName, &lastName, &phone, &passwordHash, &passwordChangedAt, &deleteAfter, &disabledAt); err != nil {
+		return nil, err
+	}
+	u.Username, u.FirstName, u.LastName, u.Phone = username.String, firstName.String, lastName.String, phone.String
+	u.passwordHash = passwordHash.String
+	if passwordChangedAt.Valid {
+		u.PasswordChangedAt = time.UnixMilli(passwordChangedAt.Int64)
+	}
+	u.DeleteAfter = unixTime(deleteAfter)
+	u.DisabledAt = unixTime(disabledAt)
+	return &u, nil
+}
+
+func unixTime(v sql.NullInt64) time.Time {
+	if !v.Valid {
+		return time.Time{}
+	}
+	return time.Unix(v.Int64, 0)
+}
+
+func getBy(q Queryer, where string, args ...interface{}) (*User, error) {
+	u, err := scan(q.QueryRow("SELECT "+columns+" FROM users WHERE "+where, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return u, err
+}
+
+// Get returns user id.
+func Get(q Queryer, id int) (*User, error) {
+	return getBy(q, "id = ?", id)
+}
+
+// GetByEmail returns the user with the given email address.
+func GetByEmail(q Queryer, email string) (*User, error) {
+	return getBy(q, "email = ?", email)
+}
+
+// List returns every user ordered by id.
+func List(q Queryer) ([]User, error) {
+	var list []User
+	err := Each(q, func(u *User) error {
+		list = append(list, *u)
+		return nil
+	})
+	return list, err
+}
+
+// Each calls fn with every user in id order, without loading them all at once. It
+// stops at the first error fn returns.
+func Each(q Queryer, fn func(u *User) error) error {
+	rows, err := q.Query("SELECT " + columns + " FROM users ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := scan(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// NewUser holds the fields of an account to create. Role defaults to RoleUser.
+// Without Password and PasswordHash the account has no password (NULL), and its
+// owner signs in through a linked identity or an emailed sign-in link.
+type NewUser struct {
+	Email    string
+	Password string
+	// PasswordHash, when set, is stored instead of a hash of Password, e.g. for
+	// accounts imported from another system. It must pass CheckHash.
+	PasswordHash string
+	Role         string
+	FirstName    string
+	LastName     string
+	// EmailVerified records that the owner receives mail at Email, e.g. because
+	// they signed up through an emailed link.
+	EmailVerified bool
+}
+
+// Create adds an account and returns its id. The password is hashed here; callers
+// check it against the policy first.
+func Create(q Queryer, nu NewUser) (int, error) {
+	if nu.Role == "" {
+		nu.Role = RoleUser
+	}
+	if !ValidRole(nu.Role) {
+		return 0, ErrInvalidRole
+	}
+	hash := nu.PasswordHash
+	if hash != "" {
+		if err := CheckHash(hash); err != nil {
+			return 0, err
+		}
+	} else if nu.Password != "" {
+		var err error
+		if hash, err = HashPassword(nu.Password); err != nil {
+			return 0, err
+		}
+	}
+	var verifiedAt interface{}
+	if nu.EmailVerified {
+		verifiedAt = time.Now().Unix()
+	}
+	res, err := q.Exec("INSERT INTO users (email, password, role, first_name, last_name, email_verified_at) VALUES (?, ?, ?, ?, ?, ?)",
+		nu.Email, nullString(hash), nu.Role, nullString(nu.FirstName), nullString(nu.LastName), verifiedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return 0, ErrEmailTaken
+		}
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// nullString stores empty values as NULL: profile fields, like PATCH does, and the
+// password of accounts without one.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// SetPassword replaces the password of user id and returns the time of the change.
+// Tokens issued before it are rejected from then on.
+func SetPassword(q Queryer, id int, password string) (time.Time, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return SetPasswordHash(q, id, hash)
+}
+
+// SetPasswordHash is SetPassword with a hash that passes CheckHash.
+func SetPasswordHash(q Queryer, id int, hash string) (time.Time, error) {
+	if err := CheckHash(hash); err != nil {
+		return time.Time{}, err
+	}
+	// stored to the millisecond, the precision of token issue times
+	changedAt := time.Now().Truncate(time.Millisecond)
+	res, err := q.Exec("UPDATE users SET password = ?, password_changed_at = ? WHERE id = ?", hash, changedAt.UnixMilli(), id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return time.Time{}, ErrNotFound
+	}
+	return changedAt, nil
+}
+
+// MarkEmailVerified records that the owner of account id receives mail at email,
+// unless the address has changed in the meantime.
+func MarkEmailVerified(q Queryer, id int, email string) error {
+	_, err := q.Exec("UPDATE users SET email_verified_at = ?, version = version + 1 WHERE id = ? AND email = ? AND email_verified_at IS NULL",
+		time.Now().Unix(), id, email)
+	return err
+}
+
+// UpgradePasswordHash replaces the stored hash of u with one made by DefaultHasher
+// when NeedsRehash says so, and reports whether it did. password must be the one
+// that just matched. Unlike SetPassword it keeps password_changed_at, so tokens stay
+// valid, and it does nothing if the password was changed in the meantime.
+func UpgradePasswordHash(q Queryer, u *User, password string) (bool, error) {
+	if !NeedsRehash(u.passwordHash) {
+		return false, nil
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return false, err
+	}
+	res, err := q.Exec("UPDATE users SET password = ? WHERE id = ? AND password = ?", hash, u.ID, u.passwordHash)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	u.passwordHash = hash
+	return true, nil
+}
+
+// SetRole gives user id the role.
+func SetRole(q Queryer, id int, role string) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+	res, err := q.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetName replaces the first and last name of user id, changing the profile version
+// like an edit through the API.
+func SetName(q Queryer, id int, firstName, lastName string) error {
+	res, err := q.Exec("UPDATE users SET first_name = ?, last_name = ?, version = version + 1 WHERE id = ?",
+		nullString(firstName), nullString(lastName), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetDisabled disables or re-enables user id and reports whether that changed
+// anything. A disabled account can neither log in nor use its tokens.
+func SetDisabled(q Queryer, id int, disabled bool) (bool, error) {
+	var res sql.Result
+	var err error
+	if disabled {
+		res, err = q.Exec("UPDATE users SET disabled_at = ? WHERE id = ? AND disabled_at IS NULL", time.Now().Unix(), id)
+	} else {
+		res, err = q.Exec("UPDATE users SET disabled_at = NULL WHERE id = ? AND disabled_at IS NOT NULL", id)
+	}
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	if _, err := Get(q, id); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
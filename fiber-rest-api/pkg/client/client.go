@@ -0,0 +1,387 @@
+// Package client is a Go client for version 1 of the API:
+//
+//	c := client.New("https://api.example.com")
+//	if _, err := c.Login(ctx, email, password); err != nil {
+//		return err
+//	}
+//	profile, etag, err := c.GetProfile(ctx)
+//
+// After Login the client logs in again with the same credentials when the token is
+// about to expire or the server rejects it, so long-running services need not track
+// token lifetimes. Idempotent calls are retried with exponential back-off on network
+// errors, 429 and 5xx responses. Error responses are returned as *Error, carrying the
+// stable code of the problem document.
+//
+// The request and response types are generated from the OpenAPI document by
+// cmd/clientgen; run go generate after changing a schema they use.
+package client
+
+//go:generate go run ../../cmd/clientgen -o types.gen.go -types AuthRequest,TokenResponse,MessageResponse,Profile,ProfileUpdate,AvatarResponse,Problem
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// basePath prefixes every API path.
+const basePath = "/api/v1"
+
+// refreshMargin is how long before its expiry a token is replaced.
+const refreshMargin = time.Minute
+
+// Retry configures the retries of idempotent calls (GET, PUT, DELETE).
+type Retry struct {
+	// Attempts is the total number of attempts; 1 disables retries.
+	Attempts int
+	// Backoff is the wait before the second attempt, doubled for each further one.
+	Backoff time.Duration
+	// MaxBackoff caps the wait. A Retry-After longer than MaxBackoff ends the retries.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetry is the Retry of clients returned by New.
+var DefaultRetry = Retry{Attempts: 4, Backoff: 200 * time.Millisecond, MaxBackoff: 5 * time.Second}
+
+// Client calls the API. It is safe for concurrent use.
+type Client struct {
+	// BaseURL is the scheme and host of the server, e.g. https://api.example.com.
+	BaseURL string
+	// HTTPClient sends the requests; nil means http.DefaultClient.
+	HTTPClient *http.Client
+	Retry      Retry
+
+	mu          sync.Mutex
+	token       string
+	expires     time.Time
+	credentials *AuthRequest
+}
+
+// New returns a client of the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Retry: DefaultRetry}
+}
+
+// SetToken makes the client authenticate with a token obtained elsewhere, or with
+// an API key. Such a token is not refreshed.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token, c.expires, c.credentials = token, tokenExpiry(token), nil
+}
+
+// Token returns the token the client authenticates with, if any.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// Register creates an account. It does not log in. A server that responds to every
+// registration alike returns no error for an address that is already registered.
+func (c *Client) Register(ctx context.Context, email, password string) error {
+	body, err := json.Marshal(AuthRequest{Email: email, Password: password})
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, request{method: http.MethodPost, path: "/auth/register", body: body}, &MessageResponse{})
+	return err
+}
+
+// Login logs in and keeps the token and the credentials to refresh it. Logging in
+// to an account pending deletion restores it, which the response reports.
+func (c *Client) Login(ctx context.Context, email, password string) (*TokenResponse, error) {
+	creds := &AuthRequest{Email: email, Password: password}
+	resp, err := c.login(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token, c.expires, c.credentials = resp.Token, tokenExpiry(resp.Token), creds
+	return resp, nil
+}
+
+// GetProfile returns the profile of the logged-in user and its ETag, which
+// UpdateProfile and UploadAvatar accept to detect concurrent changes.
+func (c *Client) GetProfile(ctx context.Context) (*Profile, string, error) {
+	var p Profile
+	header, err := c.do(ctx, request{method: http.MethodGet, path: "/profile", auth: true}, &p)
+	if err != nil {
+		return nil, "", err
+	}
+	return &p, header.Get("ETag"), nil
+}
+
+// UpdateProfile replaces the profile of the logged-in user and returns it with its
+// new ETag. With a non-empty etag the update fails with *PreconditionFailedError if
+// the profile changed since that ETag was read.
+func (c *Client) UpdateProfile(ctx context.Context, update ProfileUpdate, etag string) (*Profile, string, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return nil, "", err
+	}
+	var p Profile
+	header, err := c.do(ctx, request{method: http.MethodPut, path: "/profile", body: body, ifMatch: etag, auth: true}, &p)
+	if err != nil {
+		return nil, "", err
+	}
+	return &p, header.Get("ETag"), nil
+}
+
+// UploadAvatar replaces the avatar of the logged-in user with the image read from
+// content and returns its URL path and the new ETag of the profile. etag works as in
+// UpdateProfile.
+func (c *Client) UploadAvatar(ctx context.Context, filename string, content io.Reader, etag string) (*AvatarResponse, string, error) {
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	part, err := form.CreateFormFile("avatar", filename)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return nil, "", err
+	}
+	if err := form.Close(); err != nil {
+		return nil, "", err
+	}
+	var out AvatarResponse
+	header, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        "/profile/avatar",
+		body:        buf.Bytes(),
+		contentType: form.FormDataContentType(),
+		ifMatch:     etag,
+		auth:        true,
+	}, &out)
+	if err != nil {
+		return nil, "", err
+	}
+	return &out, header.Get("ETag"), nil
+}
+
+func (c *Client) login(ctx context.Context, creds *AuthRequest) (*TokenResponse, error) {
+	body, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	var out TokenResponse
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/auth/login", body: body}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// request is a call to the API. body is kept in memory so that it can be resent.
+type request struct {
+	method      string
+	path        string
+	body        []byte
+	contentType string
+	ifMatch     string
+	auth        bool
+}
+
+// do sends r, decoding a successful JSON response into out, and returns the response
+// header. An expired or rejected token is refreshed once.
+func (c *Client) do(ctx context.Context, r request, out interface{}) (http.Header, error) {
+	var token string
+	if r.auth {
+		var err error
+		if token, err = c.currentToken(ctx); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.send(ctx, r, token)
+	if err == nil && r.auth && resp.status == http.StatusUnauthorized {
+		var fresh string
+		if fresh, err = c.refresh(ctx, token, resp); err == nil && fresh != "" {
+			resp, err = c.send(ctx, r, fresh)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.status >= 300 {
+		return nil, resp.err()
+	}
+	if out != nil && len(resp.body) > 0 {
+		if err := json.Unmarshal(resp.body, out); err != nil {
+			return nil, err
+		}
+	}
+	return resp.header, nil
+}
+
+// currentToken returns the token to send, logging in again first if it is about to
+// expire.
+func (c *Client) currentToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	token, expires, creds := c.token, c.expires, c.credentials
+	c.mu.Unlock()
+	if creds == nil || (token != "" && (expires.IsZero() || time.Until(expires) > refreshMargin)) {
+		return token, nil
+	}
+	return c.relogin(ctx, token, creds)
+}
+
+// refresh logs in again after the server rejected token with resp, returning the new
+// token, or "" when the rejection is not one a new token can fix.
+func (c *Client) refresh(ctx context.Context, token string, resp *response) (string, error) {
+	var e *Error
+	if !errors.As(resp.err(), &e) || (e.Code != ErrorCodeInvalidToken && e.Code != ErrorCodeTokenRevoked) {
+		return "", nil
+	}
+	c.mu.Lock()
+	creds := c.credentials
+	c.mu.Unlock()
+	if creds == nil {
+		return "", nil
+	}
+	return c.relogin(ctx, token, creds)
+}
+
+// relogin replaces stale with a token from a new login, unless another call has
+// already done so.
+func (c *Client) relogin(ctx context.Context, stale string, creds *AuthRequest) (string, error) {
+	c.mu.Lock()
+	if c.token != stale {
+		token := c.token
+		c.mu.Unlock()
+		return token, nil
+	}
+	c.mu.Unlock()
+
+	resp, err := c.login(ctx, creds)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credentials == creds {
+		c.token, c.expires = resp.Token, tokenExpiry(resp.Token)
+	}
+	return resp.Token, nil
+}
+
+// response is a response read in full.
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// send sends r with token, retrying idempotent methods.
+func (c *Client) send(ctx context.Context, r request, token string) (*response, error) {
+	attempts := c.Retry.Attempts
+	switch r.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		attempts = 1
+	}
+	backoff := c.Retry.Backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := c.attempt(ctx, r, token)
+		if attempt >= attempts || ctx.Err() != nil || (err == nil && !retryable(resp.status)) {
+			return resp, err
+		}
+
+		// equal jitter: between half and all of the back-off
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if err == nil {
+			if s, convErr := strconv.Atoi(resp.header.Get("Retry-After")); convErr == nil {
+				if after := time.Duration(s) * time.Second; after > c.Retry.MaxBackoff {
+					return resp, nil
+				} else if after > wait {
+					wait = after
+				}
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > c.Retry.MaxBackoff {
+			backoff = c.Retry.MaxBackoff
+		}
+	}
+}
+
+// retryable reports whether a response with status may succeed when repeated.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || (status >= 500 && status != http.StatusNotImplemented)
+}
+
+// attempt sends r once.
+func (c *Client) attempt(ctx context.Context, r request, token string) (*response, error) {
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, c.BaseURL+basePath+r.path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json, application/problem+json")
+	if r.body != nil {
+		contentType := r.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	if r.ifMatch != "" {
+		req.Header.Set("If-Match", r.ifMatch)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &response{status: resp.StatusCode, header: resp.Header, body: data}, nil
+}
+
+// tokenExpiry reads the exp claim of a JWT without verifying it; the server does
+// that. It returns the zero time when the token has no readable expiry.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+)
+
+// Error is an error response of the API. Match on Code, which is stable; Detail and
+// Title are meant for people and may be translated.
+type Error struct {
+	Problem
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("api: %d", e.Status)
+	if e.Code != "" {
+		msg += " " + string(e.Code)
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// HasCode reports whether err is an *Error with code.
+func HasCode(err error, code ErrorCode) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// PreconditionFailedError is returned by writes made with an ETag that no longer
+// matches: the profile changed since it was read. Current is the profile as it is
+// now and ETag its version, to retry the write with.
+type PreconditionFailedError struct {
+	Current Profile
+	ETag    string
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return "api: 412 profile was modified since it was read"
+}
+
+// err converts an error response. Responses that are not problem documents, e.g.
+// from a proxy, give an *Error with only Status and Title set.
+func (r *response) err() error {
+	mediaType, _, _ := mime.ParseMediaType(r.header.Get("Content-Type"))
+	if r.status == http.StatusPreconditionFailed && mediaType == "application/json" {
+		e := &PreconditionFailedError{ETag: r.header.Get("ETag")}
+		if json.Unmarshal(r.body, &e.Current) == nil {
+			return e
+		}
+	}
+	e := &Error{}
+	if mediaType != "application/problem+json" || json.Unmarshal(r.body, &e.Problem) != nil {
+		e.Problem = Problem{Status: r.status, Title: http.StatusText(r.status)}
+	}
+	if e.Status == 0 {
+		e.Status = r.status
+	}
+	if e.RequestID == "" {
+		e.RequestID = r.header.Get("X-Request-ID")
+	}
+	return e
+}
@@ -0,0 +1,139 @@
+// Code generated by cmd/clientgen from the OpenAPI document. DO NOT EDIT.
+
+package client
+
+import (
+	"encoding/json"
+)
+
+// AuthRequest is the AuthRequest schema of the API.
+type AuthRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// AvatarResponse is the AvatarResponse schema of the API.
+type AvatarResponse struct {
+	Avatar string `json:"avatar,omitempty"`
+}
+
+// ErrorCode is the ErrorCode schema of the API.
+//
+// stable, machine-readable error code
+type ErrorCode string
+
+// ErrorCode values.
+const (
+	ErrorCodeAccountDisabled          ErrorCode = "account_disabled"
+	ErrorCodeAccountPendingDeletion   ErrorCode = "account_pending_deletion"
+	ErrorCodeAttributeExists          ErrorCode = "attribute_exists"
+	ErrorCodeAuthorizationDenied      ErrorCode = "authorization_denied"
+	ErrorCodeDeliveryFailed           ErrorCode = "delivery_failed"
+	ErrorCodeEmailTaken               ErrorCode = "email_taken"
+	ErrorCodeEmailUnverified          ErrorCode = "email_unverified"
+	ErrorCodeExportExpired            ErrorCode = "export_expired"
+	ErrorCodeForbidden                ErrorCode = "forbidden"
+	ErrorCodeIdentityLinked           ErrorCode = "identity_linked"
+	ErrorCodeInsufficientScope        ErrorCode = "insufficient_scope"
+	ErrorCodeInternalError            ErrorCode = "internal_error"
+	ErrorCodeInvalidConfirmationToken ErrorCode = "invalid_confirmation_token"
+	ErrorCodeInvalidCredentials       ErrorCode = "invalid_credentials"
+	ErrorCodeInvalidRequest           ErrorCode = "invalid_request"
+	ErrorCodeInvalidSignature         ErrorCode = "invalid_signature"
+	ErrorCodeInvalidState             ErrorCode = "invalid_state"
+	ErrorCodeInvalidToken             ErrorCode = "invalid_token"
+	ErrorCodeInvalidVerificationCode  ErrorCode = "invalid_verification_code"
+	ErrorCodeLastLoginMethod          ErrorCode = "last_login_method"
+	ErrorCodeLinkExpired              ErrorCode = "link_expired"
+	ErrorCodeMalformedBody            ErrorCode = "malformed_body"
+	ErrorCodeMethodNotAllowed         ErrorCode = "method_not_allowed"
+	ErrorCodeNotFound                 ErrorCode = "not_found"
+	ErrorCodePayloadTooLarge          ErrorCode = "payload_too_large"
+	ErrorCodePhoneAlreadyVerified     ErrorCode = "phone_already_verified"
+	ErrorCodePhoneMissing             ErrorCode = "phone_missing"
+	ErrorCodePreconditionRequired     ErrorCode = "precondition_required"
+	ErrorCodeProviderError            ErrorCode = "provider_error"
+	ErrorCodeRateLimited              ErrorCode = "rate_limited"
+	ErrorCodeReauthenticationRequired ErrorCode = "reauthentication_required"
+	ErrorCodeTokenRevoked             ErrorCode = "token_revoked"
+	ErrorCodeUnauthenticated          ErrorCode = "unauthenticated"
+	ErrorCodeUnsupportedMediaType     ErrorCode = "unsupported_media_type"
+	ErrorCodeUsernameTaken            ErrorCode = "username_taken"
+	ErrorCodeVerificationExpired      ErrorCode = "verification_expired"
+	ErrorCodeVerificationNotPending   ErrorCode = "verification_not_pending"
+	ErrorCodeWeakPassword             ErrorCode = "weak_password"
+)
+
+// FieldError is the FieldError schema of the API.
+type FieldError struct {
+	// path of the value, e.g. attributes.team or options[0]; empty for the whole body
+	Field   string `json:"field,omitempty"`
+	In      string `json:"in,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// MessageResponse is the MessageResponse schema of the API.
+type MessageResponse struct {
+	Message string `json:"message,omitempty"`
+}
+
+// Problem is the Problem schema of the API.
+type Problem struct {
+	Code ErrorCode `json:"code"`
+	// human-readable explanation, translated; do not match on it
+	Detail string `json:"detail,omitempty"`
+	// invalid values of a request rejected with invalid_request
+	Errors []FieldError `json:"errors,omitempty"`
+	// path of the request
+	Instance string `json:"instance,omitempty"`
+	// also sent in the X-Request-ID header; quote it when reporting a problem
+	RequestID string `json:"request_id,omitempty"`
+	Status    int    `json:"status"`
+	// HTTP status text, translated
+	Title string `json:"title"`
+	// URI of the problem type, derived from code
+	Type string `json:"type"`
+}
+
+// Profile is the Profile schema of the API.
+type Profile struct {
+	Attributes ProfileAttributes `json:"attributes,omitempty"`
+	Avatar     string            `json:"avatar,omitempty"`
+	Email      string            `json:"email,omitempty"`
+	// the address was confirmed through an emailed link or code, or by the identity provider the account was created with
+	EmailVerified bool   `json:"email_verified,omitempty"`
+	FirstName     string `json:"first_name,omitempty"`
+	ID            int    `json:"id,omitempty"`
+	LastName      string `json:"last_name,omitempty"`
+	// E.164, e.g. +66812345678
+	Phone string `json:"phone,omitempty"`
+	// phone as entered by the user
+	PhoneDisplay  string `json:"phone_display,omitempty"`
+	PhoneVerified bool   `json:"phone_verified,omitempty"`
+	Username      string `json:"username,omitempty"`
+}
+
+// ProfileAttributes is the ProfileAttributes schema of the API.
+//
+// Custom profile attributes defined by admins via /admin/profile-attributes.
+type ProfileAttributes map[string]json.RawMessage
+
+// ProfileUpdate is the ProfileUpdate schema of the API.
+type ProfileUpdate struct {
+	// replaces all custom attributes when present; left unchanged when omitted
+	Attributes ProfileAttributes `json:"attributes,omitempty"`
+	FirstName  string            `json:"first_name,omitempty"`
+	LastName   string            `json:"last_name,omitempty"`
+	Phone      string            `json:"phone,omitempty"`
+}
+
+// TokenResponse is the TokenResponse schema of the API.
+type TokenResponse struct {
+	// present when signing in with a provider or an emailed link created the account
+	Created bool `json:"created,omitempty"`
+	// present when the provider was linked to the account that started the sign-in
+	Linked bool `json:"linked,omitempty"`
+	// present when the login cancelled a pending account deletion
+	Restored bool   `json:"restored,omitempty"`
+	Token    string `json:"token,omitempty"`
+}